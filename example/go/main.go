@@ -0,0 +1,101 @@
+// Command producer produces sample messages to Kafka in any of the formats
+// the datasource decodes, e.g.
+//
+//	go run ./example/go -topic test -mode msgpack
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+
+	"github.com/hoptical/grafana-kafka-datasource/pkg/testdata"
+)
+
+// modes generate the nth message of a mode.
+var modes = map[string]func(n int, now time.Time) testdata.Message{
+	"json": func(n int, now time.Time) testdata.Message {
+		return testdata.Message{Value: testdata.JSON(sample())}
+	},
+	"jsonSchema": func(n int, now time.Time) testdata.Message {
+		return testdata.Message{Value: testdata.JSONSchema(1, sample())}
+	},
+	"msgpack": func(n int, now time.Time) testdata.Message {
+		return testdata.Message{Value: testdata.MessagePack(sample())}
+	},
+	"cbor": func(n int, now time.Time) testdata.Message {
+		return testdata.Message{Value: testdata.CBOR(sample())}
+	},
+	"raw": func(n int, now time.Time) testdata.Message {
+		line := fmt.Sprintf("INFO sample=%d value1=%f", n, rand.Float64())
+		return testdata.Message{Value: []byte(line)}
+	},
+	"array": func(n int, now time.Time) testdata.Message {
+		return testdata.Message{Value: testdata.JSON([]interface{}{sample(), sample()})}
+	},
+	"cloudevents": func(n int, now time.Time) testdata.Message {
+		return testdata.Message{Value: testdata.CloudEvent(strconv.Itoa(n), "/example", "sample", now, sample())}
+	},
+	// debezium cycles through the creation, updates and deletion of rows,
+	// deletes being followed by a tombstone.
+	"debezium": func(n int, now time.Time) testdata.Message {
+		key := testdata.JSON(map[string]interface{}{"id": n / 5})
+		row := func() map[string]interface{} {
+			return map[string]interface{}{"id": n / 5, "value1": rand.Float64()}
+		}
+		switch n % 5 {
+		case 0:
+			return testdata.Message{Key: key, Value: testdata.Debezium(testdata.DebeziumCreate, "samples", nil, row(), now)}
+		case 3:
+			return testdata.Message{Key: key, Value: testdata.Debezium(testdata.DebeziumDelete, "samples", row(), nil, now)}
+		case 4:
+			return testdata.Tombstone(key)
+		}
+		return testdata.Message{Key: key, Value: testdata.Debezium(testdata.DebeziumUpdate, "samples", row(), row(), now)}
+	},
+}
+
+func sample() map[string]interface{} {
+	return map[string]interface{}{
+		"value1": rand.Float64(),
+		"value2": 1 + rand.Float64(),
+	}
+}
+
+func main() {
+	servers := flag.String("bootstrap-servers", "localhost:9092", "Kafka bootstrap servers")
+	topic := flag.String("topic", "test", "topic to produce to")
+	mode := flag.String("mode", "json", "format of the messages: json, jsonSchema, msgpack, cbor, raw, array, cloudevents or debezium")
+	interval := flag.Duration("interval", 500*time.Millisecond, "time between messages")
+	flag.Parse()
+
+	generate, ok := modes[*mode]
+	if !ok {
+		log.Fatalf("unknown mode %q", *mode)
+	}
+	producer, err := kafka.NewProducer(&kafka.ConfigMap{"bootstrap.servers": *servers})
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer producer.Close()
+
+	for n := 0; ; n++ {
+		msg := generate(n, time.Now())
+		err := producer.Produce(&kafka.Message{
+			TopicPartition: kafka.TopicPartition{Topic: topic, Partition: kafka.PartitionAny},
+			Key:            msg.Key,
+			Value:          msg.Value,
+		}, nil)
+		if err != nil {
+			log.Fatal(err)
+		}
+		producer.Flush(1000)
+		log.Printf("Sample #%d produced!", n+1)
+		time.Sleep(*interval)
+	}
+}
@@ -5,4 +5,5 @@ go 1.16
 require (
 	github.com/confluentinc/confluent-kafka-go v1.7.0
 	github.com/grafana/grafana-plugin-sdk-go v0.102.0
+	github.com/prometheus/client_golang v1.10.0
 )
@@ -0,0 +1,88 @@
+//go:build logfmt
+// +build logfmt
+
+package main
+
+// This file registers an example decoder, of logfmt lines like
+// `level=info msg="request served" duration=12ms`, when the plugin is built
+// with the logfmt build tag. Decoders of other formats are added the same
+// way, in a file of their own with a build tag of their own.
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hoptical/grafana-kafka-datasource/pkg/kafka_client"
+)
+
+func init() {
+	kafka_client.RegisterDecoder("logfmt", kafka_client.DecoderFunc(decodeLogfmt))
+}
+
+// decodeLogfmt decodes the pairs of a logfmt line into an object of strings.
+// Keys without a value are true.
+func decodeLogfmt(b []byte, limits kafka_client.JSONLimits) (interface{}, error) {
+	if limits.MaxSize > 0 && len(b) > limits.MaxSize {
+		return nil, fmt.Errorf("message size of %d bytes exceeds the limit of %d bytes", len(b), limits.MaxSize)
+	}
+	line := strings.TrimSpace(string(b))
+	value := make(map[string]interface{})
+	for line != "" {
+		end := strings.IndexAny(line, "= ")
+		if end == -1 {
+			end = len(line)
+		}
+		key := line[:end]
+		if key == "" {
+			return nil, errors.New("logfmt pair without a key")
+		}
+		line = line[end:]
+		if !strings.HasPrefix(line, "=") {
+			value[key] = true
+			line = strings.TrimLeft(line, " ")
+			continue
+		}
+		line = line[1:]
+
+		var v string
+		if strings.HasPrefix(line, `"`) {
+			quoted := quotedPrefix(line)
+			var err error
+			if v, err = strconv.Unquote(quoted); err != nil {
+				return nil, fmt.Errorf("logfmt value of %s: %w", key, err)
+			}
+			line = line[len(quoted):]
+		} else {
+			end := strings.IndexByte(line, ' ')
+			if end == -1 {
+				end = len(line)
+			}
+			v, line = line[:end], line[end:]
+		}
+		if limits.MaxStringLength > 0 && len(v) > limits.MaxStringLength {
+			return nil, fmt.Errorf("string of %d bytes exceeds the limit of %d bytes", len(v), limits.MaxStringLength)
+		}
+		value[key] = v
+		line = strings.TrimLeft(line, " ")
+	}
+	return value, nil
+}
+
+// quotedPrefix returns the double quoted string the line starts with, up to
+// the first unescaped quote, or the whole line if unterminated.
+func quotedPrefix(line string) string {
+	escaped := false
+	for i := 1; i < len(line); i++ {
+		switch {
+		case escaped:
+			escaped = false
+		case line[i] == '\\':
+			escaped = true
+		case line[i] == '"':
+			return line[:i+1]
+		}
+	}
+	return line
+}
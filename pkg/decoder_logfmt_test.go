@@ -0,0 +1,36 @@
+//go:build logfmt
+// +build logfmt
+
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/hoptical/grafana-kafka-datasource/pkg/kafka_client"
+)
+
+func TestDecodeLogfmt(t *testing.T) {
+	value, err := decodeLogfmt([]byte(`level=info msg="request \"served\"" duration=12ms cached`), kafka_client.JSONLimits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"level":    "info",
+		"msg":      `request "served"`,
+		"duration": "12ms",
+		"cached":   true,
+	}
+	if !reflect.DeepEqual(value, expected) {
+		t.Errorf("expected %v, got %v", expected, value)
+	}
+
+	for _, line := range []string{`=value`, `msg="unterminated`} {
+		if _, err := decodeLogfmt([]byte(line), kafka_client.JSONLimits{}); err == nil {
+			t.Errorf("expected an error for %q", line)
+		}
+	}
+	if err := kafka_client.ValidateMessageFormat("logfmt"); err != nil {
+		t.Error(err)
+	}
+}
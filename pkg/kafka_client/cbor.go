@@ -0,0 +1,275 @@
+package kafka_client
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+var errCBORTruncated = errors.New("truncated CBOR message")
+
+// cborBreak marks the end of indefinite length items.
+const cborBreak = 0xff
+
+// decodeCBOR decodes a CBOR map or array into the same values as JSON
+// messages, so that they are flattened the same way: numbers are float64,
+// byte strings base64 strings, epoch timestamps (tag 1) RFC 3339 strings, and
+// map keys strings. Other tags are ignored in favor of their content. The
+// JSON limits apply to the size, nesting depth and string lengths.
+func decodeCBOR(b []byte, limits JSONLimits) (interface{}, error) {
+	if limits.MaxSize > 0 && len(b) > limits.MaxSize {
+		return nil, fmt.Errorf("message size of %d bytes exceeds the limit of %d bytes", len(b), limits.MaxSize)
+	}
+
+	d := cborDecoder{b: b, limits: limits}
+	value, err := d.value(0)
+	if err != nil {
+		return nil, err
+	}
+	if d.pos != len(b) {
+		return nil, fmt.Errorf("%d bytes after the CBOR value", len(b)-d.pos)
+	}
+	return value, nil
+}
+
+type cborDecoder struct {
+	b      []byte
+	pos    int
+	limits JSONLimits
+}
+
+func (d *cborDecoder) next(n uint64) ([]byte, error) {
+	if uint64(len(d.b)-d.pos) < n {
+		return nil, errCBORTruncated
+	}
+	b := d.b[d.pos : d.pos+int(n)]
+	d.pos += int(n)
+	return b, nil
+}
+
+// head reads the initial byte of an item and its argument. indefinite is set
+// for the indefinite length strings, arrays and maps.
+func (d *cborDecoder) head() (major byte, info byte, arg uint64, indefinite bool, err error) {
+	b, err := d.next(1)
+	if err != nil {
+		return 0, 0, 0, false, err
+	}
+	major, info = b[0]>>5, b[0]&0x1f
+
+	switch {
+	case info < 24:
+		return major, info, uint64(info), false, nil
+	case info <= 27:
+		size := uint64(1) << (info - 24)
+		b, err := d.next(size)
+		if err != nil {
+			return 0, 0, 0, false, err
+		}
+		for _, c := range b {
+			arg = arg<<8 | uint64(c)
+		}
+		return major, info, arg, false, nil
+	case info == 31 && major >= 2 && major <= 5:
+		return major, info, 0, true, nil
+	}
+	return 0, 0, 0, false, fmt.Errorf("invalid CBOR item 0x%x", b[0])
+}
+
+// isBreak reports whether the next byte ends an indefinite length item, and
+// consumes it if so.
+func (d *cborDecoder) isBreak() (bool, error) {
+	if d.pos >= len(d.b) {
+		return false, errCBORTruncated
+	}
+	if d.b[d.pos] == cborBreak {
+		d.pos++
+		return true, nil
+	}
+	return false, nil
+}
+
+func (d *cborDecoder) value(depth int) (interface{}, error) {
+	major, info, arg, indefinite, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+
+	switch major {
+	case 0:
+		return float64(arg), nil
+	case 1:
+		return -1 - float64(arg), nil
+	case 2, 3:
+		s, err := d.string(major, arg, indefinite)
+		if err != nil {
+			return nil, err
+		}
+		if major == 2 {
+			return base64.StdEncoding.EncodeToString([]byte(s)), nil
+		}
+		return s, nil
+	case 4:
+		return d.array(arg, indefinite, depth)
+	case 5:
+		return d.object(arg, indefinite, depth)
+	case 6:
+		content, err := d.value(depth)
+		if err != nil {
+			return nil, err
+		}
+		if seconds, ok := content.(float64); ok && arg == 1 {
+			sec, frac := math.Modf(seconds)
+			return time.Unix(int64(sec), int64(frac*1e9)).UTC().Format(time.RFC3339Nano), nil
+		}
+		return content, nil
+	}
+
+	switch info {
+	case 20:
+		return false, nil
+	case 21:
+		return true, nil
+	case 22, 23:
+		return nil, nil
+	case 25:
+		return halfFloat(uint16(arg)), nil
+	case 26:
+		return float64(math.Float32frombits(uint32(arg))), nil
+	case 27:
+		return math.Float64frombits(arg), nil
+	}
+	return nil, fmt.Errorf("unsupported CBOR simple value %d", arg)
+}
+
+// string reads a byte or text string, concatenating the chunks of indefinite
+// length ones.
+func (d *cborDecoder) string(major byte, n uint64, indefinite bool) (string, error) {
+	if !indefinite {
+		if d.limits.MaxStringLength > 0 && n > uint64(d.limits.MaxStringLength) {
+			return "", fmt.Errorf("string of %d bytes exceeds the limit of %d bytes", n, d.limits.MaxStringLength)
+		}
+		b, err := d.next(n)
+		return string(b), err
+	}
+
+	var s strings.Builder
+	for {
+		end, err := d.isBreak()
+		if err != nil {
+			return "", err
+		}
+		if end {
+			return s.String(), nil
+		}
+		chunkMajor, _, chunkLength, chunkIndefinite, err := d.head()
+		if err != nil {
+			return "", err
+		}
+		if chunkMajor != major || chunkIndefinite {
+			return "", errors.New("invalid chunk of indefinite length CBOR string")
+		}
+		chunk, err := d.string(major, chunkLength, false)
+		if err != nil {
+			return "", err
+		}
+		s.WriteString(chunk)
+		if d.limits.MaxStringLength > 0 && s.Len() > d.limits.MaxStringLength {
+			return "", fmt.Errorf("string of %d bytes exceeds the limit of %d bytes", s.Len(), d.limits.MaxStringLength)
+		}
+	}
+}
+
+func (d *cborDecoder) array(n uint64, indefinite bool, depth int) (interface{}, error) {
+	if err := d.nest(depth); err != nil {
+		return nil, err
+	}
+	// Every element takes at least a byte, which bounds the allocation.
+	if n > uint64(len(d.b)-d.pos) {
+		return nil, errCBORTruncated
+	}
+	array := make([]interface{}, 0, n)
+	for i := uint64(0); indefinite || i < n; i++ {
+		if indefinite {
+			end, err := d.isBreak()
+			if err != nil {
+				return nil, err
+			}
+			if end {
+				break
+			}
+		}
+		v, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		array = append(array, v)
+	}
+	return array, nil
+}
+
+func (d *cborDecoder) object(n uint64, indefinite bool, depth int) (interface{}, error) {
+	if err := d.nest(depth); err != nil {
+		return nil, err
+	}
+	if n > uint64(len(d.b)-d.pos) {
+		return nil, errCBORTruncated
+	}
+	object := make(map[string]interface{}, n)
+	for i := uint64(0); indefinite || i < n; i++ {
+		if indefinite {
+			end, err := d.isBreak()
+			if err != nil {
+				return nil, err
+			}
+			if end {
+				break
+			}
+		}
+		k, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		v, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		key, ok := k.(string)
+		if !ok {
+			key = fmt.Sprint(k)
+		}
+		object[key] = v
+	}
+	return object, nil
+}
+
+func (d *cborDecoder) nest(depth int) error {
+	if d.limits.MaxDepth > 0 && depth+1 > d.limits.MaxDepth {
+		return fmt.Errorf("nesting depth exceeds the limit of %d", d.limits.MaxDepth)
+	}
+	return nil
+}
+
+// halfFloat converts an IEEE 754 half precision float.
+func halfFloat(h uint16) float64 {
+	exponent, mantissa := int(h>>10&0x1f), float64(h&0x3ff)
+	var value float64
+	switch exponent {
+	case 0:
+		value = math.Ldexp(mantissa, -24)
+	case 31:
+		if mantissa == 0 {
+			value = math.Inf(1)
+		} else {
+			value = math.NaN()
+		}
+	default:
+		value = math.Ldexp(mantissa+1024, exponent-25)
+	}
+	if h&0x8000 != 0 {
+		return -value
+	}
+	return value
+}
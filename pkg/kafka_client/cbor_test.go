@@ -0,0 +1,70 @@
+package kafka_client
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDecodeCBOR(t *testing.T) {
+	message := []byte{
+		0xa8,
+		0x61, 'a', 0x01,
+		0x61, 'b', 0x82, 0xf5, 0xf6,
+		0x61, 's', 0x7f, 0x61, 'h', 0x61, 'i', 0xff,
+		0x61, 'f', 0xf9, 0x3e, 0x00,
+		0x61, 'n', 0x38, 0x63,
+		0x63, 'b', 'i', 'n', 0x42, 0x01, 0x02,
+		0x61, 't', 0xc1, 0x18, 0x3c,
+		0x05, 0x9f, 0x19, 0x01, 0x2c, 0xff,
+	}
+
+	value, err := decodeCBOR(message, JSONLimits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"a":   1.0,
+		"b":   []interface{}{true, nil},
+		"s":   "hi",
+		"f":   1.5,
+		"n":   -100.0,
+		"bin": "AQI=",
+		"t":   "1970-01-01T00:01:00Z",
+		"5":   []interface{}{300.0},
+	}
+	if !reflect.DeepEqual(value, want) {
+		t.Errorf("expected %v, got %v", want, value)
+	}
+}
+
+func TestDecodeCBORErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		message []byte
+		limits  JSONLimits
+	}{
+		{"truncated", []byte{0xa2, 0x61, 'a', 0x01}, JSONLimits{}},
+		{"trailing bytes", []byte{0xa0, 0x01}, JSONLimits{}},
+		{"unterminated", []byte{0xbf, 0x61, 'a', 0x01}, JSONLimits{}},
+		{"stray break", []byte{0xa1, 0x61, 'a', 0xff}, JSONLimits{}},
+		{"huge array", []byte{0xa1, 0x61, 'a', 0x9a, 0xff, 0xff, 0xff, 0xff}, JSONLimits{}},
+		{"too deep", []byte{0xa1, 0x61, 'a', 0xa1, 0x61, 'b', 0xa0}, JSONLimits{MaxDepth: 2}},
+		{"long string", []byte{0xa1, 0x61, 'a', 0x63, 'a', 'b', 'c'}, JSONLimits{MaxStringLength: 2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := decodeCBOR(tt.message, tt.limits); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestHalfFloat(t *testing.T) {
+	for h, want := range map[uint16]float64{0x3c00: 1, 0xc000: -2, 0x0001: 5.960464477539063e-08, 0x7bff: 65504} {
+		if got := halfFloat(h); got != want {
+			t.Errorf("halfFloat(0x%x): expected %v, got %v", h, want, got)
+		}
+	}
+}
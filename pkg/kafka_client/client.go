@@ -1,9 +1,10 @@
 package kafka_client
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
-	"os"
+	"regexp"
 	"time"
 
 	"github.com/confluentinc/confluent-kafka-go/kafka"
@@ -11,117 +12,535 @@ import (
 
 const MAX_EARLIEST int64 = 100
 
+// ALL_PARTITIONS selects every partition of a topic in TopicAssign.
+const ALL_PARTITIONS int32 = -1
+
+// METADATA_TIMEOUT bounds metadata and offset lookups when the caller's
+// context has no earlier deadline.
+const METADATA_TIMEOUT = 5 * time.Second
+
+// READ_TIMEOUT bounds reading single messages out of a topic.
+const READ_TIMEOUT = 10 * time.Second
+
+// DEFAULT_COMMIT_INTERVAL is how often consumer group offsets are committed
+// unless configured otherwise.
+const DEFAULT_COMMIT_INTERVAL = 5 * time.Second
+
+// ErrMessageNotFound is returned when a requested message doesn't exist.
+var ErrMessageNotFound = errors.New("message not found")
+
+// ErrPartitionRead is wrapped by the errors of messages that could not be
+// read out of their partition, e.g. because they are corrupted.
+var ErrPartitionRead = errors.New("error reading partition")
+
+// ErrBrokerUnreachable is wrapped by errors caused by the brokers not being
+// reachable at all, as opposed to errors returned by a reachable cluster.
+var ErrBrokerUnreachable = errors.New("broker unreachable")
+
 type Options struct {
-	BootstrapServers string `json:"bootstrapServers"`
+	BootstrapServers    string `json:"bootstrapServers"`
+	JSONMaxDepth        int    `json:"jsonMaxDepth"`
+	JSONMaxSize         int    `json:"jsonMaxSize"`
+	JSONMaxStringLength int    `json:"jsonMaxStringLength"`
+	CommitIntervalMs    int    `json:"commitIntervalMs"`
+	// ConnectionString authenticates to Event Hubs. It comes from the
+	// secure settings, never from the JSON data.
+	ConnectionString string `json:"-"`
 }
 
 type KafkaClient struct {
 	Consumer         *kafka.Consumer
 	BootstrapServers string
-	TimestampMode    string
+	// Platform is the Kafka compatible platform of the bootstrap servers,
+	// one of the PLATFORM constants.
+	Platform      string
+	TimestampMode string
+	// MessageFormat is the format of the values of consumed messages, JSON
+	// unless set to one of the MESSAGE_FORMAT constants.
+	MessageFormat string
+	// RawPattern, if set, extracts the named capture groups of raw messages
+	// into fields of their own.
+	RawPattern *regexp.Regexp
+	JSONLimits JSONLimits
+	// PartitionErrors holds the partitions skipped by the last TopicAssign,
+	// or nil if all of them were assigned.
+	PartitionErrors *PartitionErrors
+	// StatsInterval, if set, makes the consumer emit its statistics as
+	// *kafka.Stats events.
+	StatsInterval time.Duration
+	// GroupID is the consumer group joined by TopicSubscribe.
+	GroupID          string
+	groupOffsetReset string
+	// CommitInterval is how often the offsets consumed in a consumer group
+	// are to be committed.
+	CommitInterval time.Duration
+	// Now returns the current time, which bounds reads with a budget. It's
+	// time.Now unless set, e.g. to the clock of the datasource.
+	Now func() time.Time
+	// partitionEOF makes the consumer emit kafka.PartitionEOF events.
+	partitionEOF bool
+	// connectionString authenticates to Event Hubs.
+	connectionString string
+}
+
+func (client KafkaClient) now() time.Time {
+	if client.Now == nil {
+		return time.Now()
+	}
+	return client.Now()
 }
 
 type KafkaMessage struct {
-	Value     map[string]float64
+	Value map[string]interface{}
+	// Items holds the elements of messages whose value is an array rather
+	// than an object, in which case Value is nil.
+	Items     []interface{}
 	Timestamp time.Time
 	Offset    kafka.Offset
+	Partition int32
+	Size      int
+	Key       []byte
+	RawValue  []byte
+	Headers   map[string][]byte
+	// Err is set when the message value could not be decoded. Value then
+	// holds the top-level fields decoded before the error, if any.
+	Err error
 }
 
 func NewKafkaClient(options Options) KafkaClient {
-	client := KafkaClient{BootstrapServers: options.BootstrapServers}
+	client := KafkaClient{
+		BootstrapServers: options.BootstrapServers,
+		Platform:         DetectPlatform(options.BootstrapServers),
+		JSONLimits:       newJSONLimits(options),
+		CommitInterval:   time.Duration(options.CommitIntervalMs) * time.Millisecond,
+	}
+	if client.CommitInterval <= 0 {
+		client.CommitInterval = DEFAULT_COMMIT_INTERVAL
+	}
+	client.connectionString = options.ConnectionString
 	return client
 }
 
-func (client *KafkaClient) consumerInitialize() {
+func (client *KafkaClient) consumerInitialize() error {
 	var err error
-	client.Consumer, err = kafka.NewConsumer(&kafka.ConfigMap{
+	config := kafka.ConfigMap{
 		"bootstrap.servers":  client.BootstrapServers,
 		"group.id":           "kafka-datasource",
 		"enable.auto.commit": "false",
-	})
+	}
+	if client.StatsInterval > 0 {
+		config["statistics.interval.ms"] = int(client.StatsInterval / time.Millisecond)
+	}
+	if client.partitionEOF {
+		config["enable.partition.eof"] = true
+	}
+	client.applyPlatformConfig(config)
+	if client.GroupID != "" {
+		config["group.id"] = client.GroupID
+		if client.groupOffsetReset != "" {
+			config["auto.offset.reset"] = client.groupOffsetReset
+		}
+		config["go.application.rebalance.enable"] = true
+	}
+	client.Consumer, err = kafka.NewConsumer(&config)
+
+	return err
+}
+
+// TopicSubscribe subscribes a new consumer to the topic as a member of the
+// GroupID consumer group. The group shares the partitions of the topic among
+// its members, which resume from the offsets committed by the group, or from
+// the beginning or the end of the partitions, depending on autoOffsetReset,
+// when the group has none. Consumed offsets are committed by Commit.
+func (client *KafkaClient) TopicSubscribe(ctx context.Context, topic string, autoOffsetReset string,
+	timestampMode string) error {
+	client.groupOffsetReset = "latest"
+	if autoOffsetReset == "earliest" {
+		client.groupOffsetReset = "earliest"
+	}
+	if err := client.consumerInitialize(); err != nil {
+		return err
+	}
+	client.TimestampMode = timestampMode
+	client.PartitionErrors = nil
+
+	if _, err := client.topicPartitions(ctx, topic, ALL_PARTITIONS); err != nil {
+		return err
+	}
+	return client.Consumer.Subscribe(topic, nil)
+}
+
+// Rebalance applies the partitions assigned to, or revoked from, the consumer
+// by its group, as told by an AssignedPartitions or RevokedPartitions event.
+// The offsets consumed from revoked partitions are committed first, so that
+// their next owner resumes where the consumer left off. It reports whether
+// the event was one of them.
+func (client *KafkaClient) Rebalance(event kafka.Event) (bool, error) {
+	switch e := event.(type) {
+	case kafka.AssignedPartitions:
+		return true, client.Consumer.Assign(e.Partitions)
+	case kafka.RevokedPartitions:
+		_, commitErr := client.Commit()
+		if err := client.Consumer.Unassign(); err != nil {
+			return true, err
+		}
+		return true, commitErr
+	}
+	return false, nil
+}
 
+// Commit commits the offsets consumed in the consumer group and returns the
+// committed offsets, i.e. those of the next messages to consume, by
+// partition. Nothing is committed, and no error returned, if no message was
+// consumed since the last commit.
+func (client *KafkaClient) Commit() (map[int32]int64, error) {
+	partitions, err := client.Consumer.Commit()
+	var kafkaErr kafka.Error
+	if errors.As(err, &kafkaErr) && kafkaErr.Code() == kafka.ErrNoOffset {
+		return nil, nil
+	}
 	if err != nil {
-		panic(err)
+		return nil, classifyError(err)
+	}
+	offsets := make(map[int32]int64, len(partitions))
+	for _, p := range partitions {
+		if p.Error == nil && p.Offset >= 0 {
+			offsets[p.Partition] = int64(p.Offset)
+		}
 	}
+	return offsets, nil
 }
 
-func (client *KafkaClient) TopicAssign(topic string, partition int32, autoOffsetReset string,
-	timestampMode string) {
-	client.consumerInitialize()
+// TopicAssign assigns the given partition of the topic, or all of its
+// partitions for ALL_PARTITIONS, to a new consumer. Partitions that can't be
+// consumed are skipped and reported through a *PartitionErrors, while the
+// others are still assigned.
+func (client *KafkaClient) TopicAssign(ctx context.Context, topic string, partition int32, autoOffsetReset string,
+	timestampMode string) error {
+	if err := client.consumerInitialize(); err != nil {
+		return err
+	}
 	client.TimestampMode = timestampMode
-	var err error
-	var offset int64
-	var high, low int64
-	switch autoOffsetReset {
-	case "latest":
-		offset = int64(kafka.OffsetEnd)
-	case "earliest":
-		low, high, err = client.Consumer.QueryWatermarkOffsets(topic, partition, 100)
+	client.PartitionErrors = nil
+
+	topicPartitions, err := client.topicPartitions(ctx, topic, partition)
+	if err != nil {
+		return err
+	}
+
+	partitionErrors := &PartitionErrors{Topic: topic}
+	var partitions []kafka.TopicPartition
+	for _, p := range topicPartitions {
+		if p.Error.Code() != kafka.ErrNoError {
+			partitionErrors.Errors = append(partitionErrors.Errors, PartitionError{p.ID, p.Error})
+			continue
+		}
+		offset, err := client.startOffset(ctx, topic, p.ID, autoOffsetReset)
 		if err != nil {
-			panic(err)
+			partitionErrors.Errors = append(partitionErrors.Errors, PartitionError{p.ID, err})
+			continue
 		}
-		if high-low > MAX_EARLIEST {
-			offset = high - MAX_EARLIEST
-		} else {
-			offset = low
+
+		partitions = append(partitions, kafka.TopicPartition{
+			Topic:     &topic,
+			Partition: p.ID,
+			Offset:    offset,
+			Metadata:  new(string),
+		})
+		partitionErrors.Assigned = append(partitionErrors.Assigned, p.ID)
+	}
+
+	if len(partitions) > 0 {
+		if err := client.Consumer.Assign(partitions); err != nil {
+			return err
 		}
-	default:
-		offset = int64(kafka.OffsetEnd)
 	}
+	if len(partitionErrors.Errors) > 0 {
+		client.PartitionErrors = partitionErrors
+		return partitionErrors
+	}
+	return nil
+}
+
+// TableAssign assigns every partition of a compacted topic from its beginning
+// to a new consumer, and returns the offset each non-empty partition has to be
+// read up to for the latest value of every key to be known.
+func (client *KafkaClient) TableAssign(ctx context.Context, topic string) (map[int32]int64, error) {
+	if err := client.consumerInitialize(); err != nil {
+		return nil, err
+	}
+
+	topicPartitions, err := client.topicPartitions(ctx, topic, ALL_PARTITIONS)
+	if err != nil {
+		return nil, err
+	}
+
+	ends := make(map[int32]int64)
+	var partitions []kafka.TopicPartition
+	for _, p := range topicPartitions {
+		if p.Error.Code() != kafka.ErrNoError {
+			return nil, PartitionError{p.ID, p.Error}
+		}
+		low, high, err := client.Consumer.QueryWatermarkOffsets(topic, p.ID, timeoutMs(ctx, METADATA_TIMEOUT))
+		if err != nil {
+			return nil, PartitionError{p.ID, classifyError(err)}
+		}
+		if high > low {
+			ends[p.ID] = high
+		}
+		partitions = append(partitions, kafka.TopicPartition{
+			Topic:     &topic,
+			Partition: p.ID,
+			Offset:    kafka.OffsetBeginning,
+		})
+	}
+
+	if err := client.Consumer.Assign(partitions); err != nil {
+		return nil, err
+	}
+	return ends, nil
+}
 
-	topic_partition := kafka.TopicPartition{
-		Topic:     &topic,
-		Partition: partition,
-		Offset:    kafka.Offset(offset),
-		Metadata:  new(string),
-		Error:     err,
+// ValidateTopic checks that the brokers are reachable and that the partition,
+// or any partition for ALL_PARTITIONS, of the topic exists.
+func (client KafkaClient) ValidateTopic(ctx context.Context, topic string, partition int32) error {
+	if err := client.consumerInitialize(); err != nil {
+		return err
 	}
-	partitions := []kafka.TopicPartition{topic_partition}
-	err = client.Consumer.Assign(partitions)
+	defer client.Consumer.Close()
 
+	_, err := client.topicPartitions(ctx, topic, partition)
+	return err
+}
+
+// topicPartitions returns the metadata of the selected partitions of the
+// topic. Creating a consumer doesn't connect to the brokers, so this is also
+// where an unreachable cluster is detected.
+func (client *KafkaClient) topicPartitions(ctx context.Context, topic string,
+	partition int32) ([]kafka.PartitionMetadata, error) {
+	metadata, err := client.Consumer.GetMetadata(&topic, false, timeoutMs(ctx, METADATA_TIMEOUT))
 	if err != nil {
-		panic(err)
+		return nil, classifyError(err)
+	}
+	topicMetadata, ok := metadata.Topics[topic]
+	switch code := topicMetadata.Error.Code(); {
+	case !ok, code == kafka.ErrUnknownTopicOrPart, code == kafka.ErrUnknownTopic:
+		return nil, client.topicNotFound(ctx, topic)
+	case code != kafka.ErrNoError:
+		return nil, topicMetadata.Error
 	}
+
+	var partitions []kafka.PartitionMetadata
+	for _, p := range topicMetadata.Partitions {
+		if partition == ALL_PARTITIONS || p.ID == partition {
+			partitions = append(partitions, p)
+		}
+	}
+	if len(partitions) == 0 {
+		return nil, fmt.Errorf("partition %d of topic %s does not exist", partition, topic)
+	}
+	return partitions, nil
 }
 
+// topicNotFound returns the error of a missing topic, suggesting the closest
+// existing topics when they can be listed.
+func (client *KafkaClient) topicNotFound(ctx context.Context, topic string) error {
+	err := &TopicNotFoundError{Topic: topic}
+	metadata, metadataErr := client.Consumer.GetMetadata(nil, true, timeoutMs(ctx, METADATA_TIMEOUT))
+	if metadataErr != nil {
+		return err
+	}
+	topics := make([]string, 0, len(metadata.Topics))
+	for t := range metadata.Topics {
+		topics = append(topics, t)
+	}
+	err.Suggestions = suggestTopics(topic, topics)
+	return err
+}
+
+func (client *KafkaClient) startOffset(ctx context.Context, topic string, partition int32,
+	autoOffsetReset string) (kafka.Offset, error) {
+	switch autoOffsetReset {
+	case "earliest":
+		low, high, err := client.Consumer.QueryWatermarkOffsets(topic, partition, timeoutMs(ctx, METADATA_TIMEOUT))
+		if err != nil {
+			return 0, classifyError(err)
+		}
+		if high-low > MAX_EARLIEST {
+			return kafka.Offset(high - MAX_EARLIEST), nil
+		}
+		return kafka.Offset(low), nil
+	default:
+		return kafka.OffsetEnd, nil
+	}
+}
+
+// ConsumerPull polls the consumer for the next message or event. Errors,
+// including all the brokers being down, are returned as kafka.Error events
+// for the caller to handle, since the consumer keeps retrying on its own.
 func (client *KafkaClient) ConsumerPull() (KafkaMessage, kafka.Event) {
 	var message KafkaMessage
 	ev := client.Consumer.Poll(100)
 
-	if ev == nil {
-		return message, ev
+	if e, ok := ev.(*kafka.Message); ok {
+		message = client.newMessage(e)
 	}
+	return message, ev
+}
 
-	switch e := ev.(type) {
-	case *kafka.Message:
-		json.Unmarshal([]byte(e.Value), &message.Value)
-		message.Offset = e.TopicPartition.Offset
-		message.Timestamp = e.Timestamp
-	case kafka.Error:
-		fmt.Fprintf(os.Stderr, "%% Error: %v: %v\n", e.Code(), e)
-		if e.Code() == kafka.ErrAllBrokersDown {
-			panic(e)
+func (client *KafkaClient) newMessage(e *kafka.Message) KafkaMessage {
+	message := KafkaMessage{
+		Timestamp: e.Timestamp,
+		Offset:    e.TopicPartition.Offset,
+		Partition: e.TopicPartition.Partition,
+		Size:      len(e.Value),
+		Key:       e.Key,
+		RawValue:  e.Value,
+	}
+	if len(e.Headers) > 0 {
+		message.Headers = make(map[string][]byte, len(e.Headers))
+		for _, header := range e.Headers {
+			message.Headers[header.Key] = header.Value
 		}
-	default:
 	}
-	return message, ev
+	if e.TopicPartition.Error != nil {
+		message.Err = fmt.Errorf("%w %d: %v", ErrPartitionRead, e.TopicPartition.Partition, e.TopicPartition.Error)
+		return message
+	}
+	message.Value, message.Items, message.Err = client.decode(e.Value)
+	return message
+}
+
+// PausePartition stops fetching the partition of the topic until it is
+// resumed.
+func (client *KafkaClient) PausePartition(topic string, partition int32) error {
+	return client.Consumer.Pause([]kafka.TopicPartition{{Topic: &topic, Partition: partition}})
 }
 
-func (client KafkaClient) HealthCheck() error {
-	client.consumerInitialize()
+// ResumePartition resumes fetching a paused partition of the topic.
+func (client *KafkaClient) ResumePartition(topic string, partition int32) error {
+	return client.Consumer.Resume([]kafka.TopicPartition{{Topic: &topic, Partition: partition}})
+}
+
+// ReadMessage reads the single message stored at the offset of the topic
+// partition.
+func (client KafkaClient) ReadMessage(ctx context.Context, topic string, partition int32,
+	offset int64) (KafkaMessage, error) {
+	if err := client.consumerInitialize(); err != nil {
+		return KafkaMessage{}, err
+	}
+	defer client.Consumer.Close()
+
+	if _, err := client.topicPartitions(ctx, topic, partition); err != nil {
+		return KafkaMessage{}, err
+	}
+	err := client.Consumer.Assign([]kafka.TopicPartition{
+		{Topic: &topic, Partition: partition, Offset: kafka.Offset(offset)},
+	})
+	if err != nil {
+		return KafkaMessage{}, err
+	}
 
-	topic := ""
-	_, err := client.Consumer.GetMetadata(&topic, false, 200)
+	ctx, cancel := context.WithTimeout(ctx, READ_TIMEOUT)
+	defer cancel()
+	for ctx.Err() == nil {
+		switch e := client.Consumer.Poll(100).(type) {
+		case *kafka.Message:
+			// The offset might have been removed by retention or compaction,
+			// in which case the consumer skips to the next one.
+			if int64(e.TopicPartition.Offset) != offset {
+				return KafkaMessage{}, fmt.Errorf("%w: offset %d of partition %d of topic %s",
+					ErrMessageNotFound, offset, partition, topic)
+			}
+			return client.newMessage(e), nil
+		case kafka.Error:
+			return KafkaMessage{}, classifyError(e)
+		}
+	}
+	return KafkaMessage{}, fmt.Errorf("%w: offset %d of partition %d of topic %s",
+		ErrMessageNotFound, offset, partition, topic)
+}
+
+// LatestMessage reads the last message of the partition of the topic, or of
+// its first non-empty partition for ALL_PARTITIONS, e.g. to sample the fields
+// messages carry. ErrMessageNotFound is returned if they are all empty.
+func (client KafkaClient) LatestMessage(ctx context.Context, topic string, partition int32) (KafkaMessage, error) {
+	if err := client.consumerInitialize(); err != nil {
+		return KafkaMessage{}, err
+	}
+	defer client.Consumer.Close()
 
+	partitions, err := client.topicPartitions(ctx, topic, partition)
 	if err != nil {
-		if err.(kafka.Error).Code() == kafka.ErrTransport {
-			return err
+		return KafkaMessage{}, err
+	}
+	for _, p := range partitions {
+		if p.Error.Code() != kafka.ErrNoError {
+			continue
+		}
+		low, high, err := client.Consumer.QueryWatermarkOffsets(topic, p.ID, timeoutMs(ctx, METADATA_TIMEOUT))
+		if err != nil {
+			return KafkaMessage{}, classifyError(err)
+		}
+		if high > low {
+			return client.ReadMessage(ctx, topic, p.ID, high-1)
 		}
 	}
+	return KafkaMessage{}, fmt.Errorf("%w: topic %s is empty", ErrMessageNotFound, topic)
+}
 
-	return nil
+// timeoutMs returns the milliseconds left until the context deadline, capped
+// at max, for use with the librdkafka calls that only accept a timeout.
+func timeoutMs(ctx context.Context, max time.Duration) int {
+	timeout := max
+	if deadline, ok := ctx.Deadline(); ok {
+		if left := time.Until(deadline); left < timeout {
+			timeout = left
+		}
+	}
+	if timeout < time.Millisecond {
+		timeout = time.Millisecond
+	}
+	return int(timeout / time.Millisecond)
+}
+
+func classifyError(err error) error {
+	var kafkaErr kafka.Error
+	if !errors.As(err, &kafkaErr) {
+		return err
+	}
+	switch kafkaErr.Code() {
+	case kafka.ErrTransport, kafka.ErrAllBrokersDown, kafka.ErrResolve, kafka.ErrTimedOut:
+		return brokerUnreachableError{err}
+	}
+	return err
+}
+
+// brokerUnreachableError matches ErrBrokerUnreachable while wrapping the
+// error of the client, so that its code is still at hand.
+type brokerUnreachableError struct {
+	err error
 }
 
+func (e brokerUnreachableError) Error() string {
+	return fmt.Sprintf("%v: %v", ErrBrokerUnreachable, e.err)
+}
+
+func (e brokerUnreachableError) Is(target error) bool {
+	return target == ErrBrokerUnreachable
+}
+
+func (e brokerUnreachableError) Unwrap() error {
+	return e.err
+}
+
+// Dispose closes the consumer, committing the offsets consumed in a consumer
+// group first.
 func (client *KafkaClient) Dispose() {
-	client.Consumer.Close()
+	if client.Consumer != nil {
+		if client.GroupID != "" {
+			client.Commit()
+		}
+		client.Consumer.Close()
+	}
 }
@@ -0,0 +1,157 @@
+package kafka_client
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+func TestTimeoutMs(t *testing.T) {
+	if got := timeoutMs(context.Background(), 2*time.Second); got != 2000 {
+		t.Errorf("expected the maximum without a deadline, got %d", got)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	if got := timeoutMs(ctx, 2*time.Second); got > 500 || got < 400 {
+		t.Errorf("expected the time left until the deadline, got %d", got)
+	}
+
+	ctx, cancel = context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	if got := timeoutMs(ctx, 2*time.Second); got != 1 {
+		t.Errorf("expected a minimal timeout once the deadline passed, got %d", got)
+	}
+}
+
+func TestClassifyError(t *testing.T) {
+	err := classifyError(kafka.NewError(kafka.ErrTransport, "transport failure", false))
+	if !errors.Is(err, ErrBrokerUnreachable) {
+		t.Errorf("expected transport failure to be classified as unreachable, got %v", err)
+	}
+	var kafkaErr kafka.Error
+	if !errors.As(err, &kafkaErr) || kafkaErr.Code() != kafka.ErrTransport {
+		t.Errorf("expected the client error to be kept, got %v", err)
+	}
+
+	err = classifyError(kafka.NewError(kafka.ErrUnknownTopicOrPart, "unknown topic", false))
+	if errors.Is(err, ErrBrokerUnreachable) {
+		t.Errorf("expected unknown topic not to be classified as unreachable, got %v", err)
+	}
+}
+
+func TestPartitionErrors(t *testing.T) {
+	err := &PartitionErrors{
+		Topic: "events",
+		Errors: []PartitionError{
+			{Partition: 3, Err: classifyError(kafka.NewError(kafka.ErrTransport, "leader down", false))},
+		},
+		Assigned: []int32{0, 1, 2},
+	}
+
+	if !err.Partial() {
+		t.Error("expected partial assignment")
+	}
+	if !errors.Is(err, ErrBrokerUnreachable) {
+		t.Error("expected partition errors to match the wrapped error")
+	}
+	if got := err.Failed(); len(got) != 1 || got[0] != 3 {
+		t.Errorf("unexpected failed partitions %v", got)
+	}
+	if got := err.Error(); got != "1 of 4 partitions of topic events failed: partition 3: broker unreachable: leader down" {
+		t.Errorf("unexpected message %q", got)
+	}
+}
+
+func TestSuggestTopics(t *testing.T) {
+	topics := []string{"orders", "Orders.v2", "order-events", "payments", "ordres"}
+
+	got := suggestTopics("order", topics)
+	want := []string{"orders", "ordres"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("expected suggestions %v, got %v", want, got)
+	}
+	if got := suggestTopics("commandés", []string{"commandes", "commandées"}); strings.Join(got, ",") != "commandes,commandées" {
+		t.Errorf("expected the distance of unicode names in characters, got %v", got)
+	}
+	if got := suggestTopics("invoices", topics); len(got) != 0 {
+		t.Errorf("expected no suggestions, got %v", got)
+	}
+
+	err := &TopicNotFoundError{Topic: "order", Suggestions: want}
+	if !errors.Is(err, ErrTopicNotFound) {
+		t.Error("expected the error to match ErrTopicNotFound")
+	}
+	if got := err.Error(); got != "topic order does not exist, did you mean orders, ordres?" {
+		t.Errorf("unexpected message %q", got)
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "abc", 3},
+		{"kitten", "sitting", 3},
+		{"orders", "ordres", 2},
+		{"héllo", "hello", 1},
+	}
+	for _, tt := range tests {
+		if got := editDistance(tt.a, tt.b); got != tt.want {
+			t.Errorf("expected distance %d between %q and %q, got %d", tt.want, tt.a, tt.b, got)
+		}
+	}
+}
+
+func TestBrokers(t *testing.T) {
+	client := NewKafkaClient(Options{BootstrapServers: "kafka-1:9092, kafka-2:9092,,"})
+	brokers := client.brokers()
+	if len(brokers) != 2 || brokers[0] != "kafka-1:9092" || brokers[1] != "kafka-2:9092" {
+		t.Errorf("unexpected brokers %q", brokers)
+	}
+
+	if _, err := NewKafkaClient(Options{}).HealthCheck(context.Background()); err == nil {
+		t.Error("expected the health check to fail without bootstrap servers")
+	}
+}
+
+func TestFailedBrokers(t *testing.T) {
+	failed := FailedBrokers([]BrokerHealth{
+		{Broker: "kafka-1:9092", Attempts: 1},
+		{Broker: "kafka-2:9092", Attempts: 3, Err: ErrBrokerUnreachable},
+	})
+	if len(failed) != 1 || failed[0] != "kafka-2:9092" {
+		t.Errorf("expected kafka-2:9092 to have failed, got %q", failed)
+	}
+}
+
+func TestCommitInterval(t *testing.T) {
+	if got := NewKafkaClient(Options{}).CommitInterval; got != DEFAULT_COMMIT_INTERVAL {
+		t.Errorf("expected the default commit interval, got %v", got)
+	}
+	if got := NewKafkaClient(Options{CommitIntervalMs: 1500}).CommitInterval; got != 1500*time.Millisecond {
+		t.Errorf("expected a commit interval of 1.5s, got %v", got)
+	}
+}
+
+func TestConsumerPullBrokersDown(t *testing.T) {
+	client := NewKafkaClient(Options{BootstrapServers: "localhost:1"})
+	if err := client.consumerInitialize(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Consumer.Close()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		_, event := client.ConsumerPull()
+		if e, ok := event.(kafka.Error); ok && e.Code() == kafka.ErrAllBrokersDown {
+			return
+		}
+	}
+	t.Fatal("expected all the brokers being down to be returned as an event")
+}
@@ -0,0 +1,50 @@
+package kafka_client
+
+import (
+	"context"
+	"sort"
+)
+
+// Broker is a broker of the cluster, as advertised in its metadata.
+type Broker struct {
+	ID   int32  `json:"id"`
+	Host string `json:"host"`
+	Port int    `json:"port"`
+}
+
+// Cluster is the metadata of the cluster the client connects to.
+type Cluster struct {
+	Brokers []Broker `json:"brokers"`
+	// OriginatingBroker is the ID of the broker that answered the request.
+	OriginatingBroker int32  `json:"originatingBroker"`
+	Topics            int    `json:"topics"`
+	Platform          string `json:"platform"`
+}
+
+// ClusterMetadata returns the brokers of the cluster, sorted by ID. Their
+// rack, the controller and the Kafka version aren't part of the metadata the
+// consumer gets.
+func (client KafkaClient) ClusterMetadata(ctx context.Context) (Cluster, error) {
+	if err := client.consumerInitialize(); err != nil {
+		return Cluster{}, err
+	}
+	defer client.Consumer.Close()
+
+	metadata, err := client.Consumer.GetMetadata(nil, true, timeoutMs(ctx, METADATA_TIMEOUT))
+	if err != nil {
+		return Cluster{}, classifyError(err)
+	}
+	cluster := Cluster{
+		Brokers:           make([]Broker, 0, len(metadata.Brokers)),
+		OriginatingBroker: metadata.OriginatingBroker.ID,
+		Topics:            len(metadata.Topics),
+		Platform:          client.Platform,
+	}
+	for _, b := range metadata.Brokers {
+		cluster.Brokers = append(cluster.Brokers, Broker{ID: b.ID, Host: b.Host, Port: b.Port})
+	}
+	sort.Slice(cluster.Brokers, func(i, j int) bool {
+		return cluster.Brokers[i].ID < cluster.Brokers[j].ID
+	})
+	return cluster, nil
+}
@@ -0,0 +1,280 @@
+package kafka_client
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+const (
+	DEFAULT_JSON_MAX_DEPTH         = 64
+	DEFAULT_JSON_MAX_SIZE          = 4 << 20
+	DEFAULT_JSON_MAX_STRING_LENGTH = 1 << 20
+)
+
+// JSONLimits bounds the JSON documents accepted by the decoder, so that
+// pathological messages are rejected before being parsed into memory.
+type JSONLimits struct {
+	MaxDepth        int
+	MaxSize         int
+	MaxStringLength int
+}
+
+func newJSONLimits(options Options) JSONLimits {
+	limits := JSONLimits{
+		MaxDepth:        options.JSONMaxDepth,
+		MaxSize:         options.JSONMaxSize,
+		MaxStringLength: options.JSONMaxStringLength,
+	}
+	if limits.MaxDepth <= 0 {
+		limits.MaxDepth = DEFAULT_JSON_MAX_DEPTH
+	}
+	if limits.MaxSize <= 0 {
+		limits.MaxSize = DEFAULT_JSON_MAX_SIZE
+	}
+	if limits.MaxStringLength <= 0 {
+		limits.MaxStringLength = DEFAULT_JSON_MAX_STRING_LENGTH
+	}
+	return limits
+}
+
+// check scans the raw document once, without allocating, and returns an
+// error if it exceeds any of the limits. Zero limits are not enforced.
+func (limits JSONLimits) check(b []byte) error {
+	if limits.MaxSize > 0 && len(b) > limits.MaxSize {
+		return fmt.Errorf("message size of %d bytes exceeds the limit of %d bytes", len(b), limits.MaxSize)
+	}
+
+	depth := 0
+	inString, escaped := false, false
+	stringStart := 0
+	for i, c := range b {
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+				length := i - stringStart - 1
+				if limits.MaxStringLength > 0 && length > limits.MaxStringLength {
+					return fmt.Errorf("string of %d bytes exceeds the limit of %d bytes", length, limits.MaxStringLength)
+				}
+			}
+			continue
+		}
+
+		switch c {
+		case '"':
+			inString = true
+			stringStart = i
+		case '{', '[':
+			depth++
+			if limits.MaxDepth > 0 && depth > limits.MaxDepth {
+				return fmt.Errorf("nesting depth exceeds the limit of %d", limits.MaxDepth)
+			}
+		case '}', ']':
+			depth--
+		}
+	}
+
+	return nil
+}
+
+// Formats of message values.
+const (
+	MESSAGE_FORMAT_JSON = "json"
+	// MESSAGE_FORMAT_JSON_SCHEMA is JSON prefixed with the magic byte and
+	// schema ID of the Confluent JSON Schema serializer.
+	MESSAGE_FORMAT_JSON_SCHEMA = "jsonSchema"
+	// MESSAGE_FORMAT_RAW skips decoding, e.g. for binary or plain text
+	// messages like log lines, and keeps the whole value as a single string
+	// field, from which a pattern may extract fields.
+	MESSAGE_FORMAT_RAW = "raw"
+	// MESSAGE_FORMAT_MSGPACK is MessagePack, as emitted by many IoT
+	// producers for compactness.
+	MESSAGE_FORMAT_MSGPACK = "msgpack"
+	// MESSAGE_FORMAT_CBOR is CBOR, as published by CoAP and IoT pipelines.
+	MESSAGE_FORMAT_CBOR = "cbor"
+)
+
+// RAW_MESSAGE_FIELD is the field holding the value of raw messages.
+const RAW_MESSAGE_FIELD = "message"
+
+// SCHEMA_HEADER_SIZE is the size of the magic byte and the big-endian schema
+// ID put before the payload by Confluent serializers.
+const SCHEMA_HEADER_SIZE = 5
+
+// ValidateMessageFormat returns an error for unknown message formats, which
+// are neither built in nor registered. An empty format is JSON.
+func ValidateMessageFormat(format string) error {
+	if format == "" || builtinMessageFormat(format) {
+		return nil
+	}
+	if _, ok := registeredDecoder(format); ok {
+		return nil
+	}
+	return fmt.Errorf("unknown message format %q", format)
+}
+
+func builtinMessageFormat(format string) bool {
+	switch format {
+	case MESSAGE_FORMAT_JSON, MESSAGE_FORMAT_JSON_SCHEMA, MESSAGE_FORMAT_RAW, MESSAGE_FORMAT_MSGPACK,
+		MESSAGE_FORMAT_CBOR:
+		return true
+	}
+	return false
+}
+
+// CompileRawPattern compiles the pattern extracting the fields of raw
+// messages from their named capture groups, or returns nil if empty.
+func CompileRawPattern(pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid raw pattern: %w", err)
+	}
+	named := false
+	for _, name := range re.SubexpNames() {
+		if name == RAW_MESSAGE_FIELD {
+			return nil, fmt.Errorf("invalid raw pattern: the %s group would hide the message", name)
+		}
+		named = named || name != ""
+	}
+	if !named {
+		return nil, errors.New("invalid raw pattern: no named capture group, e.g. (?P<level>\\w+)")
+	}
+	return re, nil
+}
+
+// decode decodes a message value into either an object or the items of an
+// array.
+func (client *KafkaClient) decode(b []byte) (map[string]interface{}, []interface{}, error) {
+	switch client.MessageFormat {
+	case MESSAGE_FORMAT_RAW:
+		return client.decodeRaw(b), nil, nil
+	case MESSAGE_FORMAT_MSGPACK:
+		return splitItems(decodeMsgpack(b, client.JSONLimits))
+	case MESSAGE_FORMAT_CBOR:
+		return splitItems(decodeCBOR(b, client.JSONLimits))
+	case MESSAGE_FORMAT_JSON_SCHEMA:
+		payload, _, err := stripSchemaHeader(b)
+		if err != nil {
+			return nil, nil, err
+		}
+		b = payload
+	case "", MESSAGE_FORMAT_JSON:
+	default:
+		if decoder, ok := registeredDecoder(client.MessageFormat); ok {
+			return splitItems(decodeRegistered(decoder, b, client.JSONLimits))
+		}
+	}
+	if trimmed := bytes.TrimLeft(b, " \t\r\n"); len(trimmed) > 0 && trimmed[0] == '[' {
+		return splitItems(decodeJSONArray(b, client.JSONLimits))
+	}
+	value, err := decodeJSON(b, client.JSONLimits)
+	return value, nil, err
+}
+
+// decodeRaw keeps the value as the message field, along with the named
+// capture groups of the raw pattern if it matches.
+func (client *KafkaClient) decodeRaw(b []byte) map[string]interface{} {
+	value := map[string]interface{}{RAW_MESSAGE_FIELD: string(b)}
+	if client.RawPattern == nil {
+		return value
+	}
+	match := client.RawPattern.FindSubmatch(b)
+	if match == nil {
+		return value
+	}
+	for i, name := range client.RawPattern.SubexpNames() {
+		if name != "" && match[i] != nil {
+			value[name] = string(match[i])
+		}
+	}
+	return value
+}
+
+// splitItems tells decoded objects from arrays.
+func splitItems(value interface{}, err error) (map[string]interface{}, []interface{}, error) {
+	if err != nil {
+		return nil, nil, err
+	}
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return v, nil, nil
+	case []interface{}:
+		return nil, v, nil
+	}
+	return nil, nil, fmt.Errorf("message is a %T, not an object or an array", value)
+}
+
+// stripSchemaHeader returns the payload of a message in the Confluent wire
+// format, along with the ID of its schema in the Schema Registry.
+func stripSchemaHeader(b []byte) ([]byte, uint32, error) {
+	if len(b) < SCHEMA_HEADER_SIZE || b[0] != 0 {
+		return nil, 0, errors.New("message is not in the Confluent wire format, expected a magic byte and a schema ID")
+	}
+	return b[SCHEMA_HEADER_SIZE:], binary.BigEndian.Uint32(b[1:SCHEMA_HEADER_SIZE]), nil
+}
+
+func decodeJSON(b []byte, limits JSONLimits) (map[string]interface{}, error) {
+	if err := limits.check(b); err != nil {
+		return nil, err
+	}
+
+	var value map[string]interface{}
+	if err := json.Unmarshal(b, &value); err != nil {
+		return decodePartialJSON(b), err
+	}
+	return value, nil
+}
+
+func decodeJSONArray(b []byte, limits JSONLimits) (interface{}, error) {
+	if err := limits.check(b); err != nil {
+		return nil, err
+	}
+
+	var items []interface{}
+	if err := json.Unmarshal(b, &items); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// decodePartialJSON returns the top-level members of an object decoded
+// before the first syntax error, e.g. of a truncated message, or nil if none
+// could be decoded.
+func decodePartialJSON(b []byte) map[string]interface{} {
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	if token, err := decoder.Token(); err != nil || token != json.Delim('{') {
+		return nil
+	}
+
+	var value map[string]interface{}
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			break
+		}
+		key, ok := token.(string)
+		if !ok {
+			break
+		}
+		var member interface{}
+		if err := decoder.Decode(&member); err != nil {
+			break
+		}
+		if value == nil {
+			value = make(map[string]interface{})
+		}
+		value[key] = member
+	}
+	return value
+}
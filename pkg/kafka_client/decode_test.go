@@ -0,0 +1,190 @@
+package kafka_client
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hoptical/grafana-kafka-datasource/pkg/testdata"
+)
+
+func TestDecodeJSONLimits(t *testing.T) {
+	limits := JSONLimits{MaxDepth: 2, MaxSize: 64, MaxStringLength: 8}
+
+	tests := []struct {
+		name    string
+		input   string
+		wantErr bool
+	}{
+		{"within limits", `{"a": {"b": "short"}}`, false},
+		{"too deep", `{"a": {"b": [1]}}`, true},
+		{"too large", `{"a": "` + strings.Repeat("x", 64) + `"}`, true},
+		{"string too long", `{"a": "longer than 8"}`, true},
+		{"escaped quotes", `{"a": "\"\"\""}`, false},
+		{"brackets in strings", `{"a": "[[[{{{"}`, false},
+		{"invalid json", `{"a": `, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := decodeJSON([]byte(tt.input), limits)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("expected error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestDecodePartialJSON(t *testing.T) {
+	value, err := decodeJSON([]byte(`{"a": 1, "b": {"c": "d"}, "e": [1, `), JSONLimits{})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if len(value) != 2 || value["a"] != 1.0 || value["b"] == nil {
+		t.Errorf("expected the fields decoded before the error, got %v", value)
+	}
+
+	if value, _ := decodeJSON([]byte(`[1, 2`), JSONLimits{}); value != nil {
+		t.Errorf("expected no fields out of a non-object, got %v", value)
+	}
+}
+
+func TestDecodeJSONSchema(t *testing.T) {
+	client := KafkaClient{MessageFormat: MESSAGE_FORMAT_JSON_SCHEMA}
+	message := append([]byte{0, 0, 0, 1, 2}, `{"a":1}`...)
+
+	value, _, err := client.decode(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value["a"] != 1.0 {
+		t.Errorf("expected the payload after the header, got %v", value)
+	}
+	if _, id, _ := stripSchemaHeader(message); id != 258 {
+		t.Errorf("expected schema ID 258, got %d", id)
+	}
+
+	for _, b := range [][]byte{[]byte(`{"a":1}`), {0, 0, 1}} {
+		if _, _, err := client.decode(b); err == nil {
+			t.Errorf("expected an error for %q without the wire format header", b)
+		}
+	}
+
+	if err := ValidateMessageFormat("avro"); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
+
+func TestDecodeRaw(t *testing.T) {
+	client := KafkaClient{MessageFormat: MESSAGE_FORMAT_RAW, JSONLimits: JSONLimits{MaxSize: 4}}
+	value, _, err := client.decode([]byte(`{"not": "parsed"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(value) != 1 || value[RAW_MESSAGE_FIELD] != `{"not": "parsed"}` {
+		t.Errorf("expected the raw value, got %v", value)
+	}
+
+	pattern, err := CompileRawPattern(`^(?P<level>[A-Z]+) (?:user=(?P<user>\w+) )?(.*)$`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.RawPattern = pattern
+	for _, tc := range []struct {
+		line     string
+		expected map[string]interface{}
+	}{
+		{"WARN user=bob disk full", map[string]interface{}{"level": "WARN", "user": "bob"}},
+		{"INFO started", map[string]interface{}{"level": "INFO"}},
+		{"not a log line", map[string]interface{}{}},
+	} {
+		value, _, err := client.decode([]byte(tc.line))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tc.expected[RAW_MESSAGE_FIELD] = tc.line
+		if !reflect.DeepEqual(value, tc.expected) {
+			t.Errorf("%q: expected %v, got %v", tc.line, tc.expected, value)
+		}
+	}
+
+	for _, pattern := range []string{`(unclosed`, `^\w+$`, `(?P<message>.*)`} {
+		if _, err := CompileRawPattern(pattern); err == nil {
+			t.Errorf("%q: expected an invalid pattern to fail", pattern)
+		}
+	}
+}
+
+func TestDecodeJSONArray(t *testing.T) {
+	client := KafkaClient{}
+	value, items, err := client.decode([]byte(` [{"a": 1}, 2]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != nil || len(items) != 2 || items[1] != 2.0 {
+		t.Errorf("expected the items of the array, got %v, %v", value, items)
+	}
+
+	if _, _, err := client.decode([]byte(`"text"`)); err == nil {
+		t.Error("expected an error for a message that is neither an object nor an array")
+	}
+}
+
+func TestDecodeTestdata(t *testing.T) {
+	at := time.Date(2021, 6, 1, 12, 0, 0, 500000000, time.UTC)
+	value := map[string]interface{}{
+		"name":   "sensor",
+		"count":  3.0,
+		"ratio":  -0.25,
+		"on":     true,
+		"unset":  nil,
+		"tags":   []interface{}{"a", -40.0},
+		"nested": map[string]interface{}{"big": 70000.0},
+	}
+	withTime := func(v interface{}) map[string]interface{} {
+		m := map[string]interface{}{"at": v}
+		for k, v := range value {
+			m[k] = v
+		}
+		return m
+	}
+
+	tests := []struct {
+		format   string
+		message  []byte
+		expected map[string]interface{}
+	}{
+		{MESSAGE_FORMAT_JSON, testdata.JSON(value), value},
+		{MESSAGE_FORMAT_JSON_SCHEMA, testdata.JSONSchema(7, value), value},
+		{MESSAGE_FORMAT_MSGPACK, testdata.MessagePack(withTime(at)), withTime("2021-06-01T12:00:00.5Z")},
+		{MESSAGE_FORMAT_CBOR, testdata.CBOR(withTime(at)), withTime("2021-06-01T12:00:00.5Z")},
+	}
+	for _, tt := range tests {
+		client := KafkaClient{MessageFormat: tt.format}
+		decoded, _, err := client.decode(tt.message)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.format, err)
+		}
+		if !reflect.DeepEqual(decoded, tt.expected) {
+			t.Errorf("%s: expected %v, got %v", tt.format, tt.expected, decoded)
+		}
+	}
+
+	client := KafkaClient{}
+	event, _, err := client.decode(testdata.CloudEvent("1", "/sensors", "reading", at, value))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event["type"] != "reading" || !reflect.DeepEqual(event["data"], value) {
+		t.Errorf("expected the CloudEvent with its data, got %v", event)
+	}
+	change, _, err := client.decode(testdata.Debezium(testdata.DebeziumUpdate, "sensors",
+		map[string]interface{}{"id": 1}, map[string]interface{}{"id": 1, "name": "b"}, at))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if change["op"] != "u" || change["after"].(map[string]interface{})["name"] != "b" {
+		t.Errorf("expected the Debezium change, got %v", change)
+	}
+}
@@ -0,0 +1,152 @@
+package kafka_client
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// PartitionError is the error of a single partition that could not be
+// assigned.
+type PartitionError struct {
+	Partition int32
+	Err       error
+}
+
+func (e PartitionError) Error() string {
+	return fmt.Sprintf("partition %d: %v", e.Partition, e.Err)
+}
+
+func (e PartitionError) Unwrap() error {
+	return e.Err
+}
+
+// PartitionErrors aggregates the errors of the partitions of a topic that
+// could not be assigned. The remaining Assigned partitions are still consumed.
+type PartitionErrors struct {
+	Topic    string
+	Errors   []PartitionError
+	Assigned []int32
+}
+
+func (e *PartitionErrors) Error() string {
+	messages := make([]string, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		messages = append(messages, err.Error())
+	}
+	return fmt.Sprintf("%d of %d partitions of topic %s failed: %s",
+		len(e.Errors), len(e.Errors)+len(e.Assigned), e.Topic, strings.Join(messages, "; "))
+}
+
+// Is reports whether any of the partition errors matches target.
+func (e *PartitionErrors) Is(target error) bool {
+	for _, err := range e.Errors {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// Partial reports whether some partitions were assigned despite the errors.
+func (e *PartitionErrors) Partial() bool {
+	return len(e.Assigned) > 0
+}
+
+// Failed returns the partitions that could not be assigned.
+func (e *PartitionErrors) Failed() []int32 {
+	partitions := make([]int32, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		partitions = append(partitions, err.Partition)
+	}
+	return partitions
+}
+
+// ErrTopicNotFound is matched by the errors of topics that don't exist.
+var ErrTopicNotFound = errors.New("topic not found")
+
+// MAX_TOPIC_SUGGESTIONS bounds the existing topics suggested for a missing
+// one.
+const MAX_TOPIC_SUGGESTIONS = 3
+
+// TopicNotFoundError tells that a topic doesn't exist, along with the
+// existing topics whose name is the closest, most likely what was meant.
+type TopicNotFoundError struct {
+	Topic       string
+	Suggestions []string
+}
+
+func (e *TopicNotFoundError) Error() string {
+	if len(e.Suggestions) == 0 {
+		return fmt.Sprintf("topic %s does not exist", e.Topic)
+	}
+	return fmt.Sprintf("topic %s does not exist, did you mean %s?", e.Topic, strings.Join(e.Suggestions, ", "))
+}
+
+func (e *TopicNotFoundError) Is(target error) bool {
+	return target == ErrTopicNotFound
+}
+
+// suggestTopics returns up to MAX_TOPIC_SUGGESTIONS topics within an edit
+// distance of a third of the length of the missing topic, at least 2, closest
+// first. Case differences don't count.
+func suggestTopics(topic string, topics []string) []string {
+	maxDistance := utf8.RuneCountInString(topic) / 3
+	if maxDistance < 2 {
+		maxDistance = 2
+	}
+
+	distances := make(map[string]int)
+	var suggestions []string
+	for _, t := range topics {
+		if d := editDistance(strings.ToLower(topic), strings.ToLower(t)); d <= maxDistance {
+			distances[t] = d
+			suggestions = append(suggestions, t)
+		}
+	}
+	sort.Slice(suggestions, func(i, j int) bool {
+		a, b := suggestions[i], suggestions[j]
+		if distances[a] != distances[b] {
+			return distances[a] < distances[b]
+		}
+		return a < b
+	})
+	if len(suggestions) > MAX_TOPIC_SUGGESTIONS {
+		suggestions = suggestions[:MAX_TOPIC_SUGGESTIONS]
+	}
+	return suggestions
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	previous := make([]int, len(rb)+1)
+	current := make([]int, len(rb)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		current[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			current[j] = min3(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(rb)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
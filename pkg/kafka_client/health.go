@@ -0,0 +1,118 @@
+package kafka_client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HEALTH_ATTEMPTS is the number of times an unreachable broker is probed
+// before being reported as failed.
+const HEALTH_ATTEMPTS = 3
+
+// HEALTH_TIMEOUT bounds a single probe of a broker.
+const HEALTH_TIMEOUT = 2 * time.Second
+
+// HEALTH_BACKOFF is the delay before retrying to probe a broker, doubled for
+// every further retry.
+const HEALTH_BACKOFF = 250 * time.Millisecond
+
+// BrokerHealth is the result of probing a single bootstrap server.
+type BrokerHealth struct {
+	Broker   string
+	Attempts int
+	// Err is set if the broker couldn't be reached by any attempt.
+	Err error
+}
+
+// HealthCheck probes every bootstrap server concurrently with a few bounded attempts and
+// returns their results. It only fails, wrapping ErrBrokerUnreachable, when
+// none of them could be reached.
+func (client KafkaClient) HealthCheck(ctx context.Context) ([]BrokerHealth, error) {
+	brokers := client.brokers()
+	if len(brokers) == 0 {
+		return nil, errors.New("no bootstrap servers configured")
+	}
+
+	// Brokers are probed concurrently, so that dead ones don't delay the
+	// others.
+	results := make([]BrokerHealth, len(brokers))
+	var wg sync.WaitGroup
+	for i, broker := range brokers {
+		wg.Add(1)
+		go func(i int, broker string) {
+			defer wg.Done()
+			results[i] = client.checkBroker(ctx, broker)
+		}(i, broker)
+	}
+	wg.Wait()
+
+	failed := len(FailedBrokers(results))
+	if failed == len(results) {
+		return results, fmt.Errorf("%w: none of the %d brokers could be reached", ErrBrokerUnreachable, failed)
+	}
+	return results, nil
+}
+
+// FailedBrokers returns the brokers of the results that couldn't be reached.
+func FailedBrokers(results []BrokerHealth) []string {
+	var failed []string
+	for _, health := range results {
+		if health.Err != nil {
+			failed = append(failed, health.Broker)
+		}
+	}
+	return failed
+}
+
+// brokers returns the individual bootstrap servers.
+func (client KafkaClient) brokers() []string {
+	var brokers []string
+	for _, broker := range strings.Split(client.BootstrapServers, ",") {
+		if broker = strings.TrimSpace(broker); broker != "" {
+			brokers = append(brokers, broker)
+		}
+	}
+	return brokers
+}
+
+// checkBroker probes the broker until it answers, retrying with an
+// exponential backoff up to HEALTH_ATTEMPTS times.
+func (client KafkaClient) checkBroker(ctx context.Context, broker string) BrokerHealth {
+	health := BrokerHealth{Broker: broker}
+	backoff := HEALTH_BACKOFF
+	for {
+		health.Attempts++
+		health.Err = client.probeBroker(ctx, broker)
+		if health.Err == nil || health.Attempts == HEALTH_ATTEMPTS {
+			return health
+		}
+
+		select {
+		case <-ctx.Done():
+			return health
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// probeBroker requests the cluster metadata from the broker alone. Errors
+// returned by a reachable broker don't count as failures.
+func (client KafkaClient) probeBroker(ctx context.Context, broker string) error {
+	client.BootstrapServers = broker
+	if err := client.consumerInitialize(); err != nil {
+		return err
+	}
+	defer client.Consumer.Close()
+
+	topic := ""
+	_, err := client.Consumer.GetMetadata(&topic, false, timeoutMs(ctx, HEALTH_TIMEOUT))
+	if err = classifyError(err); errors.Is(err, ErrBrokerUnreachable) {
+		return err
+	}
+	return nil
+}
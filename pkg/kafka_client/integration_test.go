@@ -0,0 +1,415 @@
+//go:build integration
+// +build integration
+
+package kafka_client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+
+	"github.com/hoptical/grafana-kafka-datasource/pkg/testdata"
+)
+
+// The integration tests run against the broker of KAFKA_BOOTSTRAP_SERVERS,
+// localhost:9092 by default, e.g. a Redpanda container, and are skipped when
+// the default broker can't be reached. CI runs them against a Kafka service
+// container:
+//
+//	docker run -d -p 9092:9092 docker.redpanda.com/redpandadata/redpanda \
+//		redpanda start --overprovisioned --smp 1 --kafka-addr 0.0.0.0:9092 \
+//		--advertise-kafka-addr localhost:9092
+//	go test -tags=integration ./pkg/...
+//
+// Running them against both Kafka and Redpanda catches their differences.
+// The Event Hubs tests run when EVENT_HUBS_NAMESPACE, e.g.
+// ns.servicebus.windows.net:9093, EVENT_HUBS_CONNECTION_STRING and
+// EVENT_HUBS_TOPIC, an event hub holding messages, are set.
+
+// createTopic creates a topic of its own for the test, with the messages
+// produced to its partitions in order, one millisecond apart from start.
+func createTopic(t *testing.T, partitions int, start time.Time, messages ...testdata.Message) string {
+	t.Helper()
+	testdata.RequireBroker(t)
+	topic := fmt.Sprintf("integration-%s-%d", t.Name(), time.Now().UnixNano())
+	config := kafka.ConfigMap{"bootstrap.servers": testdata.BootstrapServers()}
+
+	admin, err := kafka.NewAdminClient(&config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Cleanups run last in first out, so the topic is deleted first.
+	t.Cleanup(admin.Close)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	results, err := admin.CreateTopics(ctx, []kafka.TopicSpecification{
+		{Topic: topic, NumPartitions: partitions, ReplicationFactor: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Error.Code() != kafka.ErrNoError {
+		t.Fatal(results[0].Error)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		_, _ = admin.DeleteTopics(ctx, []string{topic})
+	})
+
+	producer, err := kafka.NewProducer(&config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer producer.Close()
+	for i, msg := range messages {
+		err := producer.Produce(&kafka.Message{
+			TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: int32(i % partitions)},
+			Key:            msg.Key,
+			Value:          msg.Value,
+			Timestamp:      start.Add(time.Duration(i) * time.Millisecond),
+		}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if left := producer.Flush(30000); left > 0 {
+		t.Fatalf("%d messages not produced", left)
+	}
+	return topic
+}
+
+// counters returns messages whose JSON values count from 0 to n-1 in their
+// n field.
+func counters(n int) []testdata.Message {
+	messages := make([]testdata.Message, n)
+	for i := range messages {
+		messages[i].Value = testdata.JSON(map[string]interface{}{"n": i})
+	}
+	return messages
+}
+
+func integrationClient() KafkaClient {
+	return NewKafkaClient(Options{BootstrapServers: testdata.BootstrapServers()})
+}
+
+func TestIntegrationReadMessage(t *testing.T) {
+	topic := createTopic(t, 1, time.Now(), counters(3)...)
+	ctx := context.Background()
+
+	msg, err := integrationClient().ReadMessage(ctx, topic, 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Offset != 1 || msg.Value["n"] != 1.0 {
+		t.Errorf("expected the message at offset 1, got %+v", msg)
+	}
+
+	msg, err = integrationClient().LatestMessage(ctx, topic, ALL_PARTITIONS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Value["n"] != 2.0 {
+		t.Errorf("expected the latest message, got %+v", msg)
+	}
+
+	if _, err := integrationClient().ReadMessage(ctx, topic, 0, 10); !errors.Is(err, ErrMessageNotFound) {
+		t.Errorf("expected a missing offset not to be found, got %v", err)
+	}
+	if _, err := integrationClient().ReadMessage(ctx, topic+"-missing", 0, 0); !errors.Is(err, ErrTopicNotFound) {
+		t.Errorf("expected a missing topic not to be found, got %v", err)
+	}
+}
+
+func TestIntegrationTopicAssign(t *testing.T) {
+	topic := createTopic(t, 2, time.Now(), counters(4)...)
+
+	for _, tc := range []struct {
+		autoOffsetReset string
+		expected        int
+	}{
+		{"earliest", 4},
+		{"latest", 0},
+	} {
+		client := integrationClient()
+		if err := client.TopicAssign(context.Background(), topic, ALL_PARTITIONS, tc.autoOffsetReset, "message"); err != nil {
+			t.Fatal(err)
+		}
+
+		consumed := 0
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+			if _, ok := client.Consumer.Poll(100).(*kafka.Message); ok {
+				consumed++
+			}
+		}
+		client.Dispose()
+		if consumed != tc.expected {
+			t.Errorf("%s: expected %d messages, got %d", tc.autoOffsetReset, tc.expected, consumed)
+		}
+	}
+}
+
+func TestIntegrationReadRange(t *testing.T) {
+	start := time.Now().Add(-time.Hour).Truncate(time.Millisecond)
+	topic := createTopic(t, 3, start, counters(10)...)
+	ctx := context.Background()
+
+	from, to := start.Add(2*time.Millisecond), start.Add(7*time.Millisecond)
+	result, err := integrationClient().ReadRange(ctx, topic, ALL_PARTITIONS, from, to, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Messages) != 6 || result.LimitReached || result.Expired {
+		t.Fatalf("expected the 6 messages of the range, got %+v", result)
+	}
+	for i, msg := range result.Messages {
+		if msg.Value["n"] != float64(i+2) {
+			t.Errorf("expected the messages in timestamp order, got %v at %d", msg.Value, i)
+		}
+	}
+
+	result, err = integrationClient().ReadRange(ctx, topic, ALL_PARTITIONS, from, to, 3, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Messages) != 3 || !result.LimitReached {
+		t.Errorf("expected the range to stop at 3 messages, got %+v", result)
+	}
+
+	size, err := integrationClient().RangeSize(ctx, topic, ALL_PARTITIONS, from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != 6 {
+		t.Errorf("expected a range size of 6, got %d", size)
+	}
+}
+
+func TestIntegrationMessageFormats(t *testing.T) {
+	value := map[string]interface{}{"host": "a", "load": 0.5}
+	topic := createTopic(t, 1, time.Now(),
+		testdata.Message{Value: testdata.JSON(value)},
+		testdata.Message{Value: testdata.JSONSchema(1, value)},
+		testdata.Message{Value: testdata.MessagePack(value)},
+		testdata.Message{Value: testdata.CBOR(value)},
+		testdata.Message{Value: testdata.CloudEvent("1", "/hosts", "load", time.Now(), value)},
+		testdata.Tombstone([]byte("a")),
+	)
+	ctx := context.Background()
+
+	for offset, format := range []string{MESSAGE_FORMAT_JSON, MESSAGE_FORMAT_JSON_SCHEMA, MESSAGE_FORMAT_MSGPACK,
+		MESSAGE_FORMAT_CBOR} {
+		client := integrationClient()
+		client.MessageFormat = format
+		msg, err := client.ReadMessage(ctx, topic, 0, int64(offset))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg.Err != nil || msg.Value["host"] != "a" || msg.Value["load"] != 0.5 {
+			t.Errorf("%s: expected the decoded value, got %v (%v)", format, msg.Value, msg.Err)
+		}
+	}
+
+	msg, err := integrationClient().ReadMessage(ctx, topic, 0, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Value["data"] == nil {
+		t.Errorf("expected the data of the CloudEvent, got %v", msg.Value)
+	}
+	msg, err = integrationClient().ReadMessage(ctx, topic, 0, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(msg.Key) != "a" || len(msg.RawValue) != 0 {
+		t.Errorf("expected the tombstone of key a, got %+v", msg)
+	}
+}
+
+func TestIntegrationConsumerLag(t *testing.T) {
+	topic := createTopic(t, 2, time.Now(), counters(5)...)
+	group := topic + "-group"
+	ctx := context.Background()
+
+	lags, err := integrationClient().ConsumerLag(ctx, group, topic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var total int64
+	for _, lag := range lags {
+		if lag.Committed != -1 {
+			t.Errorf("expected no committed offset, got %+v", lag)
+		}
+		total += lag.Lag
+	}
+	if len(lags) != 2 || total != 5 {
+		t.Errorf("expected every message of both partitions to lag, got %+v", lags)
+	}
+}
+
+func TestIntegrationClusterMetadata(t *testing.T) {
+	testdata.RequireBroker(t)
+	cluster, err := integrationClient().ClusterMetadata(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cluster.Brokers) == 0 || cluster.Brokers[0].Host == "" {
+		t.Errorf("expected the brokers of the cluster, got %+v", cluster)
+	}
+	if cluster.Platform != PLATFORM_KAFKA {
+		t.Errorf("expected the %s platform, got %q", PLATFORM_KAFKA, cluster.Platform)
+	}
+}
+
+func TestIntegrationReadNew(t *testing.T) {
+	topic := createTopic(t, 2, time.Now(), counters(5)...)
+	ctx := context.Background()
+	client := integrationClient()
+
+	result, err := client.ReadNew(ctx, topic, ALL_PARTITIONS, nil, "earliest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[int32]int64{0: 3, 1: 2}
+	if len(result.Messages) != 5 || !reflect.DeepEqual(result.Cursor, expected) {
+		t.Errorf("expected every message and a cursor of %v, got %d messages and %v", expected, len(result.Messages),
+			result.Cursor)
+	}
+
+	result, err = client.ReadNew(ctx, topic, ALL_PARTITIONS, result.Cursor, "earliest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Messages) != 0 || !reflect.DeepEqual(result.Cursor, expected) {
+		t.Errorf("expected no new message, got %d messages and %v", len(result.Messages), result.Cursor)
+	}
+}
+
+func TestIntegrationTopicConfig(t *testing.T) {
+	topic := createTopic(t, 3, time.Now())
+
+	config, err := integrationClient().TopicConfig(context.Background(), topic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Partitions != 3 || config.ReplicationFactor != 1 {
+		t.Errorf("expected 3 partitions of a single replica, got %+v", config)
+	}
+	if config.Configs["cleanup.policy"] != "delete" || config.Configs["retention.ms"] == "" {
+		t.Errorf("expected the default configs, got %v", config.Configs)
+	}
+}
+
+func TestIntegrationReadPage(t *testing.T) {
+	topic := createTopic(t, 2, time.Now(), counters(5)...)
+	ctx := context.Background()
+	client := integrationClient()
+
+	var cursor map[int32]int64
+	var read int
+	for page := 0; ; page++ {
+		if page > 5 {
+			t.Fatal("expected the pages to end")
+		}
+		result, err := client.ReadPage(ctx, topic, ALL_PARTITIONS, cursor, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(result.Messages) > 2 {
+			t.Fatalf("expected pages of 2 messages at most, got %d", len(result.Messages))
+		}
+		read += len(result.Messages)
+		cursor = result.Cursor
+		if result.End {
+			break
+		}
+	}
+	if read != 5 {
+		t.Errorf("expected to page through the 5 messages, got %d", read)
+	}
+}
+
+func TestIntegrationPartitionOffsets(t *testing.T) {
+	start := time.Now().Add(-time.Minute)
+	topic := createTopic(t, 1, start, counters(5)...)
+
+	offsets, err := integrationClient().PartitionOffsets(context.Background(), topic, start.Add(3*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(offsets) != 1 || offsets[0].Earliest != 0 || offsets[0].Latest != 5 {
+		t.Fatalf("expected the watermarks of 5 messages, got %+v", offsets)
+	}
+	if offsets[0].AtTimestamp == nil || *offsets[0].AtTimestamp != 3 {
+		t.Errorf("expected the offset of the fourth message, got %+v", offsets[0])
+	}
+}
+
+func TestIntegrationProduce(t *testing.T) {
+	topic := createTopic(t, 1, time.Now(), counters(2)...)
+	ctx := context.Background()
+
+	produced, err := integrationClient().Produce(ctx, topic, []byte("alert"), []byte(`{"n": 2}`),
+		map[string]string{"source": "grafana"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if produced.Partition != 0 || produced.Offset != 2 {
+		t.Fatalf("expected the message after the existing ones, got %+v", produced)
+	}
+	msg, err := integrationClient().ReadMessage(ctx, topic, 0, produced.Offset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(msg.Key) != "alert" || msg.Value["n"] != 2.0 || string(msg.Headers["source"]) != "grafana" {
+		t.Errorf("unexpected message %+v", msg)
+	}
+}
+
+func TestIntegrationHealthCheck(t *testing.T) {
+	testdata.RequireBroker(t)
+	brokers, err := integrationClient().HealthCheck(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failed := FailedBrokers(brokers); len(failed) > 0 {
+		t.Errorf("expected every broker to answer, got %v failing", failed)
+	}
+}
+
+func TestIntegrationEventHubs(t *testing.T) {
+	namespace, topic := os.Getenv("EVENT_HUBS_NAMESPACE"), os.Getenv("EVENT_HUBS_TOPIC")
+	if namespace == "" || topic == "" {
+		t.Skip("EVENT_HUBS_NAMESPACE and EVENT_HUBS_TOPIC are not set")
+	}
+	client := NewKafkaClient(Options{
+		BootstrapServers: namespace,
+		ConnectionString: os.Getenv("EVENT_HUBS_CONNECTION_STRING"),
+	})
+	if client.Platform != PLATFORM_EVENT_HUBS {
+		t.Fatalf("expected %s to be detected as Event Hubs", namespace)
+	}
+	ctx := context.Background()
+
+	brokers, err := client.HealthCheck(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failed := FailedBrokers(brokers); len(failed) > 0 {
+		t.Fatalf("expected the namespace to answer, got %v failing", failed)
+	}
+	if _, err := client.LatestMessage(ctx, topic, ALL_PARTITIONS); err != nil {
+		t.Error(err)
+	}
+	size, err := client.RangeSize(ctx, topic, ALL_PARTITIONS, time.Now().Add(-24*time.Hour), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("%d messages in the last day", size)
+}
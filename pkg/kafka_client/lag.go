@@ -0,0 +1,66 @@
+package kafka_client
+
+import (
+	"context"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+// PartitionLag is the lag of a consumer group on a partition: the number of
+// messages between its committed offset and the end of the partition.
+// Committed is -1 when the group has no committed offset for the partition,
+// in which case, as for offsets removed by retention, every retained message
+// counts as lag.
+type PartitionLag struct {
+	Partition int32 `json:"partition"`
+	Committed int64 `json:"committed"`
+	End       int64 `json:"end"`
+	Lag       int64 `json:"lag"`
+}
+
+// ConsumerLag returns the lag of the consumer group on every partition of
+// the topic, from the offsets the group committed. The group isn't joined,
+// so its members aren't disturbed.
+func (client KafkaClient) ConsumerLag(ctx context.Context, group, topic string) ([]PartitionLag, error) {
+	client.GroupID = group
+	if err := client.consumerInitialize(); err != nil {
+		return nil, err
+	}
+	defer client.Consumer.Close()
+
+	partitions, err := client.topicPartitions(ctx, topic, ALL_PARTITIONS)
+	if err != nil {
+		return nil, err
+	}
+	var requested []kafka.TopicPartition
+	for _, p := range partitions {
+		if p.Error.Code() == kafka.ErrNoError {
+			requested = append(requested, kafka.TopicPartition{Topic: &topic, Partition: p.ID})
+		}
+	}
+	committed, err := client.Consumer.Committed(requested, timeoutMs(ctx, METADATA_TIMEOUT))
+	if err != nil {
+		return nil, classifyError(err)
+	}
+
+	lags := make([]PartitionLag, 0, len(committed))
+	for _, p := range committed {
+		low, high, err := client.Consumer.QueryWatermarkOffsets(topic, p.Partition, timeoutMs(ctx, METADATA_TIMEOUT))
+		if err != nil {
+			return nil, classifyError(err)
+		}
+		lag := PartitionLag{Partition: p.Partition, Committed: -1, End: high}
+		start := low
+		if p.Error == nil && p.Offset >= 0 {
+			lag.Committed = int64(p.Offset)
+			if lag.Committed > low {
+				start = lag.Committed
+			}
+		}
+		if high > start {
+			lag.Lag = high - start
+		}
+		lags = append(lags, lag)
+	}
+	return lags, nil
+}
@@ -0,0 +1,247 @@
+package kafka_client
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+var errMsgpackTruncated = errors.New("truncated MessagePack message")
+
+// decodeMsgpack decodes a MessagePack map or array into the same values as
+// JSON messages, so that they are flattened the same way: numbers are
+// float64, binary and extension values base64 strings, timestamps RFC 3339
+// strings, and map keys strings. The JSON limits apply to the size, nesting
+// depth and string lengths.
+func decodeMsgpack(b []byte, limits JSONLimits) (interface{}, error) {
+	if limits.MaxSize > 0 && len(b) > limits.MaxSize {
+		return nil, fmt.Errorf("message size of %d bytes exceeds the limit of %d bytes", len(b), limits.MaxSize)
+	}
+
+	d := msgpackDecoder{b: b, limits: limits}
+	value, err := d.value(0)
+	if err != nil {
+		return nil, err
+	}
+	if d.pos != len(b) {
+		return nil, fmt.Errorf("%d bytes after the MessagePack value", len(b)-d.pos)
+	}
+	return value, nil
+}
+
+type msgpackDecoder struct {
+	b      []byte
+	pos    int
+	limits JSONLimits
+}
+
+func (d *msgpackDecoder) next(n int) ([]byte, error) {
+	if n < 0 || len(d.b)-d.pos < n {
+		return nil, errMsgpackTruncated
+	}
+	b := d.b[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+// uint reads a big-endian unsigned integer of size bytes.
+func (d *msgpackDecoder) uint(size int) (uint64, error) {
+	b, err := d.next(size)
+	if err != nil {
+		return 0, err
+	}
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v, nil
+}
+
+func (d *msgpackDecoder) value(depth int) (interface{}, error) {
+	b, err := d.next(1)
+	if err != nil {
+		return nil, err
+	}
+	c := b[0]
+
+	switch {
+	case c <= 0x7f:
+		return float64(c), nil
+	case c >= 0xe0:
+		return float64(int8(c)), nil
+	case c&0xf0 == 0x80:
+		return d.object(int(c&0x0f), depth)
+	case c&0xf0 == 0x90:
+		return d.array(int(c&0x0f), depth)
+	case c&0xe0 == 0xa0:
+		return d.string(int(c & 0x1f))
+	}
+
+	switch c {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6:
+		n, err := d.uint(1 << (c - 0xc4))
+		if err != nil {
+			return nil, err
+		}
+		return d.binary(int(n))
+	case 0xc7, 0xc8, 0xc9:
+		n, err := d.uint(1 << (c - 0xc7))
+		if err != nil {
+			return nil, err
+		}
+		return d.extension(int(n))
+	case 0xca:
+		v, err := d.uint(4)
+		return float64(math.Float32frombits(uint32(v))), err
+	case 0xcb:
+		v, err := d.uint(8)
+		return math.Float64frombits(v), err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		v, err := d.uint(1 << (c - 0xcc))
+		return float64(v), err
+	case 0xd0:
+		v, err := d.uint(1)
+		return float64(int8(v)), err
+	case 0xd1:
+		v, err := d.uint(2)
+		return float64(int16(v)), err
+	case 0xd2:
+		v, err := d.uint(4)
+		return float64(int32(v)), err
+	case 0xd3:
+		v, err := d.uint(8)
+		return float64(int64(v)), err
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		return d.extension(1 << (c - 0xd4))
+	case 0xd9, 0xda, 0xdb:
+		n, err := d.uint(1 << (c - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+		return d.string(int(n))
+	case 0xdc, 0xdd:
+		n, err := d.uint(2 << (c - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return d.array(int(n), depth)
+	case 0xde, 0xdf:
+		n, err := d.uint(2 << (c - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return d.object(int(n), depth)
+	}
+	return nil, fmt.Errorf("invalid MessagePack type 0x%x", c)
+}
+
+func (d *msgpackDecoder) string(n int) (interface{}, error) {
+	if d.limits.MaxStringLength > 0 && n > d.limits.MaxStringLength {
+		return nil, fmt.Errorf("string of %d bytes exceeds the limit of %d bytes", n, d.limits.MaxStringLength)
+	}
+	b, err := d.next(n)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+func (d *msgpackDecoder) binary(n int) (interface{}, error) {
+	b, err := d.next(n)
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.EncodeToString(b), nil
+}
+
+// extension decodes timestamps, of type -1, into RFC 3339 strings, and other
+// extension types into base64 strings of their data.
+func (d *msgpackDecoder) extension(n int) (interface{}, error) {
+	t, err := d.next(1)
+	if err != nil {
+		return nil, err
+	}
+	b, err := d.next(n)
+	if err != nil {
+		return nil, err
+	}
+	if int8(t[0]) != -1 {
+		return base64.StdEncoding.EncodeToString(b), nil
+	}
+
+	var sec int64
+	var nsec uint32
+	switch n {
+	case 4:
+		sec = int64(binary.BigEndian.Uint32(b))
+	case 8:
+		v := binary.BigEndian.Uint64(b)
+		nsec, sec = uint32(v>>34), int64(v&(1<<34-1))
+	case 12:
+		nsec, sec = binary.BigEndian.Uint32(b), int64(binary.BigEndian.Uint64(b[4:]))
+	default:
+		return nil, fmt.Errorf("invalid MessagePack timestamp of %d bytes", n)
+	}
+	return time.Unix(sec, int64(nsec)).UTC().Format(time.RFC3339Nano), nil
+}
+
+func (d *msgpackDecoder) array(n int, depth int) (interface{}, error) {
+	if err := d.nest(depth); err != nil {
+		return nil, err
+	}
+	// Every element takes at least a byte, which bounds the allocation.
+	if n > len(d.b)-d.pos {
+		return nil, errMsgpackTruncated
+	}
+	array := make([]interface{}, 0, n)
+	for i := 0; i < n; i++ {
+		v, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		array = append(array, v)
+	}
+	return array, nil
+}
+
+func (d *msgpackDecoder) object(n int, depth int) (interface{}, error) {
+	if err := d.nest(depth); err != nil {
+		return nil, err
+	}
+	if n > len(d.b)-d.pos {
+		return nil, errMsgpackTruncated
+	}
+	object := make(map[string]interface{}, n)
+	for i := 0; i < n; i++ {
+		k, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		v, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		key, ok := k.(string)
+		if !ok {
+			key = fmt.Sprint(k)
+		}
+		object[key] = v
+	}
+	return object, nil
+}
+
+func (d *msgpackDecoder) nest(depth int) error {
+	if d.limits.MaxDepth > 0 && depth+1 > d.limits.MaxDepth {
+		return fmt.Errorf("nesting depth exceeds the limit of %d", d.limits.MaxDepth)
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package kafka_client
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDecodeMsgpack(t *testing.T) {
+	message := []byte{
+		0x88,
+		0xa1, 'a', 0x01,
+		0xa1, 'b', 0x92, 0xc3, 0xc0,
+		0xa1, 's', 0xa2, 'h', 'i',
+		0xa1, 'f', 0xcb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0,
+		0xa1, 'n', 0xfd,
+		0xa3, 'b', 'i', 'n', 0xc4, 0x02, 0x01, 0x02,
+		0xa1, 't', 0xd6, 0xff, 0, 0, 0, 0x3c,
+		0x05, 0xcd, 0x01, 0x2c,
+	}
+
+	value, err := decodeMsgpack(message, JSONLimits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"a":   1.0,
+		"b":   []interface{}{true, nil},
+		"s":   "hi",
+		"f":   1.5,
+		"n":   -3.0,
+		"bin": "AQI=",
+		"t":   "1970-01-01T00:01:00Z",
+		"5":   300.0,
+	}
+	if !reflect.DeepEqual(value, want) {
+		t.Errorf("expected %v, got %v", want, value)
+	}
+}
+
+func TestDecodeMsgpackErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		message []byte
+		limits  JSONLimits
+	}{
+		{"truncated", []byte{0x82, 0xa1, 'a', 0x01}, JSONLimits{}},
+		{"trailing bytes", []byte{0x80, 0x01}, JSONLimits{}},
+		{"invalid type", []byte{0x81, 0xa1, 'a', 0xc1}, JSONLimits{}},
+		{"huge array", []byte{0x81, 0xa1, 'a', 0xdd, 0xff, 0xff, 0xff, 0xff}, JSONLimits{}},
+		{"too deep", []byte{0x81, 0xa1, 'a', 0x81, 0xa1, 'b', 0x80}, JSONLimits{MaxDepth: 2}},
+		{"long string", []byte{0x81, 0xa1, 'a', 0xa3, 'a', 'b', 'c'}, JSONLimits{MaxStringLength: 2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := decodeMsgpack(tt.message, tt.limits); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+
+	client := KafkaClient{MessageFormat: MESSAGE_FORMAT_MSGPACK}
+	if value, _, err := client.decode([]byte{0x81, 0xa1, 'a', 0xc2}); err != nil || value["a"] != false {
+		t.Errorf("expected the client to decode MessagePack, got %v, %v", value, err)
+	}
+	if _, items, err := client.decode([]byte{0x92, 0x01, 0x02}); err != nil || len(items) != 2 {
+		t.Errorf("expected the items of an array, got %v, %v", items, err)
+	}
+	if _, _, err := client.decode([]byte{0x01}); err == nil {
+		t.Error("expected an error for a message that is neither a map nor an array")
+	}
+}
@@ -0,0 +1,73 @@
+package kafka_client
+
+import (
+	"context"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+// PartitionOffsets are the watermark offsets of a partition: the offset of
+// its first message retained and the offset past its last one. Their
+// difference bounds the messages of the partition, which compaction and
+// transaction markers make fewer.
+type PartitionOffsets struct {
+	Partition int32 `json:"partition"`
+	Earliest  int64 `json:"earliest"`
+	Latest    int64 `json:"latest"`
+	// AtTimestamp is the offset of the first message at or after the
+	// timestamp asked for, or -1 if there is none, when asked for.
+	AtTimestamp *int64 `json:"atTimestamp,omitempty"`
+}
+
+// PartitionOffsets returns the watermark offsets of every partition of the
+// topic, along with the offsets of the timestamp unless zero.
+func (client KafkaClient) PartitionOffsets(ctx context.Context, topic string, timestamp time.Time) ([]PartitionOffsets, error) {
+	if err := client.consumerInitialize(); err != nil {
+		return nil, err
+	}
+	defer client.Consumer.Close()
+
+	partitions, err := client.topicPartitions(ctx, topic, ALL_PARTITIONS)
+	if err != nil {
+		return nil, err
+	}
+	offsets := make([]PartitionOffsets, 0, len(partitions))
+	var requested []kafka.TopicPartition
+	for _, p := range partitions {
+		if p.Error.Code() != kafka.ErrNoError {
+			continue
+		}
+		low, high, err := client.Consumer.QueryWatermarkOffsets(topic, p.ID, timeoutMs(ctx, METADATA_TIMEOUT))
+		if err != nil {
+			return nil, classifyError(err)
+		}
+		offsets = append(offsets, PartitionOffsets{Partition: p.ID, Earliest: low, Latest: high})
+		requested = append(requested, kafka.TopicPartition{Topic: &topic, Partition: p.ID,
+			Offset: kafka.Offset(timestampMs(timestamp))})
+	}
+	if timestamp.IsZero() || len(requested) == 0 {
+		return offsets, nil
+	}
+
+	found, err := client.Consumer.OffsetsForTimes(requested, timeoutMs(ctx, METADATA_TIMEOUT))
+	if err != nil {
+		return nil, classifyError(err)
+	}
+	byPartition := make(map[int32]int64, len(found))
+	for _, p := range found {
+		offset := int64(p.Offset)
+		if p.Error != nil || offset < 0 {
+			offset = -1
+		}
+		byPartition[p.Partition] = offset
+	}
+	for i := range offsets {
+		offset, ok := byPartition[offsets[i].Partition]
+		if !ok {
+			offset = -1
+		}
+		offsets[i].AtTimestamp = &offset
+	}
+	return offsets, nil
+}
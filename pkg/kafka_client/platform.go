@@ -0,0 +1,58 @@
+package kafka_client
+
+import (
+	"strings"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+// Kafka compatible platforms, told apart by DetectPlatform as they need
+// settings of their own.
+const (
+	PLATFORM_KAFKA = "kafka"
+	// PLATFORM_EVENT_HUBS is the Kafka endpoint of Azure Event Hubs, which
+	// only accepts SASL PLAIN over TLS with the namespace connection string,
+	// and closes connections idle for 240 seconds.
+	PLATFORM_EVENT_HUBS = "eventHubs"
+)
+
+// EVENT_HUBS_HOST_SUFFIX ends the host names of Event Hubs namespaces.
+const EVENT_HUBS_HOST_SUFFIX = ".servicebus.windows.net"
+
+// EVENT_HUBS_USERNAME is the SASL username of Event Hubs, whose password is
+// the connection string of the namespace.
+const EVENT_HUBS_USERNAME = "$ConnectionString"
+
+// DetectPlatform returns the platform of the bootstrap servers, which are
+// Event Hubs when any of them is the host of an Event Hubs namespace.
+// Redpanda and other Kafka compatible brokers need no settings of their own,
+// so they are PLATFORM_KAFKA.
+func DetectPlatform(bootstrapServers string) string {
+	for _, server := range strings.Split(bootstrapServers, ",") {
+		host := strings.ToLower(strings.TrimSpace(server))
+		if i := strings.LastIndex(host, ":"); i >= 0 {
+			host = host[:i]
+		}
+		if strings.HasSuffix(host, EVENT_HUBS_HOST_SUFFIX) {
+			return PLATFORM_EVENT_HUBS
+		}
+	}
+	return PLATFORM_KAFKA
+}
+
+// applyPlatformConfig adds the settings of the platform of the client to the
+// consumer configuration.
+func (client *KafkaClient) applyPlatformConfig(config kafka.ConfigMap) {
+	if client.Platform != PLATFORM_EVENT_HUBS {
+		return
+	}
+	config["security.protocol"] = "SASL_SSL"
+	config["sasl.mechanisms"] = "PLAIN"
+	config["sasl.username"] = EVENT_HUBS_USERNAME
+	config["sasl.password"] = client.connectionString
+	// Event Hubs closes idle connections silently, which keepalives and
+	// metadata refreshes more frequent than its 240 seconds idle timeout
+	// prevent.
+	config["socket.keepalive.enable"] = true
+	config["metadata.max.age.ms"] = 180000
+}
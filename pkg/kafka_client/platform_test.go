@@ -0,0 +1,45 @@
+package kafka_client
+
+import (
+	"testing"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+func TestDetectPlatform(t *testing.T) {
+	tests := []struct {
+		servers  string
+		platform string
+	}{
+		{"localhost:9092", PLATFORM_KAFKA},
+		{"redpanda-0:9092, redpanda-1:9092", PLATFORM_KAFKA},
+		{"ns.servicebus.windows.net:9093", PLATFORM_EVENT_HUBS},
+		{"broker:9092,NS.ServiceBus.Windows.Net:9093", PLATFORM_EVENT_HUBS},
+		{"servicebus.windows.net.example.com:9092", PLATFORM_KAFKA},
+	}
+	for _, tt := range tests {
+		if platform := DetectPlatform(tt.servers); platform != tt.platform {
+			t.Errorf("%s: expected %s, got %s", tt.servers, tt.platform, platform)
+		}
+	}
+}
+
+func TestApplyPlatformConfig(t *testing.T) {
+	client := NewKafkaClient(Options{
+		BootstrapServers: "ns.servicebus.windows.net:9093",
+		ConnectionString: "Endpoint=sb://ns.servicebus.windows.net/;SharedAccessKey=secret",
+	})
+	config := kafka.ConfigMap{}
+	client.applyPlatformConfig(config)
+	if config["security.protocol"] != "SASL_SSL" || config["sasl.username"] != EVENT_HUBS_USERNAME ||
+		config["sasl.password"] != "Endpoint=sb://ns.servicebus.windows.net/;SharedAccessKey=secret" {
+		t.Errorf("expected the Event Hubs authentication, got %v", config)
+	}
+
+	client = NewKafkaClient(Options{BootstrapServers: "localhost:9092", ConnectionString: "ignored"})
+	config = kafka.ConfigMap{}
+	client.applyPlatformConfig(config)
+	if len(config) != 0 {
+		t.Errorf("expected no settings for Kafka, got %v", config)
+	}
+}
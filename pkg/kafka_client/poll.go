@@ -0,0 +1,143 @@
+package kafka_client
+
+import (
+	"context"
+	"sort"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+// MAX_POLL_MESSAGES bounds the messages read by ReadNew.
+const MAX_POLL_MESSAGES = 1000
+
+// PollResult holds the messages read by ReadNew and ReadPage, in timestamp
+// order, along with the cursor to read the next ones from: the offset of the
+// next message of every partition. End tells whether every partition was
+// read up to its end.
+type PollResult struct {
+	Messages     []KafkaMessage
+	Cursor       map[int32]int64
+	LimitReached bool
+	End          bool
+}
+
+// ReadNew reads the messages of the partition of the topic, or of all its
+// partitions for ALL_PARTITIONS, from the offsets of the cursor up to the end
+// of the partitions, e.g. for clients polling for new messages. Partitions
+// missing from the cursor start as streams do, at their end, or MAX_EARLIEST
+// messages before it for earliest. Offsets of the cursor removed by retention
+// start at the first message retained.
+func (client KafkaClient) ReadNew(ctx context.Context, topic string, partition int32, cursor map[int32]int64,
+	autoOffsetReset string) (PollResult, error) {
+	start := func(low, high int64) int64 {
+		if autoOffsetReset == "earliest" {
+			return high - MAX_EARLIEST
+		}
+		return high
+	}
+	return client.readCursor(ctx, topic, partition, cursor, start, MAX_POLL_MESSAGES)
+}
+
+// ReadPage reads up to max messages, or MAX_POLL_MESSAGES if max isn't
+// positive, of the partition of the topic, or of all its partitions for
+// ALL_PARTITIONS, from the offsets of the cursor, e.g. for scripts paging
+// through a topic. Partitions missing from the cursor start at their first
+// message retained, and are read one after the other.
+func (client KafkaClient) ReadPage(ctx context.Context, topic string, partition int32, cursor map[int32]int64,
+	max int) (PollResult, error) {
+	if max <= 0 || max > MAX_POLL_MESSAGES {
+		max = MAX_POLL_MESSAGES
+	}
+	start := func(low, high int64) int64 {
+		return low
+	}
+	return client.readCursor(ctx, topic, partition, cursor, start, max)
+}
+
+// readCursor reads up to max messages from the offsets of the cursor, or
+// from the start of the partitions missing from it, given their watermarks.
+func (client KafkaClient) readCursor(ctx context.Context, topic string, partition int32, cursor map[int32]int64,
+	start func(low, high int64) int64, max int) (PollResult, error) {
+	result := PollResult{Cursor: make(map[int32]int64)}
+	bounds, err := client.cursorBounds(ctx, topic, partition, cursor, start)
+	if err != nil {
+		return result, err
+	}
+
+	// Partitions are read one after the other, sharing the max.
+	read := rangeRead{topic: topic, max: int64(max)}
+	ids := make([]int32, 0, len(bounds))
+	for p := range bounds {
+		ids = append(ids, p)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for _, p := range ids {
+		bound := bounds[p]
+		result.Cursor[p] = bound[0]
+		if bound[1] <= bound[0] || result.LimitReached {
+			continue
+		}
+		partial, err := client.readPartition(ctx, &read, p, bound)
+		if err != nil {
+			return PollResult{}, err
+		}
+		result.Messages = append(result.Messages, partial.Messages...)
+		// Partitions read up to their end resume there, even past offsets
+		// without messages, like transaction markers.
+		if partial.LimitReached {
+			result.LimitReached = true
+			if n := len(partial.Messages); n > 0 {
+				result.Cursor[p] = int64(partial.Messages[n-1].Offset) + 1
+			}
+		} else {
+			result.Cursor[p] = bound[1]
+		}
+	}
+
+	result.End = true
+	for p, bound := range bounds {
+		result.End = result.End && result.Cursor[p] >= bound[1]
+	}
+
+	sort.SliceStable(result.Messages, func(i, j int) bool {
+		return result.Messages[i].Timestamp.Before(result.Messages[j].Timestamp)
+	})
+	return result, nil
+}
+
+// cursorBounds returns, for every partition, the offset to start reading at
+// and the offset past its last message.
+func (client KafkaClient) cursorBounds(ctx context.Context, topic string, partition int32, cursor map[int32]int64,
+	start func(low, high int64) int64) (map[int32][2]int64, error) {
+	if err := client.consumerInitialize(); err != nil {
+		return nil, err
+	}
+	defer client.Consumer.Close()
+
+	partitions, err := client.topicPartitions(ctx, topic, partition)
+	if err != nil {
+		return nil, err
+	}
+	bounds := make(map[int32][2]int64)
+	for _, p := range partitions {
+		if p.Error.Code() != kafka.ErrNoError {
+			continue
+		}
+		low, high, err := client.Consumer.QueryWatermarkOffsets(topic, p.ID, timeoutMs(ctx, METADATA_TIMEOUT))
+		if err != nil {
+			return nil, classifyError(err)
+		}
+		offset, ok := cursor[p.ID]
+		if !ok {
+			offset = start(low, high)
+		}
+		if offset < low {
+			offset = low
+		}
+		if offset > high {
+			offset = high
+		}
+		bounds[p.ID] = [2]int64{offset, high}
+	}
+	return bounds, nil
+}
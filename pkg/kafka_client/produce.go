@@ -0,0 +1,71 @@
+package kafka_client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+// PRODUCE_TIMEOUT bounds how long Produce waits for a message to be
+// acknowledged by the brokers.
+const PRODUCE_TIMEOUT = 10 * time.Second
+
+// ProducedMessage is the position of a message written by Produce.
+type ProducedMessage struct {
+	Topic     string `json:"topic"`
+	Partition int32  `json:"partition"`
+	Offset    int64  `json:"offset"`
+}
+
+// Produce writes a message with the key, value and headers to the topic, to
+// the partition its key hashes to, and returns where it was written once the
+// brokers acknowledged it. A nil key spreads messages across partitions.
+func (client KafkaClient) Produce(ctx context.Context, topic string, key, value []byte,
+	headers map[string]string) (ProducedMessage, error) {
+	config := kafka.ConfigMap{
+		"bootstrap.servers": client.BootstrapServers,
+		"acks":              "all",
+	}
+	client.applyPlatformConfig(config)
+	producer, err := kafka.NewProducer(&config)
+	if err != nil {
+		return ProducedMessage{}, err
+	}
+	defer producer.Close()
+
+	msg := &kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: kafka.PartitionAny},
+		Key:            key,
+		Value:          value,
+	}
+	for k, v := range headers {
+		msg.Headers = append(msg.Headers, kafka.Header{Key: k, Value: []byte(v)})
+	}
+	delivered := make(chan kafka.Event, 1)
+	if err := producer.Produce(msg, delivered); err != nil {
+		return ProducedMessage{}, classifyError(err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, PRODUCE_TIMEOUT)
+	defer cancel()
+	select {
+	case e := <-delivered:
+		m, ok := e.(*kafka.Message)
+		if !ok {
+			return ProducedMessage{}, errors.New("unexpected delivery report")
+		}
+		if m.TopicPartition.Error != nil {
+			return ProducedMessage{}, classifyError(m.TopicPartition.Error)
+		}
+		return ProducedMessage{
+			Topic:     topic,
+			Partition: m.TopicPartition.Partition,
+			Offset:    int64(m.TopicPartition.Offset),
+		}, nil
+	case <-ctx.Done():
+		return ProducedMessage{}, fmt.Errorf("%w: message not acknowledged in time", ErrBrokerUnreachable)
+	}
+}
@@ -0,0 +1,279 @@
+package kafka_client
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+// MAX_RANGE_MESSAGES bounds the messages read by ReadRange.
+const MAX_RANGE_MESSAGES = 10000
+
+// MAX_RANGE_WORKERS bounds the partitions read concurrently by ReadRange.
+const MAX_RANGE_WORKERS = 4
+
+// RangeResult holds the messages read by ReadRange, and tells whether it
+// stopped before the end of the range because it read the maximum number of
+// messages or ran out of time. PartitionErrors holds the partitions skipped
+// because they can't be read, if any.
+type RangeResult struct {
+	Messages        []KafkaMessage
+	LimitReached    bool
+	Expired         bool
+	PartitionErrors *PartitionErrors
+}
+
+// ReadRange reads the messages of the partition of the topic, or of all its
+// partitions for ALL_PARTITIONS, whose timestamp is within the time range,
+// in timestamp order. The offsets bounding the range are looked up by
+// timestamp on the partition leaders, then up to MAX_RANGE_WORKERS
+// partitions are read concurrently, each by its own consumer. The partitions
+// share a budget of max messages, or MAX_RANGE_MESSAGES if max isn't
+// positive, so that no more are ever held, and the messages read are returned
+// in timestamp order. Reading stops
+// after the budget, unless zero, as measured by the Now of the client,
+// returning the messages read so far. Partitions that can't be read are
+// skipped and reported in the result, unless none can be read.
+func (client KafkaClient) ReadRange(ctx context.Context, topic string, partition int32, from, to time.Time,
+	max int, budget time.Duration) (RangeResult, error) {
+	var result RangeResult
+	if max <= 0 {
+		max = MAX_RANGE_MESSAGES
+	}
+	read := rangeRead{topic: topic, from: from, to: to, max: int64(max)}
+	if budget > 0 {
+		read.deadline = client.now().Add(budget)
+	}
+
+	bounds, partitionErrors, err := client.rangeBounds(ctx, topic, partition, from, to)
+	if err != nil {
+		return result, err
+	}
+	if partitionErrors != nil && !partitionErrors.Partial() {
+		return result, partitionErrors
+	}
+	result.PartitionErrors = partitionErrors
+	if len(bounds) == 0 {
+		return result, nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	workers := make(chan struct{}, MAX_RANGE_WORKERS)
+	for p, bound := range bounds {
+		wg.Add(1)
+		go func(p int32, bound [2]int64) {
+			defer wg.Done()
+			select {
+			case workers <- struct{}{}:
+				defer func() { <-workers }()
+			case <-ctx.Done():
+				return
+			}
+
+			partial, err := client.readPartition(ctx, &read, p, bound)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+					cancel()
+				}
+				return
+			}
+			result.Messages = append(result.Messages, partial.Messages...)
+			result.LimitReached = result.LimitReached || partial.LimitReached
+			result.Expired = result.Expired || partial.Expired
+		}(p, bound)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return RangeResult{}, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return RangeResult{}, err
+	}
+
+	sortMessages(result.Messages)
+	return result, nil
+}
+
+// sortMessages sorts the messages of the partitions by timestamp.
+func sortMessages(messages []KafkaMessage) {
+	sort.SliceStable(messages, func(i, j int) bool {
+		return messages[i].Timestamp.Before(messages[j].Timestamp)
+	})
+}
+
+// RangeSize returns the number of offsets within the time range, which bounds
+// the number of messages ReadRange would read without a maximum. Partitions
+// that can't be read don't count.
+func (client KafkaClient) RangeSize(ctx context.Context, topic string, partition int32, from, to time.Time) (int64, error) {
+	bounds, _, err := client.rangeBounds(ctx, topic, partition, from, to)
+	if err != nil {
+		return 0, err
+	}
+	var size int64
+	for _, bound := range bounds {
+		size += bound[1] - bound[0]
+	}
+	return size, nil
+}
+
+// rangeBounds looks up the offsets of the time range with a consumer of its
+// own.
+func (client KafkaClient) rangeBounds(ctx context.Context, topic string, partition int32,
+	from, to time.Time) (map[int32][2]int64, *PartitionErrors, error) {
+	if err := client.consumerInitialize(); err != nil {
+		return nil, nil, err
+	}
+	defer client.Consumer.Close()
+
+	topicPartitions, err := client.topicPartitions(ctx, topic, partition)
+	if err != nil {
+		return nil, nil, err
+	}
+	return client.rangeOffsets(ctx, topic, topicPartitions, from, to)
+}
+
+// rangeRead is shared by the partitions read by ReadRange. A zero to doesn't
+// bound the time range.
+type rangeRead struct {
+	// read counts the messages read by all the partitions, accessed
+	// atomically, and comes first to be 64-bit aligned.
+	read     int64
+	topic    string
+	from, to time.Time
+	deadline time.Time
+	// max bounds the messages read by all the partitions together.
+	max int64
+}
+
+// take counts a message read by one of the partitions, and tells whether it
+// is within the max.
+func (r *rangeRead) take() bool {
+	return atomic.AddInt64(&r.read, 1) <= r.max
+}
+
+// readPartition reads the messages of the partition within the time range,
+// from the first offset of its bound up to the second one, with a consumer of
+// its own.
+func (client KafkaClient) readPartition(ctx context.Context, read *rangeRead, partition int32,
+	bound [2]int64) (RangeResult, error) {
+	var result RangeResult
+	client.partitionEOF = true
+	if err := client.consumerInitialize(); err != nil {
+		return result, err
+	}
+	defer client.Consumer.Close()
+
+	assignment := []kafka.TopicPartition{{Topic: &read.topic, Partition: partition, Offset: kafka.Offset(bound[0])}}
+	if err := client.Consumer.Assign(assignment); err != nil {
+		return result, err
+	}
+
+	for ctx.Err() == nil {
+		if !read.deadline.IsZero() && client.now().After(read.deadline) {
+			result.Expired = true
+			return result, nil
+		}
+		switch e := client.Consumer.Poll(100).(type) {
+		case *kafka.Message:
+			if e.TopicPartition.Partition != partition {
+				continue
+			}
+			offset := int64(e.TopicPartition.Offset)
+			if offset >= bound[1] {
+				return result, nil
+			}
+			if !e.Timestamp.Before(read.from) && (read.to.IsZero() || !e.Timestamp.After(read.to)) {
+				if !read.take() {
+					result.LimitReached = true
+					return result, nil
+				}
+				result.Messages = append(result.Messages, client.newMessage(e))
+			}
+			if offset == bound[1]-1 {
+				return result, nil
+			}
+		case kafka.PartitionEOF:
+			return result, nil
+		case kafka.Error:
+			return RangeResult{}, classifyError(e)
+		}
+	}
+	return result, ctx.Err()
+}
+
+// rangeOffsets returns, for every partition holding messages of the time
+// range, the offset of its first message and the offset past its last one.
+// Partitions whose metadata has an error are skipped and returned as a
+// *PartitionErrors, nil if there are none.
+func (client *KafkaClient) rangeOffsets(ctx context.Context, topic string, partitions []kafka.PartitionMetadata,
+	from, to time.Time) (map[int32][2]int64, *PartitionErrors, error) {
+	partitionErrors := &PartitionErrors{Topic: topic}
+	var starts, stops []kafka.TopicPartition
+	for _, p := range partitions {
+		if p.Error.Code() != kafka.ErrNoError {
+			partitionErrors.Errors = append(partitionErrors.Errors, PartitionError{p.ID, p.Error})
+			continue
+		}
+		starts = append(starts, kafka.TopicPartition{Topic: &topic, Partition: p.ID, Offset: kafka.Offset(timestampMs(from))})
+		stops = append(stops, kafka.TopicPartition{Topic: &topic, Partition: p.ID, Offset: kafka.Offset(timestampMs(to) + 1)})
+		partitionErrors.Assigned = append(partitionErrors.Assigned, p.ID)
+	}
+	if len(partitionErrors.Errors) == 0 {
+		partitionErrors = nil
+	}
+	if len(starts) == 0 {
+		return nil, partitionErrors, nil
+	}
+
+	starts, err := client.Consumer.OffsetsForTimes(starts, timeoutMs(ctx, METADATA_TIMEOUT))
+	if err != nil {
+		return nil, nil, classifyError(err)
+	}
+	stops, err = client.Consumer.OffsetsForTimes(stops, timeoutMs(ctx, METADATA_TIMEOUT))
+	if err != nil {
+		return nil, nil, classifyError(err)
+	}
+	stopOffsets := make(map[int32]kafka.Offset, len(stops))
+	for _, p := range stops {
+		stopOffsets[p.Partition] = p.Offset
+	}
+
+	offsets := make(map[int32][2]int64)
+	for _, p := range starts {
+		// No message at or after the start of the range.
+		if p.Offset < 0 {
+			continue
+		}
+		stop := stopOffsets[p.Partition]
+		// No message after the end of the range, so read up to the end of
+		// the partition.
+		if stop < 0 {
+			_, high, err := client.Consumer.QueryWatermarkOffsets(topic, p.Partition, timeoutMs(ctx, METADATA_TIMEOUT))
+			if err != nil {
+				return nil, nil, classifyError(err)
+			}
+			stop = kafka.Offset(high)
+		}
+		if int64(stop) > int64(p.Offset) {
+			offsets[p.Partition] = [2]int64{int64(p.Offset), int64(stop)}
+		}
+	}
+	return offsets, partitionErrors, nil
+}
+
+func timestampMs(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
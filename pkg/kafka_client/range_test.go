@@ -0,0 +1,80 @@
+package kafka_client
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+func TestSortMessages(t *testing.T) {
+	at := func(partition int32, seconds int64) KafkaMessage {
+		return KafkaMessage{Partition: partition, Timestamp: time.Unix(seconds, 0)}
+	}
+	// The messages of the partition read first come last by timestamp.
+	messages := []KafkaMessage{at(1, 5), at(1, 6), at(0, 1), at(0, 3), at(2, 2)}
+
+	sortMessages(messages)
+	expected := []int64{1, 2, 3, 5, 6}
+	for i, msg := range messages {
+		if msg.Timestamp.Unix() != expected[i] {
+			t.Errorf("expected message %d at %d, got %d", i, expected[i], msg.Timestamp.Unix())
+		}
+	}
+}
+
+func TestRangeReadTake(t *testing.T) {
+	read := rangeRead{max: 250}
+	var (
+		wg    sync.WaitGroup
+		taken int64
+	)
+	// Partitions read concurrently share the max.
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if read.take() {
+					atomic.AddInt64(&taken, 1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if taken != 250 {
+		t.Errorf("expected 250 messages to be read, got %d", taken)
+	}
+}
+
+func TestRangeOffsetsPartitionErrors(t *testing.T) {
+	partitions := []kafka.PartitionMetadata{
+		{ID: 0, Error: kafka.NewError(kafka.ErrLeaderNotAvailable, "leader not available", false)},
+		{ID: 1, Error: kafka.NewError(kafka.ErrReplicaNotAvailable, "replica not available", false)},
+	}
+
+	// No partition is left to look the offsets up on.
+	offsets, partitionErrors, err := (&KafkaClient{}).rangeOffsets(context.Background(), "t", partitions,
+		time.Unix(0, 0), time.Unix(1, 0))
+	if err != nil || offsets != nil {
+		t.Fatalf("expected no offsets nor error, got %v, %v", offsets, err)
+	}
+	if partitionErrors == nil || !reflect.DeepEqual(partitionErrors.Failed(), []int32{0, 1}) || partitionErrors.Partial() {
+		t.Errorf("expected partitions 0 and 1 to fail, got %v", partitionErrors)
+	}
+}
+
+func TestClientNow(t *testing.T) {
+	now := time.Unix(1000, 0)
+	client := KafkaClient{Now: func() time.Time { return now }}
+	if got := client.now(); !got.Equal(now) {
+		t.Errorf("expected %v, got %v", now, got)
+	}
+	if got := (KafkaClient{}).now(); got.IsZero() {
+		t.Error("expected the current time without a clock")
+	}
+}
@@ -0,0 +1,80 @@
+package kafka_client
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Decoder decodes the message values of an organization-specific format into
+// the same types as decoded JSON: an object, or an array whose items are
+// framed like those of JSON arrays, of maps with string keys, slices,
+// strings, float64 numbers, booleans and nil. Decoders are called
+// concurrently by the streams, and should enforce the limits on what they
+// hold in memory.
+type Decoder interface {
+	Decode(b []byte, limits JSONLimits) (interface{}, error)
+}
+
+// DecoderFunc adapts a function to the Decoder interface.
+type DecoderFunc func(b []byte, limits JSONLimits) (interface{}, error)
+
+func (f DecoderFunc) Decode(b []byte, limits JSONLimits) (interface{}, error) {
+	return f(b, limits)
+}
+
+var (
+	decodersMu sync.RWMutex
+	decoders   = make(map[string]Decoder)
+)
+
+// RegisterDecoder makes the decoder available as a message format, usually
+// from the init function of a file of the main package built with a build
+// tag, so that organizations can add their formats without forking the
+// datasource. It panics if the format is empty, built in or already
+// registered.
+func RegisterDecoder(format string, decoder Decoder) {
+	if format == "" || decoder == nil {
+		panic("kafka_client: RegisterDecoder with an empty format or a nil decoder")
+	}
+	if builtinMessageFormat(format) {
+		panic(fmt.Sprintf("kafka_client: RegisterDecoder of the built-in format %q", format))
+	}
+	decodersMu.Lock()
+	defer decodersMu.Unlock()
+	if _, ok := decoders[format]; ok {
+		panic(fmt.Sprintf("kafka_client: RegisterDecoder called twice for format %q", format))
+	}
+	decoders[format] = decoder
+}
+
+// RegisteredFormats returns the formats of the registered decoders, sorted.
+func RegisteredFormats() []string {
+	decodersMu.RLock()
+	defer decodersMu.RUnlock()
+	formats := make([]string, 0, len(decoders))
+	for format := range decoders {
+		formats = append(formats, format)
+	}
+	sort.Strings(formats)
+	return formats
+}
+
+func registeredDecoder(format string) (Decoder, bool) {
+	decodersMu.RLock()
+	defer decodersMu.RUnlock()
+	decoder, ok := decoders[format]
+	return decoder, ok
+}
+
+// decodeRegistered decodes the value with a registered decoder, turning its
+// panics into errors of the message, so that a decoder choking on a message
+// doesn't take the datasource down.
+func decodeRegistered(decoder Decoder, b []byte, limits JSONLimits) (value interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			value, err = nil, fmt.Errorf("decoder panicked: %v", r)
+		}
+	}()
+	return decoder.Decode(b, limits)
+}
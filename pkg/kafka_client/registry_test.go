@@ -0,0 +1,58 @@
+package kafka_client
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func init() {
+	// Values of the test format are comma separated key=value pairs.
+	RegisterDecoder("test-kv", DecoderFunc(func(b []byte, limits JSONLimits) (interface{}, error) {
+		value := make(map[string]interface{})
+		for _, pair := range strings.Split(string(b), ",") {
+			kv := strings.SplitN(pair, "=", 2)
+			if len(kv) != 2 {
+				panic("not a key=value pair")
+			}
+			value[kv[0]] = kv[1]
+		}
+		return value, nil
+	}))
+}
+
+func TestRegisteredDecoder(t *testing.T) {
+	if err := ValidateMessageFormat("test-kv"); err != nil {
+		t.Fatal(err)
+	}
+	client := KafkaClient{MessageFormat: "test-kv"}
+	value, _, err := client.decode([]byte("host=a,level=info"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{"host": "a", "level": "info"}
+	if !reflect.DeepEqual(value, expected) {
+		t.Errorf("expected %v, got %v", expected, value)
+	}
+
+	if _, _, err := client.decode([]byte("garbage")); err == nil || !strings.Contains(err.Error(), "panicked") {
+		t.Errorf("expected the panic of the decoder as an error, got %v", err)
+	}
+	if formats := RegisteredFormats(); !reflect.DeepEqual(formats, []string{"test-kv"}) {
+		t.Errorf("unexpected formats %v", formats)
+	}
+}
+
+func TestRegisterDecoderPanics(t *testing.T) {
+	decoder := DecoderFunc(func(b []byte, limits JSONLimits) (interface{}, error) { return nil, nil })
+	for _, format := range []string{"", MESSAGE_FORMAT_JSON, "test-kv"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("expected registering %q to panic", format)
+				}
+			}()
+			RegisterDecoder(format, decoder)
+		}()
+	}
+}
@@ -0,0 +1,85 @@
+package kafka_client
+
+import (
+	"context"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+// MAX_SAMPLE_MESSAGES bounds the messages read by SampleMessages.
+const MAX_SAMPLE_MESSAGES = 1000
+
+// SampleMessages reads up to n of the latest messages of the partition of the
+// topic, or of all its partitions for ALL_PARTITIONS, which share them
+// evenly, e.g. to infer the schema of the messages. Reading stops after
+// READ_TIMEOUT, returning the messages read so far.
+func (client KafkaClient) SampleMessages(ctx context.Context, topic string, partition int32,
+	n int) ([]KafkaMessage, error) {
+	if n <= 0 || n > MAX_SAMPLE_MESSAGES {
+		n = MAX_SAMPLE_MESSAGES
+	}
+	client.partitionEOF = true
+	if err := client.consumerInitialize(); err != nil {
+		return nil, err
+	}
+	defer client.Consumer.Close()
+
+	partitions, err := client.topicPartitions(ctx, topic, partition)
+	if err != nil {
+		return nil, err
+	}
+	perPartition := int64((n + len(partitions) - 1) / len(partitions))
+
+	// The offset past the last message to read, by partition.
+	ends := make(map[int32]int64)
+	var assignment []kafka.TopicPartition
+	for _, p := range partitions {
+		if p.Error.Code() != kafka.ErrNoError {
+			continue
+		}
+		low, high, err := client.Consumer.QueryWatermarkOffsets(topic, p.ID, timeoutMs(ctx, METADATA_TIMEOUT))
+		if err != nil {
+			return nil, classifyError(err)
+		}
+		if high <= low {
+			continue
+		}
+		start := high - perPartition
+		if start < low {
+			start = low
+		}
+		ends[p.ID] = high
+		assignment = append(assignment, kafka.TopicPartition{Topic: &topic, Partition: p.ID, Offset: kafka.Offset(start)})
+	}
+	if len(assignment) == 0 {
+		return nil, nil
+	}
+	if err := client.Consumer.Assign(assignment); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, READ_TIMEOUT)
+	defer cancel()
+	var messages []KafkaMessage
+	for len(ends) > 0 && ctx.Err() == nil {
+		switch e := client.Consumer.Poll(100).(type) {
+		case *kafka.Message:
+			end, ok := ends[e.TopicPartition.Partition]
+			if !ok {
+				continue
+			}
+			offset := int64(e.TopicPartition.Offset)
+			if offset < end {
+				messages = append(messages, client.newMessage(e))
+			}
+			if offset >= end-1 {
+				delete(ends, e.TopicPartition.Partition)
+			}
+		case kafka.PartitionEOF:
+			delete(ends, e.Partition)
+		case kafka.Error:
+			return nil, classifyError(e)
+		}
+	}
+	return messages, nil
+}
@@ -0,0 +1,73 @@
+package kafka_client
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// STATS_INTERVAL is how often stream consumers emit their statistics.
+const STATS_INTERVAL = 10 * time.Second
+
+// ConsumerStats is the subset of the librdkafka statistics of a consumer that
+// tells how it keeps up with its topic.
+type ConsumerStats struct {
+	// Fetches is the number of fetch requests sent to the brokers.
+	Fetches int64 `json:"fetches"`
+	// Messages and Bytes are the messages, and their size, received.
+	Messages int64 `json:"messages"`
+	Bytes    int64 `json:"bytes"`
+	// Rebalances is the number of consumer group rebalances.
+	Rebalances int64 `json:"rebalances"`
+	// Errors is the number of transmission and reception errors.
+	Errors int64 `json:"errors"`
+	// Lag is the number of messages the consumer is behind the end of its
+	// partitions.
+	Lag int64 `json:"lag"`
+}
+
+type rawStats struct {
+	RxMsgs     int64 `json:"rxmsgs"`
+	RxMsgBytes int64 `json:"rxmsg_bytes"`
+	Brokers    map[string]struct {
+		TxErrs int64            `json:"txerrs"`
+		RxErrs int64            `json:"rxerrs"`
+		Req    map[string]int64 `json:"req"`
+	} `json:"brokers"`
+	Topics map[string]struct {
+		Partitions map[string]struct {
+			ConsumerLag int64 `json:"consumer_lag"`
+		} `json:"partitions"`
+	} `json:"topics"`
+	Cgrp struct {
+		RebalanceCnt int64 `json:"rebalance_cnt"`
+	} `json:"cgrp"`
+}
+
+// ParseStats parses the JSON statistics emitted by librdkafka every
+// STATS_INTERVAL as *kafka.Stats events.
+func ParseStats(s string) (ConsumerStats, error) {
+	var raw rawStats
+	if err := json.Unmarshal([]byte(s), &raw); err != nil {
+		return ConsumerStats{}, err
+	}
+
+	stats := ConsumerStats{
+		Messages:   raw.RxMsgs,
+		Bytes:      raw.RxMsgBytes,
+		Rebalances: raw.Cgrp.RebalanceCnt,
+	}
+	for _, broker := range raw.Brokers {
+		stats.Fetches += broker.Req["Fetch"]
+		stats.Errors += broker.TxErrs + broker.RxErrs
+	}
+	for _, topic := range raw.Topics {
+		for _, partition := range topic.Partitions {
+			// The lag is -1 when unknown, e.g. for the internal
+			// partition -1.
+			if partition.ConsumerLag > 0 {
+				stats.Lag += partition.ConsumerLag
+			}
+		}
+	}
+	return stats, nil
+}
@@ -0,0 +1,27 @@
+package kafka_client
+
+import "testing"
+
+func TestParseStats(t *testing.T) {
+	stats, err := ParseStats(`{
+		"rxmsgs": 120, "rxmsg_bytes": 4096,
+		"brokers": {
+			"kafka-1:9092/1": {"txerrs": 1, "rxerrs": 2, "req": {"Fetch": 30, "Metadata": 4}},
+			"kafka-2:9092/2": {"txerrs": 0, "rxerrs": 0, "req": {"Fetch": 10}}
+		},
+		"topics": {"events": {"partitions": {
+			"0": {"consumer_lag": 7},
+			"1": {"consumer_lag": 3},
+			"-1": {"consumer_lag": -1}
+		}}},
+		"cgrp": {"rebalance_cnt": 2}
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := ConsumerStats{Fetches: 40, Messages: 120, Bytes: 4096, Rebalances: 2, Errors: 3, Lag: 10}
+	if stats != want {
+		t.Errorf("expected %+v, got %+v", want, stats)
+	}
+}
@@ -0,0 +1,73 @@
+package kafka_client
+
+import (
+	"context"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+// TOPIC_CONFIGS are the configs of topics returned by TopicConfig.
+var TOPIC_CONFIGS = []string{
+	"cleanup.policy",
+	"retention.ms",
+	"retention.bytes",
+	"max.message.bytes",
+	"min.insync.replicas",
+}
+
+// TopicConfig is the configuration of a topic, as far as consuming it is
+// concerned.
+type TopicConfig struct {
+	Topic      string `json:"topic"`
+	Partitions int    `json:"partitions"`
+	// ReplicationFactor is the number of replicas of the partitions, or of
+	// the most replicated one if they differ.
+	ReplicationFactor int `json:"replicationFactor"`
+	// Configs holds the values of TOPIC_CONFIGS, whether set on the topic
+	// or defaults of the brokers.
+	Configs map[string]string `json:"configs"`
+}
+
+// TopicConfig returns the partitions and replicas of the topic along with its
+// TOPIC_CONFIGS, described by the brokers.
+func (client KafkaClient) TopicConfig(ctx context.Context, topic string) (TopicConfig, error) {
+	config := TopicConfig{Topic: topic, Configs: make(map[string]string)}
+	if err := client.consumerInitialize(); err != nil {
+		return config, err
+	}
+	defer client.Consumer.Close()
+
+	partitions, err := client.topicPartitions(ctx, topic, ALL_PARTITIONS)
+	if err != nil {
+		return config, err
+	}
+	config.Partitions = len(partitions)
+	for _, p := range partitions {
+		if len(p.Replicas) > config.ReplicationFactor {
+			config.ReplicationFactor = len(p.Replicas)
+		}
+	}
+
+	admin, err := kafka.NewAdminClientFromConsumer(client.Consumer)
+	if err != nil {
+		return config, err
+	}
+	defer admin.Close()
+	ctx, cancel := context.WithTimeout(ctx, METADATA_TIMEOUT)
+	defer cancel()
+	results, err := admin.DescribeConfigs(ctx, []kafka.ConfigResource{{Type: kafka.ResourceTopic, Name: topic}})
+	if err != nil {
+		return config, classifyError(err)
+	}
+	if len(results) > 0 && results[0].Error.Code() != kafka.ErrNoError {
+		return config, results[0].Error
+	}
+	for _, result := range results {
+		for _, name := range TOPIC_CONFIGS {
+			if entry, ok := result.Config[name]; ok {
+				config.Configs[name] = entry.Value
+			}
+		}
+	}
+	return config, nil
+}
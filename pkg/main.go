@@ -2,6 +2,8 @@ package main
 
 import (
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend/datasource"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
@@ -9,7 +11,19 @@ import (
 )
 
 func main() {
-	if err := datasource.Manage("hamedkarbasi93-kafka-datasource", plugin.NewKafkaInstance, datasource.ManageOpts{}); err != nil {
+	// Grafana stops plugins by shutting down their server, or by terminating
+	// them, either way after which the streams are stopped before exiting.
+	terminated := make(chan os.Signal, 1)
+	signal.Notify(terminated, syscall.SIGTERM)
+	go func() {
+		<-terminated
+		plugin.Shutdown(plugin.ShutdownTimeout)
+		os.Exit(0)
+	}()
+
+	err := datasource.Manage("hamedkarbasi93-kafka-datasource", plugin.NewKafkaInstance, datasource.ManageOpts{})
+	plugin.Shutdown(plugin.ShutdownTimeout)
+	if err != nil {
 		log.DefaultLogger.Error(err.Error())
 		os.Exit(1)
 	}
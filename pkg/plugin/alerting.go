@@ -0,0 +1,124 @@
+package plugin
+
+import (
+	"math"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// alertSeries turns the frame of the messages of the time range of an alert
+// rule into the numeric time series alert conditions reduce: the time field
+// and the numeric fields, as nullable float64, without the text fields nor
+// the meta fields like __partition and __offset. With maxDataPoints, the rows
+// are averaged into at most as many buckets evenly spread over the time range,
+// placed at the start of their bucket.
+func alertSeries(frame *data.Frame, timeRange backend.TimeRange, maxDataPoints int64) *data.Frame {
+	var timeField *data.Field
+	var valueFields []*data.Field
+	for _, f := range frame.Fields {
+		if strings.HasPrefix(f.Name, "__") {
+			continue
+		}
+		switch {
+		case f.Type().Time() && timeField == nil:
+			timeField = f
+		case f.Type().Numeric():
+			valueFields = append(valueFields, f)
+		}
+	}
+
+	series := data.NewFrame(frame.Name)
+	series.RefID = frame.RefID
+	series.Meta = frame.Meta
+	if timeField == nil {
+		return series
+	}
+
+	rows := timeField.Len()
+	buckets := int64(rows)
+	span := timeRange.To.Sub(timeRange.From)
+	if maxDataPoints > 0 && maxDataPoints < buckets && span > 0 {
+		buckets = maxDataPoints
+	}
+	step := time.Duration(0)
+	if buckets < int64(rows) {
+		step = time.Duration(math.Ceil(float64(span) / float64(buckets)))
+	}
+
+	// bucketOf returns the bucket of the row, or -1 for rows without time.
+	bucketOf := func(row int) int64 {
+		t, ok := timeField.ConcreteAt(row)
+		if !ok {
+			return -1
+		}
+		if step == 0 {
+			return int64(row)
+		}
+		b := int64(t.(time.Time).Sub(timeRange.From) / step)
+		if b < 0 {
+			b = 0
+		} else if b >= buckets {
+			b = buckets - 1
+		}
+		return b
+	}
+
+	times := make([]*time.Time, buckets)
+	sums := make([][]float64, len(valueFields))
+	counts := make([][]int64, len(valueFields))
+	for i := range valueFields {
+		sums[i] = make([]float64, buckets)
+		counts[i] = make([]int64, buckets)
+	}
+	for row := 0; row < rows; row++ {
+		b := bucketOf(row)
+		if b < 0 {
+			continue
+		}
+		if times[b] == nil {
+			t, _ := timeField.ConcreteAt(row)
+			at := t.(time.Time)
+			if step != 0 {
+				at = timeRange.From.Add(time.Duration(b) * step)
+			}
+			times[b] = &at
+		}
+		for i, f := range valueFields {
+			v, err := f.FloatAt(row)
+			if err != nil || math.IsNaN(v) {
+				continue
+			}
+			sums[i][b] += v
+			counts[i][b]++
+		}
+	}
+
+	// Buckets without messages are left out rather than filled with nulls.
+	var kept []int64
+	for b := int64(0); b < buckets; b++ {
+		if times[b] != nil {
+			kept = append(kept, b)
+		}
+	}
+	keptTimes := make([]time.Time, 0, len(kept))
+	for _, b := range kept {
+		keptTimes = append(keptTimes, *times[b])
+	}
+	series.Fields = append(series.Fields, data.NewField(timeField.Name, nil, keptTimes))
+	for i, f := range valueFields {
+		values := make([]*float64, 0, len(kept))
+		for _, b := range kept {
+			if counts[i][b] == 0 {
+				values = append(values, nil)
+				continue
+			}
+			v := sums[i][b] / float64(counts[i][b])
+			values = append(values, &v)
+		}
+		series.Fields = append(series.Fields, data.NewField(f.Name, f.Labels, values).SetConfig(f.Config))
+	}
+	return series
+}
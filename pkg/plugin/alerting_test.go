@@ -0,0 +1,73 @@
+package plugin
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+func TestAlertSeries(t *testing.T) {
+	from := time.Unix(0, 0).UTC()
+	timeRange := backend.TimeRange{From: from, To: from.Add(4 * time.Second)}
+	one, three := int64(1), int64(3)
+	frame := data.NewFrame("response",
+		data.NewField("time", nil, []time.Time{
+			from, from.Add(500 * time.Millisecond), from.Add(time.Second), from.Add(3 * time.Second),
+		}),
+		data.NewField("value", nil, []float64{1, 3, 5, 7}),
+		data.NewField("count", nil, []*int64{&one, &three, nil, nil}),
+		data.NewField("name", nil, []string{"a", "b", "c", "d"}),
+		data.NewField("__offset", nil, []int64{10, 11, 12, 13}),
+	)
+
+	floats := func(values ...float64) []*float64 {
+		pointers := make([]*float64, len(values))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		return pointers
+	}
+
+	t.Run("numeric fields", func(t *testing.T) {
+		series := alertSeries(frame, timeRange, 0)
+		if len(series.Fields) != 3 {
+			t.Fatalf("expected the time and numeric fields, without the meta fields, got %d fields", len(series.Fields))
+		}
+		if got := series.Fields[1].At(3).(*float64); *got != 7 {
+			t.Errorf("expected 7, got %v", *got)
+		}
+		if got := series.Fields[2].At(2).(*float64); got != nil {
+			t.Errorf("expected null, got %v", *got)
+		}
+	})
+
+	t.Run("max data points", func(t *testing.T) {
+		series := alertSeries(frame, timeRange, 2)
+		expectedTimes := []time.Time{from, from.Add(2 * time.Second)}
+		var times []time.Time
+		for i := 0; i < series.Fields[0].Len(); i++ {
+			times = append(times, series.Fields[0].At(i).(time.Time))
+		}
+		if !reflect.DeepEqual(times, expectedTimes) {
+			t.Errorf("expected times %v, got %v", expectedTimes, times)
+		}
+		for i, expected := range floats(3, 7) {
+			if got := series.Fields[1].At(i).(*float64); *got != *expected {
+				t.Errorf("expected bucket %d to average to %v, got %v", i, *expected, *got)
+			}
+		}
+		if got := series.Fields[2].At(1).(*float64); got != nil {
+			t.Errorf("expected the bucket without counts to be null, got %v", *got)
+		}
+	})
+
+	t.Run("no time field", func(t *testing.T) {
+		series := alertSeries(data.NewFrame("response", data.NewField("value", nil, []float64{1})), timeRange, 0)
+		if len(series.Fields) != 0 {
+			t.Errorf("expected no fields, got %d", len(series.Fields))
+		}
+	})
+}
@@ -0,0 +1,115 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// annotationTimeout bounds every request to the Grafana HTTP API.
+const annotationTimeout = 5 * time.Second
+
+// annotationTag tags every annotation written by the datasource, along with
+// the event type and topic, so that dashboards can show them with a tag
+// filtered annotation query.
+const annotationTag = "kafka"
+
+// annotatedEvents are the events written as annotations: streams starting and
+// stopping, and the errors interrupting them until the broker is reached again.
+var annotatedEvents = map[string]bool{
+	eventStreamStarted:   true,
+	eventStreamStopped:   true,
+	eventBrokerError:     true,
+	eventBrokerReconnect: true,
+}
+
+// annotator writes datasource events as Grafana annotations.
+type annotator struct {
+	url          string
+	token        string
+	dashboardUID string
+	client       *http.Client
+}
+
+// newAnnotator returns the annotator of the settings, or nil unless both the
+// Grafana URL and API token are set.
+func newAnnotator(settings datasourceSettings) *annotator {
+	if settings.AnnotationsURL == "" || settings.AnnotationsToken == "" {
+		return nil
+	}
+	return &annotator{
+		url:          strings.TrimSuffix(settings.AnnotationsURL, "/") + "/api/annotations",
+		token:        settings.AnnotationsToken,
+		dashboardUID: settings.AnnotationsDashboardUID,
+		client:       &http.Client{Timeout: annotationTimeout},
+	}
+}
+
+type annotation struct {
+	DashboardUID string   `json:"dashboardUID,omitempty"`
+	Time         int64    `json:"time"`
+	Tags         []string `json:"tags"`
+	Text         string   `json:"text"`
+}
+
+// annotate writes the event as an annotation.
+func (a *annotator) annotate(ctx context.Context, e datasourceEvent) error {
+	tags := []string{annotationTag, e.Type}
+	if e.Topic != "" {
+		tags = append(tags, e.Topic)
+	}
+	body, err := json.Marshal(annotation{
+		DashboardUID: a.dashboardUID,
+		Time:         e.Time.UnixNano() / int64(time.Millisecond),
+		Tags:         tags,
+		Text:         e.Message,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+a.token)
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("grafana responded %s", resp.Status)
+	}
+	return nil
+}
+
+// run annotates the events of the hub until the instance is disposed. Events
+// are written one at a time, off the streams, and annotations that fail are
+// only logged. Streams stopped by the disposal aren't annotated, as they are
+// started again by the new instance right away.
+func (a *annotator) run(events *eventHub, disposed <-chan struct{}) {
+	ch := events.subscribe()
+	defer events.unsubscribe(ch)
+
+	for {
+		select {
+		case <-disposed:
+			return
+		case e := <-ch:
+			if !annotatedEvents[e.Type] {
+				continue
+			}
+			if err := a.annotate(context.Background(), e); err != nil {
+				log.DefaultLogger.Error("Error writing annotation", "event", e.Type, "topic", e.Topic, "error", err)
+			}
+		}
+	}
+}
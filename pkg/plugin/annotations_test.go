@@ -0,0 +1,73 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNewAnnotator(t *testing.T) {
+	if a := newAnnotator(datasourceSettings{AnnotationsURL: "http://grafana"}); a != nil {
+		t.Error("expected no annotator without a token")
+	}
+	if a := newAnnotator(datasourceSettings{AnnotationsToken: "token"}); a != nil {
+		t.Error("expected no annotator without a URL")
+	}
+	a := newAnnotator(datasourceSettings{AnnotationsURL: "http://grafana/", AnnotationsToken: "token"})
+	if a == nil || a.url != "http://grafana/api/annotations" {
+		t.Errorf("unexpected annotator %+v", a)
+	}
+}
+
+func TestAnnotate(t *testing.T) {
+	var got annotation
+	var authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Error(err)
+		}
+		if r.URL.Path == "/api/annotations" {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	a := newAnnotator(datasourceSettings{
+		AnnotationsURL:          server.URL,
+		AnnotationsToken:        "token",
+		AnnotationsDashboardUID: "uid",
+	})
+	e := datasourceEvent{
+		Time:    time.Unix(10, 0),
+		Type:    eventStreamStopped,
+		Topic:   "orders",
+		Message: "Stopped streaming partition all",
+	}
+	if err := a.annotate(context.Background(), e); err != nil {
+		t.Fatal(err)
+	}
+	expected := annotation{
+		DashboardUID: "uid",
+		Time:         10000,
+		Tags:         []string{annotationTag, eventStreamStopped, "orders"},
+		Text:         "Stopped streaming partition all",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %+v, got %+v", expected, got)
+	}
+	if authorization != "Bearer token" {
+		t.Errorf("unexpected authorization %q", authorization)
+	}
+
+	a.url = server.URL + "/missing"
+	if err := a.annotate(context.Background(), e); err == nil {
+		t.Error("expected an error for a failed response")
+	}
+}
@@ -0,0 +1,80 @@
+package plugin
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/hoptical/grafana-kafka-datasource/pkg/kafka_client"
+)
+
+// Ways to frame messages whose value is a top-level array.
+const (
+	// arrayItemsFields makes every item a field, or the parent of fields, of
+	// a single row named after the item key template.
+	arrayItemsFields = "fields"
+	// arrayItemsRows frames every item as a message of its own.
+	arrayItemsRows = "rows"
+)
+
+// defaultItemKeyTemplate names the items of top-level arrays by default.
+const defaultItemKeyTemplate = "item_%d"
+
+// itemKeyVerb is the verb formatting the index of items in key templates,
+// e.g. %d or %02d for padded indexes that sort well.
+var itemKeyVerb = regexp.MustCompile(`%0?[0-9]*d`)
+
+// parseItemKeyTemplate returns the template naming the items of top-level
+// arrays, which holds a single index verb.
+func parseItemKeyTemplate(template string) (string, error) {
+	if template == "" {
+		return defaultItemKeyTemplate, nil
+	}
+	if strings.Count(template, "%") != 1 || !itemKeyVerb.MatchString(template) {
+		return "", fmt.Errorf("item key template %q must hold a single index verb like %%d or %%02d", template)
+	}
+	return template, nil
+}
+
+func validateArrayItems(mode string) error {
+	switch mode {
+	case "", arrayItemsFields, arrayItemsRows:
+		return nil
+	}
+	return fmt.Errorf("unknown array items mode %q", mode)
+}
+
+// items returns the messages to frame out of a message: the message itself,
+// or, if its value is a top-level array, one message per item in rows mode
+// and otherwise a message whose fields are the items, keyed by the template.
+func (f messageFramer) items(msg kafka_client.KafkaMessage) []kafka_client.KafkaMessage {
+	if msg.Items == nil {
+		return []kafka_client.KafkaMessage{msg}
+	}
+
+	items := msg.Items
+	msg.Items = nil
+	if f.qm.ArrayItems != arrayItemsRows || len(items) == 0 {
+		template := f.itemKeys
+		if template == "" {
+			template = defaultItemKeyTemplate
+		}
+		msg.Value = make(map[string]interface{}, len(items))
+		for i, item := range items {
+			msg.Value[fmt.Sprintf(template, i)] = item
+		}
+		return []kafka_client.KafkaMessage{msg}
+	}
+
+	messages := make([]kafka_client.KafkaMessage, 0, len(items))
+	for _, item := range items {
+		m := msg
+		if object, ok := item.(map[string]interface{}); ok {
+			m.Value = object
+		} else {
+			m.Value = map[string]interface{}{"value": item}
+		}
+		messages = append(messages, m)
+	}
+	return messages
+}
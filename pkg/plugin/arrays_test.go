@@ -0,0 +1,47 @@
+package plugin
+
+import (
+	"testing"
+
+	"github.com/hoptical/grafana-kafka-datasource/pkg/kafka_client"
+)
+
+func TestParseItemKeyTemplate(t *testing.T) {
+	if template, err := parseItemKeyTemplate(""); err != nil || template != defaultItemKeyTemplate {
+		t.Errorf("expected the default template, got %q, %v", template, err)
+	}
+	if template, err := parseItemKeyTemplate("row[%02d]"); err != nil || template != "row[%02d]" {
+		t.Errorf("expected the template, got %q, %v", template, err)
+	}
+	for _, template := range []string{"row", "row%s", "%d_%d", "100%_%d"} {
+		if _, err := parseItemKeyTemplate(template); err == nil {
+			t.Errorf("expected an error for %q", template)
+		}
+	}
+}
+
+func TestMessageFramerItems(t *testing.T) {
+	msg := kafka_client.KafkaMessage{
+		Offset: 7,
+		Items:  []interface{}{map[string]interface{}{"id": "a"}, 2.0},
+	}
+
+	items := messageFramer{itemKeys: "row[%02d]"}.items(msg)
+	if len(items) != 1 || items[0].Items != nil {
+		t.Fatalf("expected a single message in fields mode, got %+v", items)
+	}
+	frame := newMessageFrame(items[0], items[0].Timestamp, queryModel{})
+	if !hasField(frame, "row[00].id") || !hasField(frame, "row[01]") {
+		t.Errorf("expected the items as fields named after the template, got %d fields", len(frame.Fields))
+	}
+
+	items = messageFramer{qm: queryModel{ArrayItems: arrayItemsRows}}.items(msg)
+	if len(items) != 2 || items[0].Value["id"] != "a" || items[1].Value["value"] != 2.0 || items[1].Offset != 7 {
+		t.Errorf("expected a message per item in rows mode, got %+v", items)
+	}
+
+	object := kafka_client.KafkaMessage{Value: map[string]interface{}{"id": "a"}}
+	if items := (messageFramer{qm: queryModel{ArrayItems: arrayItemsRows}}).items(object); len(items) != 1 || items[0].Value["id"] != "a" {
+		t.Errorf("expected objects to be left alone, got %+v", items)
+	}
+}
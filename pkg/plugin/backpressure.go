@@ -0,0 +1,215 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// frameQueueSize is the number of frames buffered for a slow Live connection
+// before the drop policy applies.
+const frameQueueSize = 100
+
+const (
+	dropPolicyNewest = "newest"
+	dropPolicyOldest = "oldest"
+)
+
+// dropNoticeChange is the factor by which the drop rate has to change for a
+// new notice to be sent while frames are dropped.
+const dropNoticeChange = 2
+
+// frameQueue decouples consuming a topic from sending its frames, so that a
+// browser or Live connection that can't keep up doesn't stall the consumer.
+// When the queue is full, the newest frame, or the oldest one with the
+// "oldest" policy, is dropped. The bytes of the queued frames count towards
+// the buffer usage of the instance, and frames are dropped while it exceeds
+// its maximum.
+type frameQueue struct {
+	frames chan queuedFrame
+	policy string
+	clock  clock
+	usage  *bufferUsage
+
+	mu      sync.Mutex
+	dropped throughputTracker
+	// overflowed tells that messages were dropped over the buffer usage
+	// maximum since the last frame sent.
+	overflowed bool
+}
+
+type queuedFrame struct {
+	frame *data.Frame
+	bytes int64
+}
+
+func newFrameQueue(policy string, clock clock, usage *bufferUsage) *frameQueue {
+	return &frameQueue{
+		frames: make(chan queuedFrame, frameQueueSize),
+		policy: policy,
+		clock:  clock,
+		usage:  usage,
+	}
+}
+
+// push queues the frame without ever blocking.
+func (q *frameQueue) push(frame *data.Frame) {
+	if q.usage.over() {
+		q.overflow()
+		return
+	}
+	queued := queuedFrame{frame: frame, bytes: frameBytes(frame)}
+	if q.offer(queued) {
+		return
+	}
+
+	if q.policy == dropPolicyOldest {
+		select {
+		case oldest := <-q.frames:
+			q.usage.add(-oldest.bytes)
+			q.drop()
+		default:
+		}
+		if q.offer(queued) {
+			return
+		}
+	}
+	q.drop()
+}
+
+// offer queues the frame if there's room.
+func (q *frameQueue) offer(queued queuedFrame) bool {
+	select {
+	case q.frames <- queued:
+		q.usage.add(queued.bytes)
+		return true
+	default:
+		return false
+	}
+}
+
+// next returns the next queued frame, which no longer counts as buffered.
+func (q *frameQueue) next(queued queuedFrame) *data.Frame {
+	q.usage.add(-queued.bytes)
+	return queued.frame
+}
+
+func (q *frameQueue) drop() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.dropped.add(q.clock.Now(), 0)
+}
+
+// overflow records messages dropped over the buffer usage maximum, which the
+// next frame sent tells about.
+func (q *frameQueue) overflow() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.overflowed = true
+}
+
+// overflowNotice returns the notice of the messages dropped over the buffer
+// usage maximum since the last call, if any.
+func (q *frameQueue) overflowNotice() *data.Notice {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.overflowed {
+		return nil
+	}
+	q.overflowed = false
+	return &data.Notice{
+		Severity: data.NoticeSeverityWarning,
+		Text:     fmt.Sprintf("Messages were dropped while %s", errBufferLimit(q.usage.max)),
+	}
+}
+
+// dropRate returns the frames dropped per second over the throughput window.
+func (q *frameQueue) dropRate() float64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	rate, _ := q.dropped.rates(q.clock.Now())
+	return rate
+}
+
+// run sends the queued frames until ctx is done. When frames start being
+// dropped, the next frame sent carries a notice with the drop rate, which is
+// sent again only once the rate changes by dropNoticeChange, or dropping stops
+// and resumes. Frames failing to be sent count as dropped, and only the first
+// error of a streak is logged.
+func (q *frameQueue) run(ctx context.Context, send func(*data.Frame) error) {
+	failing := false
+	var notified float64
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case queued := <-q.frames:
+			frame := q.next(queued)
+			// Dropping starting counts as a change from a zero rate, and
+			// stopping as a change to it.
+			rate := q.dropRate()
+			notify := rate >= notified*dropNoticeChange || rate <= notified/dropNoticeChange
+			if notify && rate > 0 {
+				frame.AppendNotices(data.Notice{
+					Severity: data.NoticeSeverityWarning,
+					Text:     fmt.Sprintf("Client can't keep up, dropping %.1f msg/s", rate),
+				})
+			}
+			overflow := q.overflowNotice()
+			if overflow != nil {
+				frame.AppendNotices(*overflow)
+			}
+			if err := send(frame); err != nil {
+				if !failing {
+					log.DefaultLogger.Error("Error sending frame", "error", err)
+				}
+				failing = true
+				q.drop()
+				if overflow != nil {
+					q.overflow()
+				}
+				continue
+			}
+			failing = false
+			// The notice of a frame failing to be sent is sent again with
+			// the next one.
+			if notify {
+				notified = rate
+			}
+		}
+	}
+}
+
+// flush sends the frames left in the queue, once run returned, and returns
+// how many were sent.
+func (q *frameQueue) flush(send func(*data.Frame) error) int {
+	sent := 0
+	for {
+		select {
+		case queued := <-q.frames:
+			if err := send(q.next(queued)); err != nil {
+				log.DefaultLogger.Error("Error flushing frames", "error", err)
+				q.discard()
+				return sent
+			}
+			sent++
+		default:
+			return sent
+		}
+	}
+}
+
+// discard drops the frames left in the queue, once run returned.
+func (q *frameQueue) discard() {
+	for {
+		select {
+		case queued := <-q.frames:
+			q.next(queued)
+		default:
+			return
+		}
+	}
+}
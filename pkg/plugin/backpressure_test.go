@@ -0,0 +1,176 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+type fixedClock struct {
+	now time.Time
+}
+
+func (c fixedClock) Now() time.Time {
+	return c.now
+}
+
+func (c fixedClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func TestFrameQueueDropPolicy(t *testing.T) {
+	for _, policy := range []string{dropPolicyNewest, dropPolicyOldest} {
+		queue := newFrameQueue(policy, fixedClock{time.Unix(100, 0)}, nil)
+		for i := 0; i < frameQueueSize+5; i++ {
+			queue.push(data.NewFrame(string(rune('a' + i%26))))
+		}
+
+		if got := queue.dropRate(); got != 0.5 {
+			t.Errorf("%s: expected 5 drops over the window, got a rate of %v", policy, got)
+		}
+		first := queue.next(<-queue.frames)
+		want := "a"
+		if policy == dropPolicyOldest {
+			want = "f"
+		}
+		if first.Name != want {
+			t.Errorf("%s: expected frame %s at the head of the queue, got %s", policy, want, first.Name)
+		}
+	}
+}
+
+func TestFrameQueueRun(t *testing.T) {
+	queue := newFrameQueue(dropPolicyNewest, fixedClock{time.Unix(100, 0)}, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var sent []*data.Frame
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		queue.run(ctx, func(frame *data.Frame) error {
+			if frame.Name == "failing" {
+				return errors.New("connection closed")
+			}
+			sent = append(sent, frame)
+			if len(sent) == 1 {
+				cancel()
+			}
+			return nil
+		})
+	}()
+
+	queue.push(data.NewFrame("failing"))
+	queue.push(data.NewFrame("ok"))
+	<-done
+
+	if len(sent) != 1 {
+		t.Fatalf("expected 1 frame to be sent, got %d", len(sent))
+	}
+	if sent[0].Meta == nil || len(sent[0].Meta.Notices) != 1 ||
+		!strings.Contains(sent[0].Meta.Notices[0].Text, "can't keep up") {
+		t.Errorf("expected a notice about the failed frame, got %+v", sent[0].Meta)
+	}
+}
+
+func TestFrameQueueDropNotice(t *testing.T) {
+	queue := newFrameQueue(dropPolicyNewest, fixedClock{time.Unix(100, 0)}, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var notices []int
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		queue.run(ctx, func(frame *data.Frame) error {
+			if frame.Name == "failing" {
+				return errors.New("connection closed")
+			}
+			n := 0
+			if frame.Meta != nil {
+				n = len(frame.Meta.Notices)
+			}
+			notices = append(notices, n)
+			if len(notices) == 3 {
+				cancel()
+			}
+			return nil
+		})
+	}()
+
+	// The notice is sent when dropping starts, not again at the same rate,
+	// and again once the rate tripled.
+	for _, name := range []string{"failing", "ok", "ok", "failing", "failing", "ok"} {
+		queue.push(data.NewFrame(name))
+	}
+	<-done
+
+	if expected := []int{1, 0, 1}; !reflect.DeepEqual(notices, expected) {
+		t.Errorf("expected notices %v, got %v", expected, notices)
+	}
+}
+
+func TestFrameQueueUsage(t *testing.T) {
+	usage := &bufferUsage{}
+	queue := newFrameQueue(dropPolicyOldest, fixedClock{time.Unix(100, 0)}, usage)
+	frame := data.NewFrame("response", data.NewField("value", nil, []string{"abcd"}))
+
+	for i := 0; i < frameQueueSize+1; i++ {
+		queue.push(frame)
+	}
+	if expected := int64(4 * frameQueueSize); usage.bytes != expected {
+		t.Errorf("expected %d bytes queued, got %d", expected, usage.bytes)
+	}
+
+	queue.flush(func(*data.Frame) error { return nil })
+	if usage.bytes != 0 {
+		t.Errorf("expected the sent frames to be released, got %d bytes", usage.bytes)
+	}
+
+	queue.push(frame)
+	queue.discard()
+	if usage.bytes != 0 {
+		t.Errorf("expected the discarded frames to be released, got %d bytes", usage.bytes)
+	}
+}
+
+func TestFrameQueueBufferLimit(t *testing.T) {
+	usage := &bufferUsage{max: 100}
+	queue := newFrameQueue(dropPolicyNewest, fixedClock{time.Unix(100, 0)}, usage)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sent := make(chan *data.Frame)
+	go queue.run(ctx, func(frame *data.Frame) error {
+		sent <- frame
+		return nil
+	})
+	push := func(name string) {
+		queue.push(data.NewFrame(name, data.NewField("value", nil, []string{"abcd"})))
+	}
+
+	push("before")
+	if frame := <-sent; frame.Name != "before" || frame.Meta != nil {
+		t.Errorf("expected the frame before the limit without notice, got %v", frame)
+	}
+	// Other streams of the instance cross the limit while this one runs.
+	usage.add(200)
+	push("over")
+	usage.add(-200)
+	push("after")
+
+	frame := <-sent
+	if frame.Name != "after" {
+		t.Fatalf("expected the frame over the limit to be dropped, got %q", frame.Name)
+	}
+	if frame.Meta == nil || len(frame.Meta.Notices) != 1 ||
+		!strings.Contains(frame.Meta.Notices[0].Text, "more than 100 bytes") {
+		t.Errorf("expected a notice of the dropped messages, got %v", frame.Meta)
+	}
+	if usage.bytes != 0 {
+		t.Errorf("expected the sent frames to be released, got %d bytes", usage.bytes)
+	}
+}
@@ -0,0 +1,92 @@
+package plugin
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+
+	"github.com/hoptical/grafana-kafka-datasource/pkg/kafka_client"
+)
+
+const (
+	binaryBase64 = "base64"
+	binaryHex    = "hex"
+	binaryLength = "length"
+	binaryUTF8   = "utf8"
+)
+
+// parseBinaryFields parses a comma separated list of field:rendering pairs,
+// e.g. "payload:hex, __key:length".
+func parseBinaryFields(s string) (map[string]string, error) {
+	renderings := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		i := strings.LastIndex(pair, ":")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid binary field %q, expected field:rendering", pair)
+		}
+		field, rendering := strings.TrimSpace(pair[:i]), strings.TrimSpace(pair[i+1:])
+		switch rendering {
+		case binaryBase64, binaryHex, binaryLength, binaryUTF8:
+		default:
+			return nil, fmt.Errorf("invalid binary rendering %q of field %s", rendering, field)
+		}
+		renderings[field] = rendering
+	}
+	return renderings, nil
+}
+
+// renderBinary renders bytes as base64, hex, their length, or UTF-8 if valid
+// and base64 otherwise.
+func renderBinary(b []byte, rendering string) *data.Field {
+	switch rendering {
+	case binaryHex:
+		return data.NewField("", nil, []string{hex.EncodeToString(b)})
+	case binaryLength:
+		return data.NewField("", nil, []int64{int64(len(b))})
+	case binaryUTF8:
+		if utf8.Valid(b) {
+			return data.NewField("", nil, []string{string(b)})
+		}
+	}
+	return data.NewField("", nil, []string{base64.StdEncoding.EncodeToString(b)})
+}
+
+// applyBinaryFields renders the binary fields of the frame as asked. JSON
+// encodes bytes, like those of Protobuf or Avro messages, as base64 strings;
+// strings that aren't valid base64 are left untouched. The __key field is
+// rendered out of the raw message key.
+func applyBinaryFields(frame *data.Frame, msg kafka_client.KafkaMessage, renderings map[string]string) {
+	for i, field := range frame.Fields {
+		rendering, ok := renderings[field.Name]
+		if !ok || field.Len() == 0 {
+			continue
+		}
+
+		var b []byte
+		if field.Name == "__key" {
+			b = msg.Key
+		} else {
+			s, ok := field.At(0).(string)
+			if !ok {
+				continue
+			}
+			var err error
+			if b, err = base64.StdEncoding.DecodeString(s); err != nil {
+				continue
+			}
+		}
+
+		rendered := renderBinary(b, rendering)
+		rendered.Name = field.Name
+		rendered.Labels = field.Labels
+		rendered.Config = field.Config
+		frame.Fields[i] = rendered
+	}
+}
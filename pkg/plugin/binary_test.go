@@ -0,0 +1,59 @@
+package plugin
+
+import (
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+
+	"github.com/hoptical/grafana-kafka-datasource/pkg/kafka_client"
+)
+
+func TestParseBinaryFields(t *testing.T) {
+	renderings, err := parseBinaryFields("payload:hex, nested.blob : length,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(renderings) != 2 || renderings["payload"] != binaryHex || renderings["nested.blob"] != binaryLength {
+		t.Errorf("unexpected renderings %v", renderings)
+	}
+
+	for _, s := range []string{"payload", ":hex", "payload:octal"} {
+		if _, err := parseBinaryFields(s); err == nil {
+			t.Errorf("expected an error for %q", s)
+		}
+	}
+}
+
+func TestApplyBinaryFields(t *testing.T) {
+	tests := []struct {
+		rendering string
+		value     string
+		want      interface{}
+	}{
+		{binaryBase64, "aGk=", "aGk="},
+		{binaryHex, "aGk=", "6869"},
+		{binaryLength, "aGk=", int64(2)},
+		{binaryUTF8, "aGk=", "hi"},
+		{binaryUTF8, "/w==", "/w=="},
+		{binaryHex, "not base64", "not base64"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.rendering+" "+tt.value, func(t *testing.T) {
+			frame := data.NewFrame("response", data.NewField("payload", nil, []string{tt.value}))
+			applyBinaryFields(frame, kafka_client.KafkaMessage{}, map[string]string{"payload": tt.rendering})
+			if got := frameField(frame, "payload").At(0); got != tt.want {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestApplyBinaryFieldsKey(t *testing.T) {
+	frame := data.NewFrame("response", data.NewField("__key", nil, []string{"�"}))
+	msg := kafka_client.KafkaMessage{Key: []byte{0xff, 0x01}}
+	applyBinaryFields(frame, msg, map[string]string{"__key": binaryHex})
+	if got := frameField(frame, "__key").At(0); got != "ff01" {
+		t.Errorf("expected the raw key in hex, got %v", got)
+	}
+}
@@ -0,0 +1,96 @@
+package plugin
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+const (
+	defaultErrorBudget   = 10
+	defaultErrorCooldown = 30 * time.Second
+)
+
+// errorBudget suspends the partitions of a stream that fail to be read too
+// many times in a row, e.g. because of a corrupted segment, for a cool-down
+// period instead of hot looping on them.
+type errorBudget struct {
+	limit     int
+	cooldown  time.Duration
+	failures  map[int32]int
+	suspended map[int32]time.Time
+}
+
+func newErrorBudget(qm queryModel) (*errorBudget, error) {
+	b := &errorBudget{
+		limit:     qm.ErrorBudget,
+		cooldown:  defaultErrorCooldown,
+		failures:  make(map[int32]int),
+		suspended: make(map[int32]time.Time),
+	}
+	if b.limit <= 0 {
+		b.limit = defaultErrorBudget
+	}
+	if qm.ErrorCooldown != "" {
+		cooldown, err := time.ParseDuration(qm.ErrorCooldown)
+		if err != nil || cooldown <= 0 {
+			return nil, fmt.Errorf("invalid error cool-down %q", qm.ErrorCooldown)
+		}
+		b.cooldown = cooldown
+	}
+	return b, nil
+}
+
+// failure records a read error of the partition and reports whether it used
+// up the budget, in which case the partition has to be suspended.
+func (b *errorBudget) failure(now time.Time, partition int32) bool {
+	b.failures[partition]++
+	if b.failures[partition] < b.limit {
+		return false
+	}
+	b.failures[partition] = 0
+	b.suspended[partition] = now.Add(b.cooldown)
+	return true
+}
+
+func (b *errorBudget) success(partition int32) {
+	b.failures[partition] = 0
+}
+
+// due returns the suspended partitions whose cool-down is over, which are no
+// longer considered suspended.
+func (b *errorBudget) due(now time.Time) []int32 {
+	var partitions []int32
+	for partition, until := range b.suspended {
+		if !now.Before(until) {
+			partitions = append(partitions, partition)
+			delete(b.suspended, partition)
+		}
+	}
+	return partitions
+}
+
+// notice lists the suspended partitions, if any.
+func (b *errorBudget) notice() *data.Notice {
+	if len(b.suspended) == 0 {
+		return nil
+	}
+	partitions := make([]int, 0, len(b.suspended))
+	for partition := range b.suspended {
+		partitions = append(partitions, int(partition))
+	}
+	sort.Ints(partitions)
+	names := make([]string, len(partitions))
+	for i, partition := range partitions {
+		names[i] = strconv.Itoa(partition)
+	}
+	return &data.Notice{
+		Severity: data.NoticeSeverityWarning,
+		Text: fmt.Sprintf("Partitions %s suspended for %s after %d consecutive read errors",
+			strings.Join(names, ", "), b.cooldown, b.limit),
+	}
+}
@@ -0,0 +1,43 @@
+package plugin
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestErrorBudget(t *testing.T) {
+	budget, err := newErrorBudget(queryModel{ErrorBudget: 3, ErrorCooldown: "1m"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(100, 0)
+
+	budget.failure(now, 2)
+	budget.failure(now, 2)
+	budget.success(2)
+	if budget.failure(now, 2) || budget.failure(now, 2) {
+		t.Error("expected a success to reset the budget")
+	}
+	if !budget.failure(now, 2) {
+		t.Fatal("expected the partition to be suspended after 3 consecutive errors")
+	}
+
+	notice := budget.notice()
+	if notice == nil || !strings.Contains(notice.Text, "Partitions 2 suspended for 1m0s") {
+		t.Errorf("unexpected notice %+v", notice)
+	}
+	if due := budget.due(now.Add(30 * time.Second)); len(due) != 0 {
+		t.Errorf("expected no partition to resume during the cool-down, got %v", due)
+	}
+	if due := budget.due(now.Add(time.Minute)); len(due) != 1 || due[0] != 2 {
+		t.Errorf("expected partition 2 to resume after the cool-down, got %v", due)
+	}
+	if budget.notice() != nil {
+		t.Error("expected no notice once resumed")
+	}
+
+	if _, err := newErrorBudget(queryModel{ErrorCooldown: "later"}); err == nil {
+		t.Error("expected an invalid cool-down to fail")
+	}
+}
@@ -0,0 +1,75 @@
+package plugin
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// changeDetector suppresses frames whose monitored fields haven't changed since
+// the last frame sent, which cuts most of the Live traffic of topics carrying
+// slowly changing state. Unchanged frames are still sent once per heartbeat,
+// if set, so that panels don't look stale.
+type changeDetector struct {
+	fields    map[string]struct{}
+	heartbeat time.Duration
+	last      string
+	lastSent  time.Time
+}
+
+func newChangeDetector(qm queryModel) (*changeDetector, error) {
+	c := &changeDetector{}
+	for _, field := range strings.Split(qm.ChangeFields, ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			if c.fields == nil {
+				c.fields = make(map[string]struct{})
+			}
+			c.fields[field] = struct{}{}
+		}
+	}
+	if qm.ChangeHeartbeat != "" {
+		heartbeat, err := time.ParseDuration(qm.ChangeHeartbeat)
+		if err != nil || heartbeat <= 0 {
+			return nil, fmt.Errorf("invalid change heartbeat %q", qm.ChangeHeartbeat)
+		}
+		c.heartbeat = heartbeat
+	}
+	return c, nil
+}
+
+// changed reports whether the frame has to be sent, and if so records it as
+// the last frame sent.
+func (c *changeDetector) changed(now time.Time, frame *data.Frame) bool {
+	key := c.key(frame)
+	if key == c.last && (c.heartbeat == 0 || now.Sub(c.lastSent) < c.heartbeat) {
+		return false
+	}
+	c.last = key
+	c.lastSent = now
+	return true
+}
+
+// key renders the monitored fields of the frame. Without designated fields,
+// all of them are monitored but the time and the meta fields, like __delay,
+// which change with every message.
+func (c *changeDetector) key(frame *data.Frame) string {
+	var b strings.Builder
+	for _, f := range frame.Fields {
+		if c.fields != nil {
+			if _, ok := c.fields[f.Name]; !ok {
+				continue
+			}
+		} else if f.Type() == data.FieldTypeTime || strings.HasPrefix(f.Name, "__") {
+			continue
+		}
+		b.WriteString(f.Name)
+		b.WriteString(f.Labels.String())
+		for i := 0; i < f.Len(); i++ {
+			fmt.Fprintf(&b, "=%v", f.At(i))
+		}
+		b.WriteByte(';')
+	}
+	return b.String()
+}
@@ -0,0 +1,58 @@
+package plugin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hoptical/grafana-kafka-datasource/pkg/kafka_client"
+)
+
+func TestChangeDetector(t *testing.T) {
+	changes, err := newChangeDetector(queryModel{ChangeHeartbeat: "1m"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	frame := func(now time.Time, state string, bytes float64) bool {
+		msg := kafka_client.KafkaMessage{
+			Timestamp: now.Add(-time.Second),
+			Value:     map[string]interface{}{"state": state},
+			Size:      int(bytes),
+		}
+		qm := queryModel{TimestampMode: "now", MessageStats: true}
+		return changes.changed(now, newMessageFrame(msg, now, qm))
+	}
+
+	start := time.Unix(100, 0)
+	if !frame(start, "on", 10) {
+		t.Error("expected the first frame to be sent")
+	}
+	if frame(start.Add(time.Second), "on", 20) {
+		t.Error("expected a frame with unchanged values to be suppressed")
+	}
+	if !frame(start.Add(2*time.Second), "off", 20) {
+		t.Error("expected a frame with changed values to be sent")
+	}
+	if !frame(start.Add(2*time.Minute), "off", 20) {
+		t.Error("expected an unchanged frame to be sent after the heartbeat")
+	}
+
+	changes, err = newChangeDetector(queryModel{ChangeFields: "level"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	qm := queryModel{TimestampMode: "message"}
+	for i, value := range []map[string]interface{}{
+		{"level": 1.0, "noise": 1.0},
+		{"level": 1.0, "noise": 2.0},
+	} {
+		sent := changes.changed(start, newMessageFrame(kafka_client.KafkaMessage{Value: value}, start, qm))
+		if sent != (i == 0) {
+			t.Errorf("frame %d: expected only changes of the designated fields to count", i)
+		}
+	}
+
+	if _, err := newChangeDetector(queryModel{ChangeHeartbeat: "often"}); err == nil {
+		t.Error("expected an invalid heartbeat to fail")
+	}
+}
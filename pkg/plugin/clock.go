@@ -0,0 +1,20 @@
+package plugin
+
+import "time"
+
+// clock abstracts the passage of time for the streaming code, so that tests
+// can drive timestamps, rates and backoffs deterministically.
+type clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
@@ -0,0 +1,108 @@
+package plugin
+
+import (
+	"time"
+
+	"github.com/hoptical/grafana-kafka-datasource/pkg/kafka_client"
+)
+
+// rangeReadKey identifies the reads of time ranges whose messages are the
+// same, whatever the queries then make of them.
+type rangeReadKey struct {
+	topic         string
+	partition     int32
+	from          int64
+	to            int64
+	messageFormat string
+	rawPattern    string
+	budget        time.Duration
+}
+
+// rangeReads shares the reads of time ranges between the queries of a
+// QueryData request, so that dashboards with several queries of the same
+// topic and range, e.g. selecting or naming different fields, read it from
+// the brokers once. Queries are run one after the other, so it isn't safe
+// for concurrent use. A nil rangeReads doesn't share anything.
+//
+// The bytes of the messages read count towards usage until the reads are
+// released, at the end of the request. Reads fail rather than exceed the
+// maximum of usage.
+type rangeReads struct {
+	sizes   map[rangeReadKey]int64
+	results map[rangeReadKey]kafka_client.RangeResult
+	usage   *bufferUsage
+	bytes   int64
+}
+
+func newRangeReads(usage *bufferUsage) *rangeReads {
+	return &rangeReads{
+		sizes:   make(map[rangeReadKey]int64),
+		results: make(map[rangeReadKey]kafka_client.RangeResult),
+		usage:   usage,
+	}
+}
+
+// release stops counting the messages read towards the buffer usage.
+func (r *rangeReads) release() {
+	if r == nil {
+		return
+	}
+	r.usage.add(-r.bytes)
+	r.bytes = 0
+}
+
+// size returns the number of messages of the range, which doesn't depend on
+// how they are decoded.
+func (r *rangeReads) size(key rangeReadKey, read func() (int64, error)) (int64, error) {
+	key.messageFormat, key.rawPattern, key.budget = "", "", 0
+	if r == nil {
+		return read()
+	}
+	if size, ok := r.sizes[key]; ok {
+		return size, nil
+	}
+	size, err := read()
+	if err == nil {
+		r.sizes[key] = size
+	}
+	return size, err
+}
+
+// result returns the messages of the range. Failed reads aren't shared, so
+// that the next query tries again.
+func (r *rangeReads) result(key rangeReadKey,
+	read func() (kafka_client.RangeResult, error)) (kafka_client.RangeResult, error) {
+	if r == nil {
+		return read()
+	}
+	if result, ok := r.results[key]; ok {
+		return result, nil
+	}
+	if r.usage.over() {
+		return kafka_client.RangeResult{}, errBufferLimit(r.usage.max)
+	}
+	result, err := read()
+	if err != nil {
+		return result, err
+	}
+	bytes := messagesBytes(result.Messages)
+	if !r.usage.reserve(bytes) {
+		return kafka_client.RangeResult{}, errBufferLimit(r.usage.max)
+	}
+	r.results[key] = result
+	r.bytes += bytes
+	return result, nil
+}
+
+// rangeKey returns the key of the read of the time range of the query.
+func rangeKey(qm queryModel, from, to time.Time, budget time.Duration) rangeReadKey {
+	return rangeReadKey{
+		topic:         qm.Topic,
+		partition:     int32(qm.Partition),
+		from:          from.UnixNano(),
+		to:            to.UnixNano(),
+		messageFormat: qm.MessageFormat,
+		rawPattern:    qm.RawPattern,
+		budget:        budget,
+	}
+}
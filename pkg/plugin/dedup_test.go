@@ -0,0 +1,65 @@
+package plugin
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hoptical/grafana-kafka-datasource/pkg/kafka_client"
+)
+
+func TestRangeReadsResult(t *testing.T) {
+	reads := newRangeReads(nil)
+	from, to := time.Unix(0, 0), time.Unix(60, 0)
+	calls := 0
+	read := func() (kafka_client.RangeResult, error) {
+		calls++
+		return kafka_client.RangeResult{Messages: []kafka_client.KafkaMessage{{Offset: 1}}}, nil
+	}
+
+	// Queries only differing in what they make of the messages share them.
+	key := rangeKey(queryModel{Topic: "t", Partition: 0, RefID: "A"}, from, to, 0)
+	if _, err := reads.result(key, read); err != nil {
+		t.Fatal(err)
+	}
+	key = rangeKey(queryModel{Topic: "t", Partition: 0, RefID: "B", EmptyKeyName: "_"}, from, to, 0)
+	if result, err := reads.result(key, read); err != nil || len(result.Messages) != 1 {
+		t.Fatalf("unexpected result %+v, %v", result, err)
+	}
+	if calls != 1 {
+		t.Errorf("expected a single read, got %d", calls)
+	}
+
+	// Messages decoded otherwise are read again.
+	key = rangeKey(queryModel{Topic: "t", Partition: 0, MessageFormat: "raw"}, from, to, 0)
+	if _, err := reads.result(key, read); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 {
+		t.Errorf("expected a second read for another format, got %d", calls)
+	}
+}
+
+func TestRangeReadsFailure(t *testing.T) {
+	reads := newRangeReads(nil)
+	key := rangeKey(queryModel{Topic: "t"}, time.Unix(0, 0), time.Unix(60, 0), 0)
+	calls := 0
+	read := func() (int64, error) {
+		calls++
+		if calls == 1 {
+			return 0, errors.New("broker unreachable")
+		}
+		return 10, nil
+	}
+	if _, err := reads.size(key, read); err == nil {
+		t.Fatal("expected the error of the read")
+	}
+	if size, err := reads.size(key, read); err != nil || size != 10 {
+		t.Errorf("expected the read to be tried again, got %d, %v", size, err)
+	}
+
+	var none *rangeReads
+	if size, _ := none.size(key, read); size != 10 || calls != 3 {
+		t.Errorf("expected nil reads to read every time, got %d reads", calls)
+	}
+}
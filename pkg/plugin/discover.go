@@ -0,0 +1,111 @@
+package plugin
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+
+	"github.com/hoptical/grafana-kafka-datasource/pkg/kafka_client"
+)
+
+// defaultDiscoveryMessages is the default number of messages sampled to
+// discover fields.
+const defaultDiscoveryMessages = 100
+
+// discoveredField is a flattened field found in sampled messages.
+type discoveredField struct {
+	Name string `json:"name"`
+	// Types are the types of the values of the field: boolean, number,
+	// string or null.
+	Types []string `json:"types"`
+	// Messages counts the sampled messages holding the field.
+	Messages int `json:"messages"`
+}
+
+// fieldDiscovery accumulates the flattened fields of sampled messages.
+type fieldDiscovery struct {
+	types    map[string]map[string]bool
+	messages map[string]int
+}
+
+func newFieldDiscovery() *fieldDiscovery {
+	return &fieldDiscovery{types: make(map[string]map[string]bool), messages: make(map[string]int)}
+}
+
+// observe accounts for the flattened fields of a message.
+func (f *fieldDiscovery) observe(fields map[string]interface{}) {
+	for name, value := range fields {
+		types, ok := f.types[name]
+		if !ok {
+			types = make(map[string]bool)
+			f.types[name] = types
+		}
+		types[valueType(value)] = true
+		f.messages[name]++
+	}
+}
+
+// fields returns the discovered fields, sorted by name.
+func (f *fieldDiscovery) fields() []discoveredField {
+	fields := make([]discoveredField, 0, len(f.types))
+	for name, types := range f.types {
+		field := discoveredField{Name: name, Messages: f.messages[name]}
+		for t := range types {
+			field.Types = append(field.Types, t)
+		}
+		sort.Strings(field.Types)
+		fields = append(fields, field)
+	}
+	sort.Slice(fields, func(i, j int) bool { return fields[i].Name < fields[j].Name })
+	return fields
+}
+
+// valueType returns the type of a flattened value.
+func valueType(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	}
+	return "string"
+}
+
+// handleDiscoverFields samples the latest messages of a topic and returns the
+// union of their flattened fields, named as in the frames of streams, along
+// with the types of their values, e.g. to offer the fields of a topic in the
+// query editor. Messages that fail to decode are skipped.
+func (d *KafkaDatasource) handleDiscoverFields(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+	query := r.URL.Query()
+	itemKeys, err := parseItemKeyTemplate(query.Get("itemKeyTemplate"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	topic, messages, ok := d.readRequestedSample(w, r, defaultDiscoveryMessages, kafka_client.MAX_SAMPLE_MESSAGES)
+	if !ok {
+		return
+	}
+
+	emptyKeyName := query.Get("emptyKeyName")
+	discovery := newFieldDiscovery()
+	sampled := 0
+	for _, msg := range messages {
+		if msg.Err != nil {
+			continue
+		}
+		discovery.observe(messageFields(msg, itemKeys, emptyKeyName))
+		sampled++
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"topic":   topic,
+		"fields":  discovery.fields(),
+		"samples": sampled,
+	})
+}
@@ -0,0 +1,52 @@
+package plugin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestFieldDiscovery(t *testing.T) {
+	discovery := newFieldDiscovery()
+	discovery.observe(map[string]interface{}{"id": 1.0, "owner.name": "a", "active": true})
+	discovery.observe(map[string]interface{}{"id": "2", "owner.name": nil})
+
+	expected := []discoveredField{
+		{Name: "active", Types: []string{"boolean"}, Messages: 1},
+		{Name: "id", Types: []string{"number", "string"}, Messages: 2},
+		{Name: "owner.name", Types: []string{"null", "string"}, Messages: 2},
+	}
+	if got := discovery.fields(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %+v, got %+v", expected, got)
+	}
+}
+
+func TestHandleDiscoverFieldsValidation(t *testing.T) {
+	d := &KafkaDatasource{}
+	mux := d.newResourceMux()
+
+	tests := []struct {
+		name   string
+		method string
+		url    string
+		status int
+	}{
+		{"wrong method", http.MethodPost, "/discover-fields?topic=t", http.StatusMethodNotAllowed},
+		{"missing topic", http.MethodGet, "/discover-fields", http.StatusBadRequest},
+		{"invalid partition", http.MethodGet, "/discover-fields?topic=t&partition=x", http.StatusBadRequest},
+		{"too many messages", http.MethodGet, "/discover-fields?topic=t&n=5000", http.StatusBadRequest},
+		{"unknown format", http.MethodGet, "/discover-fields?topic=t&messageFormat=xml", http.StatusBadRequest},
+		{"invalid item key template", http.MethodGet, "/discover-fields?topic=t&itemKeyTemplate=%25s", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequest(tt.method, tt.url, nil))
+			if w.Code != tt.status {
+				t.Errorf("expected status %d, got %d: %s", tt.status, w.Code, w.Body.String())
+			}
+		})
+	}
+}
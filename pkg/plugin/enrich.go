@@ -0,0 +1,242 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+
+	"github.com/hoptical/grafana-kafka-datasource/pkg/kafka_client"
+)
+
+// maxReferenceKeys bounds the keys held by a reference table, beyond which
+// new keys are ignored.
+const maxReferenceKeys = 100000
+
+// referenceLoadTimeout bounds how long the initial load of a reference table
+// takes, after which queries go on with the keys read so far.
+const referenceLoadTimeout = 10 * time.Second
+
+// referenceTable holds the latest value of every key of a compacted reference
+// topic, e.g. device metadata, which streamed messages are joined with by key.
+type referenceTable struct {
+	mu     sync.RWMutex
+	topic  string
+	values map[string]map[string]interface{}
+	// fields are the top-level fields of reference values joined onto
+	// messages, or all of them if empty.
+	fields []string
+	// max bounds the keys held. full is set once keys were ignored because
+	// of it, and partial if the topic wasn't read up to its end in time.
+	max     int
+	full    bool
+	partial bool
+	// sizes holds the bytes of the message of every key, which count
+	// towards usage until the table is released.
+	sizes map[string]int64
+	usage *bufferUsage
+}
+
+func newReferenceTable(topic, fields string) *referenceTable {
+	t := &referenceTable{
+		topic:  topic,
+		values: make(map[string]map[string]interface{}),
+		max:    maxReferenceKeys,
+		sizes:  make(map[string]int64),
+	}
+	for _, field := range strings.Split(fields, ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			t.fields = append(t.fields, field)
+		}
+	}
+	return t
+}
+
+// update stores the value of a reference message. Tombstones, messages
+// without a value, delete their key.
+func (t *referenceTable) update(msg kafka_client.KafkaMessage) {
+	if msg.Key == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := string(msg.Key)
+	switch {
+	case len(msg.RawValue) == 0:
+		delete(t.values, key)
+		t.usage.add(-t.sizes[key])
+		delete(t.sizes, key)
+	case msg.Err == nil:
+		if _, ok := t.values[key]; !ok && len(t.values) >= t.max {
+			t.full = true
+			return
+		}
+		t.values[key] = msg.Value
+		size := int64(len(msg.Key) + len(msg.RawValue))
+		t.usage.add(size - t.sizes[key])
+		t.sizes[key] = size
+	}
+}
+
+// release stops counting the bytes of the table towards the buffer usage,
+// once it's no longer used. A nil table has nothing to release.
+func (t *referenceTable) release() {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, size := range t.sizes {
+		t.usage.add(-size)
+	}
+	t.sizes = make(map[string]int64)
+	t.usage = nil
+}
+
+// notice returns the notice telling that some messages may not be enriched
+// because the table is incomplete, or nil.
+func (t *referenceTable) notice() *data.Notice {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	var text string
+	switch {
+	case t.full:
+		text = fmt.Sprintf("Reference topic %s holds more than %d keys, messages with other keys aren't enriched",
+			t.topic, t.max)
+	case t.partial:
+		text = fmt.Sprintf("Reference topic %s wasn't read up to its end within %s, some messages may not be enriched",
+			t.topic, referenceLoadTimeout)
+	default:
+		return nil
+	}
+	return &data.Notice{Severity: data.NoticeSeverityWarning, Text: text}
+}
+
+// enrich joins the reference value with the key of the message onto the
+// message. Fields of the message take precedence over reference fields.
+func (t *referenceTable) enrich(msg *kafka_client.KafkaMessage) {
+	if msg.Key == nil || msg.Value == nil {
+		return
+	}
+
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	reference, ok := t.values[string(msg.Key)]
+	if !ok {
+		return
+	}
+	// The value is copied, as the messages of time ranges are shared
+	// between queries.
+	value := make(map[string]interface{}, len(msg.Value)+len(reference))
+	for field, v := range msg.Value {
+		value[field] = v
+	}
+	msg.Value = value
+	join := func(field string, value interface{}) {
+		if _, exists := msg.Value[field]; !exists {
+			msg.Value[field] = value
+		}
+	}
+	if len(t.fields) == 0 {
+		for field, value := range reference {
+			join(field, value)
+		}
+		return
+	}
+	for _, field := range t.fields {
+		if value, ok := reference[field]; ok {
+			join(field, value)
+		}
+	}
+}
+
+// loadReferenceTable reads the reference topic of the query up to its current
+// end, so that messages are enriched from the start, then, if follow, keeps
+// following it in the background until ctx is done. Topics not read up to
+// their end within referenceLoadTimeout, or holding more than
+// maxReferenceKeys keys, yield an incomplete table, whose notice says so.
+// The bytes of the table count towards the buffer usage of the instance
+// until it stops following the topic, or, if not following it, until it's
+// released.
+func (d *KafkaDatasource) loadReferenceTable(ctx context.Context, qm queryModel, follow bool) (*referenceTable, error) {
+	client := d.client
+	ends, err := client.TableAssign(ctx, qm.EnrichmentTopic)
+	if err != nil {
+		client.Dispose()
+		return nil, err
+	}
+
+	table := newReferenceTable(qm.EnrichmentTopic, qm.EnrichmentFields)
+	table.usage = &d.buffers
+	deadline := d.clock.Now().Add(referenceLoadTimeout)
+	for len(ends) > 0 {
+		if err := ctx.Err(); err != nil {
+			client.Dispose()
+			table.release()
+			return nil, err
+		}
+		if d.clock.Now().After(deadline) {
+			table.mu.Lock()
+			table.partial = true
+			table.mu.Unlock()
+			break
+		}
+		msg, event := client.ConsumerPull()
+		switch e := event.(type) {
+		case kafka.Error:
+			// The table can't be loaded while no broker is reachable.
+			if e.Code() == kafka.ErrAllBrokersDown {
+				client.Dispose()
+				table.release()
+				return nil, fmt.Errorf("%w: %v", kafka_client.ErrBrokerUnreachable, e)
+			}
+		case *kafka.Message:
+			table.update(msg)
+			if int64(msg.Offset) >= ends[msg.Partition]-1 {
+				delete(ends, msg.Partition)
+			}
+		case nil:
+			// The last offsets of a partition aren't delivered if they hold
+			// transaction markers, so check the positions once idle.
+			for partition, end := range ends {
+				if reachedOffset(client.Consumer, qm.EnrichmentTopic, partition, end) {
+					delete(ends, partition)
+				}
+			}
+		}
+	}
+
+	if !follow {
+		client.Dispose()
+		return table, nil
+	}
+	go func() {
+		defer client.Dispose()
+		defer table.release()
+		for ctx.Err() == nil {
+			msg, event := client.ConsumerPull()
+			if _, ok := event.(*kafka.Message); ok {
+				table.update(msg)
+			}
+		}
+	}()
+	return table, nil
+}
+
+func reachedOffset(consumer *kafka.Consumer, topic string, partition int32, offset int64) bool {
+	positions, err := consumer.Position([]kafka.TopicPartition{{Topic: &topic, Partition: partition}})
+	if err != nil || len(positions) == 0 || positions[0].Offset < 0 {
+		return false
+	}
+	return int64(positions[0].Offset) >= offset
+}
@@ -0,0 +1,62 @@
+package plugin
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hoptical/grafana-kafka-datasource/pkg/kafka_client"
+)
+
+func TestReferenceTable(t *testing.T) {
+	table := newReferenceTable("devices", "site, model")
+	table.update(kafka_client.KafkaMessage{
+		Key:      []byte("device-1"),
+		RawValue: []byte(`{"site":"tehran","model":"x1","serial":"abc"}`),
+		Value:    map[string]interface{}{"site": "tehran", "model": "x1", "serial": "abc"},
+	})
+
+	msg := kafka_client.KafkaMessage{
+		Key:   []byte("device-1"),
+		Value: map[string]interface{}{"temperature": 21.5, "model": "x2"},
+	}
+	table.enrich(&msg)
+	if msg.Value["site"] != "tehran" {
+		t.Errorf("expected the site to be joined, got %v", msg.Value["site"])
+	}
+	if msg.Value["model"] != "x2" {
+		t.Errorf("expected the message field to take precedence, got %v", msg.Value["model"])
+	}
+	if _, ok := msg.Value["serial"]; ok {
+		t.Error("expected unselected fields not to be joined")
+	}
+
+	// A tombstone removes the key.
+	table.update(kafka_client.KafkaMessage{Key: []byte("device-1")})
+	msg = kafka_client.KafkaMessage{Key: []byte("device-1"), Value: map[string]interface{}{}}
+	table.enrich(&msg)
+	if len(msg.Value) != 0 {
+		t.Errorf("expected no fields after the tombstone, got %v", msg.Value)
+	}
+}
+
+func TestReferenceTableNotice(t *testing.T) {
+	table := newReferenceTable("devices", "")
+	if notice := table.notice(); notice != nil {
+		t.Errorf("expected no notice for a complete table, got %q", notice.Text)
+	}
+
+	table.max = 1
+	for _, key := range []string{"device-1", "device-2"} {
+		table.update(kafka_client.KafkaMessage{
+			Key:      []byte(key),
+			RawValue: []byte(`{}`),
+			Value:    map[string]interface{}{},
+		})
+	}
+	if _, ok := table.values["device-2"]; ok {
+		t.Error("expected keys beyond the maximum to be ignored")
+	}
+	if notice := table.notice(); notice == nil || !strings.Contains(notice.Text, "more than 1 keys") {
+		t.Errorf("expected a notice for a full table, got %+v", notice)
+	}
+}
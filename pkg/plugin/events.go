@@ -0,0 +1,139 @@
+package plugin
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// eventsPath is the Live channel path on which datasource-level events are
+// published. Query stream paths are base64 encoded JSON, so they never clash
+// with it.
+const eventsPath = "events"
+
+const (
+	eventStreamStarted    = "streamStarted"
+	eventStreamStopped    = "streamStopped"
+	eventSchemaChanged    = "schemaChanged"
+	eventBrokerError      = "brokerError"
+	eventBrokerReconnect  = "brokerReconnect"
+	eventSettingsReloaded = "settingsReloaded"
+	eventRebalanced       = "rebalanced"
+	eventMessageProduced  = "messageProduced"
+)
+
+type datasourceEvent struct {
+	Time    time.Time
+	Type    string
+	Topic   string
+	Message string
+}
+
+func (e datasourceEvent) frame() *data.Frame {
+	return data.NewFrame("events",
+		data.NewField("time", nil, []time.Time{e.Time}),
+		data.NewField("type", nil, []string{e.Type}),
+		data.NewField("topic", nil, []string{e.Topic}),
+		data.NewField("message", nil, []string{e.Message}),
+	)
+}
+
+// rebalanceMessage describes the partitions assigned to, or revoked from, a
+// stream by its consumer group.
+func rebalanceMessage(event kafka.Event, group string) string {
+	var action string
+	var partitions []kafka.TopicPartition
+	switch e := event.(type) {
+	case kafka.AssignedPartitions:
+		action, partitions = "assigned", e.Partitions
+	case kafka.RevokedPartitions:
+		action, partitions = "revoked", e.Partitions
+	}
+	ids := make([]string, 0, len(partitions))
+	for _, p := range partitions {
+		ids = append(ids, strconv.Itoa(int(p.Partition)))
+	}
+	return fmt.Sprintf("Consumer group %s %s partitions %s", group, action, strings.Join(ids, ", "))
+}
+
+// brokerError reports an error of the consumer of a stream, e.g. all the
+// brokers being down, which the consumer keeps retrying on its own.
+func (d *KafkaDatasource) brokerError(topic string, e kafka.Error) {
+	log.DefaultLogger.Warn("Broker error", "topic", topic, "code", e.Code().String(), "error", e)
+	d.events.publish(d.clock.Now(), eventBrokerError, topic, e.Error())
+}
+
+// brokerHealth tracks the broker errors of the consumer of a stream, to report
+// when it reads again after them.
+type brokerHealth struct {
+	topic   string
+	failing bool
+}
+
+func (h *brokerHealth) error(d *KafkaDatasource, e kafka.Error) {
+	d.brokerError(h.topic, e)
+	h.failing = true
+}
+
+// read reports the stream reading a message again after broker errors.
+func (h *brokerHealth) read(d *KafkaDatasource) {
+	if !h.failing {
+		return
+	}
+	h.failing = false
+	log.DefaultLogger.Info("Broker reconnected", "topic", h.topic)
+	d.events.publish(d.clock.Now(), eventBrokerReconnect, h.topic, "Reading again after broker errors")
+}
+
+// eventHub fans datasource-level events out to the subscribers of the events
+// channel. The zero value is ready to use.
+type eventHub struct {
+	mu          sync.Mutex
+	subscribers map[chan datasourceEvent]struct{}
+}
+
+func (h *eventHub) subscribe() chan datasourceEvent {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.subscribers == nil {
+		h.subscribers = make(map[chan datasourceEvent]struct{})
+	}
+	ch := make(chan datasourceEvent, 100)
+	h.subscribers[ch] = struct{}{}
+	return ch
+}
+
+func (h *eventHub) unsubscribe(ch chan datasourceEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.subscribers, ch)
+}
+
+// publish delivers the event to every subscriber without blocking; events are
+// dropped for subscribers that don't keep up.
+func (h *eventHub) publish(now time.Time, eventType, topic, message string) {
+	e := datasourceEvent{
+		Time:    now,
+		Type:    eventType,
+		Topic:   topic,
+		Message: message,
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for ch := range h.subscribers {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package plugin
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+func TestBrokerError(t *testing.T) {
+	d := &KafkaDatasource{clock: fixedClock{time.Unix(1000, 0)}}
+	events := d.events.subscribe()
+	defer d.events.unsubscribe(events)
+
+	d.brokerError("orders", kafka.NewError(kafka.ErrAllBrokersDown, "1/1 brokers are down", false))
+
+	select {
+	case e := <-events:
+		if e.Type != eventBrokerError || e.Topic != "orders" || !e.Time.Equal(time.Unix(1000, 0)) {
+			t.Errorf("unexpected event %+v", e)
+		}
+		if !annotatedEvents[e.Type] {
+			t.Errorf("expected broker errors to be annotated")
+		}
+	default:
+		t.Fatal("expected a broker error event")
+	}
+}
+
+func TestBrokerReconnect(t *testing.T) {
+	d := &KafkaDatasource{clock: fixedClock{time.Unix(1000, 0)}}
+	events := d.events.subscribe()
+	defer d.events.unsubscribe(events)
+
+	health := brokerHealth{topic: "orders"}
+	health.read(d)
+	health.error(d, kafka.NewError(kafka.ErrAllBrokersDown, "1/1 brokers are down", false))
+	health.error(d, kafka.NewError(kafka.ErrAllBrokersDown, "1/1 brokers are down", false))
+	health.read(d)
+	health.read(d)
+
+	var types []string
+	for len(events) > 0 {
+		e := <-events
+		if e.Topic != "orders" {
+			t.Errorf("unexpected event %+v", e)
+		}
+		types = append(types, e.Type)
+	}
+	expected := []string{eventBrokerError, eventBrokerError, eventBrokerReconnect}
+	if !reflect.DeepEqual(types, expected) {
+		t.Errorf("expected events %v, got %v", expected, types)
+	}
+	if !annotatedEvents[eventBrokerReconnect] {
+		t.Errorf("expected broker reconnects to be annotated")
+	}
+}
@@ -0,0 +1,533 @@
+package plugin
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+
+	"github.com/hoptical/grafana-kafka-datasource/pkg/kafka_client"
+)
+
+// messageField is a single leaf value of a decoded message together with the
+// keys leading to it from the message root.
+type messageField struct {
+	path  []string
+	value interface{}
+}
+
+// flattenMessage walks a decoded JSON message and returns its leaf values
+// sorted by their dotted path, so nested objects and arrays end up as
+// separate columns in a stable order.
+func flattenMessage(value map[string]interface{}) []messageField {
+	return flattenSelected(value, nil)
+}
+
+// flattenSelected flattens the selected fields of a decoded message only,
+// skipping the objects and arrays holding none of them.
+func flattenSelected(value map[string]interface{}, selection fieldSelection) []messageField {
+	fields := make([]messageField, 0, len(value))
+	flattenValue(nil, value, selection, &fields)
+
+	sort.SliceStable(fields, func(i, j int) bool {
+		return strings.Join(fields[i].path, ".") < strings.Join(fields[j].path, ".")
+	})
+
+	return fields
+}
+
+func flattenValue(path []string, value interface{}, selection fieldSelection, fields *[]messageField) {
+	if !selection.leadsTo(path) {
+		return
+	}
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			flattenValue(appendPath(path, key), child, selection, fields)
+		}
+	case []interface{}:
+		for i, child := range v {
+			flattenValue(appendPath(path, strconv.Itoa(i)), child, selection, fields)
+		}
+	default:
+		*fields = append(*fields, messageField{path: path, value: v})
+	}
+}
+
+// fieldSelection holds the dotted paths of the fields selected in a panel. A
+// selected object selects all of its fields, and a nil selection selects
+// every field.
+type fieldSelection []string
+
+// parseFieldSelection returns the selection of the query, widened to the
+// extra fields, or nil if the query selects no field.
+func parseFieldSelection(selected []string, extra ...string) fieldSelection {
+	if len(selected) == 0 {
+		return nil
+	}
+	var selection fieldSelection
+	for _, name := range append(selected, extra...) {
+		if name = strings.TrimSpace(name); name != "" {
+			selection = append(selection, name)
+		}
+	}
+	return selection
+}
+
+// selects reports whether the field at path is selected.
+func (s fieldSelection) selects(path []string) bool {
+	if s == nil {
+		return true
+	}
+	name := strings.Join(path, ".")
+	for _, selected := range s {
+		if name == selected || strings.HasPrefix(name, selected+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// leadsTo reports whether the value at path is selected or holds selected
+// fields.
+func (s fieldSelection) leadsTo(path []string) bool {
+	if s == nil || len(path) == 0 {
+		return true
+	}
+	name := strings.Join(path, ".")
+	for _, selected := range s {
+		if strings.HasPrefix(selected, name+".") {
+			return true
+		}
+	}
+	return s.selects(path)
+}
+
+func appendPath(path []string, key string) []string {
+	next := make([]string, len(path), len(path)+1)
+	copy(next, path)
+	return append(next, key)
+}
+
+// normalizePath renames empty keys, as produced by payloads like
+// {"counters": {"155": {"": {...}}}}, to emptyKeyName or drops them when no
+// name is configured. Otherwise they'd end up as ".." in field names, which
+// transformations can't address.
+func normalizePath(path []string, emptyKeyName string) []string {
+	normalized := make([]string, 0, len(path))
+	for _, segment := range path {
+		if segment == "" {
+			if emptyKeyName == "" {
+				continue
+			}
+			segment = emptyKeyName
+		}
+		normalized = append(normalized, segment)
+	}
+	return normalized
+}
+
+// pivotPath moves numeric identifiers embedded in a key path, like the 155 in
+// counters.155..VALUE_1, into labels named after their parent key. This way a
+// topic carrying thousands of IDs yields one labeled series per ID instead of
+// one column per ID. Invalid UTF-8 in label names is handled like in field
+// names, according to utf8Mode.
+func pivotPath(path []string, utf8Mode string) ([]string, data.Labels) {
+	var labels data.Labels
+	name := make([]string, 0, len(path))
+
+	for i, segment := range path {
+		if !isNumericKey(segment) {
+			name = append(name, segment)
+			continue
+		}
+
+		label := "key"
+		if i > 0 && path[i-1] != "" && !isNumericKey(path[i-1]) {
+			label = sanitizeUTF8(path[i-1], utf8Mode)
+		}
+		if labels == nil {
+			labels = data.Labels{}
+		}
+		if _, exists := labels[label]; exists {
+			label = label + "_" + strconv.Itoa(i)
+		}
+		labels[label] = segment
+	}
+
+	return name, labels
+}
+
+func isNumericKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for _, r := range key {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// sanitizeUTF8 replaces invalid UTF-8 sequences, which would break the JSON
+// serialization of frames, with the replacement character or strips them
+// when mode is "strip".
+func sanitizeUTF8(s string, mode string) string {
+	if utf8.ValidString(s) {
+		return s
+	}
+	if mode == "strip" {
+		return strings.ToValidUTF8(s, "")
+	}
+	return strings.ToValidUTF8(s, string(utf8.RuneError))
+}
+
+// truncationSuffix marks strings truncated to the maximum string length.
+const truncationSuffix = "…"
+
+// truncateString cuts s to max characters, appending the truncation suffix,
+// and tells whether it did.
+func truncateString(s string, max int) (string, bool) {
+	count := 0
+	for i := range s {
+		if count == max {
+			return s[:i] + truncationSuffix, true
+		}
+		count++
+	}
+	return s, false
+}
+
+func newMessageField(name string, labels data.Labels, value interface{}, utf8Mode string) *data.Field {
+	switch v := value.(type) {
+	case float64:
+		return data.NewField(name, labels, []float64{v})
+	case string:
+		return data.NewField(name, labels, []string{sanitizeUTF8(v, utf8Mode)})
+	case bool:
+		return data.NewField(name, labels, []bool{v})
+	}
+	return nil
+}
+
+// degradedNotice warns that some partitions of the streamed or read topic are
+// skipped.
+func degradedNotice(err *kafka_client.PartitionErrors) data.Notice {
+	failed := make([]string, 0, len(err.Errors))
+	for _, partition := range err.Failed() {
+		failed = append(failed, strconv.Itoa(int(partition)))
+	}
+	return data.Notice{
+		Severity: data.NoticeSeverityWarning,
+		Text: fmt.Sprintf("Partitions %s of topic %s cannot be consumed and are skipped: %v",
+			strings.Join(failed, ", "), err.Topic, err),
+	}
+}
+
+// addTraceFields exposes the fields designated as trace and span IDs under
+// the traceID and spanID names Grafana expects, so that correlations to
+// tracing datasources work. The references name either a message field or,
+// prefixed with "header:", a message header. W3C traceparent headers are
+// split into their trace and span IDs.
+func addTraceFields(frame *data.Frame, msg kafka_client.KafkaMessage, qm queryModel) {
+	addTraceField(frame, msg, qm.TraceIDField, "traceID", 1, qm.InvalidUTF8)
+	addTraceField(frame, msg, qm.SpanIDField, "spanID", 2, qm.InvalidUTF8)
+}
+
+func addTraceField(frame *data.Frame, msg kafka_client.KafkaMessage, ref string, name string, traceparentPart int, utf8Mode string) {
+	if ref == "" {
+		return
+	}
+
+	if header := strings.TrimPrefix(ref, "header:"); header != ref {
+		value, ok := msg.Headers[header]
+		if !ok {
+			return
+		}
+		id := sanitizeUTF8(string(value), utf8Mode)
+		if parts := strings.Split(id, "-"); len(parts) == 4 && len(parts[1]) == 32 && len(parts[2]) == 16 {
+			id = parts[traceparentPart]
+		}
+		frame.Fields = append(frame.Fields, data.NewField(name, nil, []string{id}))
+		return
+	}
+
+	for _, field := range frame.Fields {
+		if field.Name == ref && field.Type() == data.FieldTypeString {
+			field.Name = name
+			return
+		}
+	}
+}
+
+// dataLink is a link to external tooling, e.g. a Kafka UI, attached to the
+// fields of streamed frames. Its URL can reference the ${topic}, ${partition}
+// and ${offset} of the message.
+type dataLink struct {
+	Title string `json:"title"`
+	URL   string `json:"url"`
+}
+
+// addDataLinks attaches the links to every field but the time. The partition
+// and offset vary per row, so they are added as fields and referenced through
+// Grafana's own data variables rather than substituted here.
+func addDataLinks(frame *data.Frame, msg kafka_client.KafkaMessage, topic string, links []dataLink) {
+	if len(links) == 0 {
+		return
+	}
+
+	if !hasField(frame, "__partition") {
+		frame.Fields = append(frame.Fields, data.NewField("__partition", nil, []int32{msg.Partition}))
+	}
+	if !hasField(frame, "__offset") {
+		frame.Fields = append(frame.Fields, data.NewField("__offset", nil, []int64{int64(msg.Offset)}))
+	}
+
+	replacer := strings.NewReplacer(
+		"${topic}", url.PathEscape(topic),
+		"${partition}", "${__data.fields.__partition}",
+		"${offset}", "${__data.fields.__offset}",
+	)
+	fieldLinks := make([]data.DataLink, 0, len(links))
+	for _, link := range links {
+		fieldLinks = append(fieldLinks, data.DataLink{
+			Title:       link.Title,
+			URL:         replacer.Replace(link.URL),
+			TargetBlank: true,
+		})
+	}
+
+	for _, field := range frame.Fields[1:] {
+		if field.Config == nil {
+			field.Config = &data.FieldConfig{}
+		}
+		field.Config.Links = fieldLinks
+	}
+}
+
+func hasField(frame *data.Frame, name string) bool {
+	for _, field := range frame.Fields {
+		if field.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// streamCustomMeta is attached as custom meta to every frame sent over a stream.
+type streamCustomMeta struct {
+	// SchemaVersion is incremented whenever the emitted field set changes, so
+	// consumers know when the streaming buffer has been reset.
+	SchemaVersion int `json:"schemaVersion"`
+	// Consumer holds the latest statistics of the stream consumer, when
+	// asked for.
+	Consumer *kafka_client.ConsumerStats `json:"consumer,omitempty"`
+	// LateDropped is the number of late messages dropped.
+	LateDropped int64 `json:"lateDropped,omitempty"`
+	// Offsets holds the latest offset consumed per partition, refreshed
+	// every offsetCheckpointInterval when asked for.
+	Offsets map[string]int64 `json:"offsets,omitempty"`
+	// Committed holds the offsets last committed per partition by the
+	// consumer group of the stream.
+	Committed map[string]int64 `json:"committed,omitempty"`
+}
+
+// schemaTracker keeps track of the field set emitted by a stream.
+type schemaTracker struct {
+	key     string
+	version int
+}
+
+// observe returns the schema version of the frame, bumping it if the field
+// names, labels or types differ from the previously observed frame.
+func (t *schemaTracker) observe(frame *data.Frame) int {
+	var b strings.Builder
+	for _, f := range frame.Fields {
+		b.WriteString(f.Name)
+		b.WriteString(f.Labels.String())
+		b.WriteString(f.Type().ItemTypeString())
+		b.WriteByte(';')
+	}
+
+	if key := b.String(); t.version == 0 || key != t.key {
+		t.key = key
+		t.version++
+	}
+	return t.version
+}
+
+// newMessageFrame builds a single row frame out of a consumed message.
+func newMessageFrame(msg kafka_client.KafkaMessage, frameTime time.Time, qm queryModel) *data.Frame {
+	frame := data.NewFrame("response")
+	frame.Fields = append(frame.Fields,
+		data.NewField("time", nil, []time.Time{frameTime}),
+	)
+
+	// In "now" mode the event time would otherwise be lost, so keep the
+	// record timestamp and how long it took the message to reach us.
+	if qm.TimestampMode == "now" {
+		delay := frameTime.Sub(msg.Timestamp)
+		frame.Fields = append(frame.Fields,
+			data.NewField("__timestamp", nil, []time.Time{msg.Timestamp}),
+			data.NewField("__delay", nil, []float64{float64(delay) / float64(time.Millisecond)}).
+				SetConfig(&data.FieldConfig{Unit: "ms"}),
+		)
+	}
+
+	var warnings []userMessage
+	if msg.Err != nil {
+		err := userMessage{
+			Key:  messageDecodeFailed,
+			Args: map[string]interface{}{"error": msg.Err.Error()},
+			Text: msg.Err.Error(),
+		}
+		if qm.StrictDecode || msg.Value == nil {
+			return appendError(frame, err, qm)
+		}
+		warnings = append(warnings, err)
+	}
+
+	// Required fields are flattened to be checked, even when not selected.
+	selection := parseFieldSelection(qm.SelectedFields)
+	fields := flattenSelected(msg.Value, parseFieldSelection(qm.SelectedFields, strings.Split(qm.RequiredFields, ",")...))
+	if missing := missingFields(fields, qm.RequiredFields); len(missing) > 0 {
+		err := userMessage{
+			Key:  messageMissingRequiredFields,
+			Args: map[string]interface{}{"fields": missing},
+			Text: fmt.Sprintf("missing required fields: %s", strings.Join(missing, ", ")),
+		}
+		if qm.StrictDecode {
+			return appendError(frame, err, qm)
+		}
+		warnings = append(warnings, err)
+	}
+
+	truncated := 0
+	for _, f := range fields {
+		if !selection.selects(f.path) {
+			continue
+		}
+		path := normalizePath(f.path, qm.EmptyKeyName)
+		var labels data.Labels
+		if qm.PivotNumericKeys {
+			path, labels = pivotPath(path, qm.InvalidUTF8)
+		}
+
+		name := sanitizeUTF8(strings.Join(path, "."), qm.InvalidUTF8)
+		if f.value == nil {
+			// Both missing fields and explicit nulls end up as empty cells,
+			// so mark the latter when asked to tell them apart.
+			if qm.MarkExplicitNulls {
+				frame.Fields = append(frame.Fields,
+					data.NewField(name+"__present", labels, []bool{true}),
+				)
+			}
+			continue
+		}
+
+		if v, ok := f.value.(string); ok && qm.MaxStringLength > 0 {
+			if v, ok := truncateString(v, qm.MaxStringLength); ok {
+				f.value = v
+				truncated++
+			}
+		}
+
+		field := newMessageField(name, labels, f.value, qm.InvalidUTF8)
+		if field == nil {
+			continue
+		}
+		frame.Fields = append(frame.Fields, field)
+	}
+
+	if int32(qm.Partition) == kafka_client.ALL_PARTITIONS {
+		frame.Fields = append(frame.Fields,
+			data.NewField("__partition", nil, []int32{msg.Partition}),
+		)
+	}
+
+	if qm.MessageStats {
+		frame.Fields = append(frame.Fields,
+			data.NewField("__bytes", nil, []int64{int64(msg.Size)}),
+		)
+	}
+
+	if truncated > 0 {
+		frame.AppendNotices(data.Notice{
+			Severity: data.NoticeSeverityInfo,
+			Text:     fmt.Sprintf("%d string fields truncated to %d characters", truncated, qm.MaxStringLength),
+		})
+	}
+
+	if len(warnings) > 0 {
+		frame.Fields = append(frame.Fields, messagesField("__warning", warnings, qm))
+	}
+
+	return frame
+}
+
+// messageFramer turns messages into frames along with the fields added by the
+// query options, the same way for streams and time range queries.
+type messageFramer struct {
+	qm           queryModel
+	dataLinks    []dataLink
+	reference    *referenceTable
+	lookup       *lookupTable
+	binaryFields map[string]string
+	// itemKeys is the template naming the items of top-level arrays.
+	itemKeys string
+}
+
+func (f messageFramer) frame(msg kafka_client.KafkaMessage, frameTime time.Time) *data.Frame {
+	if isSummarized(msg, f.qm) {
+		frame := newSummaryFrame(msg, frameTime, f.qm)
+		applyBinaryFields(frame, msg, f.binaryFields)
+		addDataLinks(frame, msg, f.qm.Topic, f.dataLinks)
+		return frame
+	}
+	if f.reference != nil {
+		f.reference.enrich(&msg)
+	}
+	frame := newMessageFrame(msg, frameTime, f.qm)
+	addTraceFields(frame, msg, f.qm)
+	addGeoFields(frame, f.qm)
+	if f.lookup != nil {
+		f.lookup.addFields(frame, f.qm.LookupField)
+	}
+	applyBinaryFields(frame, msg, f.binaryFields)
+	addDataLinks(frame, msg, f.qm.Topic, f.dataLinks)
+	return frame
+}
+
+func appendError(frame *data.Frame, err userMessage, qm queryModel) *data.Frame {
+	frame.Fields = append(frame.Fields, messagesField("__error", []userMessage{err}, qm))
+	return frame
+}
+
+// missingFields returns the comma separated required fields, as dotted paths,
+// that are neither a flattened field nor one of their parents.
+func missingFields(fields []messageField, required string) []string {
+	var missing []string
+	for _, name := range strings.Split(required, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		found := false
+		for _, f := range fields {
+			path := strings.Join(f.path, ".")
+			if path == name || strings.HasPrefix(path, name+".") {
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
@@ -0,0 +1,370 @@
+package plugin
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+
+	"github.com/hoptical/grafana-kafka-datasource/pkg/kafka_client"
+)
+
+func frameField(frame *data.Frame, name string) *data.Field {
+	for _, f := range frame.Fields {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+func TestFlattenMessage(t *testing.T) {
+	fields := flattenMessage(map[string]interface{}{
+		"b": 2.0,
+		"a": map[string]interface{}{
+			"y": "text",
+			"x": []interface{}{1.0, true},
+		},
+	})
+
+	want := []string{"a.x.0", "a.x.1", "a.y", "b"}
+	if len(fields) != len(want) {
+		t.Fatalf("expected %d fields, got %d", len(want), len(fields))
+	}
+	for i, f := range fields {
+		if name := strings.Join(f.path, "."); name != want[i] {
+			t.Errorf("field %d: expected %q, got %q", i, want[i], name)
+		}
+	}
+}
+
+func TestPivotPath(t *testing.T) {
+	name, labels := pivotPath([]string{"counters", "155", "", "VALUE_1"}, "")
+
+	if got := strings.Join(name, "."); got != "counters..VALUE_1" {
+		t.Errorf("unexpected name %q", got)
+	}
+	if labels["counters"] != "155" {
+		t.Errorf("unexpected labels %v", labels)
+	}
+
+	name, labels = pivotPath([]string{"value"}, "")
+	if len(name) != 1 || labels != nil {
+		t.Errorf("expected path without identifiers to be left alone, got %v %v", name, labels)
+	}
+}
+
+func TestNormalizePath(t *testing.T) {
+	path := []string{"counters", "155", "", "VALUE_1"}
+
+	if got := strings.Join(normalizePath(path, ""), "."); got != "counters.155.VALUE_1" {
+		t.Errorf("expected empty key to be dropped, got %q", got)
+	}
+	if got := strings.Join(normalizePath(path, "_"), "."); got != "counters.155._.VALUE_1" {
+		t.Errorf("expected empty key to be renamed, got %q", got)
+	}
+}
+
+func TestNewMessageFramePivot(t *testing.T) {
+	msg := kafka_client.KafkaMessage{
+		Value: map[string]interface{}{
+			"counters": map[string]interface{}{
+				"155": map[string]interface{}{"": map[string]interface{}{"VALUE_1": 1.0}},
+				"156": map[string]interface{}{"": map[string]interface{}{"VALUE_1": 2.0}},
+			},
+		},
+	}
+
+	frame := newMessageFrame(msg, time.Now(), queryModel{PivotNumericKeys: true})
+
+	if len(frame.Fields) != 3 {
+		t.Fatalf("expected time and two pivoted fields, got %d fields", len(frame.Fields))
+	}
+	for _, f := range frame.Fields[1:] {
+		if f.Name != "counters.VALUE_1" {
+			t.Errorf("unexpected field name %q", f.Name)
+		}
+		if f.Labels["counters"] == "" {
+			t.Errorf("expected counters label on field %q", f.Name)
+		}
+	}
+}
+
+func TestNewMessageFrameNowMode(t *testing.T) {
+	timestamp := time.Unix(1000, 0)
+	msg := kafka_client.KafkaMessage{
+		Value:     map[string]interface{}{"a": 1.0},
+		Timestamp: timestamp,
+	}
+
+	frame := newMessageFrame(msg, timestamp.Add(1500*time.Millisecond), queryModel{TimestampMode: "now"})
+
+	field := frameField(frame, "__timestamp")
+	if field == nil || !field.At(0).(time.Time).Equal(timestamp) {
+		t.Fatalf("expected record timestamp field, got %v", field)
+	}
+	field = frameField(frame, "__delay")
+	if field == nil || field.At(0).(float64) != 1500 {
+		t.Fatalf("expected a 1500ms delay field, got %v", field)
+	}
+}
+
+func TestSanitizeUTF8(t *testing.T) {
+	invalid := "a\xffb"
+
+	if got := sanitizeUTF8(invalid, "replace"); got != "a\uFFFDb" {
+		t.Errorf("expected invalid byte to be replaced, got %q", got)
+	}
+	if got := sanitizeUTF8(invalid, "strip"); got != "ab" {
+		t.Errorf("expected invalid byte to be stripped, got %q", got)
+	}
+	if got := sanitizeUTF8("valid ✓", "strip"); got != "valid ✓" {
+		t.Errorf("expected valid string to be kept, got %q", got)
+	}
+}
+
+func TestNewMessageFrameInvalidUTF8(t *testing.T) {
+	msg := kafka_client.KafkaMessage{
+		Value: map[string]interface{}{
+			"count\xffers": map[string]interface{}{"155": map[string]interface{}{"value": 1.0}},
+		},
+		Headers: map[string][]byte{"trace": []byte("a\xffb")},
+	}
+
+	for mode, expected := range map[string]string{"replace": "\uFFFD", "strip": ""} {
+		qm := queryModel{PivotNumericKeys: true, InvalidUTF8: mode, TraceIDField: "header:trace"}
+		frame := newMessageFrame(msg, time.Now(), qm)
+		addTraceFields(frame, msg, qm)
+
+		field := frameField(frame, "count"+expected+"ers.value")
+		if field == nil || field.Labels["count"+expected+"ers"] != "155" {
+			t.Errorf("%s: expected the pivoted label to be sanitized, got %v", mode, field)
+		}
+		if field := frameField(frame, "traceID"); field == nil || field.At(0) != "a"+expected+"b" {
+			t.Errorf("%s: expected the trace header to be sanitized, got %v", mode, field)
+		}
+	}
+}
+
+func TestNewMessageFrameExplicitNulls(t *testing.T) {
+	msg := kafka_client.KafkaMessage{
+		Value: map[string]interface{}{"a": nil, "b": 1.0},
+	}
+
+	frame := newMessageFrame(msg, time.Now(), queryModel{})
+	if frameField(frame, "a") != nil || frameField(frame, "a__present") != nil {
+		t.Error("expected explicit null to be skipped by default")
+	}
+
+	frame = newMessageFrame(msg, time.Now(), queryModel{MarkExplicitNulls: true})
+	field := frameField(frame, "a__present")
+	if field == nil || field.At(0) != true {
+		t.Fatalf("expected a__present field, got %v", field)
+	}
+	if frameField(frame, "b__present") != nil {
+		t.Error("expected no presence field for non-null values")
+	}
+}
+
+func TestNewMessageFrameDecodeMode(t *testing.T) {
+	partial := kafka_client.KafkaMessage{
+		Value: map[string]interface{}{"a": 1.0},
+		Err:   errors.New("unexpected end of JSON input"),
+	}
+	missing := kafka_client.KafkaMessage{
+		Value: map[string]interface{}{"a": map[string]interface{}{"b": 1.0}},
+	}
+
+	tests := []struct {
+		name    string
+		msg     kafka_client.KafkaMessage
+		qm      queryModel
+		field   string
+		wantErr string
+	}{
+		{"lenient partial", partial, queryModel{}, "a", ""},
+		{"strict partial", partial, queryModel{StrictDecode: true}, "", "unexpected end of JSON input"},
+		{"parent present", missing, queryModel{RequiredFields: "a"}, "a.b", ""},
+		{"lenient missing", missing, queryModel{RequiredFields: "a.b, c"}, "a.b", ""},
+		{"strict missing", missing, queryModel{StrictDecode: true, RequiredFields: "a.b, c"}, "", "missing required fields: c"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frame := newMessageFrame(tt.msg, time.Now(), tt.qm)
+			if tt.wantErr != "" {
+				if field := frameField(frame, "__error"); field == nil || field.At(0) != tt.wantErr {
+					t.Fatalf("expected error %q, got %v", tt.wantErr, field)
+				}
+				if frameField(frame, "a") != nil || frameField(frame, "a.b") != nil {
+					t.Error("expected no fields besides the error")
+				}
+				return
+			}
+			if frameField(frame, tt.field) == nil {
+				t.Errorf("expected field %s", tt.field)
+			}
+			warning := frameField(frame, "__warning")
+			wantWarning := tt.msg.Err != nil || strings.Contains(tt.qm.RequiredFields, "c")
+			if (warning != nil) != wantWarning {
+				t.Errorf("expected warning %v, got %v", wantWarning, warning)
+			}
+		})
+	}
+}
+
+func TestNewMessageFrameMaxStringLength(t *testing.T) {
+	msg := kafka_client.KafkaMessage{
+		Value: map[string]interface{}{"short": "abc", "long": "héllo world"},
+	}
+
+	frame := newMessageFrame(msg, time.Now(), queryModel{MaxStringLength: 5})
+	if v := frameField(frame, "long").At(0); v != "héllo…" {
+		t.Errorf("expected the long string to be truncated, got %q", v)
+	}
+	if v := frameField(frame, "short").At(0); v != "abc" {
+		t.Errorf("expected the short string to be kept, got %q", v)
+	}
+	if frame.Meta == nil || len(frame.Meta.Notices) != 1 || !strings.Contains(frame.Meta.Notices[0].Text, "1 string fields") {
+		t.Errorf("expected a truncation notice, got %+v", frame.Meta)
+	}
+
+	frame = newMessageFrame(msg, time.Now(), queryModel{})
+	if v := frameField(frame, "long").At(0); v != "héllo world" || frame.Meta != nil {
+		t.Errorf("expected no truncation by default, got %q", v)
+	}
+}
+
+func TestNewMessageFrameSelectedFields(t *testing.T) {
+	msg := kafka_client.KafkaMessage{
+		Value: map[string]interface{}{
+			"id":     "a1",
+			"sensor": map[string]interface{}{"temp": 21.5, "hum": 40.0},
+			"debug":  map[string]interface{}{"trace": "..."},
+		},
+	}
+
+	frame := newMessageFrame(msg, time.Now(), queryModel{SelectedFields: []string{"sensor.temp", "id"}, RequiredFields: "debug"})
+	var names []string
+	for _, f := range frame.Fields {
+		names = append(names, f.Name)
+	}
+	if strings.Join(names, ",") != "time,id,sensor.temp" {
+		t.Errorf("expected only the selected fields, got %v", names)
+	}
+	if hasField(frame, "__warning") {
+		t.Error("expected the required field to be found even though not selected")
+	}
+
+	frame = newMessageFrame(msg, time.Now(), queryModel{SelectedFields: []string{"sensor"}})
+	if len(frame.Fields) != 3 || !hasField(frame, "sensor.hum") || !hasField(frame, "sensor.temp") {
+		t.Errorf("expected a selected object to select its fields, got %d fields", len(frame.Fields))
+	}
+}
+
+func TestDegradedNotice(t *testing.T) {
+	notice := degradedNotice(&kafka_client.PartitionErrors{
+		Topic: "events",
+		Errors: []kafka_client.PartitionError{
+			{Partition: 3, Err: errors.New("leader not available")},
+			{Partition: 5, Err: errors.New("leader not available")},
+		},
+		Assigned: []int32{0, 1, 2, 4},
+	})
+
+	if notice.Severity != data.NoticeSeverityWarning {
+		t.Errorf("expected a warning, got %v", notice.Severity)
+	}
+	if !strings.HasPrefix(notice.Text, "Partitions 3, 5 of topic events cannot be consumed") {
+		t.Errorf("unexpected notice %q", notice.Text)
+	}
+}
+
+func TestAddDataLinks(t *testing.T) {
+	msg := kafka_client.KafkaMessage{
+		Value:     map[string]interface{}{"a": 1.0},
+		Partition: 2,
+		Offset:    42,
+	}
+	frame := newMessageFrame(msg, time.Now(), queryModel{})
+
+	addDataLinks(frame, msg, "my topic", []dataLink{
+		{Title: "AKHQ", URL: "http://akhq/topic/${topic}/data?partition=${partition}&offset=${offset}"},
+	})
+
+	if frameField(frame, "__partition") == nil || frameField(frame, "__offset") == nil {
+		t.Fatal("expected partition and offset fields to be added")
+	}
+	links := frameField(frame, "a").Config.Links
+	want := "http://akhq/topic/my%20topic/data?partition=${__data.fields.__partition}&offset=${__data.fields.__offset}"
+	if len(links) != 1 || links[0].URL != want {
+		t.Errorf("unexpected links %+v", links)
+	}
+	if frame.Fields[0].Config != nil {
+		t.Error("expected no links on the time field")
+	}
+
+	addDataLinks(frame, msg, "été/orders.v2", []dataLink{{Title: "AKHQ", URL: "http://akhq/topic/${topic}"}})
+	if url := frameField(frame, "a").Config.Links[0].URL; url != "http://akhq/topic/%C3%A9t%C3%A9%2Forders.v2" {
+		t.Errorf("expected the topic to be escaped as a single path segment, got %q", url)
+	}
+}
+
+func TestAddTraceFields(t *testing.T) {
+	msg := kafka_client.KafkaMessage{
+		Value: map[string]interface{}{"trace": map[string]interface{}{"id": "abc"}},
+		Headers: map[string][]byte{
+			"traceparent": []byte("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"),
+		},
+	}
+
+	frame := newMessageFrame(msg, time.Now(), queryModel{})
+	addTraceFields(frame, msg, queryModel{TraceIDField: "trace.id", SpanIDField: "header:traceparent"})
+
+	if field := frameField(frame, "traceID"); field == nil || field.At(0) != "abc" {
+		t.Errorf("expected trace.id to be renamed to traceID, got %v", field)
+	}
+	if field := frameField(frame, "spanID"); field == nil || field.At(0) != "00f067aa0ba902b7" {
+		t.Errorf("expected span ID out of the traceparent header, got %v", field)
+	}
+}
+
+func TestSchemaTracker(t *testing.T) {
+	var tracker schemaTracker
+	newFrame := func(value map[string]interface{}) *data.Frame {
+		return newMessageFrame(kafka_client.KafkaMessage{Value: value}, time.Now(), queryModel{})
+	}
+
+	if v := tracker.observe(newFrame(map[string]interface{}{"a": 1.0})); v != 1 {
+		t.Errorf("expected first frame to have version 1, got %d", v)
+	}
+	if v := tracker.observe(newFrame(map[string]interface{}{"a": 2.0})); v != 1 {
+		t.Errorf("expected unchanged fields to keep version 1, got %d", v)
+	}
+	if v := tracker.observe(newFrame(map[string]interface{}{"a": "text"})); v != 2 {
+		t.Errorf("expected type change to bump version to 2, got %d", v)
+	}
+	if v := tracker.observe(newFrame(map[string]interface{}{"a": "text", "b": 1.0})); v != 3 {
+		t.Errorf("expected new field to bump version to 3, got %d", v)
+	}
+}
+
+func TestThroughputTracker(t *testing.T) {
+	var tracker throughputTracker
+	start := time.Unix(1000, 0)
+
+	for i := 0; i < throughputWindow; i++ {
+		tracker.add(start.Add(time.Duration(i)*time.Second), 100)
+	}
+	messages, bytes := tracker.rates(start.Add((throughputWindow - 1) * time.Second))
+	if messages != 1 || bytes != 100 {
+		t.Errorf("expected 1 msg/s and 100 B/s, got %v and %v", messages, bytes)
+	}
+
+	messages, _ = tracker.rates(start.Add(2 * throughputWindow * time.Second))
+	if messages != 0 {
+		t.Errorf("expected buckets outside of the window to be ignored, got %v msg/s", messages)
+	}
+}
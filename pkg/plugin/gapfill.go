@@ -0,0 +1,125 @@
+package plugin
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+
+	"github.com/hoptical/grafana-kafka-datasource/pkg/kafka_client"
+)
+
+const (
+	gapFillNull     = "null"
+	gapFillZero     = "zero"
+	gapFillPrevious = "previous"
+)
+
+// maxGapFillRows bounds the rows added to a frame, so that a short interval
+// over a long time range doesn't blow up the response.
+const maxGapFillRows = kafka_client.MAX_RANGE_MESSAGES
+
+// gapFiller adds a row to every interval without messages between the
+// messages of batched frames, at the start of the interval, so that
+// sparse topics render as continuous lines. Added rows are empty, zero for
+// numeric fields, or repeat the previous row, depending on the mode.
+type gapFiller struct {
+	mode     string
+	interval time.Duration
+}
+
+// newGapFiller returns nil if the query doesn't fill gaps.
+func newGapFiller(qm queryModel) (*gapFiller, error) {
+	if qm.GapFill == "" {
+		return nil, nil
+	}
+	switch qm.GapFill {
+	case gapFillNull, gapFillZero, gapFillPrevious:
+	default:
+		return nil, fmt.Errorf("invalid gap fill %q", qm.GapFill)
+	}
+	interval, err := time.ParseDuration(qm.GapFillInterval)
+	if err != nil || interval <= 0 {
+		return nil, fmt.Errorf("invalid gap fill interval %q", qm.GapFillInterval)
+	}
+	return &gapFiller{mode: qm.GapFill, interval: interval}, nil
+}
+
+// gapRow is a row of a filled frame: a row of the original frame, or a row
+// added at time after it.
+type gapRow struct {
+	source int
+	filled bool
+	time   time.Time
+}
+
+// fill returns the frame with its gaps filled. Frames without a time field
+// are returned as is.
+func (g *gapFiller) fill(frame *data.Frame) *data.Frame {
+	timeIndex := -1
+	for i, f := range frame.Fields {
+		if f.Type().Time() {
+			timeIndex = i
+			break
+		}
+	}
+	if timeIndex < 0 {
+		return frame
+	}
+	timeField := frame.Fields[timeIndex]
+
+	var rows []gapRow
+	added, truncated := 0, false
+	for i := 0; i < timeField.Len(); i++ {
+		rows = append(rows, gapRow{source: i})
+		if i == timeField.Len()-1 {
+			break
+		}
+		from, ok := timeField.ConcreteAt(i)
+		if !ok {
+			continue
+		}
+		to, ok := timeField.ConcreteAt(i + 1)
+		if !ok {
+			continue
+		}
+		// Rows are added to the intervals between the ones of the messages.
+		last := to.(time.Time).Truncate(g.interval)
+		for t := from.(time.Time).Truncate(g.interval).Add(g.interval); t.Before(last); t = t.Add(g.interval) {
+			if added == maxGapFillRows {
+				truncated = true
+				break
+			}
+			rows = append(rows, gapRow{source: i, filled: true, time: t})
+			added++
+		}
+	}
+	if added == 0 {
+		return frame
+	}
+
+	filled := data.NewFrame(frame.Name)
+	filled.Meta = frame.Meta
+	for i, f := range frame.Fields {
+		field := data.NewFieldFromFieldType(f.Type(), len(rows))
+		field.Name, field.Labels, field.Config = f.Name, f.Labels, f.Config
+		for r, row := range rows {
+			switch {
+			case !row.filled || (g.mode == gapFillPrevious && i != timeIndex):
+				field.Set(r, f.CopyAt(row.source))
+			case i == timeIndex:
+				field.SetConcrete(r, row.time)
+			case g.mode == gapFillZero && f.Type().Numeric():
+				field.SetConcrete(r, data.NewFieldFromFieldType(f.Type().NonNullableType(), 1).At(0))
+			}
+		}
+		filled.Fields = append(filled.Fields, field)
+	}
+	if truncated {
+		filled.AppendNotices(data.Notice{
+			Severity: data.NoticeSeverityWarning,
+			Text:     fmt.Sprintf("Only the first %d gaps of %s are filled", maxGapFillRows, g.interval),
+		})
+	}
+	return filled
+}
@@ -0,0 +1,76 @@
+package plugin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+func TestGapFiller(t *testing.T) {
+	start := time.Unix(100, 0)
+	frame := func() *data.Frame {
+		value := func(v float64) *float64 { return &v }
+		return data.NewFrame("response",
+			data.NewField("time", nil, []*time.Time{
+				timePtr(start), timePtr(start.Add(2 * time.Second)), timePtr(start.Add(35 * time.Second)),
+			}),
+			data.NewField("level", nil, []*float64{value(1), value(2), value(3)}),
+		)
+	}
+
+	// The intervals starting 10s and 20s past the start have no messages.
+	for _, tc := range []struct {
+		mode   string
+		levels []interface{}
+	}{
+		{gapFillNull, []interface{}{1.0, 2.0, nil, nil, 3.0}},
+		{gapFillZero, []interface{}{1.0, 2.0, 0.0, 0.0, 3.0}},
+		{gapFillPrevious, []interface{}{1.0, 2.0, 2.0, 2.0, 3.0}},
+	} {
+		gaps, err := newGapFiller(queryModel{GapFill: tc.mode, GapFillInterval: "10s"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		filled := gaps.fill(frame())
+
+		level := filled.Fields[1]
+		if level.Len() != len(tc.levels) {
+			t.Fatalf("%s: expected %d rows, got %d", tc.mode, len(tc.levels), level.Len())
+		}
+		for i, want := range tc.levels {
+			got, ok := level.ConcreteAt(i)
+			if !ok {
+				got = nil
+			}
+			if got != want {
+				t.Errorf("%s: expected %v at row %d, got %v", tc.mode, want, i, got)
+			}
+		}
+		if got := filled.Fields[0].At(2).(*time.Time); !got.Equal(start.Add(10 * time.Second)) {
+			t.Errorf("%s: expected the first row added at the start of its interval, got %s", tc.mode, got)
+		}
+	}
+
+	gaps, err := newGapFiller(queryModel{GapFill: gapFillNull, GapFillInterval: "1m"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filled := gaps.fill(frame()); filled.Fields[0].Len() != 3 {
+		t.Errorf("expected frames without empty intervals to be left as is, got %d rows", filled.Fields[0].Len())
+	}
+
+	if gaps, err := newGapFiller(queryModel{}); gaps != nil || err != nil {
+		t.Error("expected no gap filling by default")
+	}
+	if _, err := newGapFiller(queryModel{GapFill: "linear", GapFillInterval: "10s"}); err == nil {
+		t.Error("expected an invalid mode to fail")
+	}
+	if _, err := newGapFiller(queryModel{GapFill: gapFillNull}); err == nil {
+		t.Error("expected a missing interval to fail")
+	}
+}
+
+func timePtr(t time.Time) *time.Time {
+	return &t
+}
@@ -0,0 +1,108 @@
+package plugin
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+const geohashAlphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+// addGeoFields exposes the coordinates of a message as the latitude and
+// longitude number fields the Geomap panel picks up automatically. They either
+// come from two designated fields, which may hold numeric strings, or from a
+// single location field holding a "lat,lon" string or a geohash.
+func addGeoFields(frame *data.Frame, qm queryModel) {
+	renameCoordinate(frame, qm.LatitudeField, "latitude")
+	renameCoordinate(frame, qm.LongitudeField, "longitude")
+
+	if qm.LocationField == "" {
+		return
+	}
+	for _, field := range frame.Fields {
+		if field.Name != qm.LocationField || field.Type() != data.FieldTypeString {
+			continue
+		}
+		lat, lon, ok := parseLocation(field.At(0).(string))
+		if !ok {
+			return
+		}
+		frame.Fields = append(frame.Fields,
+			data.NewField("latitude", field.Labels, []float64{lat}),
+			data.NewField("longitude", field.Labels, []float64{lon}),
+		)
+		return
+	}
+}
+
+func renameCoordinate(frame *data.Frame, ref string, name string) {
+	if ref == "" {
+		return
+	}
+
+	for i, field := range frame.Fields {
+		if field.Name != ref {
+			continue
+		}
+		switch field.Type() {
+		case data.FieldTypeFloat64:
+			field.Name = name
+		case data.FieldTypeString:
+			value, err := strconv.ParseFloat(strings.TrimSpace(field.At(0).(string)), 64)
+			if err == nil {
+				frame.Fields[i] = data.NewField(name, field.Labels, []float64{value})
+			}
+		}
+		return
+	}
+}
+
+// parseLocation parses a "lat,lon" string or, failing that, a geohash, which
+// is decoded to the center of its cell.
+func parseLocation(s string) (float64, float64, bool) {
+	if parts := strings.Split(s, ","); len(parts) == 2 {
+		lat, err := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+		if err != nil || lat < -90 || lat > 90 {
+			return 0, 0, false
+		}
+		lon, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		if err != nil || lon < -180 || lon > 180 {
+			return 0, 0, false
+		}
+		return lat, lon, true
+	}
+	return decodeGeohash(s)
+}
+
+func decodeGeohash(hash string) (float64, float64, bool) {
+	if hash == "" {
+		return 0, 0, false
+	}
+
+	latRange := [2]float64{-90, 90}
+	lonRange := [2]float64{-180, 180}
+	even := true
+	for _, c := range strings.ToLower(hash) {
+		index := strings.IndexRune(geohashAlphabet, c)
+		if index < 0 {
+			return 0, 0, false
+		}
+		// Each character encodes 5 bits, alternately refining the longitude
+		// and the latitude, starting with the longitude.
+		for bit := 4; bit >= 0; bit-- {
+			r := &latRange
+			if even {
+				r = &lonRange
+			}
+			mid := (r[0] + r[1]) / 2
+			if index&(1<<bit) != 0 {
+				r[0] = mid
+			} else {
+				r[1] = mid
+			}
+			even = !even
+		}
+	}
+	return (latRange[0] + latRange[1]) / 2, (lonRange[0] + lonRange[1]) / 2, true
+}
@@ -0,0 +1,61 @@
+package plugin
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/hoptical/grafana-kafka-datasource/pkg/kafka_client"
+)
+
+func TestAddGeoFields(t *testing.T) {
+	msg := kafka_client.KafkaMessage{Value: map[string]interface{}{
+		"lat":  "35.7",
+		"lng":  51.4,
+		"home": "48.85, 2.35",
+	}}
+
+	qm := queryModel{TimestampMode: "message", LatitudeField: "lat", LongitudeField: "lng"}
+	frame := newMessageFrame(msg, time.Unix(1, 0), qm)
+	addGeoFields(frame, qm)
+	if got := frameField(frame, "latitude").At(0).(float64); got != 35.7 {
+		t.Errorf("expected latitude 35.7, got %v", got)
+	}
+	if got := frameField(frame, "longitude").At(0).(float64); got != 51.4 {
+		t.Errorf("expected longitude 51.4, got %v", got)
+	}
+
+	qm = queryModel{TimestampMode: "message", LocationField: "home"}
+	frame = newMessageFrame(msg, time.Unix(1, 0), qm)
+	addGeoFields(frame, qm)
+	if got := frameField(frame, "latitude").At(0).(float64); got != 48.85 {
+		t.Errorf("expected latitude 48.85, got %v", got)
+	}
+	if got := frameField(frame, "longitude").At(0).(float64); got != 2.35 {
+		t.Errorf("expected longitude 2.35, got %v", got)
+	}
+}
+
+func TestParseLocation(t *testing.T) {
+	tests := []struct {
+		location string
+		lat, lon float64
+		ok       bool
+	}{
+		{"1.5,-2", 1.5, -2, true},
+		{"91,0", 0, 0, false},
+		{"ezs42", 42.605, -5.603, true},
+		{"abc", 0, 0, false},
+		{"", 0, 0, false},
+	}
+	for _, tt := range tests {
+		lat, lon, ok := parseLocation(tt.location)
+		if ok != tt.ok {
+			t.Errorf("%q: expected ok to be %v", tt.location, tt.ok)
+			continue
+		}
+		if math.Abs(lat-tt.lat) > 0.001 || math.Abs(lon-tt.lon) > 0.001 {
+			t.Errorf("%q: expected %v,%v, got %v,%v", tt.location, tt.lat, tt.lon, lat, lon)
+		}
+	}
+}
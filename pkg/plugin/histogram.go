@@ -0,0 +1,143 @@
+package plugin
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+
+	"github.com/hoptical/grafana-kafka-datasource/pkg/kafka_client"
+)
+
+const defaultHistogramInterval = 10 * time.Second
+
+// defaultHistogramBuckets are the upper bounds of the default buckets, the
+// same as the Prometheus client defaults, which suit latencies in seconds.
+var defaultHistogramBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// histogram counts the values of a message field into le buckets over fixed
+// intervals, producing one frame per interval for heatmaps.
+type histogram struct {
+	field    string
+	interval time.Duration
+	bounds   []float64
+	counts   []float64
+	start    time.Time
+	observed bool
+}
+
+func newHistogram(qm queryModel) (*histogram, error) {
+	if qm.HistogramField == "" {
+		return nil, fmt.Errorf("histogram field is required")
+	}
+
+	h := &histogram{
+		field:    qm.HistogramField,
+		interval: defaultHistogramInterval,
+		bounds:   defaultHistogramBuckets,
+	}
+	if qm.HistogramInterval != "" {
+		interval, err := time.ParseDuration(qm.HistogramInterval)
+		if err != nil || interval <= 0 {
+			return nil, fmt.Errorf("invalid histogram interval %q", qm.HistogramInterval)
+		}
+		h.interval = interval
+	}
+	if qm.HistogramBuckets != "" {
+		bounds, err := parseHistogramBuckets(qm.HistogramBuckets)
+		if err != nil {
+			return nil, err
+		}
+		h.bounds = bounds
+	}
+	// The last bucket is +Inf.
+	h.counts = make([]float64, len(h.bounds)+1)
+	return h, nil
+}
+
+// parseHistogramBuckets parses a comma separated list of bucket upper bounds.
+func parseHistogramBuckets(s string) ([]float64, error) {
+	var bounds []float64
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		bound, err := strconv.ParseFloat(part, 64)
+		if err != nil || math.IsNaN(bound) || math.IsInf(bound, 0) {
+			return nil, fmt.Errorf("invalid histogram bucket %q", part)
+		}
+		bounds = append(bounds, bound)
+	}
+	if len(bounds) == 0 {
+		return nil, fmt.Errorf("histogram buckets are empty")
+	}
+	sort.Float64s(bounds)
+	return bounds, nil
+}
+
+// observe counts the field value of the message in the interval of t. When t
+// belongs to a later interval, the frame of the current interval is returned
+// before starting the next one. Messages without a numeric value for the field
+// are ignored.
+func (h *histogram) observe(t time.Time, msg kafka_client.KafkaMessage) *data.Frame {
+	value, ok := h.value(msg)
+	if !ok {
+		return nil
+	}
+
+	frame := h.flush(t)
+	if !h.observed {
+		h.start = t.Truncate(h.interval)
+		h.observed = true
+	}
+	h.counts[sort.SearchFloat64s(h.bounds, value)]++
+	return frame
+}
+
+// flush returns the frame of the current interval and resets the counts if
+// the interval ended before now, or nil otherwise.
+func (h *histogram) flush(now time.Time) *data.Frame {
+	if !h.observed || now.Before(h.start.Add(h.interval)) {
+		return nil
+	}
+
+	frame := h.frame()
+	for i := range h.counts {
+		h.counts[i] = 0
+	}
+	h.observed = false
+	return frame
+}
+
+// frame builds a frame with one field per bucket named after its upper bound,
+// the layout Grafana's heatmap expects for time series buckets.
+func (h *histogram) frame() *data.Frame {
+	frame := data.NewFrame("histogram", data.NewField("time", nil, []time.Time{h.start}))
+	for i, count := range h.counts {
+		le := "+Inf"
+		if i < len(h.bounds) {
+			le = strconv.FormatFloat(h.bounds[i], 'g', -1, 64)
+		}
+		frame.Fields = append(frame.Fields, data.NewField(le, nil, []float64{count}))
+	}
+	return frame
+}
+
+func (h *histogram) value(msg kafka_client.KafkaMessage) (float64, bool) {
+	if msg.Err != nil {
+		return 0, false
+	}
+	for _, f := range flattenMessage(msg.Value) {
+		if strings.Join(f.path, ".") != h.field {
+			continue
+		}
+		value, ok := f.value.(float64)
+		return value, ok && !math.IsNaN(value)
+	}
+	return 0, false
+}
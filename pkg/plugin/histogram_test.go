@@ -0,0 +1,88 @@
+package plugin
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hoptical/grafana-kafka-datasource/pkg/kafka_client"
+)
+
+func TestHistogram(t *testing.T) {
+	h, err := newHistogram(queryModel{
+		HistogramField:    "latency.seconds",
+		HistogramBuckets:  "1, 0.1",
+		HistogramInterval: "10s",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	message := func(value interface{}) kafka_client.KafkaMessage {
+		return kafka_client.KafkaMessage{Value: map[string]interface{}{
+			"latency": map[string]interface{}{"seconds": value},
+		}}
+	}
+	start := time.Unix(100, 0)
+	for i, value := range []interface{}{0.05, 0.1, 0.5, 2.0, "slow"} {
+		if frame := h.observe(start.Add(time.Duration(i)*time.Second), message(value)); frame != nil {
+			t.Fatalf("unexpected frame before the end of the interval")
+		}
+	}
+
+	frame := h.observe(start.Add(10*time.Second), message(0.2))
+	if frame == nil {
+		t.Fatal("expected a frame at the end of the interval")
+	}
+	if got := frame.Fields[0].At(0).(time.Time); !got.Equal(start) {
+		t.Errorf("expected the interval to start at %v, got %v", start, got)
+	}
+	want := map[string]float64{"0.1": 2, "1": 1, "+Inf": 1}
+	for name, count := range want {
+		field := frameField(frame, name)
+		if field == nil {
+			t.Errorf("missing bucket %s", name)
+			continue
+		}
+		if got := field.At(0).(float64); got != count {
+			t.Errorf("bucket %s: expected %v, got %v", name, count, got)
+		}
+	}
+
+	frame = h.flush(start.Add(20 * time.Second))
+	if got := frameField(frame, "1").At(0).(float64); got != 1 {
+		t.Errorf("expected the next interval to count 1 value, got %v", got)
+	}
+	if h.flush(start.Add(30*time.Second)) != nil {
+		t.Error("expected no frame for an empty interval")
+	}
+}
+
+func TestNewHistogramErrors(t *testing.T) {
+	for _, qm := range []queryModel{
+		{},
+		{HistogramField: "a", HistogramInterval: "soon"},
+		{HistogramField: "a", HistogramBuckets: "1,x"},
+	} {
+		if _, err := newHistogram(qm); err == nil {
+			t.Errorf("expected %+v to fail", qm)
+		}
+	}
+}
+
+func TestHistogramSchemaFrame(t *testing.T) {
+	qm := queryModel{OutputMode: outputModeHistogram, HistogramField: "latency", HistogramBuckets: "0.1,1"}
+	h, err := newHistogram(qm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := &KafkaDatasource{}
+	frame := d.schemaFrame(context.Background(), qm, h, messageFramer{})
+	if rows, _ := frame.RowLen(); rows != 0 {
+		t.Errorf("expected no rows, got %d", rows)
+	}
+	if len(frame.Fields) != 4 {
+		t.Errorf("expected the time and 3 bucket fields, got %d fields", len(frame.Fields))
+	}
+}
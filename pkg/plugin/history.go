@@ -0,0 +1,359 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+
+	"github.com/hoptical/grafana-kafka-datasource/pkg/kafka_client"
+)
+
+// rangeFrame reads the messages of the time range of a query that doesn't
+// stream, e.g. of alert rules, and returns them as a single frame. The read
+// is shared with the other queries of the request through reads.
+func (d *KafkaDatasource) rangeFrame(ctx context.Context, qm queryModel, timeRange backend.TimeRange,
+	reads *rangeReads) (*data.Frame, error) {
+	budget, err := parseMaxQueryDuration(qm)
+	if err != nil {
+		return nil, err
+	}
+	client, err := d.queryClient(qm)
+	if err != nil {
+		return nil, err
+	}
+	key := rangeKey(qm, timeRange.From, timeRange.To, budget)
+	result, err := reads.result(key, func() (kafka_client.RangeResult, error) {
+		return client.ReadRange(ctx, qm.Topic, int32(qm.Partition), timeRange.From, timeRange.To, 0, budget)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	reference, err := d.rangeReference(ctx, qm)
+	if err != nil {
+		return nil, err
+	}
+	defer reference.release()
+	frame, err := d.messagesFrame(qm, result.Messages, reference)
+	if err != nil {
+		return nil, err
+	}
+	if result.LimitReached {
+		frame.AppendNotices(data.Notice{
+			Severity: data.NoticeSeverityWarning,
+			Text:     fmt.Sprintf("Only %d messages of the time range are shown", kafka_client.MAX_RANGE_MESSAGES),
+		})
+	}
+	if result.Expired {
+		frame.AppendNotices(data.Notice{
+			Severity: data.NoticeSeverityWarning,
+			Text:     fmt.Sprintf("Reading the time range took longer than %s, only the messages read until then are shown", budget),
+		})
+	}
+	if result.PartitionErrors != nil {
+		frame.AppendNotices(degradedNotice(result.PartitionErrors))
+	}
+	return frame, nil
+}
+
+// queryClient returns the client reading the messages of the query.
+func (d *KafkaDatasource) queryClient(qm queryModel) (kafka_client.KafkaClient, error) {
+	client := d.client
+	client.MessageFormat = qm.MessageFormat
+	var err error
+	client.RawPattern, err = kafka_client.CompileRawPattern(qm.RawPattern)
+	return client, err
+}
+
+// fromAlertHeader is set on the queries of alert rules.
+const fromAlertHeader = "FromAlert"
+
+// chunkedRange reports whether the time range holds too many messages for a
+// single response, in which case its frames are streamed in chunks over a
+// Live channel instead, if canStream.
+func (d *KafkaDatasource) chunkedRange(ctx context.Context, qm queryModel, timeRange backend.TimeRange,
+	canStream bool, reads *rangeReads) (bool, error) {
+	if !canStream {
+		return false, nil
+	}
+	key := rangeKey(qm, timeRange.From, timeRange.To, 0)
+	size, err := reads.size(key, func() (int64, error) {
+		return d.client.RangeSize(ctx, qm.Topic, int32(qm.Partition), timeRange.From, timeRange.To)
+	})
+	if err != nil {
+		return false, err
+	}
+	return size > kafka_client.MAX_RANGE_MESSAGES, nil
+}
+
+// runRangeStream streams the messages of the time range of the query in
+// successive frames of up to MAX_RANGE_MESSAGES messages, so that they are
+// never all held in memory, and returns once the range is read.
+func (d *KafkaDatasource) runRangeStream(ctx context.Context, path string, qm queryModel,
+	sender *backend.StreamSender) error {
+	stream, err := d.streams.register(d.clock.Now(), path, qm)
+	if err != nil {
+		return err
+	}
+	defer d.streams.unregister(stream)
+
+	from, to := fromTimestampMs(qm.RangeFrom), fromTimestampMs(qm.RangeTo)
+	size, err := d.client.RangeSize(ctx, qm.Topic, int32(qm.Partition), from, to)
+	if err != nil {
+		return err
+	}
+	// The reference table is loaded once for all the chunks.
+	reference, err := d.rangeReference(ctx, qm)
+	if err != nil {
+		return err
+	}
+	defer reference.release()
+	send := func(frame *data.Frame) error {
+		frame.RefID = qm.RefID
+		return sender.SendFrame(frame, data.IncludeAll)
+	}
+	for _, slice := range rangeSlices(from, to, size) {
+		if err := d.sendRangeChunks(ctx, qm, stream, reference, slice[0], slice[1], send); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rangeSlices splits the time range into consecutive slices expected to hold
+// a chunk of its size messages each, assuming they are evenly spread.
+// Kafka timestamps are in milliseconds, so slices are too, and include their
+// last millisecond.
+func rangeSlices(from, to time.Time, size int64) [][2]time.Time {
+	span := int64(to.Sub(from)/time.Millisecond) + 1
+	n := size/kafka_client.MAX_RANGE_MESSAGES + 1
+	step := time.Duration((span+n-1)/n) * time.Millisecond
+
+	var slices [][2]time.Time
+	for start := from; !start.After(to); start = start.Add(step) {
+		end := start.Add(step - time.Millisecond)
+		if end.After(to) {
+			end = to
+		}
+		slices = append(slices, [2]time.Time{start, end})
+	}
+	return slices
+}
+
+// sendRangeChunks reads the messages of the time range and sends them as a
+// frame, splitting the range in halves while it holds more than a chunk.
+func (d *KafkaDatasource) sendRangeChunks(ctx context.Context, qm queryModel, stream *activeStream,
+	reference *referenceTable, from, to time.Time, send func(*data.Frame) error) error {
+	client, err := d.queryClient(qm)
+	if err != nil {
+		return err
+	}
+	result, err := client.ReadRange(ctx, qm.Topic, int32(qm.Partition), from, to, 0, 0)
+	if err != nil {
+		return err
+	}
+	if result.LimitReached && to.Sub(from) >= time.Millisecond {
+		mid := from.Add(to.Sub(from) / 2).Truncate(time.Millisecond)
+		if err := d.sendRangeChunks(ctx, qm, stream, reference, from, mid, send); err != nil {
+			return err
+		}
+		return d.sendRangeChunks(ctx, qm, stream, reference, mid.Add(time.Millisecond), to, send)
+	}
+	if len(result.Messages) == 0 {
+		return nil
+	}
+	bytes := messagesBytes(result.Messages)
+	if !d.buffers.reserve(bytes) {
+		return errBufferLimit(d.buffers.max)
+	}
+	defer d.buffers.add(-bytes)
+
+	for _, msg := range result.Messages {
+		stream.consumed(msg)
+	}
+	frame, err := d.messagesFrame(qm, result.Messages, reference)
+	if err != nil {
+		return err
+	}
+	if result.LimitReached {
+		frame.AppendNotices(data.Notice{
+			Severity: data.NoticeSeverityWarning,
+			Text: fmt.Sprintf("Only %d messages of %s are shown",
+				kafka_client.MAX_RANGE_MESSAGES, from.Format(time.RFC3339Nano)),
+		})
+	}
+	return send(frame)
+}
+
+func timestampMs(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+func fromTimestampMs(ms int64) time.Time {
+	return time.Unix(0, ms*int64(time.Millisecond))
+}
+
+// parseMaxQueryDuration returns the time budget of time range reads, or zero
+// if there is none.
+func parseMaxQueryDuration(qm queryModel) (time.Duration, error) {
+	if qm.MaxQueryDuration == "" {
+		return 0, nil
+	}
+	budget, err := time.ParseDuration(qm.MaxQueryDuration)
+	if err != nil || budget <= 0 {
+		return 0, fmt.Errorf("invalid max query duration %q", qm.MaxQueryDuration)
+	}
+	return budget, nil
+}
+
+// rangeReference returns the reference table enriching the messages read by
+// a query, read once up to the end of its topic rather than followed, or nil
+// without a reference topic.
+func (d *KafkaDatasource) rangeReference(ctx context.Context, qm queryModel) (*referenceTable, error) {
+	if qm.EnrichmentTopic == "" {
+		return nil, nil
+	}
+	return d.loadReferenceTable(ctx, qm, false)
+}
+
+// messagesFrame turns messages into frames as streams do, always at their
+// timestamp, and merges them into a single frame, enriched from the
+// reference table unless nil.
+func (d *KafkaDatasource) messagesFrame(qm queryModel, messages []kafka_client.KafkaMessage,
+	reference *referenceTable) (*data.Frame, error) {
+	qm.TimestampMode = "message"
+	framer := messageFramer{qm: qm, dataLinks: d.dataLinks, reference: reference}
+	var err error
+	if qm.LookupField != "" {
+		if framer.lookup, err = parseLookupTable(qm.LookupTable); err != nil {
+			return nil, err
+		}
+	}
+	if framer.binaryFields, err = parseBinaryFields(qm.BinaryFields); err != nil {
+		return nil, err
+	}
+	if framer.itemKeys, err = parseItemKeyTemplate(qm.ItemKeyTemplate); err != nil {
+		return nil, err
+	}
+
+	var changes *changeDetector
+	if qm.OnlyChanges {
+		if changes, err = newChangeDetector(qm); err != nil {
+			return nil, err
+		}
+	}
+	var gaps *gapFiller
+	if qm.OutputMode != outputModeTraces && qm.OutputMode != outputModeHistogram {
+		if gaps, err = newGapFiller(qm); err != nil {
+			return nil, err
+		}
+	}
+	var hist *histogram
+	if qm.OutputMode == outputModeHistogram {
+		if hist, err = newHistogram(qm); err != nil {
+			return nil, err
+		}
+	}
+
+	var items []kafka_client.KafkaMessage
+	for _, msg := range messages {
+		items = append(items, framer.items(msg)...)
+	}
+
+	var frames []*data.Frame
+	for _, msg := range items {
+		var frame *data.Frame
+		switch qm.OutputMode {
+		case outputModeTraces:
+			frame = newSpansFrame(msg, msg.Timestamp, qm)
+		case outputModeHistogram:
+			frame = hist.observe(msg.Timestamp, msg)
+		default:
+			frame = framer.frame(msg, msg.Timestamp)
+		}
+		if frame == nil {
+			continue
+		}
+		if len(qm.ThresholdRules) > 0 && !applyThresholds(frame, qm) {
+			continue
+		}
+		if changes != nil && !changes.changed(msg.Timestamp, frame) {
+			continue
+		}
+		frames = append(frames, frame)
+	}
+	if hist != nil && hist.observed {
+		frames = append(frames, hist.frame())
+	}
+
+	name := "response"
+	if len(frames) > 0 {
+		name = frames[0].Name
+	}
+	merged := mergeFrames(name, frames)
+	if gaps != nil {
+		merged = gaps.fill(merged)
+	}
+	if framer.reference != nil {
+		if notice := framer.reference.notice(); notice != nil {
+			merged.AppendNotices(*notice)
+		}
+	}
+	return merged, nil
+}
+
+// mergeFrames concatenates the rows of the frames into a single frame with
+// the union of their fields, which are nullable as not every frame has all of
+// them. Values whose type differs from the first one of their field are left
+// empty.
+func mergeFrames(name string, frames []*data.Frame) *data.Frame {
+	merged := data.NewFrame(name)
+	index := make(map[string]int)
+	notices := make(map[string]bool)
+	rows := 0
+	for _, frame := range frames {
+		n, err := frame.RowLen()
+		if err != nil {
+			continue
+		}
+		for _, field := range merged.Fields {
+			field.Extend(n)
+		}
+
+		for _, f := range frame.Fields {
+			key := f.Name + f.Labels.String()
+			i, ok := index[key]
+			if !ok {
+				field := data.NewFieldFromFieldType(f.Type().NullableType(), rows+n)
+				field.Name, field.Labels, field.Config = f.Name, f.Labels, f.Config
+				merged.Fields = append(merged.Fields, field)
+				i = len(merged.Fields) - 1
+				index[key] = i
+			}
+
+			target := merged.Fields[i]
+			if target.Type() != f.Type().NullableType() {
+				continue
+			}
+			for r := 0; r < n; r++ {
+				if v, ok := f.ConcreteAt(r); ok {
+					target.SetConcrete(rows+r, v)
+				}
+			}
+		}
+
+		if frame.Meta != nil {
+			for _, notice := range frame.Meta.Notices {
+				if !notices[notice.Text] {
+					notices[notice.Text] = true
+					merged.AppendNotices(notice)
+				}
+			}
+		}
+		rows += n
+	}
+	return merged
+}
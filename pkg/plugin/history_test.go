@@ -0,0 +1,130 @@
+package plugin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+
+	"github.com/hoptical/grafana-kafka-datasource/pkg/kafka_client"
+)
+
+func TestMergeFrames(t *testing.T) {
+	a := data.NewFrame("response",
+		data.NewField("time", nil, []time.Time{time.Unix(1, 0)}),
+		data.NewField("a", nil, []float64{1}),
+	)
+	b := data.NewFrame("response",
+		data.NewField("time", nil, []time.Time{time.Unix(2, 0), time.Unix(3, 0)}),
+		data.NewField("a", nil, []string{"x", "y"}),
+		data.NewField("b", nil, []bool{true, false}),
+	)
+
+	merged := mergeFrames("response", []*data.Frame{a, b})
+	if n, _ := merged.RowLen(); n != 3 || len(merged.Fields) != 3 {
+		t.Fatalf("expected 3 rows of 3 fields, got %d rows of %d fields", n, len(merged.Fields))
+	}
+	if v, _ := frameField(merged, "time").ConcreteAt(2); v != time.Unix(3, 0) {
+		t.Errorf("expected the rows in order, got %v", v)
+	}
+	if v, ok := frameField(merged, "a").ConcreteAt(0); !ok || v != 1.0 {
+		t.Errorf("expected the first value of a, got %v", v)
+	}
+	if _, ok := frameField(merged, "a").ConcreteAt(1); ok {
+		t.Error("expected values of another type to be left empty")
+	}
+	if _, ok := frameField(merged, "b").ConcreteAt(0); ok {
+		t.Error("expected missing values to be empty")
+	}
+	if v, _ := frameField(merged, "b").ConcreteAt(1); v != true {
+		t.Errorf("expected the value of b, got %v", v)
+	}
+}
+
+func TestMessagesFrame(t *testing.T) {
+	d := &KafkaDatasource{}
+	start := time.Unix(1000, 0)
+	messages := []kafka_client.KafkaMessage{
+		{Timestamp: start, Value: map[string]interface{}{"temperature": 20.0}},
+		{Timestamp: start.Add(time.Second), Value: map[string]interface{}{"temperature": 35.0}},
+	}
+
+	qm := queryModel{
+		TimestampMode:   "now",
+		ThresholdRules:  []thresholdRule{{Field: "temperature", Operator: ">", Value: "30"}},
+		ThresholdAction: thresholdActionFilter,
+	}
+	frame, err := d.messagesFrame(qm, messages, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := frame.RowLen(); n != 1 {
+		t.Fatalf("expected a single alert, got %d rows", n)
+	}
+	if v, _ := frameField(frame, "time").ConcreteAt(0); v != start.Add(time.Second) {
+		t.Errorf("expected the message timestamp even in now mode, got %v", v)
+	}
+}
+
+func TestParseMaxQueryDuration(t *testing.T) {
+	if budget, err := parseMaxQueryDuration(queryModel{}); err != nil || budget != 0 {
+		t.Errorf("expected no budget by default, got %v, %v", budget, err)
+	}
+	if budget, err := parseMaxQueryDuration(queryModel{MaxQueryDuration: "20s"}); err != nil || budget != 20*time.Second {
+		t.Errorf("expected a 20s budget, got %v, %v", budget, err)
+	}
+	for _, s := range []string{"soon", "0s", "-1s"} {
+		if _, err := parseMaxQueryDuration(queryModel{MaxQueryDuration: s}); err == nil {
+			t.Errorf("expected an error for %q", s)
+		}
+	}
+}
+
+func TestRangeSlices(t *testing.T) {
+	from := time.Unix(1000, 0)
+	to := from.Add(time.Minute)
+
+	if slices := rangeSlices(from, to, 10); len(slices) != 1 || slices[0] != [2]time.Time{from, to} {
+		t.Errorf("expected a single slice for a small range, got %v", slices)
+	}
+
+	slices := rangeSlices(from, to, 3*kafka_client.MAX_RANGE_MESSAGES)
+	if len(slices) != 4 || slices[0][0] != from || slices[3][1] != to {
+		t.Fatalf("expected 4 slices covering the range, got %v", slices)
+	}
+	for i := 1; i < len(slices); i++ {
+		if slices[i][0] != slices[i-1][1].Add(time.Millisecond) {
+			t.Errorf("expected slice %d to start right after the previous one, got %v", i, slices)
+		}
+	}
+
+	if slices := rangeSlices(from, from.Add(time.Millisecond), 100*kafka_client.MAX_RANGE_MESSAGES); len(slices) != 2 {
+		t.Errorf("expected slices of at least a millisecond, got %v", slices)
+	}
+}
+
+func TestMessagesFrameReference(t *testing.T) {
+	d := &KafkaDatasource{}
+	reference := newReferenceTable("devices", "site")
+	reference.update(kafka_client.KafkaMessage{
+		Key:      []byte("device-1"),
+		RawValue: []byte(`{"site":"tehran"}`),
+		Value:    map[string]interface{}{"site": "tehran"},
+	})
+	reference.full = true
+
+	messages := []kafka_client.KafkaMessage{
+		{Key: []byte("device-1"), Timestamp: time.Unix(1, 0), Value: map[string]interface{}{"temperature": 21.5}},
+	}
+	qm := queryModel{EnrichmentTopic: "devices"}
+	frame, err := d.messagesFrame(qm, messages, reference)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := frameField(frame, "site").ConcreteAt(0); v != "tehran" {
+		t.Errorf("expected the site to be joined, got %v", v)
+	}
+	if frame.Meta == nil || len(frame.Meta.Notices) != 1 {
+		t.Errorf("expected the notice of the incomplete reference table, got %+v", frame.Meta)
+	}
+}
@@ -0,0 +1,72 @@
+package plugin
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/hoptical/grafana-kafka-datasource/pkg/kafka_client"
+)
+
+// normalizeQuery returns the canonical form of the query, in which options
+// set to their default are left empty and unordered field lists are sorted,
+// so that equivalent queries share their stream, the queries cached for
+// hashed stream paths and their metrics labels.
+func normalizeQuery(qm queryModel) queryModel {
+	defaults := []struct {
+		option       *string
+		defaultValue string
+	}{
+		{&qm.AutoOffsetReset, "latest"},
+		{&qm.TimestampMode, "message"},
+		{&qm.InvalidUTF8, "replace"},
+		{&qm.OutputMode, outputModeFields},
+		{&qm.DropPolicy, dropPolicyNewest},
+		{&qm.LatePolicy, latePolicyDrop},
+		{&qm.MessageFormat, kafka_client.MESSAGE_FORMAT_JSON},
+	}
+	// Saved queries are migrated before they are run, so their version
+	// doesn't change their results.
+	qm.QueryVersion = 0
+	for _, d := range defaults {
+		if *d.option == d.defaultValue {
+			*d.option = ""
+		}
+	}
+
+	qm.ChangeFields = normalizeFieldList(qm.ChangeFields)
+	qm.SummaryFields = normalizeFieldList(qm.SummaryFields)
+	qm.RequiredFields = normalizeFieldList(qm.RequiredFields)
+	qm.BinaryFields = normalizeFieldList(qm.BinaryFields)
+	if len(qm.SelectedFields) > 0 {
+		qm.SelectedFields = strings.Split(normalizeFieldList(strings.Join(qm.SelectedFields, ",")), ",")
+	}
+	return qm
+}
+
+// normalizeFieldList trims, sorts and deduplicates a comma separated list.
+func normalizeFieldList(list string) string {
+	seen := make(map[string]bool)
+	var fields []string
+	for _, field := range strings.Split(list, ",") {
+		if field = strings.TrimSpace(field); field != "" && !seen[field] {
+			seen[field] = true
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+	return strings.Join(fields, ",")
+}
+
+// canonicalQuery returns the JSON of the canonical form of the query.
+func canonicalQuery(qm queryModel) ([]byte, error) {
+	return json.Marshal(normalizeQuery(qm))
+}
+
+// queryHash identifies a canonical query.
+func queryHash(canonical []byte) string {
+	hash := sha256.Sum256(canonical)
+	return hex.EncodeToString(hash[:16])
+}
@@ -0,0 +1,53 @@
+package plugin
+
+import "testing"
+
+func TestNormalizeQuery(t *testing.T) {
+	explicit := queryModel{
+		RefID:           "A",
+		Topic:           "orders",
+		AutoOffsetReset: "latest",
+		TimestampMode:   "message",
+		OutputMode:      outputModeFields,
+		RequiredFields:  " id, amount ,id",
+		SelectedFields:  []string{"b", "a", " a"},
+	}
+	implicit := queryModel{
+		RefID:          "A",
+		Topic:          "orders",
+		RequiredFields: "amount,id",
+		SelectedFields: []string{"a", "b"},
+	}
+
+	a, err := canonicalQuery(explicit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := canonicalQuery(implicit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(a) != string(b) || queryHash(a) != queryHash(b) {
+		t.Errorf("expected equivalent queries to be identical, got %s and %s", a, b)
+	}
+
+	pathA, _ := streamPath(explicit)
+	pathB, _ := streamPath(implicit)
+	if pathA != pathB {
+		t.Errorf("expected equivalent queries to share their stream, got %q and %q", pathA, pathB)
+	}
+
+	other, _ := canonicalQuery(queryModel{RefID: "A", Topic: "orders", AutoOffsetReset: "earliest"})
+	if queryHash(other) == queryHash(a) {
+		t.Error("expected queries of different options to get different hashes")
+	}
+}
+
+func TestNormalizeFieldList(t *testing.T) {
+	if got := normalizeFieldList(" b,a,, b "); got != "a,b" {
+		t.Errorf("expected a sorted list without duplicates, got %q", got)
+	}
+	if got := normalizeFieldList(""); got != "" {
+		t.Errorf("expected an empty list, got %q", got)
+	}
+}
@@ -0,0 +1,178 @@
+package plugin
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"sort"
+
+	"github.com/hoptical/grafana-kafka-datasource/pkg/kafka_client"
+)
+
+// jsonSchemaDraft is the JSON Schema version of inferred schemas.
+const jsonSchemaDraft = "http://json-schema.org/draft-07/schema#"
+
+// schemaNode accumulates the values observed at a path of the sampled
+// messages, to infer their JSON Schema.
+type schemaNode struct {
+	types map[string]bool
+	// objects counts the observed objects, and seen the objects each
+	// property was observed in, so that properties of every object are
+	// required.
+	objects    int
+	properties map[string]*schemaNode
+	seen       map[string]int
+	items      *schemaNode
+}
+
+func (n *schemaNode) observe(v interface{}) {
+	if n.types == nil {
+		n.types = make(map[string]bool)
+	}
+	switch v := v.(type) {
+	case nil:
+		n.types["null"] = true
+	case bool:
+		n.types["boolean"] = true
+	case string:
+		n.types["string"] = true
+	case float64:
+		if v == math.Trunc(v) && !math.IsInf(v, 0) {
+			n.types["integer"] = true
+		} else {
+			n.types["number"] = true
+		}
+	case []interface{}:
+		n.types["array"] = true
+		if n.items == nil {
+			n.items = &schemaNode{}
+		}
+		for _, item := range v {
+			n.items.observe(item)
+		}
+	case map[string]interface{}:
+		n.types["object"] = true
+		n.objects++
+		if n.properties == nil {
+			n.properties = make(map[string]*schemaNode)
+			n.seen = make(map[string]int)
+		}
+		for key, value := range v {
+			property, ok := n.properties[key]
+			if !ok {
+				property = &schemaNode{}
+				n.properties[key] = property
+			}
+			property.observe(value)
+			n.seen[key]++
+		}
+	}
+}
+
+// schema returns the JSON Schema of the observed values. Integers and other
+// numbers observed at the same path make numbers.
+func (n *schemaNode) schema() map[string]interface{} {
+	schema := make(map[string]interface{})
+	if n.types["number"] {
+		delete(n.types, "integer")
+	}
+	types := make([]string, 0, len(n.types))
+	for t := range n.types {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	switch len(types) {
+	case 0:
+	case 1:
+		schema["type"] = types[0]
+	default:
+		schema["type"] = types
+	}
+
+	if n.items != nil && n.items.types != nil {
+		schema["items"] = n.items.schema()
+	}
+	if n.properties != nil {
+		properties := make(map[string]interface{}, len(n.properties))
+		required := []string{}
+		for key, property := range n.properties {
+			properties[key] = property.schema()
+			if n.seen[key] == n.objects {
+				required = append(required, key)
+			}
+		}
+		sort.Strings(required)
+		schema["properties"] = properties
+		if len(required) > 0 {
+			schema["required"] = required
+		}
+	}
+	return schema
+}
+
+// inferSchemaRequest is the body of infer-schema requests.
+type inferSchemaRequest struct {
+	Topic         string         `json:"topic"`
+	Partition     partitionValue `json:"partition"`
+	MessageFormat string         `json:"messageFormat"`
+	RawPattern    string         `json:"rawPattern"`
+	// Samples is the number of latest messages sampled, up to
+	// MAX_SAMPLE_MESSAGES, which is also the default.
+	Samples int `json:"samples"`
+}
+
+// handleInferSchema samples the latest messages of a topic and returns the
+// JSON Schema their decoded values suggest, e.g. as a starting point for
+// users who don't have the schema of the producer at hand. Messages that
+// fail to decode are skipped.
+func (d *KafkaDatasource) handleInferSchema(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+
+	request := inferSchemaRequest{Partition: partitionValue(kafka_client.ALL_PARTITIONS)}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if request.Topic == "" {
+		writeError(w, http.StatusBadRequest, errors.New("topic is required"))
+		return
+	}
+	if err := kafka_client.ValidateMessageFormat(request.MessageFormat); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	client := d.client
+	client.MessageFormat = request.MessageFormat
+	var err error
+	if client.RawPattern, err = kafka_client.CompileRawPattern(request.RawPattern); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	messages, err := client.SampleMessages(r.Context(), request.Topic, int32(request.Partition), request.Samples)
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+
+	root := &schemaNode{}
+	sampled := 0
+	for _, msg := range messages {
+		switch {
+		case msg.Err != nil:
+			continue
+		case msg.Items != nil:
+			root.observe(msg.Items)
+		default:
+			root.observe(msg.Value)
+		}
+		sampled++
+	}
+	schema := root.schema()
+	schema["$schema"] = jsonSchemaDraft
+	writeJSON(w, http.StatusOK, map[string]interface{}{"schema": schema, "samples": sampled})
+}
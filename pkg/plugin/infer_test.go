@@ -0,0 +1,95 @@
+package plugin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSchemaNode(t *testing.T) {
+	var samples []interface{}
+	for _, s := range []string{
+		`{"id": 1, "price": 2, "name": "a", "tags": ["x"], "owner": {"id": 1}, "note": null}`,
+		`{"id": 2, "price": 2.5, "name": "b", "tags": [], "owner": {"id": 2, "admin": true}}`,
+	} {
+		var v interface{}
+		if err := json.Unmarshal([]byte(s), &v); err != nil {
+			t.Fatal(err)
+		}
+		samples = append(samples, v)
+	}
+	root := &schemaNode{}
+	for _, v := range samples {
+		root.observe(v)
+	}
+
+	expected := map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"id":    map[string]interface{}{"type": "integer"},
+			"price": map[string]interface{}{"type": "number"},
+			"name":  map[string]interface{}{"type": "string"},
+			"tags": map[string]interface{}{
+				"type":  "array",
+				"items": map[string]interface{}{"type": "string"},
+			},
+			"owner": map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"id":    map[string]interface{}{"type": "integer"},
+					"admin": map[string]interface{}{"type": "boolean"},
+				},
+				"required": []string{"id"},
+			},
+			"note": map[string]interface{}{"type": "null"},
+		},
+		"required": []string{"id", "name", "owner", "price", "tags"},
+	}
+	if got := root.schema(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
+
+func TestSchemaNodeMixedTypes(t *testing.T) {
+	root := &schemaNode{}
+	for _, v := range []interface{}{"a", 1.0, nil} {
+		root.observe(v)
+	}
+	expected := map[string]interface{}{"type": []string{"integer", "null", "string"}}
+	if got := root.schema(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
+
+func TestHandleInferSchemaValidation(t *testing.T) {
+	d := &KafkaDatasource{}
+	mux := d.newResourceMux()
+
+	tests := []struct {
+		name   string
+		method string
+		body   string
+		status int
+	}{
+		{"wrong method", http.MethodGet, `{"topic": "t"}`, http.StatusMethodNotAllowed},
+		{"invalid body", http.MethodPost, `{`, http.StatusBadRequest},
+		{"missing topic", http.MethodPost, `{"partition": "all"}`, http.StatusBadRequest},
+		{"invalid partition", http.MethodPost, `{"topic": "t", "partition": "x"}`, http.StatusBadRequest},
+		{"unknown format", http.MethodPost, `{"topic": "t", "messageFormat": "xml"}`, http.StatusBadRequest},
+		{"invalid raw pattern", http.MethodPost, `{"topic": "t", "messageFormat": "raw", "rawPattern": "\\w+"}`,
+			http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequest(tt.method, "/infer-schema", strings.NewReader(tt.body)))
+			if w.Code != tt.status {
+				t.Errorf("expected status %d, got %d: %s", tt.status, w.Code, w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+//go:build integration
+// +build integration
+
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+
+	"github.com/hoptical/grafana-kafka-datasource/pkg/kafka_client"
+	"github.com/hoptical/grafana-kafka-datasource/pkg/testdata"
+)
+
+// See pkg/kafka_client/integration_test.go for running the integration tests.
+func TestIntegrationResources(t *testing.T) {
+	testdata.RequireBroker(t)
+	servers := testdata.BootstrapServers()
+	topic := fmt.Sprintf("integration-resources-%d", time.Now().UnixNano())
+	config := kafka.ConfigMap{"bootstrap.servers": servers}
+
+	admin, err := kafka.NewAdminClient(&config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer admin.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if _, err := admin.CreateTopics(ctx, []kafka.TopicSpecification{{Topic: topic, NumPartitions: 1, ReplicationFactor: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	defer admin.DeleteTopics(ctx, []string{topic})
+
+	producer, err := kafka.NewProducer(&config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer producer.Close()
+	err = producer.Produce(&kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: 0},
+		Value:          testdata.JSON(map[string]interface{}{"host": map[string]interface{}{"name": "a"}, "load": 0.5}),
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	producer.Flush(30000)
+
+	d := &KafkaDatasource{client: kafka_client.NewKafkaClient(kafka_client.Options{BootstrapServers: servers})}
+	mux := d.newResourceMux()
+	get := func(resource string, query url.Values, v interface{}) int {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/"+resource+"?"+query.Encode(), nil))
+		if v != nil {
+			if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return w.Code
+	}
+
+	query := url.Values{"topic": {topic}, "partition": {"0"}, "offset": {"0"}}
+	var fields struct {
+		Fields map[string]interface{} `json:"fields"`
+	}
+	if status := get("fields", query, &fields); status != http.StatusOK {
+		t.Fatalf("expected the fields of the message, got status %d", status)
+	}
+	if fields.Fields["host.name"] != "a" || fields.Fields["load"] != 0.5 {
+		t.Errorf("expected the flattened fields, got %v", fields.Fields)
+	}
+
+	query.Set("offset", "1")
+	if status := get("message", query, nil); status != http.StatusNotFound {
+		t.Errorf("expected a missing offset to be a 404, got %d", status)
+	}
+	query.Set("topic", topic+"-missing")
+	query.Set("offset", "0")
+	if status := get("message", query, nil); status != http.StatusNotFound {
+		t.Errorf("expected a missing topic to be a 404, got %d", status)
+	}
+}
@@ -0,0 +1,100 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+
+	"github.com/hoptical/grafana-kafka-datasource/pkg/kafka_client"
+)
+
+// bufferUsage estimates the bytes of messages held by a datasource instance,
+// in the buffers of its streams, its reference tables and its time range
+// reads, bounded by max unless zero. Its methods are safe for
+// concurrent use, including on a nil usage which tracks nothing.
+type bufferUsage struct {
+	max   int64
+	bytes int64
+}
+
+func (u *bufferUsage) add(n int64) {
+	if u != nil {
+		atomic.AddInt64(&u.bytes, n)
+	}
+}
+
+// messagesBytes estimates the bytes of the messages.
+func messagesBytes(messages []kafka_client.KafkaMessage) int64 {
+	var n int64
+	for _, msg := range messages {
+		n += int64(msg.Size + len(msg.Key))
+	}
+	return n
+}
+
+// frameBytes estimates the bytes of the values of the frame: the length of
+// strings and raw JSON, and 8 bytes for other values.
+func frameBytes(frame *data.Frame) int64 {
+	var n int64
+	for _, field := range frame.Fields {
+		for i := 0; i < field.Len(); i++ {
+			v, ok := field.ConcreteAt(i)
+			if !ok {
+				continue
+			}
+			switch v := v.(type) {
+			case string:
+				n += int64(len(v))
+			case json.RawMessage:
+				n += int64(len(v))
+			default:
+				n += 8
+			}
+		}
+	}
+	return n
+}
+
+// over reports whether the buffered bytes exceed the maximum.
+func (u *bufferUsage) over() bool {
+	return u != nil && u.max > 0 && atomic.LoadInt64(&u.bytes) > u.max
+}
+
+// reserve adds n bytes unless they would exceed the maximum, and tells
+// whether it did.
+func (u *bufferUsage) reserve(n int64) bool {
+	if u == nil {
+		return true
+	}
+	if bytes := atomic.AddInt64(&u.bytes, n); u.max > 0 && bytes > u.max {
+		atomic.AddInt64(&u.bytes, -n)
+		return false
+	}
+	return true
+}
+
+// checkLimits tells whether a stream can start on the path without exceeding
+// the limits of the instance. Streams already running can always be
+// subscribed to, as they don't take more resources.
+func (d *KafkaDatasource) checkLimits(path string) error {
+	if d.streams.running(path) {
+		return nil
+	}
+	if d.streams.full() {
+		return errStreamLimit(d.streams.max)
+	}
+	if d.buffers.over() {
+		return errBufferLimit(d.buffers.max)
+	}
+	return nil
+}
+
+func errStreamLimit(max int) error {
+	return fmt.Errorf("the datasource already runs %d streams, the maximum set in its settings", max)
+}
+
+func errBufferLimit(max int64) error {
+	return fmt.Errorf("the datasource buffers more than %d bytes of messages, the maximum set in its settings", max)
+}
@@ -0,0 +1,134 @@
+package plugin
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hoptical/grafana-kafka-datasource/pkg/kafka_client"
+)
+
+func TestCheckLimits(t *testing.T) {
+	d := &KafkaDatasource{
+		streams: streamRegistry{max: 1},
+		buffers: bufferUsage{max: 100},
+	}
+	if err := d.checkLimits("a"); err != nil {
+		t.Fatalf("expected no error below the limits, got %v", err)
+	}
+
+	if _, err := d.streams.register(time.Now(), "a", queryModel{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.checkLimits("a"); err != nil {
+		t.Errorf("expected running streams to be allowed, got %v", err)
+	}
+	if err := d.checkLimits("b"); err == nil {
+		t.Error("expected an error above the stream limit")
+	}
+	if _, err := d.streams.register(time.Now(), "b", queryModel{}); err == nil {
+		t.Error("expected registering above the stream limit to fail")
+	}
+
+	d.streams.max = 0
+	d.buffers.add(101)
+	if err := d.checkLimits("b"); err == nil {
+		t.Error("expected an error above the buffer limit")
+	}
+}
+
+func TestReorderBufferUsage(t *testing.T) {
+	usage := &bufferUsage{max: 100}
+	b, err := newReorderBuffer(queryModel{ReorderDelay: "1m"}, usage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1000, 0)
+
+	b.push(now, kafka_client.KafkaMessage{Timestamp: now, Size: 60})
+	if messages := b.pop(now); len(messages) != 0 || usage.bytes != 60 {
+		t.Fatalf("expected the message to be held, got %v and %d bytes", messages, usage.bytes)
+	}
+	b.push(now, kafka_client.KafkaMessage{Timestamp: now.Add(time.Second), Size: 60})
+	if messages := b.pop(now); len(messages) != 1 || usage.bytes != 60 {
+		t.Fatalf("expected a message to be released above the limit, got %v and %d bytes", messages, usage.bytes)
+	}
+
+	b.discard()
+	if usage.bytes != 0 {
+		t.Errorf("expected discarded messages to be released, got %d bytes", usage.bytes)
+	}
+}
+
+func TestRangeReadsUsage(t *testing.T) {
+	usage := &bufferUsage{}
+	reads := newRangeReads(usage)
+	key := rangeKey(queryModel{Topic: "t"}, time.Unix(0, 0), time.Unix(60, 0), 0)
+	read := func() (kafka_client.RangeResult, error) {
+		return kafka_client.RangeResult{Messages: []kafka_client.KafkaMessage{{Key: []byte("k"), Size: 9}}}, nil
+	}
+
+	if _, err := reads.result(key, read); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reads.result(key, read); err != nil {
+		t.Fatal(err)
+	}
+	if usage.bytes != 10 {
+		t.Errorf("expected the shared read to count once, got %d bytes", usage.bytes)
+	}
+	reads.release()
+	if usage.bytes != 0 {
+		t.Errorf("expected the reads to be released, got %d bytes", usage.bytes)
+	}
+}
+
+func TestRangeReadsLimit(t *testing.T) {
+	usage := &bufferUsage{max: 15}
+	reads := newRangeReads(usage)
+	read := func() (kafka_client.RangeResult, error) {
+		return kafka_client.RangeResult{Messages: []kafka_client.KafkaMessage{{Key: []byte("k"), Size: 9}}}, nil
+	}
+	key := func(seconds int64) rangeReadKey {
+		return rangeKey(queryModel{Topic: "t"}, time.Unix(0, 0), time.Unix(seconds, 0), 0)
+	}
+
+	if _, err := reads.result(key(60), read); err != nil {
+		t.Fatal(err)
+	}
+	// The second read would take the usage over the maximum.
+	if _, err := reads.result(key(120), read); err == nil || !strings.Contains(err.Error(), "more than 15 bytes") {
+		t.Errorf("expected the read over the limit to fail, got %v", err)
+	}
+	if usage.bytes != 10 {
+		t.Errorf("expected the failed read not to count, got %d bytes", usage.bytes)
+	}
+}
+
+func TestReferenceTableUsage(t *testing.T) {
+	usage := &bufferUsage{}
+	table := newReferenceTable("devices", "")
+	table.usage = usage
+	update := func(value string) {
+		table.update(kafka_client.KafkaMessage{
+			Key:      []byte("k"),
+			RawValue: []byte(value),
+			Value:    map[string]interface{}{},
+		})
+	}
+
+	update("1234")
+	update("12")
+	if usage.bytes != 3 {
+		t.Errorf("expected the latest value to count, got %d bytes", usage.bytes)
+	}
+	update("")
+	if usage.bytes != 0 {
+		t.Errorf("expected the tombstone to release the key, got %d bytes", usage.bytes)
+	}
+	update("1234")
+	table.release()
+	if usage.bytes != 0 {
+		t.Errorf("expected the table to be released, got %d bytes", usage.bytes)
+	}
+}
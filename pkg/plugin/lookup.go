@@ -0,0 +1,119 @@
+package plugin
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// lookupTable is a small user-provided table, keyed by the value of a message
+// field, whose columns are added to frames as human-readable fields, e.g. the
+// location of a sensor ID.
+type lookupTable struct {
+	columns []string
+	rows    map[string][]string
+}
+
+// parseLookupTable parses either a JSON object mapping keys to objects of
+// column values, or CSV whose header names the columns and whose first column
+// holds the keys.
+func parseLookupTable(s string) (*lookupTable, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "{") {
+		return parseJSONLookupTable(s)
+	}
+	return parseCSVLookupTable(s)
+}
+
+func parseJSONLookupTable(s string) (*lookupTable, error) {
+	var values map[string]map[string]interface{}
+	if err := json.Unmarshal([]byte(s), &values); err != nil {
+		return nil, fmt.Errorf("invalid lookup table: %w", err)
+	}
+
+	columnSet := make(map[string]struct{})
+	for _, row := range values {
+		for column := range row {
+			columnSet[column] = struct{}{}
+		}
+	}
+	t := &lookupTable{rows: make(map[string][]string, len(values))}
+	for column := range columnSet {
+		t.columns = append(t.columns, column)
+	}
+	sort.Strings(t.columns)
+
+	for key, row := range values {
+		cells := make([]string, len(t.columns))
+		for i, column := range t.columns {
+			if value, ok := row[column]; ok && value != nil {
+				cells[i] = fmt.Sprint(value)
+			}
+		}
+		t.rows[key] = cells
+	}
+	return t, nil
+}
+
+func parseCSVLookupTable(s string) (*lookupTable, error) {
+	reader := csv.NewReader(strings.NewReader(s))
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("invalid lookup table: %w", err)
+	}
+	if len(records) == 0 || len(records[0]) < 2 {
+		return nil, fmt.Errorf("lookup table needs a header with a key and at least one column")
+	}
+
+	t := &lookupTable{
+		columns: records[0][1:],
+		rows:    make(map[string][]string, len(records)-1),
+	}
+	for _, record := range records[1:] {
+		t.rows[record[0]] = record[1:]
+	}
+	return t, nil
+}
+
+// addFields appends the columns of the row matching the value of the key field
+// to the frame. Columns clashing with message fields are skipped.
+func (t *lookupTable) addFields(frame *data.Frame, keyField string) {
+	for _, field := range frame.Fields {
+		if field.Name != keyField || field.Len() == 0 {
+			continue
+		}
+		key, ok := lookupKey(field.At(0))
+		if !ok {
+			return
+		}
+		cells, ok := t.rows[key]
+		if !ok {
+			return
+		}
+		for i, column := range t.columns {
+			if hasField(frame, column) {
+				continue
+			}
+			frame.Fields = append(frame.Fields, data.NewField(column, field.Labels, []string{cells[i]}))
+		}
+		return
+	}
+}
+
+func lookupKey(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case bool:
+		return strconv.FormatBool(v), true
+	}
+	return "", false
+}
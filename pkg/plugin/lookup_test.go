@@ -0,0 +1,38 @@
+package plugin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hoptical/grafana-kafka-datasource/pkg/kafka_client"
+)
+
+func TestLookupTable(t *testing.T) {
+	tables := map[string]string{
+		"csv":  "sensor,location,floor\n155,Hall A,1\n156,Hall B,2\n",
+		"json": `{"155": {"location": "Hall A", "floor": 1}, "156": {"location": "Hall B", "floor": 2}}`,
+	}
+	for name, s := range tables {
+		t.Run(name, func(t *testing.T) {
+			lookup, err := parseLookupTable(s)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			msg := kafka_client.KafkaMessage{Value: map[string]interface{}{"sensor": 155.0, "value": 3.0}}
+			frame := newMessageFrame(msg, time.Unix(1, 0), queryModel{})
+			lookup.addFields(frame, "sensor")
+
+			if got := frameField(frame, "location").At(0).(string); got != "Hall A" {
+				t.Errorf("expected location Hall A, got %q", got)
+			}
+			if got := frameField(frame, "floor").At(0).(string); got != "1" {
+				t.Errorf("expected floor 1, got %q", got)
+			}
+		})
+	}
+
+	if _, err := parseLookupTable("sensor\n155\n"); err == nil {
+		t.Error("expected a table without columns to fail")
+	}
+}
@@ -0,0 +1,38 @@
+package plugin
+
+import (
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// Keys of the messages shown to users. Unlike the text of the messages, they
+// don't change, so that the frontend can translate them and the
+// documentation can list them.
+const (
+	messageHealthOK              = "health.ok"
+	messageHealthBrokersDown     = "health.brokersUnreachable"
+	messageHealthSomeBrokersDown = "health.someBrokersUnreachable"
+	messageHealthFailed          = "health.failed"
+	messageDecodeFailed          = "message.decodeFailed"
+	messageMissingRequiredFields = "message.missingRequiredFields"
+)
+
+// userMessage is a message shown to users, with its stable key and the
+// arguments its translations are formatted with.
+type userMessage struct {
+	Key  string                 `json:"key"`
+	Args map[string]interface{} `json:"args,omitempty"`
+	Text string                 `json:"text"`
+}
+
+// messagesField returns the field holding the text of messages, joined,
+// whose custom config carries the messages with their keys.
+func messagesField(name string, messages []userMessage, qm queryModel) *data.Field {
+	texts := make([]string, 0, len(messages))
+	for _, m := range messages {
+		texts = append(texts, m.Text)
+	}
+	field := data.NewField(name, nil, []string{sanitizeUTF8(strings.Join(texts, "; "), qm.InvalidUTF8)})
+	return field.SetConfig(&data.FieldConfig{Custom: map[string]interface{}{"messages": messages}})
+}
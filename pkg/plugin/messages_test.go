@@ -0,0 +1,37 @@
+package plugin
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hoptical/grafana-kafka-datasource/pkg/kafka_client"
+)
+
+func TestMessagesField(t *testing.T) {
+	messages := []userMessage{
+		{Key: messageDecodeFailed, Args: map[string]interface{}{"error": "invalid character"}, Text: "invalid character"},
+		{Key: messageMissingRequiredFields, Args: map[string]interface{}{"fields": []string{"id"}}, Text: "missing required fields: id"},
+	}
+	field := messagesField("__warning", messages, queryModel{})
+	if got := field.At(0); got != "invalid character; missing required fields: id" {
+		t.Errorf("unexpected text %q", got)
+	}
+	if got := field.Config.Custom["messages"]; !reflect.DeepEqual(got, messages) {
+		t.Errorf("expected the messages in the custom config, got %v", got)
+	}
+}
+
+func TestNewMessageFrameErrorKey(t *testing.T) {
+	msg := kafka_client.KafkaMessage{Err: errors.New("invalid character")}
+	frame := newMessageFrame(msg, time.Now(), queryModel{})
+	field := frameField(frame, "__error")
+	if field == nil {
+		t.Fatal("expected an error field")
+	}
+	messages, _ := field.Config.Custom["messages"].([]userMessage)
+	if len(messages) != 1 || messages[0].Key != messageDecodeFailed {
+		t.Errorf("expected the key of the decode error, got %v", field.Config.Custom)
+	}
+}
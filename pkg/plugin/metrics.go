@@ -0,0 +1,91 @@
+package plugin
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/hoptical/grafana-kafka-datasource/pkg/kafka_client"
+)
+
+// consumerGauges expose the statistics of the stream consumers, labeled by
+// topic, partition and the hash of the query of the stream, through the
+// metrics endpoint of the plugin.
+var consumerGauges = map[string]*prometheus.GaugeVec{
+	"fetches":    newConsumerGauge("fetches", "Fetch requests sent by the stream consumers."),
+	"messages":   newConsumerGauge("messages", "Messages received by the stream consumers."),
+	"bytes":      newConsumerGauge("bytes", "Bytes of messages received by the stream consumers."),
+	"rebalances": newConsumerGauge("rebalances", "Consumer group rebalances of the stream consumers."),
+	"errors":     newConsumerGauge("errors", "Transmission and reception errors of the stream consumers."),
+	"lag":        newConsumerGauge("lag", "Messages the stream consumers are behind the end of their partitions."),
+}
+
+func newConsumerGauge(name, help string) *prometheus.GaugeVec {
+	return promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "kafka_datasource",
+		Subsystem: "consumer",
+		Name:      name,
+		Help:      help,
+	}, []string{"topic", "partition", "stream"})
+}
+
+func recordConsumerStats(topic, partition, stream string, stats kafka_client.ConsumerStats) {
+	values := map[string]int64{
+		"fetches":    stats.Fetches,
+		"messages":   stats.Messages,
+		"bytes":      stats.Bytes,
+		"rebalances": stats.Rebalances,
+		"errors":     stats.Errors,
+		"lag":        stats.Lag,
+	}
+	for name, value := range values {
+		consumerGauges[name].WithLabelValues(topic, partition, stream).Set(float64(value))
+	}
+}
+
+func deleteConsumerStats(topic, partition, stream string) {
+	for _, gauge := range consumerGauges {
+		gauge.DeleteLabelValues(topic, partition, stream)
+	}
+}
+
+// Reasons readers restart, labeling readerRestarts.
+const (
+	restartSettingsChanged  = "settings_changed"
+	restartPartitionResumed = "partition_resumed"
+)
+
+var (
+	activeStreams = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "kafka_datasource",
+		Name:      "active_streams",
+		Help:      "Streams running, of messages, time ranges and events.",
+	})
+	consumedMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kafka_datasource",
+		Name:      "messages_total",
+		Help:      "Messages consumed by the streams.",
+	}, []string{"topic"})
+	decodeErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kafka_datasource",
+		Name:      "decode_errors_total",
+		Help:      "Messages consumed by the streams that couldn't be decoded in their message format.",
+	}, []string{"topic"})
+	readerRestarts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kafka_datasource",
+		Name:      "reader_restarts_total",
+		Help:      "Streams restarted because the settings changed, and suspended partitions resumed.",
+	}, []string{"topic", "reason"})
+)
+
+// recordMessage counts a message consumed by a stream, and whether it
+// couldn't be decoded.
+func recordMessage(topic string, msg kafka_client.KafkaMessage) {
+	consumedMessages.WithLabelValues(topic).Inc()
+	if msg.Err != nil {
+		decodeErrors.WithLabelValues(topic).Inc()
+	}
+}
+
+func recordRestart(topic, reason string) {
+	readerRestarts.WithLabelValues(topic, reason).Inc()
+}
@@ -0,0 +1,35 @@
+package plugin
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/hoptical/grafana-kafka-datasource/pkg/kafka_client"
+)
+
+func TestRecordMessage(t *testing.T) {
+	topic := "metrics-test"
+	recordMessage(topic, kafka_client.KafkaMessage{})
+	recordMessage(topic, kafka_client.KafkaMessage{Err: errors.New("invalid character")})
+
+	if got := testutil.ToFloat64(consumedMessages.WithLabelValues(topic)); got != 2 {
+		t.Errorf("expected 2 messages, got %v", got)
+	}
+	if got := testutil.ToFloat64(decodeErrors.WithLabelValues(topic)); got != 1 {
+		t.Errorf("expected 1 decode error, got %v", got)
+	}
+}
+
+func TestRecordRestart(t *testing.T) {
+	topic := "metrics-test"
+	recordRestart(topic, restartPartitionResumed)
+
+	if got := testutil.ToFloat64(readerRestarts.WithLabelValues(topic, restartPartitionResumed)); got != 1 {
+		t.Errorf("expected 1 restart, got %v", got)
+	}
+	if got := testutil.ToFloat64(readerRestarts.WithLabelValues(topic, restartSettingsChanged)); got != 0 {
+		t.Errorf("expected no restart, got %v", got)
+	}
+}
@@ -0,0 +1,140 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hoptical/grafana-kafka-datasource/pkg/kafka_client"
+)
+
+// currentQueryVersion is the version of the query model, stored in saved
+// queries as queryVersion. It is bumped along with a migration whenever the
+// meaning of saved queries changes.
+const currentQueryVersion = 1
+
+// currentSettingsVersion is the version of the datasource settings, stored in
+// their JSON data as settingsVersion.
+const currentSettingsVersion = 1
+
+// settingsMigrations upgrade the JSON data of datasource settings from the
+// version of their index to the next one.
+var settingsMigrations = []func(s map[string]interface{}) error{
+	migrateSettingsV0,
+}
+
+// numericSettings are the settings holding numbers.
+var numericSettings = []string{
+	"jsonMaxDepth",
+	"jsonMaxSize",
+	"jsonMaxStringLength",
+	"maxStreams",
+	"maxBufferedBytes",
+	"maxMessagesPerSecond",
+	"maxBytesPerSecond",
+	"commitIntervalMs",
+}
+
+// migrateSettings upgrades the JSON data of datasource settings to the
+// current version, in a single place rather than in the decoding of every
+// setting. Settings already at the current version are returned as is.
+func migrateSettings(b []byte) ([]byte, error) {
+	if len(b) == 0 {
+		return b, nil
+	}
+	var s map[string]interface{}
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil, err
+	}
+	if s == nil {
+		return b, nil
+	}
+	return migrate(s, "settingsVersion", currentSettingsVersion, settingsMigrations, b)
+}
+
+// migrateSettingsV0 upgrades the settings saved before versions were, e.g.
+// provisioned from YAML, in which numbers may be quoted, and empty strings
+// stand for unset settings.
+func migrateSettingsV0(s map[string]interface{}) error {
+	for _, key := range numericSettings {
+		value, ok := s[key].(string)
+		if !ok {
+			continue
+		}
+		if value = strings.TrimSpace(value); value == "" {
+			delete(s, key)
+			continue
+		}
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid %s setting %q", key, value)
+		}
+		s[key] = n
+	}
+	return nil
+}
+
+// queryMigrations upgrade saved queries, as decoded JSON objects, from the
+// version of their index to the next one.
+var queryMigrations = []func(q map[string]interface{}) error{
+	migrateQueryV0,
+}
+
+// migrateQuery upgrades a saved query to the current query model, so that
+// dashboards saved by older versions of the plugin keep working. Queries are
+// migrated when they are run, as the plugin SDK in use has no hook to migrate
+// them when dashboards are loaded.
+func migrateQuery(b []byte) ([]byte, error) {
+	var q map[string]interface{}
+	if err := json.Unmarshal(b, &q); err != nil {
+		return nil, err
+	}
+	return migrate(q, "queryVersion", currentQueryVersion, queryMigrations, b)
+}
+
+// migrate runs the migrations from the version of the decoded object, stored
+// under versionKey, to the current one, and stamps it with the current
+// version. The original JSON is returned when it is already current.
+func migrate(object map[string]interface{}, versionKey string, current int,
+	migrations []func(map[string]interface{}) error, original []byte) ([]byte, error) {
+	version := 0
+	if v, ok := object[versionKey].(float64); ok {
+		version = int(v)
+	}
+	if version >= current {
+		return original, nil
+	}
+	if version < 0 {
+		version = 0
+	}
+	for _, m := range migrations[version:] {
+		if err := m(object); err != nil {
+			return nil, err
+		}
+	}
+	object[versionKey] = current
+	return json.Marshal(object)
+}
+
+// migrateQueryV0 upgrades the queries saved before versions were, whose
+// partition may be a number in a string, e.g. from a text input, and whose
+// message values are JSON, which is made explicit should the default change.
+func migrateQueryV0(q map[string]interface{}) error {
+	if partition, ok := q["partition"].(string); ok && partition != "all" {
+		partition = strings.TrimSpace(partition)
+		if partition == "" {
+			delete(q, "partition")
+		} else {
+			n, err := strconv.ParseInt(partition, 10, 32)
+			if err != nil {
+				return fmt.Errorf("invalid partition %q", partition)
+			}
+			q["partition"] = n
+		}
+	}
+	if format, _ := q["messageFormat"].(string); format == "" {
+		q["messageFormat"] = kafka_client.MESSAGE_FORMAT_JSON
+	}
+	return nil
+}
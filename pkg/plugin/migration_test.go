@@ -0,0 +1,105 @@
+package plugin
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestMigrateQuery(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		expected map[string]interface{}
+	}{
+		{
+			name:  "numeric string partition",
+			query: `{"topicName": "test", "partition": " 3 ", "withStreaming": true}`,
+			expected: map[string]interface{}{
+				"topicName": "test", "partition": 3.0, "withStreaming": true,
+				"messageFormat": "json", "queryVersion": 1.0,
+			},
+		},
+		{
+			name:  "empty partition",
+			query: `{"topicName": "test", "partition": ""}`,
+			expected: map[string]interface{}{
+				"topicName": "test", "messageFormat": "json", "queryVersion": 1.0,
+			},
+		},
+		{
+			name:  "all partitions and explicit format",
+			query: `{"partition": "all", "messageFormat": "raw"}`,
+			expected: map[string]interface{}{
+				"partition": "all", "messageFormat": "raw", "queryVersion": 1.0,
+			},
+		},
+		{
+			name:     "current version",
+			query:    `{"partition": "3", "queryVersion": 1}`,
+			expected: map[string]interface{}{"partition": "3", "queryVersion": 1.0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := migrateQuery([]byte(tt.query))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got map[string]interface{}
+			if err := json.Unmarshal(b, &got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+
+	if _, err := migrateQuery([]byte(`{"partition": "first"}`)); err == nil {
+		t.Error("expected an invalid partition to fail")
+	}
+
+	// Migrated queries decode into the query model.
+	b, err := migrateQuery([]byte(`{"topicName": "test", "partition": "2"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var qm queryModel
+	if err := json.Unmarshal(b, &qm); err != nil {
+		t.Fatal(err)
+	}
+	if qm.Partition != 2 || qm.QueryVersion != currentQueryVersion {
+		t.Errorf("expected partition 2 at the current version, got %+v", qm)
+	}
+}
+
+func TestMigrateSettings(t *testing.T) {
+	settings, pluginSettings, err := getDatasourceSettings(backend.DataSourceInstanceSettings{
+		JSONData: []byte(`{"bootstrapServers": "kafka:9092", "jsonMaxDepth": "16", "maxStreams": " 4 ", "jsonMaxSize": ""}`),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings.BootstrapServers != "kafka:9092" || settings.JSONMaxDepth != 16 || settings.JSONMaxSize != 0 {
+		t.Errorf("expected the quoted numbers to be migrated, got %+v", settings)
+	}
+	if pluginSettings.MaxStreams != 4 || pluginSettings.SettingsVersion != currentSettingsVersion {
+		t.Errorf("expected the max streams migrated to the current version, got %+v", pluginSettings)
+	}
+
+	// Current settings are left as is, so a string is an error.
+	_, _, err = getDatasourceSettings(backend.DataSourceInstanceSettings{
+		JSONData: []byte(`{"jsonMaxDepth": "16", "settingsVersion": 1}`),
+	})
+	if err == nil {
+		t.Error("expected current settings not to be migrated")
+	}
+
+	if _, err := migrateSettings([]byte(`{"maxStreams": "many"}`)); err == nil {
+		t.Error("expected an invalid number to fail")
+	}
+}
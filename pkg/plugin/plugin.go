@@ -2,15 +2,20 @@ package plugin
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/confluentinc/confluent-kafka-go/kafka"
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/instancemgmt"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/resource/httpadapter"
 	"github.com/grafana/grafana-plugin-sdk-go/data"
 	"github.com/grafana/grafana-plugin-sdk-go/live"
 
@@ -21,11 +26,12 @@ var (
 	_ backend.QueryDataHandler      = (*KafkaDatasource)(nil)
 	_ backend.CheckHealthHandler    = (*KafkaDatasource)(nil)
 	_ backend.StreamHandler         = (*KafkaDatasource)(nil)
+	_ backend.CallResourceHandler   = (*KafkaDatasource)(nil)
 	_ instancemgmt.InstanceDisposer = (*KafkaDatasource)(nil)
 )
 
 func NewKafkaInstance(s backend.DataSourceInstanceSettings) (instancemgmt.Instance, error) {
-	settings, err := getDatasourceSettings(s)
+	settings, pluginSettings, err := getDatasourceSettings(s)
 
 	if err != nil {
 		return nil, err
@@ -33,25 +39,112 @@ func NewKafkaInstance(s backend.DataSourceInstanceSettings) (instancemgmt.Instan
 
 	kafka_client := kafka_client.NewKafkaClient(*settings)
 
-	return &KafkaDatasource{kafka_client}, nil
+	ctx, cancel := context.WithCancel(context.Background())
+	ds := &KafkaDatasource{
+		ctx:           ctx,
+		cancel:        cancel,
+		client:        kafka_client,
+		dataLinks:     pluginSettings.DataLinks,
+		streams:       streamRegistry{max: pluginSettings.MaxStreams},
+		buffers:       bufferUsage{max: pluginSettings.MaxBufferedBytes},
+		quota:         newConsumptionQuota(pluginSettings),
+		produceTopics: producibleTopics(pluginSettings.ProduceTopics),
+		clock:         realClock{},
+		disposed:      make(chan struct{}),
+	}
+	// Budgets of reads are measured by the clock of the instance.
+	ds.client.Now = ds.clock.Now
+	ds.resourceHandler = httpadapter.New(ds.newResourceMux())
+	if annotator := newAnnotator(pluginSettings); annotator != nil {
+		go annotator.run(&ds.events, ds.disposed)
+	}
+	instances.add(ds)
+
+	return ds, nil
+}
+
+// datasourceSettings holds the settings of the datasource that don't concern
+// the Kafka client.
+type datasourceSettings struct {
+	DataLinks []dataLink `json:"dataLinks"`
+	// MaxStreams and MaxBufferedBytes bound the streams run by the instance
+	// and the bytes of messages they buffer, unless zero.
+	MaxStreams       int   `json:"maxStreams"`
+	MaxBufferedBytes int64 `json:"maxBufferedBytes"`
+	// MaxMessagesPerSecond and MaxBytesPerSecond throttle the consumption of
+	// all the streams of the instance, unless zero.
+	MaxMessagesPerSecond int   `json:"maxMessagesPerSecond"`
+	MaxBytesPerSecond    int64 `json:"maxBytesPerSecond"`
+	// AnnotationsURL is the root URL of Grafana, e.g. http://localhost:3000,
+	// whose HTTP API stream lifecycle events are written to as annotations
+	// with the AnnotationsToken of the secure settings, restricted to the
+	// dashboard of AnnotationsDashboardUID if set.
+	AnnotationsURL          string `json:"annotationsUrl"`
+	AnnotationsDashboardUID string `json:"annotationsDashboardUid"`
+	AnnotationsToken        string `json:"-"`
+	// ProduceTopics are the comma separated topics the produce resource can
+	// write messages to, none unless set.
+	ProduceTopics string `json:"produceTopics"`
+	// SettingsVersion is the version of the settings, see migrateSettings.
+	SettingsVersion int `json:"settingsVersion"`
 }
 
-func getDatasourceSettings(s backend.DataSourceInstanceSettings) (*kafka_client.Options, error) {
+// getDatasourceSettings decodes the settings of the datasource, once migrated
+// to the current version.
+func getDatasourceSettings(s backend.DataSourceInstanceSettings) (*kafka_client.Options, datasourceSettings, error) {
 	settings := &kafka_client.Options{}
+	var pluginSettings datasourceSettings
 
-	if err := json.Unmarshal(s.JSONData, settings); err != nil {
-		return nil, err
+	jsonData, err := migrateSettings(s.JSONData)
+	if err != nil {
+		return nil, pluginSettings, err
+	}
+	if err := json.Unmarshal(jsonData, settings); err != nil {
+		return nil, pluginSettings, err
+	}
+	if err := json.Unmarshal(jsonData, &pluginSettings); err != nil {
+		return nil, pluginSettings, err
 	}
+	// The API key of the secure settings is the connection string of Event
+	// Hubs namespaces.
+	settings.ConnectionString = s.DecryptedSecureJSONData["apiKey"]
+	pluginSettings.AnnotationsToken = s.DecryptedSecureJSONData["annotationsToken"]
 
-	return settings, nil
+	return settings, pluginSettings, nil
 }
 
 type KafkaDatasource struct {
-	client kafka_client.KafkaClient
+	client    kafka_client.KafkaClient
+	dataLinks []dataLink
+	events    eventHub
+	streams   streamRegistry
+	buffers   bufferUsage
+	quota     *consumptionQuota
+	// produceTopics are the topics messages can be produced to.
+	produceTopics map[string]bool
+	clock         clock
+	// disposed is closed once the settings changed and the instance got
+	// replaced, telling its streams to hand over to the new instance, or
+	// once the plugin exits.
+	disposed chan struct{}
+	stopOnce sync.Once
+	// ctx is canceled once the plugin exits, canceling the contexts of the
+	// running streams.
+	ctx     context.Context
+	cancel  context.CancelFunc
+	running sync.WaitGroup
+
+	resourceHandler backend.CallResourceHandler
 }
 
+// Dispose is called when the datasource settings are saved. Rather than
+// breaking the active streams, they are asked to return, so that Grafana runs
+// them again against the new instance. Every stream closes its own consumer
+// on the way out.
 func (d *KafkaDatasource) Dispose() {
-	// Clean up datasource instance resources.
+	instances.remove(d)
+	d.events.publish(d.clock.Now(), eventSettingsReloaded, "", "Datasource settings changed, restarting streams")
+	d.stop()
 }
 
 func (d *KafkaDatasource) QueryData(ctx context.Context, req *backend.QueryDataRequest) (*backend.QueryDataResponse, error) {
@@ -59,8 +152,12 @@ func (d *KafkaDatasource) QueryData(ctx context.Context, req *backend.QueryDataR
 
 	response := backend.NewQueryDataResponse()
 
+	// Alert rules can't subscribe to Live channels.
+	canStream := req.Headers[fromAlertHeader] != "true"
+	reads := newRangeReads(&d.buffers)
+	defer reads.release()
 	for _, q := range req.Queries {
-		res := d.query(ctx, req.PluginContext, q)
+		res := d.query(ctx, req.PluginContext, q, canStream, reads)
 
 		response.Responses[q.RefID] = res
 	}
@@ -68,23 +165,438 @@ func (d *KafkaDatasource) QueryData(ctx context.Context, req *backend.QueryDataR
 	return response, nil
 }
 
+// partitionValue is either a single partition number or "all" to consume
+// every partition of the topic.
+type partitionValue int32
+
+func (p partitionValue) String() string {
+	if int32(p) == kafka_client.ALL_PARTITIONS {
+		return "all"
+	}
+	return strconv.Itoa(int(p))
+}
+
+func (p partitionValue) MarshalJSON() ([]byte, error) {
+	if int32(p) == kafka_client.ALL_PARTITIONS {
+		return json.Marshal("all")
+	}
+	return json.Marshal(int32(p))
+}
+
+func (p *partitionValue) UnmarshalJSON(b []byte) error {
+	var all string
+	if err := json.Unmarshal(b, &all); err == nil {
+		if all != "all" {
+			return fmt.Errorf("invalid partition %q", all)
+		}
+		*p = partitionValue(kafka_client.ALL_PARTITIONS)
+		return nil
+	}
+
+	var partition int32
+	if err := json.Unmarshal(b, &partition); err != nil {
+		return err
+	}
+	*p = partitionValue(partition)
+	return nil
+}
+
 type queryModel struct {
-	Topic           string `json:"topicName"`
-	Partition       int32  `json:"partition"`
-	WithStreaming   bool   `json:"withStreaming"`
-	AutoOffsetReset string `json:"autoOffsetReset"`
-	TimestampMode   string `json:"timestampMode"`
+	// RefID is part of the stream path, so that the streaming queries of a
+	// panel, possibly mixed with other datasources, get their own channel
+	// even when their options are the same.
+	RefID           string         `json:"refId,omitempty"`
+	Topic           string         `json:"topicName,omitempty"`
+	Partition       partitionValue `json:"partition,omitempty"`
+	WithStreaming   bool           `json:"withStreaming,omitempty"`
+	AutoOffsetReset string         `json:"autoOffsetReset,omitempty"`
+	TimestampMode   string         `json:"timestampMode,omitempty"`
+	// PivotNumericKeys moves numeric identifiers found in nested keys into
+	// field labels instead of creating a column per identifier.
+	PivotNumericKeys bool `json:"pivotNumericKeys,omitempty"`
+	// EmptyKeyName replaces empty object keys in field names. Empty keys are
+	// dropped when it is not set.
+	EmptyKeyName string `json:"emptyKeyName,omitempty"`
+	// MessageStats adds the message size as a __bytes field and the rolling
+	// throughput of the stream as frame stats.
+	MessageStats bool `json:"messageStats,omitempty"`
+	// InvalidUTF8 is either "replace" or "strip" and controls how invalid
+	// UTF-8 sequences in field names and string values are handled.
+	InvalidUTF8 string `json:"invalidUtf8,omitempty"`
+	// MarkExplicitNulls emits a <field>__present boolean for fields explicitly
+	// set to null, which would otherwise look the same as missing fields.
+	MarkExplicitNulls bool `json:"markExplicitNulls,omitempty"`
+	// TraceIDField and SpanIDField designate the message fields, or headers
+	// when prefixed with "header:", holding trace and span IDs.
+	TraceIDField string `json:"traceIdField,omitempty"`
+	SpanIDField  string `json:"spanIdField,omitempty"`
+	// OutputMode selects how messages are turned into frames: "fields"
+	// flattens them, "traces" maps OTLP, Zipkin and Jaeger spans to frames
+	// for the trace view and "histogram" buckets a numeric field per interval.
+	OutputMode string `json:"outputMode,omitempty"`
+	// HistogramField is the dotted path of the field counted in histogram
+	// mode, HistogramBuckets a comma separated list of bucket upper bounds
+	// and HistogramInterval the duration covered by each histogram frame.
+	HistogramField    string `json:"histogramField,omitempty"`
+	HistogramBuckets  string `json:"histogramBuckets,omitempty"`
+	HistogramInterval string `json:"histogramInterval,omitempty"`
+	// LatitudeField and LongitudeField designate the coordinate fields,
+	// exposed as latitude and longitude for the Geomap panel. LocationField
+	// designates a field holding both, as a "lat,lon" string or a geohash.
+	LatitudeField  string `json:"latitudeField,omitempty"`
+	LongitudeField string `json:"longitudeField,omitempty"`
+	LocationField  string `json:"locationField,omitempty"`
+	// EnrichmentTopic is a compacted topic whose latest value per key is
+	// joined onto streamed messages with the same key. EnrichmentFields is a
+	// comma separated list of the reference fields to join, or all if empty.
+	EnrichmentTopic  string `json:"enrichmentTopic,omitempty"`
+	EnrichmentFields string `json:"enrichmentFields,omitempty"`
+	// LookupTable is a small CSV or JSON table, keyed by the value of the
+	// LookupField, whose columns are added to frames as fields.
+	LookupField string `json:"lookupField,omitempty"`
+	LookupTable string `json:"lookupTable,omitempty"`
+	// OnlyChanges suppresses frames whose ChangeFields, a comma separated
+	// list defaulting to all but the time and meta fields, haven't changed
+	// since the last frame sent. Unchanged frames are still sent once per
+	// ChangeHeartbeat, if set.
+	OnlyChanges     bool   `json:"onlyChanges,omitempty"`
+	ChangeFields    string `json:"changeFields,omitempty"`
+	ChangeHeartbeat string `json:"changeHeartbeat,omitempty"`
+	// ThresholdRules are evaluated against every message. ThresholdAction
+	// is either "tag", adding an alert field telling whether any rule
+	// matched, or "filter", only keeping matching messages.
+	ThresholdRules  []thresholdRule `json:"thresholdRules,omitempty"`
+	ThresholdAction string          `json:"thresholdAction,omitempty"`
+	// DropPolicy selects the frames dropped when the client can't keep up:
+	// the "newest" ones, the default, or the "oldest" ones.
+	DropPolicy string `json:"dropPolicy,omitempty"`
+	// InitialSchema sends a frame without rows at stream start, with the
+	// fields of the latest message of the topic, so that panels render
+	// their columns before the first message arrives.
+	InitialSchema bool `json:"initialSchema,omitempty"`
+	// ConsumerStats adds the latest statistics of the stream consumer, like
+	// its lag, to the custom meta of frames.
+	ConsumerStats bool `json:"consumerStats,omitempty"`
+	// ErrorBudget is the number of consecutive read errors after which a
+	// partition is suspended for ErrorCooldown, 10 and 30s by default.
+	ErrorBudget   int    `json:"errorBudget,omitempty"`
+	ErrorCooldown string `json:"errorCooldown,omitempty"`
+	// SummaryAboveBytes, if set, is the size above which messages are only
+	// summarized by their size, key, partition, offset and the comma
+	// separated top-level SummaryFields, instead of being fully flattened.
+	SummaryAboveBytes int    `json:"summaryAboveBytes,omitempty"`
+	SummaryFields     string `json:"summaryFields,omitempty"`
+	// ReorderDelay, if set, buffers messages for up to that long to merge
+	// the partitions in timestamp order.
+	ReorderDelay string `json:"reorderDelay,omitempty"`
+	// MaxLateness, if set, is how far behind the latest message seen a
+	// message may be before being late. LatePolicy tells whether late
+	// messages are dropped, the default, emitted right away or emitted with
+	// a late field.
+	MaxLateness string `json:"maxLateness,omitempty"`
+	LatePolicy  string `json:"latePolicy,omitempty"`
+	// MaxStringLength, if set, truncates longer string fields to that many
+	// characters, followed by an ellipsis.
+	MaxStringLength int `json:"maxStringLength,omitempty"`
+	// BinaryFields is a comma separated list of field:rendering pairs
+	// rendering base64 encoded bytes, or the message key, as base64, hex,
+	// length or UTF-8 if valid.
+	BinaryFields string `json:"binaryFields,omitempty"`
+	// ConsumerGroup, if set, consumes all partitions of the topic as a
+	// member of that consumer group instead of assigning them directly, so
+	// that streams of several Grafana instances share them and resume from
+	// the offsets committed by the group.
+	ConsumerGroup string `json:"consumerGroup,omitempty"`
+	// OffsetCheckpoints adds the latest offset consumed per partition to the
+	// custom meta of frames, e.g. to replay a time range later on.
+	OffsetCheckpoints bool `json:"offsetCheckpoints,omitempty"`
+	// MaxQueryDuration bounds the time spent reading the time range of
+	// queries that don't stream, which then return the messages read so far.
+	MaxQueryDuration string `json:"maxQueryDuration,omitempty"`
+	// MessageFormat is the format of the message values, JSON by default.
+	// jsonSchema strips the header of the Confluent JSON Schema serializer,
+	// msgpack and cbor decode MessagePack and CBOR, and raw skips decoding.
+	MessageFormat string `json:"messageFormat,omitempty"`
+	// RawPattern is a regular expression whose named capture groups are
+	// extracted from raw messages into fields, e.g. of log lines.
+	RawPattern string `json:"rawPattern,omitempty"`
+	// ArrayItems frames the items of messages whose value is a top-level
+	// array either as the fields of a single row, named after
+	// ItemKeyTemplate, item_%d by default, or as rows of their own.
+	ArrayItems      string `json:"arrayItems,omitempty"`
+	ItemKeyTemplate string `json:"itemKeyTemplate,omitempty"`
+	// GapFill fills the gaps longer than GapFillInterval between the
+	// messages of queries that don't stream with rows at times aligned on
+	// the interval, either null, zero or repeating the previous row.
+	GapFill         string `json:"gapFill,omitempty"`
+	GapFillInterval string `json:"gapFillInterval,omitempty"`
+	// SelectedFields are the dotted paths of the fields used by the panel,
+	// as picked in its field selection. Only those are flattened into frames,
+	// along with the time and __ fields. All fields are when empty.
+	SelectedFields []string `json:"selectedFields,omitempty"`
+	// RangeFrom and RangeTo, in milliseconds since the epoch, are set on the
+	// streams of time ranges too large for a single response, which read the
+	// range in chunks instead of consuming new messages.
+	RangeFrom int64 `json:"rangeFrom,omitempty"`
+	RangeTo   int64 `json:"rangeTo,omitempty"`
+	// StrictDecode turns messages that fail to decode, or miss any of the
+	// comma separated RequiredFields, into error frames. Otherwise, the
+	// fields decoded are emitted along with a warning field.
+	StrictDecode   bool   `json:"strictDecode,omitempty"`
+	RequiredFields string `json:"requiredFields,omitempty"`
+	// Polling makes streaming queries return the messages past the offsets
+	// of their Cursor, by partition, instead of a Live channel, for clients
+	// polling for new messages where Live is unavailable. Queries that don't
+	// stream return the next page of up to PageSize messages past their
+	// Cursor instead of the time range when it is set, even empty.
+	Polling  bool             `json:"polling,omitempty"`
+	Cursor   map[string]int64 `json:"cursor,omitempty"`
+	PageSize int              `json:"pageSize,omitempty"`
+	// QueryVersion is the version of the query model the query was saved
+	// with, see migrateQuery.
+	QueryVersion int `json:"queryVersion,omitempty"`
 }
 
-func (d *KafkaDatasource) query(_ context.Context, pCtx backend.PluginContext, query backend.DataQuery) backend.DataResponse {
+const (
+	outputModeFields    = "fields"
+	outputModeTraces    = "traces"
+	outputModeHistogram = "histogram"
+)
+
+// maxStreamPathLength keeps channel IDs, made of the datasource UID and the
+// stream path, within the 160 characters accepted by Grafana Live.
+const maxStreamPathLength = 110
+
+// compressedStreamPrefix marks the paths of queries too large to be encoded
+// into their path as is, but not once compressed.
+const compressedStreamPrefix = "z/"
+
+// streamQueriesPrefix marks the paths of queries too large to be encoded into
+// their path even compressed, which are looked up in streamQueries instead.
+const streamQueriesPrefix = "q/"
+
+// streamQueries holds the queries of hashed stream paths. It is shared by the
+// instances of the process, so that streams still find their query once
+// handed over to a new instance.
+var streamQueries streamQueryStore
+
+// streamPath encodes the streaming options of a query, in their canonical
+// form, into a Live channel path, so that RunStream gets them back without
+// any shared state. Queries too large for a channel ID are compressed, or
+// get a path made of their hash instead if still too large.
+func streamPath(qm queryModel) (string, error) {
+	b, err := canonicalQuery(qm)
+	if err != nil {
+		return "", err
+	}
+	if path := base64.RawURLEncoding.EncodeToString(b); len(path) <= maxStreamPathLength {
+		return path, nil
+	}
+	path, err := compressedStreamPath(b)
+	if err != nil {
+		return "", err
+	}
+	if len(path) <= maxStreamPathLength {
+		return path, nil
+	}
+
+	path = streamQueriesPrefix + queryHash(b)
+	streamQueries.store(path, normalizeQuery(qm))
+	return path, nil
+}
+
+func parseStreamPath(path string) (queryModel, error) {
+	var qm queryModel
+	if strings.HasPrefix(path, streamQueriesPrefix) {
+		stored, ok := streamQueries.load(path)
+		if !ok {
+			return qm, fmt.Errorf("unknown stream %s, run the query again", path)
+		}
+		return stored, nil
+	}
+
+	var b []byte
+	var err error
+	if strings.HasPrefix(path, compressedStreamPrefix) {
+		b, err = decompressStreamPath(strings.TrimPrefix(path, compressedStreamPrefix))
+	} else {
+		b, err = base64.RawURLEncoding.DecodeString(path)
+	}
+	if err != nil {
+		return qm, err
+	}
+	err = json.Unmarshal(b, &qm)
+	return qm, err
+}
+
+// forgetStreamPath removes the query of a hashed stream path once its stream
+// ended for lack of subscribers. Streams stopped for their instance to be
+// replaced resume with the same path, so their query is kept.
+func (d *KafkaDatasource) forgetStreamPath(path string) {
+	if !strings.HasPrefix(path, streamQueriesPrefix) {
+		return
+	}
+	select {
+	case <-d.disposed:
+	default:
+		streamQueries.remove(path)
+	}
+}
+
+// query answers a query. Unless canStream is false, it may return a Live channel
+// instead of frames, for streaming queries and for the time ranges of other
+// queries too large for a single response.
+func (d *KafkaDatasource) query(ctx context.Context, pCtx backend.PluginContext, query backend.DataQuery,
+	canStream bool, reads *rangeReads) backend.DataResponse {
 	response := backend.DataResponse{}
 	var qm queryModel
-	response.Error = json.Unmarshal(query.JSON, &qm)
+	b, err := migrateQuery(query.JSON)
+	if err != nil {
+		response.Error = err
+		return response
+	}
+	response.Error = json.Unmarshal(b, &qm)
 
 	if response.Error != nil {
 		return response
 	}
+	qm.RefID = query.RefID
+
+	if qm.OutputMode == outputModeHistogram {
+		if _, err := newHistogram(qm); err != nil {
+			response.Error = err
+			return response
+		}
+	}
+
+	if qm.LookupField != "" {
+		if _, err := parseLookupTable(qm.LookupTable); err != nil {
+			response.Error = err
+			return response
+		}
+	}
+
+	if qm.OnlyChanges {
+		if _, err := newChangeDetector(qm); err != nil {
+			response.Error = err
+			return response
+		}
+	}
+
+	for _, rule := range qm.ThresholdRules {
+		if err := rule.validate(); err != nil {
+			response.Error = err
+			return response
+		}
+	}
+
+	if _, err := newErrorBudget(qm); err != nil {
+		response.Error = err
+		return response
+	}
 
+	if _, err := newReorderBuffer(qm, nil); err != nil {
+		response.Error = err
+		return response
+	}
+
+	if _, err := newLatenessTracker(qm); err != nil {
+		response.Error = err
+		return response
+	}
+
+	if _, err := parseBinaryFields(qm.BinaryFields); err != nil {
+		response.Error = err
+		return response
+	}
+
+	if err := kafka_client.ValidateMessageFormat(qm.MessageFormat); err != nil {
+		response.Error = err
+		return response
+	}
+
+	if _, err := kafka_client.CompileRawPattern(qm.RawPattern); err != nil {
+		response.Error = err
+		return response
+	}
+
+	if err := validateArrayItems(qm.ArrayItems); err != nil {
+		response.Error = err
+		return response
+	}
+
+	if _, err := parseItemKeyTemplate(qm.ItemKeyTemplate); err != nil {
+		response.Error = err
+		return response
+	}
+
+	if _, err := newGapFiller(qm); err != nil {
+		response.Error = err
+		return response
+	}
+
+	if _, err := parseMaxQueryDuration(qm); err != nil {
+		response.Error = err
+		return response
+	}
+
+	if qm.ConsumerGroup != "" && int32(qm.Partition) != kafka_client.ALL_PARTITIONS {
+		response.Error = fmt.Errorf("consumer groups consume all partitions of a topic")
+		return response
+	}
+
+	// Alert rules can't subscribe to channels, so streaming queries read the
+	// time range of the rule instead.
+	if !canStream {
+		qm.WithStreaming = false
+	}
+
+	if (qm.WithStreaming && qm.Polling) || (!qm.WithStreaming && qm.Cursor != nil) {
+		if qm.ConsumerGroup != "" {
+			response.Error = fmt.Errorf("consumer groups can't be polled nor paged")
+			return response
+		}
+		var frame *data.Frame
+		if qm.WithStreaming {
+			frame, err = d.pollFrame(ctx, qm)
+		} else {
+			frame, err = d.pageFrame(ctx, qm)
+		}
+		if err != nil {
+			response.Error = err
+			return response
+		}
+		response.Frames = append(response.Frames, frame)
+		return response
+	}
+
+	if !qm.WithStreaming {
+		chunked, err := d.chunkedRange(ctx, qm, query.TimeRange, canStream, reads)
+		if err != nil {
+			response.Error = err
+			return response
+		}
+		if !chunked {
+			frame, err := d.rangeFrame(ctx, qm, query.TimeRange, reads)
+			if err != nil {
+				response.Error = err
+				return response
+			}
+			if !canStream {
+				frame = alertSeries(frame, query.TimeRange, query.MaxDataPoints)
+			}
+			response.Frames = append(response.Frames, frame)
+			return response
+		}
+		qm.RangeFrom = timestampMs(query.TimeRange.From)
+		qm.RangeTo = timestampMs(query.TimeRange.To)
+	}
+
+	path, err := streamPath(qm)
+	if err != nil {
+		response.Error = err
+		return response
+	}
 	frame := data.NewFrame("response")
 
 	frame.Fields = append(frame.Fields,
@@ -92,55 +604,115 @@ func (d *KafkaDatasource) query(_ context.Context, pCtx backend.PluginContext, q
 		data.NewField("values", nil, []int64{0, 0}),
 	)
 
-	topic := qm.Topic
-	partition := qm.Partition
-	autoOffsetReset := qm.AutoOffsetReset
-	timestampMode := qm.TimestampMode
-	if qm.WithStreaming {
-		channel := live.Channel{
-			Scope:     live.ScopeDatasource,
-			Namespace: pCtx.DataSourceInstanceSettings.UID,
-			Path:      fmt.Sprintf("%v_%d_%v_%v", topic, partition, autoOffsetReset, timestampMode),
-		}
-		frame.SetMeta(&data.FrameMeta{Channel: channel.String()})
+	channel := live.Channel{
+		Scope:     live.ScopeDatasource,
+		Namespace: pCtx.DataSourceInstanceSettings.UID,
+		Path:      path,
 	}
+	frame.SetMeta(&data.FrameMeta{Channel: channel.String()})
 
 	response.Frames = append(response.Frames, frame)
 
 	return response
 }
 
-func (d *KafkaDatasource) CheckHealth(_ context.Context, req *backend.CheckHealthRequest) (*backend.CheckHealthResult, error) {
+func (d *KafkaDatasource) CallResource(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
+	return d.resourceHandler.CallResource(ctx, req, sender)
+}
+
+func (d *KafkaDatasource) CheckHealth(ctx context.Context, req *backend.CheckHealthRequest) (*backend.CheckHealthResult, error) {
 	log.DefaultLogger.Info("CheckHealth called", "request", req)
 
 	var status = backend.HealthStatusOk
-	var message = "Data source is working"
+	message := userMessage{Key: messageHealthOK, Text: "Data source is working"}
 
-	err := d.client.HealthCheck()
+	brokers, err := d.client.HealthCheck(ctx)
 
+	failed := kafka_client.FailedBrokers(brokers)
 	if err != nil {
 		status = backend.HealthStatusError
-		message = "Cannot connect to the brokers!"
+		message = userMessage{Key: messageHealthBrokersDown, Text: "Cannot connect to the brokers!"}
+		if !errors.Is(err, kafka_client.ErrBrokerUnreachable) {
+			message = userMessage{
+				Key:  messageHealthFailed,
+				Args: map[string]interface{}{"error": err.Error()},
+				Text: err.Error(),
+			}
+		}
+	} else if len(failed) > 0 {
+		message = userMessage{
+			Key:  messageHealthSomeBrokersDown,
+			Args: map[string]interface{}{"brokers": failed},
+			Text: fmt.Sprintf("Data source is working, but some brokers are unreachable: %s", strings.Join(failed, ", ")),
+		}
+	}
+
+	// The message is repeated in the details along with its key.
+	details, err := json.Marshal(map[string]interface{}{
+		"brokers": brokerDetails(brokers),
+		"message": message,
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	return &backend.CheckHealthResult{
-		Status:  status,
-		Message: message,
+		Status:      status,
+		Message:     message.Text,
+		JSONDetails: details,
 	}, nil
 }
 
-func (d *KafkaDatasource) SubscribeStream(_ context.Context, req *backend.SubscribeStreamRequest) (*backend.SubscribeStreamResponse, error) {
+type brokerDetail struct {
+	Broker   string `json:"broker"`
+	Attempts int    `json:"attempts"`
+	Error    string `json:"error,omitempty"`
+}
+
+func brokerDetails(brokers []kafka_client.BrokerHealth) []brokerDetail {
+	details := make([]brokerDetail, 0, len(brokers))
+	for _, b := range brokers {
+		detail := brokerDetail{Broker: b.Broker, Attempts: b.Attempts}
+		if b.Err != nil {
+			detail.Error = b.Err.Error()
+		}
+		details = append(details, detail)
+	}
+	return details
+}
+
+func (d *KafkaDatasource) SubscribeStream(ctx context.Context, req *backend.SubscribeStreamRequest) (*backend.SubscribeStreamResponse, error) {
 	log.DefaultLogger.Info("SubscribeStream called", "request", req)
+	if req.Path == eventsPath {
+		return &backend.SubscribeStreamResponse{
+			Status: backend.SubscribeStreamStatusOK,
+		}, nil
+	}
 	// Extract the query parameters
-	var path []string = strings.Split(req.Path, "_")
-	topic := path[0]
-	partition, _ := strconv.Atoi(path[1])
-	autoOffsetReset := path[2]
-	timestampMode := path[3]
-	// Initialize Consumer and Assign the topic
-	d.client.TopicAssign(topic, int32(partition), autoOffsetReset, timestampMode)
-	status := backend.SubscribeStreamStatusPermissionDenied
-	status = backend.SubscribeStreamStatusOK
+	qm, err := parseStreamPath(req.Path)
+	if err != nil {
+		log.DefaultLogger.Error("Invalid stream path", "path", req.Path, "error", err)
+		return &backend.SubscribeStreamResponse{
+			Status: backend.SubscribeStreamStatusNotFound,
+		}, nil
+	}
+	if err := d.checkLimits(req.Path); err != nil {
+		log.DefaultLogger.Warn("Refusing stream", "path", req.Path, "error", err)
+		return nil, err
+	}
+	err = d.client.ValidateTopic(ctx, qm.Topic, int32(qm.Partition))
+	if err != nil {
+		log.DefaultLogger.Error("Error validating topic", "topic", qm.Topic, "error", err)
+		return nil, err
+	}
+	if qm.EnrichmentTopic != "" {
+		err = d.client.ValidateTopic(ctx, qm.EnrichmentTopic, kafka_client.ALL_PARTITIONS)
+		if err != nil {
+			log.DefaultLogger.Error("Error validating reference topic", "topic", qm.EnrichmentTopic, "error", err)
+			return nil, err
+		}
+	}
+	status := backend.SubscribeStreamStatusOK
 
 	return &backend.SubscribeStreamResponse{
 		Status: status,
@@ -149,50 +721,436 @@ func (d *KafkaDatasource) SubscribeStream(_ context.Context, req *backend.Subscr
 
 func (d *KafkaDatasource) RunStream(ctx context.Context, req *backend.RunStreamRequest, sender *backend.StreamSender) error {
 	log.DefaultLogger.Info("RunStream called", "request", req)
+	ctx, done := d.streamContext(ctx)
+	defer done()
+	activeStreams.Inc()
+	defer activeStreams.Dec()
+	if req.Path == eventsPath {
+		return d.runEventsStream(ctx, sender)
+	}
+
+	qm, err := parseStreamPath(req.Path)
+	if err != nil {
+		return err
+	}
+	defer d.forgetStreamPath(req.Path)
+	if qm.RangeTo != 0 {
+		return d.runRangeStream(ctx, req.Path, qm, sender)
+	}
+
+	// Every stream gets its own consumer, initialized and assigned the topic
+	// here, so that streams of the same datasource don't interfere.
+	client, err := d.queryClient(qm)
+	if err != nil {
+		return err
+	}
+	client.StatsInterval = kafka_client.STATS_INTERVAL
+	defer client.Dispose()
+	canonical, err := canonicalQuery(qm)
+	if err != nil {
+		return err
+	}
+	streamID := queryHash(canonical)
+	defer deleteConsumerStats(qm.Topic, qm.Partition.String(), streamID)
+	if qm.ConsumerGroup != "" {
+		client.GroupID = qm.ConsumerGroup
+		err = client.TopicSubscribe(ctx, qm.Topic, qm.AutoOffsetReset, qm.TimestampMode)
+	} else {
+		err = client.TopicAssign(ctx, qm.Topic, int32(qm.Partition), qm.AutoOffsetReset, qm.TimestampMode)
+	}
+	var partitionErrors *kafka_client.PartitionErrors
+	if errors.As(err, &partitionErrors) && partitionErrors.Partial() {
+		log.DefaultLogger.Warn("Streaming topic partially", "topic", qm.Topic, "error", err)
+	} else if err != nil {
+		log.DefaultLogger.Error("Error assigning topic", "topic", qm.Topic, "error", err)
+		return err
+	}
+
+	d.events.publish(d.clock.Now(), eventStreamStarted, qm.Topic, fmt.Sprintf("Streaming partition %s", qm.Partition))
+	defer func() {
+		d.events.publish(d.clock.Now(), eventStreamStopped, qm.Topic, fmt.Sprintf("Stopped streaming partition %s", qm.Partition))
+	}()
+	stream, err := d.streams.register(d.clock.Now(), req.Path, qm)
+	if err != nil {
+		return err
+	}
+	defer d.streams.unregister(stream)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var reference *referenceTable
+	if qm.EnrichmentTopic != "" {
+		reference, err = d.loadReferenceTable(ctx, qm, true)
+		if err != nil {
+			log.DefaultLogger.Error("Error loading reference topic", "topic", qm.EnrichmentTopic, "error", err)
+			return err
+		}
+	}
+
+	var lookup *lookupTable
+	if qm.LookupField != "" {
+		lookup, err = parseLookupTable(qm.LookupTable)
+		if err != nil {
+			return err
+		}
+	}
+
+	var changes *changeDetector
+	if qm.OnlyChanges {
+		changes, err = newChangeDetector(qm)
+		if err != nil {
+			return err
+		}
+	}
+
+	var hist *histogram
+	if qm.OutputMode == outputModeHistogram {
+		hist, err = newHistogram(qm)
+		if err != nil {
+			return err
+		}
+	}
+
+	budget, err := newErrorBudget(qm)
+	if err != nil {
+		return err
+	}
+	health := brokerHealth{topic: qm.Topic}
+
+	reorder, err := newReorderBuffer(qm, &d.buffers)
+	if err != nil {
+		return err
+	}
+	if reorder != nil {
+		defer reorder.discard()
+	}
+
+	lateness, err := newLatenessTracker(qm)
+	if err != nil {
+		return err
+	}
+
+	binaryFields, err := parseBinaryFields(qm.BinaryFields)
+	if err != nil {
+		return err
+	}
+
+	var schema schemaTracker
+	var meta streamCustomMeta
+	var throughput throughputTracker
+	var checkpointed, committed, throttled time.Time
+
+	// Frames are sent from their own goroutine, so that a slow client
+	// doesn't stall the consumer.
+	queue := newFrameQueue(qm.DropPolicy, d.clock, &d.buffers)
+	sent := make(chan struct{})
+	go func() {
+		defer close(sent)
+		queue.run(ctx, func(frame *data.Frame) error {
+			return sender.SendFrame(frame, data.IncludeAll)
+		})
+	}()
+	defer func() {
+		cancel()
+		<-sent
+		// Frames still queued when the instance stops are sent on the
+		// way out.
+		select {
+		case <-d.disposed:
+			queue.flush(func(frame *data.Frame) error {
+				return sender.SendFrame(frame, data.IncludeAll)
+			})
+		default:
+			queue.discard()
+		}
+	}()
+
+	send := func(frame *data.Frame) {
+		version := schema.observe(frame)
+		if version > 1 && version != meta.SchemaVersion {
+			d.events.publish(d.clock.Now(), eventSchemaChanged, qm.Topic, fmt.Sprintf("Schema version changed to %d", version))
+		}
+		meta.SchemaVersion = version
+		if now := d.clock.Now(); qm.OffsetCheckpoints && now.Sub(checkpointed) >= offsetCheckpointInterval {
+			meta.Offsets = stream.checkpoint()
+			checkpointed = now
+		}
+		frame.RefID = qm.RefID
+		if frame.Meta == nil {
+			frame.SetMeta(&data.FrameMeta{})
+		}
+		frame.Meta.Custom = meta
+		if client.PartitionErrors != nil {
+			frame.AppendNotices(degradedNotice(client.PartitionErrors))
+		}
+		if notice := budget.notice(); notice != nil {
+			frame.AppendNotices(*notice)
+		}
+		if reference != nil {
+			if notice := reference.notice(); notice != nil {
+				frame.AppendNotices(*notice)
+			}
+		}
+		if !throttled.IsZero() && d.clock.Now().Sub(throttled) < throughputWindow*time.Second {
+			frame.AppendNotices(d.quota.notice())
+		}
+		if qm.MessageStats {
+			frame.Meta.Stats = throughput.stats(d.clock.Now())
+		}
+
+		queue.push(frame)
+	}
+
+	itemKeys, err := parseItemKeyTemplate(qm.ItemKeyTemplate)
+	if err != nil {
+		return err
+	}
+	framer := messageFramer{
+		qm:           qm,
+		dataLinks:    d.dataLinks,
+		reference:    reference,
+		lookup:       lookup,
+		binaryFields: binaryFields,
+		itemKeys:     itemKeys,
+	}
+	messageFrame := framer.frame
+
+	processItem := func(msg kafka_client.KafkaMessage, frame_time time.Time, late bool) {
+		var frame *data.Frame
+		switch qm.OutputMode {
+		case outputModeTraces:
+			frame = newSpansFrame(msg, frame_time, qm)
+		case outputModeHistogram:
+			frame = hist.observe(frame_time, msg)
+		default:
+			frame = messageFrame(msg, frame_time)
+			if lateness != nil && qm.LatePolicy == latePolicyTag {
+				frame.Fields = append(frame.Fields, data.NewField("late", nil, []bool{late}))
+			}
+		}
+		if frame == nil {
+			return
+		}
+		if len(qm.ThresholdRules) > 0 && !applyThresholds(frame, qm) {
+			return
+		}
+		if changes != nil && !changes.changed(d.clock.Now(), frame) {
+			return
+		}
+		send(frame)
+	}
+
+	process := func(msg kafka_client.KafkaMessage, late bool) {
+		var frame_time time.Time
+		if qm.TimestampMode == "now" {
+			frame_time = d.clock.Now()
+		} else {
+			frame_time = msg.Timestamp
+		}
+		log.DefaultLogger.Debug("Message consumed", "partition", msg.Partition, "offset", msg.Offset,
+			"timestamp", frame_time)
+		if qm.MessageStats {
+			throughput.add(d.clock.Now(), msg.Size)
+		}
+		for _, item := range framer.items(msg) {
+			processItem(item, frame_time, late)
+		}
+	}
+
+	if qm.InitialSchema {
+		if frame := d.schemaFrame(ctx, qm, hist, framer); frame != nil {
+			send(frame)
+		}
+	}
 
 	for {
 		select {
 		case <-ctx.Done():
 			log.DefaultLogger.Info("Context done, finish streaming", "path", req.Path)
 			return nil
+		case <-d.disposed:
+			log.DefaultLogger.Info("Datasource settings changed, restarting stream", "path", req.Path)
+			// Streams stopped as the plugin exits aren't restarted.
+			if d.ctx.Err() == nil {
+				recordRestart(qm.Topic, restartSettingsChanged)
+			}
+			return nil
 		default:
-			msg, event := d.client.ConsumerPull()
+			if qm.ConsumerGroup != "" && d.clock.Now().Sub(committed) >= client.CommitInterval {
+				offsets, err := client.Commit()
+				if err != nil {
+					log.DefaultLogger.Warn("Error committing offsets", "topic", qm.Topic, "group", qm.ConsumerGroup, "error", err)
+				} else if offsets != nil {
+					meta.Committed = partitionOffsets(offsets)
+				}
+				committed = d.clock.Now()
+			}
+			for _, partition := range budget.due(d.clock.Now()) {
+				if err := client.ResumePartition(qm.Topic, partition); err != nil {
+					log.DefaultLogger.Error("Error resuming partition", "topic", qm.Topic, "partition", partition, "error", err)
+				} else {
+					recordRestart(qm.Topic, restartPartitionResumed)
+				}
+			}
+			msg, event := client.ConsumerPull()
 			if event == nil {
+				if reorder != nil {
+					for _, msg := range reorder.pop(d.clock.Now()) {
+						process(msg, false)
+					}
+				}
+				// Close the histogram interval even if the topic went quiet.
+				if hist != nil && qm.TimestampMode == "now" {
+					if frame := hist.flush(d.clock.Now()); frame != nil {
+						send(frame)
+					}
+				}
+				continue
+			}
+			if ok, err := client.Rebalance(event); ok {
+				if err != nil {
+					log.DefaultLogger.Error("Error rebalancing partitions", "topic", qm.Topic, "error", err)
+				}
+				d.events.publish(d.clock.Now(), eventRebalanced, qm.Topic, rebalanceMessage(event, qm.ConsumerGroup))
 				continue
 			}
-			frame := data.NewFrame("response")
-			frame.Fields = append(frame.Fields,
-				data.NewField("time", nil, make([]time.Time, 1)),
-			)
-			var frame_time time.Time
-			if d.client.TimestampMode == "now" {
-				frame_time = time.Now()
+			switch e := event.(type) {
+			case kafka.Error:
+				health.error(d, e)
+				continue
+			case *kafka.Stats:
+				stats, err := kafka_client.ParseStats(e.String())
+				if err != nil {
+					log.DefaultLogger.Warn("Error parsing consumer statistics", "error", err)
+					continue
+				}
+				recordConsumerStats(qm.Topic, qm.Partition.String(), streamID, stats)
+				if qm.ConsumerStats {
+					meta.Consumer = &stats
+				}
+				continue
+			case *kafka.Message:
+			default:
+				continue
+			}
+			if wait := d.quota.take(d.clock.Now(), msg.Size); wait > 0 {
+				throttled = d.clock.Now()
+				select {
+				case <-d.clock.After(wait):
+				case <-ctx.Done():
+				case <-d.disposed:
+				}
+			}
+			if errors.Is(msg.Err, kafka_client.ErrPartitionRead) {
+				if budget.failure(d.clock.Now(), msg.Partition) {
+					log.DefaultLogger.Warn("Suspending partition", "topic", qm.Topic, "partition", msg.Partition, "error", msg.Err)
+					if err := client.PausePartition(qm.Topic, msg.Partition); err != nil {
+						log.DefaultLogger.Error("Error suspending partition", "topic", qm.Topic, "partition", msg.Partition, "error", err)
+					}
+				}
 			} else {
-				frame_time = msg.Timestamp
+				budget.success(msg.Partition)
+				health.read(d)
+				stream.consumed(msg)
+				recordMessage(qm.Topic, msg)
+			}
+			// Messages are dropped rather than buffered beyond the maximum of
+			// the instance, which the next frame sent tells about, while the
+			// reorder buffer is released early.
+			if d.buffers.over() {
+				queue.overflow()
+				if reorder != nil {
+					for _, msg := range reorder.pop(d.clock.Now()) {
+						process(msg, false)
+					}
+				}
+				continue
+			}
+			late := msg.Err == nil && lateness != nil && lateness.late(msg)
+			if late && (qm.LatePolicy == "" || qm.LatePolicy == latePolicyDrop) {
+				meta.LateDropped++
+				continue
 			}
-			log.DefaultLogger.Info("Offset", msg.Offset)
-			log.DefaultLogger.Info("timestamp", frame_time)
-			frame.Fields[0].Set(0, frame_time)
+			// Late messages are past the point they could be merged in
+			// order, so they skip the reorder buffer.
+			if reorder == nil || msg.Err != nil || late {
+				process(msg, late)
+				continue
+			}
+			reorder.push(d.clock.Now(), msg)
+			for _, msg := range reorder.pop(d.clock.Now()) {
+				process(msg, false)
+			}
+		}
+	}
+}
 
-			cnt := 1
+// schemaFrame returns a frame without rows with the fields the stream is
+// going to send. Histograms and traces have fixed fields, while the fields of
+// messages are sampled from the latest message of the topic.
+func (d *KafkaDatasource) schemaFrame(ctx context.Context, qm queryModel, hist *histogram,
+	framer messageFramer) *data.Frame {
+	switch qm.OutputMode {
+	case outputModeTraces:
+		return newTracesFrame(nil)
+	case outputModeHistogram:
+		return hist.frame().EmptyCopy()
+	}
 
-			for key, value := range msg.Value {
-				frame.Fields = append(frame.Fields,
-					data.NewField(key, nil, make([]float64, 1)))
-				frame.Fields[cnt].Set(0, value)
-				cnt++
-			}
+	client, err := d.queryClient(qm)
+	if err != nil {
+		return nil
+	}
+	msg, err := client.LatestMessage(ctx, qm.Topic, int32(qm.Partition))
+	if err != nil {
+		log.DefaultLogger.Warn("Error sampling topic schema", "topic", qm.Topic, "error", err)
+		return nil
+	}
+	frameTime := msg.Timestamp
+	if qm.TimestampMode == "now" {
+		frameTime = d.clock.Now()
+	}
+	frame := framer.frame(framer.items(msg)[0], frameTime)
+	if len(qm.ThresholdRules) > 0 {
+		applyThresholds(frame, qm)
+	}
+	return frame.EmptyCopy()
+}
 
-			err := sender.SendFrame(frame, data.IncludeAll)
+// runEventsStream forwards datasource-level events to the events channel until
+// the last subscriber leaves.
+func (d *KafkaDatasource) runEventsStream(ctx context.Context, sender *backend.StreamSender) error {
+	events := d.events.subscribe()
+	defer d.events.unsubscribe(events)
 
-			if err != nil {
-				log.DefaultLogger.Error("Error sending frame", "error", err)
-				continue
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-d.disposed:
+			// Flush the events published while disposing before handing
+			// over to the new instance.
+			for {
+				select {
+				case e := <-events:
+					d.sendEvent(e, sender)
+				default:
+					return nil
+				}
 			}
+		case e := <-events:
+			d.sendEvent(e, sender)
 		}
 	}
 }
 
+func (d *KafkaDatasource) sendEvent(e datasourceEvent, sender *backend.StreamSender) {
+	if err := sender.SendFrame(e.frame(), data.IncludeAll); err != nil {
+		log.DefaultLogger.Error("Error sending event frame", "error", err)
+	}
+}
+
 func (d *KafkaDatasource) PublishStream(_ context.Context, req *backend.PublishStreamRequest) (*backend.PublishStreamResponse, error) {
 	log.DefaultLogger.Info("PublishStream called", "request", req)
 
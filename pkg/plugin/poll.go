@@ -0,0 +1,90 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+
+	"github.com/hoptical/grafana-kafka-datasource/pkg/kafka_client"
+)
+
+// pollCustomMeta is the custom meta of the frames of polling and paging
+// queries.
+type pollCustomMeta struct {
+	// Cursor is the offset of the next message of every partition, which
+	// the next query passes back.
+	Cursor map[string]int64 `json:"cursor"`
+	// End tells whether every partition was read up to its end.
+	End bool `json:"end"`
+}
+
+// pollFrame returns the messages of the topic past the cursor of the query,
+// for clients that can't subscribe to Live channels and poll for new messages
+// instead.
+func (d *KafkaDatasource) pollFrame(ctx context.Context, qm queryModel) (*data.Frame, error) {
+	return d.cursorFrame(ctx, qm, func(client kafka_client.KafkaClient,
+		cursor map[int32]int64) (kafka_client.PollResult, error) {
+		return client.ReadNew(ctx, qm.Topic, int32(qm.Partition), cursor, qm.AutoOffsetReset)
+	})
+}
+
+// pageFrame returns the next page of messages of the topic past the cursor of
+// the query, for scripts paging through a topic with queries that don't
+// stream.
+func (d *KafkaDatasource) pageFrame(ctx context.Context, qm queryModel) (*data.Frame, error) {
+	return d.cursorFrame(ctx, qm, func(client kafka_client.KafkaClient,
+		cursor map[int32]int64) (kafka_client.PollResult, error) {
+		return client.ReadPage(ctx, qm.Topic, int32(qm.Partition), cursor, qm.PageSize)
+	})
+}
+
+func (d *KafkaDatasource) cursorFrame(ctx context.Context, qm queryModel,
+	read func(kafka_client.KafkaClient, map[int32]int64) (kafka_client.PollResult, error)) (*data.Frame, error) {
+	cursor, err := parseCursor(qm.Cursor)
+	if err != nil {
+		return nil, err
+	}
+	client, err := d.queryClient(qm)
+	if err != nil {
+		return nil, err
+	}
+	result, err := read(client, cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	reference, err := d.rangeReference(ctx, qm)
+	if err != nil {
+		return nil, err
+	}
+	defer reference.release()
+	frame, err := d.messagesFrame(qm, result.Messages, reference)
+	if err != nil {
+		return nil, err
+	}
+	if frame.Meta == nil {
+		frame.SetMeta(&data.FrameMeta{})
+	}
+	frame.Meta.Custom = pollCustomMeta{Cursor: partitionOffsets(result.Cursor), End: result.End}
+	if result.LimitReached && qm.WithStreaming {
+		frame.AppendNotices(data.Notice{
+			Severity: data.NoticeSeverityWarning,
+			Text:     fmt.Sprintf("More than %d new messages, the rest are read by the next polls", kafka_client.MAX_POLL_MESSAGES),
+		})
+	}
+	return frame, nil
+}
+
+func parseCursor(cursor map[string]int64) (map[int32]int64, error) {
+	offsets := make(map[int32]int64, len(cursor))
+	for key, offset := range cursor {
+		partition, err := strconv.ParseInt(key, 10, 32)
+		if err != nil || offset < 0 {
+			return nil, fmt.Errorf("invalid cursor offset %d of partition %q", offset, key)
+		}
+		offsets[int32(partition)] = offset
+	}
+	return offsets, nil
+}
@@ -0,0 +1,43 @@
+package plugin
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestParseCursor(t *testing.T) {
+	cursor, err := parseCursor(map[string]int64{"0": 12, "3": 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[int32]int64{0: 12, 3: 0}
+	if !reflect.DeepEqual(cursor, expected) {
+		t.Errorf("expected %v, got %v", expected, cursor)
+	}
+
+	for _, invalid := range []map[string]int64{{"all": 1}, {"0": -1}} {
+		if _, err := parseCursor(invalid); err == nil {
+			t.Errorf("expected an error for %v", invalid)
+		}
+	}
+}
+
+func TestQueryPolling(t *testing.T) {
+	d := &KafkaDatasource{}
+	pCtx := backend.PluginContext{DataSourceInstanceSettings: &backend.DataSourceInstanceSettings{UID: "kafka"}}
+
+	for _, query := range []string{
+		`{"topicName": "events", "partition": "all", "withStreaming": true, "polling": true, "consumerGroup": "grafana"}`,
+		`{"topicName": "events", "partition": 0, "withStreaming": true, "polling": true, "cursor": {"0": -1}}`,
+		`{"topicName": "events", "partition": "all", "cursor": {}, "consumerGroup": "grafana"}`,
+		`{"topicName": "events", "partition": 0, "cursor": {"x": 1}}`,
+	} {
+		response := d.query(context.Background(), pCtx, backend.DataQuery{JSON: []byte(query)}, true, nil)
+		if response.Error == nil {
+			t.Errorf("expected an error for %s", query)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package plugin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/resource/httpadapter"
+)
+
+// maxProduceBodySize bounds the body of produce requests.
+const maxProduceBodySize = 1 << 20
+
+// producerRoles are the roles of the users allowed to produce messages.
+var producerRoles = map[string]bool{"Admin": true, "Editor": true}
+
+// produceRequest is the body of produce requests.
+type produceRequest struct {
+	Topic string  `json:"topic"`
+	Key   *string `json:"key"`
+	// Value is written as is, unless it's a JSON string, whose content is
+	// written instead.
+	Value   json.RawMessage   `json:"value"`
+	Headers map[string]string `json:"headers"`
+}
+
+// producibleTopics returns the comma separated topics of the settings that
+// messages can be produced to.
+func producibleTopics(topics string) map[string]bool {
+	producible := make(map[string]bool)
+	for _, topic := range strings.Split(topics, ",") {
+		if topic = strings.TrimSpace(topic); topic != "" {
+			producible[topic] = true
+		}
+	}
+	return producible
+}
+
+// messageValue returns the bytes written for the value of a produce request.
+func messageValue(value json.RawMessage) ([]byte, error) {
+	if len(value) == 0 || string(value) == "null" {
+		return nil, errors.New("value is required")
+	}
+	var s string
+	if err := json.Unmarshal(value, &s); err == nil {
+		return []byte(s), nil
+	}
+	return value, nil
+}
+
+// handleProduce writes a message to one of the topics of the settings, e.g.
+// to publish events from dashboard actions or alert webhooks back to Kafka.
+// It's restricted to editors and admins.
+func (d *KafkaDatasource) handleProduce(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+	if user := httpadapter.UserFromContext(r.Context()); user == nil || !producerRoles[user.Role] {
+		writeError(w, http.StatusForbidden, errors.New("only editors and admins can produce messages"))
+		return
+	}
+
+	var request produceRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxProduceBodySize)).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if request.Topic == "" {
+		writeError(w, http.StatusBadRequest, errors.New("topic is required"))
+		return
+	}
+	if !d.produceTopics[request.Topic] {
+		writeError(w, http.StatusForbidden, fmt.Errorf("messages can't be produced to topic %s", request.Topic))
+		return
+	}
+	value, err := messageValue(request.Value)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	var key []byte
+	if request.Key != nil {
+		key = []byte(*request.Key)
+	}
+
+	produced, err := d.client.Produce(r.Context(), request.Topic, key, value, request.Headers)
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+	d.events.publish(d.clock.Now(), eventMessageProduced, request.Topic,
+		fmt.Sprintf("Message produced to partition %d at offset %d", produced.Partition, produced.Offset))
+	writeJSON(w, http.StatusOK, produced)
+}
@@ -0,0 +1,81 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/resource/httpadapter"
+)
+
+func TestProducibleTopics(t *testing.T) {
+	expected := map[string]bool{"alerts": true, "actions": true}
+	if got := producibleTopics(" alerts, ,actions "); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
+
+func TestMessageValue(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected string
+	}{
+		{`"plain text"`, "plain text"},
+		{`{"state": "alerting"}`, `{"state": "alerting"}`},
+		{`42`, "42"},
+	}
+	for _, tt := range tests {
+		got, err := messageValue(json.RawMessage(tt.value))
+		if err != nil || string(got) != tt.expected {
+			t.Errorf("%s: expected %q, got %q, %v", tt.value, tt.expected, got, err)
+		}
+	}
+	if _, err := messageValue(json.RawMessage("null")); err == nil {
+		t.Error("expected an error for a null value")
+	}
+	if _, err := messageValue(nil); err == nil {
+		t.Error("expected an error for a missing value")
+	}
+}
+
+func TestHandleProduceValidation(t *testing.T) {
+	d := &KafkaDatasource{produceTopics: producibleTopics("alerts")}
+	handler := httpadapter.New(d.newResourceMux())
+
+	tests := []struct {
+		name   string
+		role   string
+		method string
+		body   string
+		status int
+	}{
+		{"wrong method", "Editor", http.MethodGet, "", http.StatusMethodNotAllowed},
+		{"viewer", "Viewer", http.MethodPost, `{"topic": "alerts", "value": "x"}`, http.StatusForbidden},
+		{"invalid body", "Editor", http.MethodPost, `{`, http.StatusBadRequest},
+		{"missing topic", "Editor", http.MethodPost, `{"value": "x"}`, http.StatusBadRequest},
+		{"unlisted topic", "Admin", http.MethodPost, `{"topic": "orders", "value": "x"}`, http.StatusForbidden},
+		{"missing value", "Editor", http.MethodPost, `{"topic": "alerts"}`, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &backend.CallResourceRequest{
+				PluginContext: backend.PluginContext{User: &backend.User{Role: tt.role}},
+				Path:          "produce",
+				Method:        tt.method,
+				URL:           "produce",
+				Body:          []byte(tt.body),
+			}
+			sender := &responseRecorder{}
+			if err := handler.CallResource(context.Background(), req, sender); err != nil {
+				t.Fatal(err)
+			}
+			if sender.response.Status != tt.status {
+				t.Errorf("expected status %d, got %d: %s", tt.status, sender.response.Status, sender.response.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,183 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/live"
+
+	"github.com/hoptical/grafana-kafka-datasource/pkg/kafka_client"
+)
+
+func TestPartitionValueJSON(t *testing.T) {
+	var qm queryModel
+	if err := json.Unmarshal([]byte(`{"partition": "all"}`), &qm); err != nil {
+		t.Fatal(err)
+	}
+	if int32(qm.Partition) != kafka_client.ALL_PARTITIONS {
+		t.Errorf("expected all partitions, got %v", qm.Partition)
+	}
+
+	if err := json.Unmarshal([]byte(`{"partition": 3}`), &qm); err != nil {
+		t.Fatal(err)
+	}
+	if qm.Partition != 3 {
+		t.Errorf("expected partition 3, got %v", qm.Partition)
+	}
+
+	if err := json.Unmarshal([]byte(`{"partition": "some"}`), &qm); err == nil {
+		t.Error("expected invalid partition to fail")
+	}
+}
+
+func TestStreamPath(t *testing.T) {
+	qm := queryModel{
+		Topic:          "my_topic.v1",
+		Partition:      partitionValue(kafka_client.ALL_PARTITIONS),
+		ThresholdRules: []thresholdRule{{Field: "temperature", Operator: ">", Value: "30"}},
+	}
+
+	path, err := streamPath(qm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := parseStreamPath(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(parsed, qm) {
+		t.Errorf("expected %+v, got %+v", qm, parsed)
+	}
+}
+
+func TestStreamPathRefID(t *testing.T) {
+	a, err := streamPath(queryModel{RefID: "A", Topic: "events"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := streamPath(queryModel{RefID: "B", Topic: "events"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a == b {
+		t.Error("expected queries of different refIds to get their own channel")
+	}
+}
+
+func TestQueryChannelRefID(t *testing.T) {
+	d := &KafkaDatasource{}
+	pCtx := backend.PluginContext{DataSourceInstanceSettings: &backend.DataSourceInstanceSettings{UID: "kafka"}}
+	response := d.query(context.Background(), pCtx, backend.DataQuery{
+		RefID: "B",
+		JSON:  []byte(`{"topicName": "events", "withStreaming": true}`),
+	}, true, nil)
+	if response.Error != nil {
+		t.Fatal(response.Error)
+	}
+
+	channel, err := live.ParseChannel(response.Frames[0].Meta.Channel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	qm, err := parseStreamPath(channel.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if qm.RefID != "B" {
+		t.Errorf("expected the channel of query B, got %q", qm.RefID)
+	}
+}
+
+func TestStreamPathTopicNames(t *testing.T) {
+	for _, topic := range []string{"orders.v2", "my_topic-1", "événements", "a b/c=d", "日本語"} {
+		t.Run(topic, func(t *testing.T) {
+			path, err := streamPath(queryModel{RefID: "A", Topic: topic})
+			if err != nil {
+				t.Fatal(err)
+			}
+			channel := live.Channel{Scope: live.ScopeDatasource, Namespace: "kafka", Path: path}
+			if !channel.IsValid() {
+				t.Errorf("expected a valid channel, got %q", channel.String())
+			}
+			parsed, err := live.ParseChannel(channel.String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			qm, err := parseStreamPath(parsed.Path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if qm.Topic != topic {
+				t.Errorf("expected topic %q, got %q", topic, qm.Topic)
+			}
+		})
+	}
+}
+
+func TestStreamPathLength(t *testing.T) {
+	var table strings.Builder
+	for i := 0; i < 50; i++ {
+		table.WriteString(fmt.Sprintf("%d,%s\n", i, queryHash([]byte{byte(i)})))
+	}
+	tests := []struct {
+		name   string
+		table  string
+		prefix string
+	}{
+		{"compressed", strings.Repeat("155,Hall A\n", 10), compressedStreamPrefix},
+		{"hashed", table.String(), streamQueriesPrefix},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			qm := queryModel{
+				RefID:       "A",
+				Topic:       "events",
+				LookupField: "sensor",
+				LookupTable: tt.table,
+			}
+			path, err := streamPath(qm)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(path) > maxStreamPathLength || !strings.HasPrefix(path, tt.prefix) {
+				t.Errorf("expected a path starting with %q for a large query, got %q", tt.prefix, path)
+			}
+			parsed, err := parseStreamPath(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(parsed, qm) {
+				t.Errorf("expected %+v, got %+v", qm, parsed)
+			}
+		})
+	}
+
+	if _, err := parseStreamPath(streamQueriesPrefix + "unknown"); err == nil {
+		t.Error("expected an unknown hashed path to fail")
+	}
+}
+
+func TestQueryConsumerGroup(t *testing.T) {
+	d := &KafkaDatasource{}
+	pCtx := backend.PluginContext{DataSourceInstanceSettings: &backend.DataSourceInstanceSettings{UID: "kafka"}}
+
+	response := d.query(context.Background(), pCtx, backend.DataQuery{
+		JSON: []byte(`{"topicName": "events", "partition": 0, "consumerGroup": "grafana"}`),
+	}, true, nil)
+	if response.Error == nil {
+		t.Error("expected an error for a consumer group of a single partition")
+	}
+
+	response = d.query(context.Background(), pCtx, backend.DataQuery{
+		JSON: []byte(`{"topicName": "events", "partition": "all", "consumerGroup": "grafana", "withStreaming": true}`),
+	}, true, nil)
+	if response.Error != nil {
+		t.Errorf("expected no error for all partitions, got %v", response.Error)
+	}
+}
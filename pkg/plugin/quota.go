@@ -0,0 +1,96 @@
+package plugin
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// consumptionQuota throttles the streams of the datasource, all together, to
+// a maximum rate of messages and bytes per second, so that dashboards can't
+// saturate the network of a shared cluster. It's a token bucket holding up to
+// a second of consumption, in which streams run into debt and wait it off,
+// each in turn. The zero value doesn't throttle.
+type consumptionQuota struct {
+	maxMessages float64
+	maxBytes    float64
+
+	mu       sync.Mutex
+	updated  time.Time
+	messages float64
+	bytes    float64
+}
+
+func newConsumptionQuota(settings datasourceSettings) *consumptionQuota {
+	return &consumptionQuota{
+		maxMessages: float64(settings.MaxMessagesPerSecond),
+		maxBytes:    float64(settings.MaxBytesPerSecond),
+	}
+}
+
+func (q *consumptionQuota) enabled() bool {
+	return q != nil && (q.maxMessages > 0 || q.maxBytes > 0)
+}
+
+// take accounts for a message of n bytes consumed at now, and returns how
+// long the stream has to wait before consuming the next one.
+func (q *consumptionQuota) take(now time.Time, n int) time.Duration {
+	if !q.enabled() {
+		return 0
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.updated.IsZero() {
+		q.messages, q.bytes = q.maxMessages, q.maxBytes
+	} else if elapsed := now.Sub(q.updated).Seconds(); elapsed > 0 {
+		q.messages = refill(q.messages, q.maxMessages, elapsed)
+		q.bytes = refill(q.bytes, q.maxBytes, elapsed)
+	}
+	if now.After(q.updated) {
+		q.updated = now
+	}
+
+	var wait float64
+	if q.maxMessages > 0 {
+		q.messages--
+		if q.messages < 0 {
+			wait = -q.messages / q.maxMessages
+		}
+	}
+	if q.maxBytes > 0 {
+		q.bytes -= float64(n)
+		if q.bytes < 0 && -q.bytes/q.maxBytes > wait {
+			wait = -q.bytes / q.maxBytes
+		}
+	}
+	return time.Duration(wait * float64(time.Second))
+}
+
+// refill returns the tokens of a bucket refilled at rate for the elapsed
+// seconds, up to a second worth of them.
+func refill(tokens, rate, elapsed float64) float64 {
+	tokens += rate * elapsed
+	if tokens > rate {
+		return rate
+	}
+	return tokens
+}
+
+// notice returns the notice of the streams throttled by the quota.
+func (q *consumptionQuota) notice() data.Notice {
+	var limits []string
+	if q.maxMessages > 0 {
+		limits = append(limits, fmt.Sprintf("%.0f msg/s", q.maxMessages))
+	}
+	if q.maxBytes > 0 {
+		limits = append(limits, fmt.Sprintf("%.0f B/s", q.maxBytes))
+	}
+	return data.Notice{
+		Severity: data.NoticeSeverityWarning,
+		Text:     fmt.Sprintf("Consumption throttled by the datasource quota of %s", strings.Join(limits, " and ")),
+	}
+}
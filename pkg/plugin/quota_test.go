@@ -0,0 +1,63 @@
+package plugin
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConsumptionQuotaMessages(t *testing.T) {
+	q := newConsumptionQuota(datasourceSettings{MaxMessagesPerSecond: 2})
+	now := time.Unix(0, 0)
+
+	// A second of messages goes through before streams wait.
+	for i := 0; i < 2; i++ {
+		if wait := q.take(now, 10); wait != 0 {
+			t.Fatalf("message %d: expected no wait, got %s", i, wait)
+		}
+	}
+	if wait := q.take(now, 10); wait != 500*time.Millisecond {
+		t.Errorf("expected a wait of 500ms, got %s", wait)
+	}
+	// Streams share the quota, taking turns.
+	if wait := q.take(now, 10); wait != time.Second {
+		t.Errorf("expected a wait of 1s, got %s", wait)
+	}
+	if wait := q.take(now.Add(2*time.Second), 10); wait != 0 {
+		t.Errorf("expected no wait once the debt is paid, got %s", wait)
+	}
+}
+
+func TestConsumptionQuotaBytes(t *testing.T) {
+	q := newConsumptionQuota(datasourceSettings{MaxBytesPerSecond: 100})
+	now := time.Unix(0, 0)
+
+	if wait := q.take(now, 100); wait != 0 {
+		t.Errorf("expected no wait, got %s", wait)
+	}
+	if wait := q.take(now, 50); wait != 500*time.Millisecond {
+		t.Errorf("expected a wait of 500ms, got %s", wait)
+	}
+	// The bucket never holds more than a second of consumption.
+	if wait := q.take(now.Add(time.Hour), 150); wait != 500*time.Millisecond {
+		t.Errorf("expected a wait of 500ms, got %s", wait)
+	}
+}
+
+func TestConsumptionQuotaDisabled(t *testing.T) {
+	var q *consumptionQuota
+	if wait := q.take(time.Unix(0, 0), 1<<30); wait != 0 {
+		t.Errorf("expected no wait without a quota, got %s", wait)
+	}
+	q = newConsumptionQuota(datasourceSettings{})
+	if wait := q.take(time.Unix(0, 0), 1<<30); wait != 0 {
+		t.Errorf("expected no wait without limits, got %s", wait)
+	}
+}
+
+func TestConsumptionQuotaNotice(t *testing.T) {
+	q := newConsumptionQuota(datasourceSettings{MaxMessagesPerSecond: 100, MaxBytesPerSecond: 1048576})
+	expected := "Consumption throttled by the datasource quota of 100 msg/s and 1048576 B/s"
+	if got := q.notice().Text; got != expected {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+}
@@ -0,0 +1,148 @@
+package plugin
+
+import (
+	"container/heap"
+	"fmt"
+	"time"
+
+	"github.com/hoptical/grafana-kafka-datasource/pkg/kafka_client"
+)
+
+// reorderBuffer merges the messages of several partitions in timestamp order.
+// Messages are held until the watermark, the latest timestamp seen minus the
+// delay, passes them, and never longer than the delay, so that a quiet
+// partition doesn't hold back the others. They are also released early when
+// the instance buffers too many bytes.
+type reorderBuffer struct {
+	delay   time.Duration
+	usage   *bufferUsage
+	pending pendingHeap
+	// arrivals holds the pending messages in arrival order, to release the
+	// ones held for the delay.
+	arrivals  []*pendingMessage
+	watermark time.Time
+}
+
+type pendingMessage struct {
+	msg      kafka_client.KafkaMessage
+	arrival  time.Time
+	released bool
+}
+
+func newReorderBuffer(qm queryModel, usage *bufferUsage) (*reorderBuffer, error) {
+	if qm.ReorderDelay == "" {
+		return nil, nil
+	}
+	delay, err := time.ParseDuration(qm.ReorderDelay)
+	if err != nil || delay <= 0 {
+		return nil, fmt.Errorf("invalid reorder delay %q", qm.ReorderDelay)
+	}
+	return &reorderBuffer{delay: delay, usage: usage}, nil
+}
+
+func (b *reorderBuffer) push(now time.Time, msg kafka_client.KafkaMessage) {
+	p := &pendingMessage{msg: msg, arrival: now}
+	heap.Push(&b.pending, p)
+	b.arrivals = append(b.arrivals, p)
+	b.usage.add(int64(msg.Size))
+	if msg.Timestamp.After(b.watermark) {
+		b.watermark = msg.Timestamp
+	}
+}
+
+// pop returns the messages due, in timestamp order.
+func (b *reorderBuffer) pop(now time.Time) []kafka_client.KafkaMessage {
+	var messages []kafka_client.KafkaMessage
+	for b.pending.Len() > 0 {
+		for len(b.arrivals) > 0 && b.arrivals[0].released {
+			b.arrivals[0] = nil
+			b.arrivals = b.arrivals[1:]
+		}
+		next := b.pending[0]
+		if next.msg.Timestamp.After(b.watermark.Add(-b.delay)) && now.Sub(b.arrivals[0].arrival) < b.delay &&
+			!b.usage.over() {
+			break
+		}
+		heap.Pop(&b.pending)
+		next.released = true
+		b.usage.add(-int64(next.msg.Size))
+		messages = append(messages, next.msg)
+	}
+	if b.pending.Len() == 0 {
+		b.arrivals = nil
+	}
+	return messages
+}
+
+const (
+	latePolicyDrop = "drop"
+	latePolicyEmit = "emit"
+	latePolicyTag  = "tag"
+)
+
+// latenessTracker tells the messages whose timestamp is behind the latest one
+// seen by more than the maximum lateness.
+type latenessTracker struct {
+	maxLateness time.Duration
+	latest      time.Time
+}
+
+func newLatenessTracker(qm queryModel) (*latenessTracker, error) {
+	switch qm.LatePolicy {
+	case "", latePolicyDrop, latePolicyEmit, latePolicyTag:
+	default:
+		return nil, fmt.Errorf("invalid late policy %q", qm.LatePolicy)
+	}
+	if qm.MaxLateness == "" {
+		return nil, nil
+	}
+	maxLateness, err := time.ParseDuration(qm.MaxLateness)
+	if err != nil || maxLateness < 0 {
+		return nil, fmt.Errorf("invalid max lateness %q", qm.MaxLateness)
+	}
+	return &latenessTracker{maxLateness: maxLateness}, nil
+}
+
+func (t *latenessTracker) late(msg kafka_client.KafkaMessage) bool {
+	if msg.Timestamp.After(t.latest) {
+		t.latest = msg.Timestamp
+		return false
+	}
+	return t.latest.Sub(msg.Timestamp) > t.maxLateness
+}
+
+// discard drops the pending messages, e.g. when the stream stops.
+func (b *reorderBuffer) discard() {
+	for _, p := range b.pending {
+		b.usage.add(-int64(p.msg.Size))
+	}
+	b.pending = nil
+	b.arrivals = nil
+}
+
+type pendingHeap []*pendingMessage
+
+func (h pendingHeap) Len() int { return len(h) }
+
+func (h pendingHeap) Less(i, j int) bool {
+	a, b := h[i].msg, h[j].msg
+	switch {
+	case !a.Timestamp.Equal(b.Timestamp):
+		return a.Timestamp.Before(b.Timestamp)
+	case a.Partition != b.Partition:
+		return a.Partition < b.Partition
+	}
+	return a.Offset < b.Offset
+}
+
+func (h pendingHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *pendingHeap) Push(x interface{}) { *h = append(*h, x.(*pendingMessage)) }
+
+func (h *pendingHeap) Pop() interface{} {
+	old := *h
+	p := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return p
+}
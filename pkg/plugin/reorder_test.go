@@ -0,0 +1,89 @@
+package plugin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+
+	"github.com/hoptical/grafana-kafka-datasource/pkg/kafka_client"
+)
+
+func TestReorderBuffer(t *testing.T) {
+	b, err := newReorderBuffer(queryModel{ReorderDelay: "1s"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Unix(1000, 0)
+	message := func(partition int32, offset int, ms int) kafka_client.KafkaMessage {
+		return kafka_client.KafkaMessage{
+			Partition: partition,
+			Offset:    kafka.Offset(offset),
+			Timestamp: start.Add(time.Duration(ms) * time.Millisecond),
+		}
+	}
+
+	b.push(start, message(0, 1, 500))
+	b.push(start, message(1, 1, 200))
+	b.push(start, message(1, 2, 900))
+	if messages := b.pop(start); len(messages) != 0 {
+		t.Fatalf("expected messages within the delay to be held, got %v", messages)
+	}
+
+	// The watermark passes the first two messages.
+	b.push(start, message(0, 2, 1600))
+	messages := b.pop(start)
+	if len(messages) != 2 || messages[0].Partition != 1 || messages[1].Partition != 0 {
+		t.Fatalf("expected the messages before the watermark in timestamp order, got %v", messages)
+	}
+
+	// The other ones are released once held for the delay.
+	messages = b.pop(start.Add(time.Second))
+	if len(messages) != 2 || messages[0].Offset != 2 || messages[1].Timestamp != start.Add(1600*time.Millisecond) {
+		t.Fatalf("expected the messages held for the delay, got %v", messages)
+	}
+	if b.pending.Len() != 0 || b.arrivals != nil {
+		t.Error("expected the buffer to be empty")
+	}
+}
+
+func TestNewReorderBuffer(t *testing.T) {
+	if b, err := newReorderBuffer(queryModel{}, nil); b != nil || err != nil {
+		t.Errorf("expected no buffer by default, got %v, %v", b, err)
+	}
+	if _, err := newReorderBuffer(queryModel{ReorderDelay: "-1s"}, nil); err == nil {
+		t.Error("expected an error for a negative delay")
+	}
+}
+
+func TestLatenessTracker(t *testing.T) {
+	tracker, err := newLatenessTracker(queryModel{MaxLateness: "1s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Unix(1000, 0)
+
+	tests := []struct {
+		offset time.Duration
+		late   bool
+	}{
+		{0, false},
+		{2 * time.Second, false},
+		{1500 * time.Millisecond, false},
+		{500 * time.Millisecond, true},
+		{time.Second, false},
+	}
+	for _, tt := range tests {
+		msg := kafka_client.KafkaMessage{Timestamp: start.Add(tt.offset)}
+		if late := tracker.late(msg); late != tt.late {
+			t.Errorf("expected message at %s to be late %v", tt.offset, tt.late)
+		}
+	}
+
+	if tracker, err := newLatenessTracker(queryModel{}); tracker != nil || err != nil {
+		t.Errorf("expected no tracker by default, got %v, %v", tracker, err)
+	}
+	if _, err := newLatenessTracker(queryModel{LatePolicy: "ignore"}); err == nil {
+		t.Error("expected an error for an invalid late policy")
+	}
+}
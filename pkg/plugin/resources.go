@@ -0,0 +1,510 @@
+package plugin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/resource/httpadapter"
+
+	"github.com/hoptical/grafana-kafka-datasource/pkg/kafka_client"
+)
+
+func (d *KafkaDatasource) newResourceMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/message", d.handleMessage)
+	mux.HandleFunc("/fields", d.handleFields)
+	mux.HandleFunc("/active-streams", d.handleActiveStreams)
+	mux.HandleFunc("/consumer-lag", d.handleConsumerLag)
+	mux.HandleFunc("/infer-schema", d.handleInferSchema)
+	mux.HandleFunc("/cluster", d.handleCluster)
+	mux.HandleFunc("/topic-config", d.handleTopicConfig)
+	mux.HandleFunc("/offsets", d.handleOffsets)
+	mux.HandleFunc("/sample", d.handleSample)
+	mux.HandleFunc("/discover-fields", d.handleDiscoverFields)
+	mux.HandleFunc("/produce", d.handleProduce)
+	return mux
+}
+
+type messageResponse struct {
+	Topic     string    `json:"topic"`
+	Partition int32     `json:"partition"`
+	Offset    int64     `json:"offset"`
+	Timestamp time.Time `json:"timestamp"`
+	Key       []byte    `json:"key"`
+	Value     []byte    `json:"value"`
+	// Decoded is the decoded object, or the items of a top-level array.
+	Decoded interface{} `json:"decoded,omitempty"`
+	Error   string      `json:"error,omitempty"`
+}
+
+// handleMessage returns the raw key and value of a single message, base64
+// encoded, along with its decoded value, so that users can inspect the record
+// behind a data point.
+func (d *KafkaDatasource) handleMessage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+
+	topic, msg, ok := d.readRequestedMessage(w, r)
+	if !ok {
+		return
+	}
+
+	response := messageResponse{
+		Topic:     topic,
+		Partition: msg.Partition,
+		Offset:    int64(msg.Offset),
+		Timestamp: msg.Timestamp,
+		Key:       msg.Key,
+		Value:     msg.RawValue,
+	}
+	if msg.Items != nil {
+		response.Decoded = msg.Items
+	} else if msg.Value != nil {
+		response.Decoded = msg.Value
+	}
+	if msg.Err != nil {
+		response.Error = msg.Err.Error()
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// handleFields returns the flattened fields of a single message, named as in
+// the frames of streams, e.g. to drill down into a summarized message.
+func (d *KafkaDatasource) handleFields(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+
+	_, msg, ok := d.readRequestedMessage(w, r)
+	if !ok {
+		return
+	}
+	if msg.Err != nil {
+		writeError(w, http.StatusUnprocessableEntity, msg.Err)
+		return
+	}
+
+	itemKeys, err := parseItemKeyTemplate(r.URL.Query().Get("itemKeyTemplate"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	fields := messageFields(msg, itemKeys, r.URL.Query().Get("emptyKeyName"))
+	writeJSON(w, http.StatusOK, map[string]interface{}{"fields": fields})
+}
+
+// messageFields returns the flattened fields of a message, named as in the
+// frames of streams. Top-level arrays are flattened as their items are in the
+// fields output mode.
+func messageFields(msg kafka_client.KafkaMessage, itemKeys string, emptyKeyName string) map[string]interface{} {
+	msg = messageFramer{itemKeys: itemKeys}.items(msg)[0]
+	fields := make(map[string]interface{})
+	for _, f := range flattenMessage(msg.Value) {
+		fields[strings.Join(normalizePath(f.path, emptyKeyName), ".")] = f.value
+	}
+	return fields
+}
+
+// defaultPreviewMessages and maxPreviewMessages are the default and maximum
+// numbers of messages previewed by the sample resource.
+const (
+	defaultPreviewMessages = 5
+	maxPreviewMessages     = 100
+)
+
+type sampleMessage struct {
+	Partition int32                  `json:"partition"`
+	Offset    int64                  `json:"offset"`
+	Timestamp time.Time              `json:"timestamp"`
+	Fields    map[string]interface{} `json:"fields,omitempty"`
+	Error     string                 `json:"error,omitempty"`
+}
+
+// handleSample returns the flattened fields of a handful of the latest
+// messages of a topic, named as in the frames of streams, so that users can
+// preview what a query would show before running it.
+func (d *KafkaDatasource) handleSample(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+	query := r.URL.Query()
+	itemKeys, err := parseItemKeyTemplate(query.Get("itemKeyTemplate"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	topic, messages, ok := d.readRequestedSample(w, r, defaultPreviewMessages, maxPreviewMessages)
+	if !ok {
+		return
+	}
+
+	emptyKeyName := query.Get("emptyKeyName")
+	sample := make([]sampleMessage, 0, len(messages))
+	for _, msg := range messages {
+		m := sampleMessage{Partition: msg.Partition, Offset: int64(msg.Offset), Timestamp: msg.Timestamp}
+		if msg.Err != nil {
+			m.Error = msg.Err.Error()
+		} else {
+			m.Fields = messageFields(msg, itemKeys, emptyKeyName)
+		}
+		sample = append(sample, m)
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"topic": topic, "messages": sample})
+}
+
+// readRequestedSample reads the latest messages designated by the topic,
+// partition and n parameters of the request, newest first, decoded as the
+// messageFormat and rawPattern parameters tell, or writes the error response.
+func (d *KafkaDatasource) readRequestedSample(w http.ResponseWriter, r *http.Request,
+	defaultN, maxN int) (string, []kafka_client.KafkaMessage, bool) {
+	query := r.URL.Query()
+	topic, ok := requestedTopic(w, query)
+	if !ok {
+		return "", nil, false
+	}
+	partition := kafka_client.ALL_PARTITIONS
+	if value := query.Get("partition"); value != "" && value != "all" {
+		p, err := strconv.ParseInt(value, 10, 32)
+		if err != nil || p < 0 {
+			writeError(w, http.StatusBadRequest, errors.New("partition must be a number or all"))
+			return "", nil, false
+		}
+		partition = int32(p)
+	}
+	n := defaultN
+	if value := query.Get("n"); value != "" {
+		var err error
+		if n, err = strconv.Atoi(value); err != nil || n <= 0 || n > maxN {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("n must be a number between 1 and %d", maxN))
+			return "", nil, false
+		}
+	}
+
+	client := d.client
+	client.MessageFormat = query.Get("messageFormat")
+	if err := kafka_client.ValidateMessageFormat(client.MessageFormat); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return "", nil, false
+	}
+	var err error
+	if client.RawPattern, err = kafka_client.CompileRawPattern(query.Get("rawPattern")); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return "", nil, false
+	}
+
+	messages, err := client.SampleMessages(r.Context(), topic, partition, n)
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return "", nil, false
+	}
+	// Partitions share the sample evenly, which can make it exceed n.
+	sort.Slice(messages, func(i, j int) bool { return messages[i].Timestamp.After(messages[j].Timestamp) })
+	if len(messages) > n {
+		messages = messages[:n]
+	}
+	return topic, messages, true
+}
+
+// handleActiveStreams lists the streams running on the datasource instance,
+// e.g. to find out which dashboards are behind the consumers of a topic. It's
+// restricted to admins, as it reveals the queries of every dashboard.
+func (d *KafkaDatasource) handleActiveStreams(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+	if user := httpadapter.UserFromContext(r.Context()); user == nil || user.Role != "Admin" {
+		writeError(w, http.StatusForbidden, errors.New("only admins can list active streams"))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"streams": d.streams.list(d.clock.Now())})
+}
+
+// handleConsumerLag returns the lag of the consumer group designated by the
+// group parameter on every partition of the topic, along with their total,
+// e.g. for lag panels.
+func (d *KafkaDatasource) handleConsumerLag(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+
+	query := r.URL.Query()
+	group := query.Get("group")
+	if group == "" {
+		writeError(w, http.StatusBadRequest, errors.New("group is required"))
+		return
+	}
+	topic, ok := requestedTopic(w, query)
+	if !ok {
+		return
+	}
+
+	partitions, err := d.client.ConsumerLag(r.Context(), group, topic)
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+	var total int64
+	for _, p := range partitions {
+		total += p.Lag
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"group":      group,
+		"topic":      topic,
+		"partitions": partitions,
+		"lag":        total,
+	})
+}
+
+// handleCluster returns the brokers of the cluster, e.g. for cluster overview
+// dashboards and to show the connection details in the config editor.
+func (d *KafkaDatasource) handleCluster(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+
+	cluster, err := d.client.ClusterMetadata(r.Context())
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, cluster)
+}
+
+// handleTopicConfig returns the partitions, replicas and configs of the
+// topic, e.g. for the query editor to warn about compacted topics or short
+// retention.
+func (d *KafkaDatasource) handleTopicConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+	topic, ok := requestedTopic(w, r.URL.Query())
+	if !ok {
+		return
+	}
+
+	config, err := d.client.TopicConfig(r.Context(), topic)
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, config)
+}
+
+// handleOffsets returns the earliest and latest offsets of every partition of
+// the topic, along with the number of messages they bound, e.g. to tell how
+// much data a query would read. With a timestamp parameter, in milliseconds
+// since the epoch, the offset of the first message at or after it is returned
+// too.
+func (d *KafkaDatasource) handleOffsets(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+	query := r.URL.Query()
+	topic, ok := requestedTopic(w, query)
+	if !ok {
+		return
+	}
+	var timestamp time.Time
+	if value := query.Get("timestamp"); value != "" {
+		ms, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || ms < 0 {
+			writeError(w, http.StatusBadRequest, errors.New("timestamp must be a number of milliseconds since the epoch"))
+			return
+		}
+		timestamp = time.Unix(0, ms*int64(time.Millisecond))
+	}
+
+	partitions, err := d.client.PartitionOffsets(r.Context(), topic, timestamp)
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+	var messages int64
+	for _, p := range partitions {
+		messages += p.Latest - p.Earliest
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"topic":      topic,
+		"partitions": partitions,
+		"messages":   messages,
+	})
+}
+
+// requestedTopic returns the topic parameter of the request, or writes the
+// error response.
+func requestedTopic(w http.ResponseWriter, query url.Values) (string, bool) {
+	topic := query.Get("topic")
+	if topic == "" {
+		writeError(w, http.StatusBadRequest, errors.New("topic is required"))
+		return "", false
+	}
+	// Responses are JSON, which can't carry other names unchanged.
+	if !utf8.ValidString(topic) {
+		writeError(w, http.StatusBadRequest, errors.New("topic must be valid UTF-8"))
+		return "", false
+	}
+	return topic, true
+}
+
+// readRequestedMessage reads the message designated by the topic, partition
+// and offset parameters of the request, or writes the error response.
+func (d *KafkaDatasource) readRequestedMessage(w http.ResponseWriter,
+	r *http.Request) (string, kafka_client.KafkaMessage, bool) {
+	query := r.URL.Query()
+	topic, ok := requestedTopic(w, query)
+	if !ok {
+		return "", kafka_client.KafkaMessage{}, false
+	}
+	partition, err := strconv.ParseInt(query.Get("partition"), 10, 32)
+	if err != nil || partition < 0 {
+		writeError(w, http.StatusBadRequest, errors.New("partition must be a non-negative number"))
+		return "", kafka_client.KafkaMessage{}, false
+	}
+	offset, err := strconv.ParseInt(query.Get("offset"), 10, 64)
+	if err != nil || offset < 0 {
+		writeError(w, http.StatusBadRequest, errors.New("offset must be a non-negative number"))
+		return "", kafka_client.KafkaMessage{}, false
+	}
+
+	client := d.client
+	client.MessageFormat = query.Get("messageFormat")
+	if err := kafka_client.ValidateMessageFormat(client.MessageFormat); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return "", kafka_client.KafkaMessage{}, false
+	}
+	if client.RawPattern, err = kafka_client.CompileRawPattern(query.Get("rawPattern")); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return "", kafka_client.KafkaMessage{}, false
+	}
+
+	msg, err := client.ReadMessage(r.Context(), topic, int32(partition), offset)
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return "", kafka_client.KafkaMessage{}, false
+	}
+	return topic, msg, true
+}
+
+// errorStatus maps client errors to the HTTP status of resource responses.
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, kafka_client.ErrBrokerUnreachable):
+		return http.StatusBadGateway
+	case errors.Is(err, kafka_client.ErrMessageNotFound), errors.Is(err, kafka_client.ErrTopicNotFound):
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.DefaultLogger.Error("Error writing resource response", "error", err)
+	}
+}
+
+// Codes of resource errors, which clients can rely on unlike messages.
+const (
+	errorCodeInvalidRequest    = "invalid_request"
+	errorCodeMethodNotAllowed  = "method_not_allowed"
+	errorCodeForbidden         = "forbidden"
+	errorCodeTopicNotFound     = "topic_not_found"
+	errorCodeMessageNotFound   = "message_not_found"
+	errorCodeNotFound          = "not_found"
+	errorCodeUndecodable       = "undecodable_message"
+	errorCodeBrokerUnreachable = "broker_unreachable"
+	errorCodeKafka             = "kafka_error"
+	errorCodeInternal          = "internal_error"
+)
+
+// resourceError is the body of the error responses of resources.
+type resourceError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// Details are specific to the code, e.g. the suggested topics of a
+	// missing topic or the code of errors returned by the brokers.
+	Details map[string]interface{} `json:"details,omitempty"`
+	// Retryable tells whether the same request may succeed later.
+	Retryable bool `json:"retryable"`
+	// Error repeats the message for clients predating the code.
+	Error string `json:"error"`
+}
+
+// newResourceError returns the body of the error response of the status.
+func newResourceError(status int, err error) resourceError {
+	e := resourceError{Message: err.Error(), Error: err.Error()}
+	details := make(map[string]interface{})
+
+	var topicErr *kafka_client.TopicNotFoundError
+	var partitionErrs *kafka_client.PartitionErrors
+	switch {
+	case status == http.StatusBadRequest:
+		e.Code = errorCodeInvalidRequest
+	case status == http.StatusMethodNotAllowed:
+		e.Code = errorCodeMethodNotAllowed
+	case status == http.StatusForbidden:
+		e.Code = errorCodeForbidden
+	case status == http.StatusUnprocessableEntity:
+		e.Code = errorCodeUndecodable
+	case errors.As(err, &topicErr):
+		e.Code = errorCodeTopicNotFound
+		details["topic"] = topicErr.Topic
+		if len(topicErr.Suggestions) > 0 {
+			details["suggestions"] = topicErr.Suggestions
+		}
+	case errors.Is(err, kafka_client.ErrTopicNotFound):
+		e.Code = errorCodeTopicNotFound
+	case errors.Is(err, kafka_client.ErrMessageNotFound):
+		e.Code = errorCodeMessageNotFound
+	case status == http.StatusNotFound:
+		e.Code = errorCodeNotFound
+	case errors.Is(err, kafka_client.ErrBrokerUnreachable):
+		e.Code = errorCodeBrokerUnreachable
+		e.Retryable = true
+	default:
+		e.Code = errorCodeInternal
+	}
+
+	if errors.As(err, &partitionErrs) {
+		details["failedPartitions"] = partitionErrs.Failed()
+	}
+	var kafkaErr kafka.Error
+	if errors.As(err, &kafkaErr) && kafkaErr.Code() != kafka.ErrNoError {
+		if e.Code == errorCodeInternal {
+			e.Code = errorCodeKafka
+		}
+		details["kafkaCode"] = int(kafkaErr.Code())
+		details["kafkaError"] = kafkaErr.Code().String()
+		e.Retryable = e.Retryable || kafkaErr.IsRetriable()
+	}
+	if len(details) > 0 {
+		e.Details = details
+	}
+	return e
+}
+
+// writeError writes the error response of the status.
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, newResourceError(status, err))
+}
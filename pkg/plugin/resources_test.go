@@ -0,0 +1,132 @@
+package plugin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+
+	"github.com/hoptical/grafana-kafka-datasource/pkg/kafka_client"
+)
+
+func TestHandleMessageValidation(t *testing.T) {
+	d := &KafkaDatasource{}
+	mux := d.newResourceMux()
+
+	tests := []struct {
+		name   string
+		method string
+		url    string
+		status int
+	}{
+		{"wrong method", http.MethodPost, "/message?topic=t&partition=0&offset=1", http.StatusMethodNotAllowed},
+		{"missing topic", http.MethodGet, "/message?partition=0&offset=1", http.StatusBadRequest},
+		{"invalid partition", http.MethodGet, "/message?topic=t&partition=x&offset=1", http.StatusBadRequest},
+		{"negative partition", http.MethodGet, "/message?topic=t&partition=-1&offset=1", http.StatusBadRequest},
+		{"invalid topic", http.MethodGet, "/message?topic=%ff&partition=0&offset=1", http.StatusBadRequest},
+		{"negative offset", http.MethodGet, "/message?topic=t&partition=0&offset=-1", http.StatusBadRequest},
+		{"fields wrong method", http.MethodPost, "/fields?topic=t&partition=0&offset=1", http.StatusMethodNotAllowed},
+		{"fields missing topic", http.MethodGet, "/fields?partition=0&offset=1", http.StatusBadRequest},
+		{"fields negative partition", http.MethodGet, "/fields?topic=t&partition=-1&offset=1", http.StatusBadRequest},
+		{"lag wrong method", http.MethodPost, "/consumer-lag?group=g&topic=t", http.StatusMethodNotAllowed},
+		{"lag missing group", http.MethodGet, "/consumer-lag?topic=t", http.StatusBadRequest},
+		{"lag missing topic", http.MethodGet, "/consumer-lag?group=g", http.StatusBadRequest},
+		{"lag invalid topic", http.MethodGet, "/consumer-lag?group=g&topic=%ff", http.StatusBadRequest},
+		{"cluster wrong method", http.MethodPost, "/cluster", http.StatusMethodNotAllowed},
+		{"topic config wrong method", http.MethodPost, "/topic-config?topic=t", http.StatusMethodNotAllowed},
+		{"topic config missing topic", http.MethodGet, "/topic-config", http.StatusBadRequest},
+		{"offsets wrong method", http.MethodPost, "/offsets?topic=t", http.StatusMethodNotAllowed},
+		{"offsets missing topic", http.MethodGet, "/offsets", http.StatusBadRequest},
+		{"offsets invalid timestamp", http.MethodGet, "/offsets?topic=t&timestamp=yesterday", http.StatusBadRequest},
+		{"sample wrong method", http.MethodPost, "/sample?topic=t", http.StatusMethodNotAllowed},
+		{"sample missing topic", http.MethodGet, "/sample?n=5", http.StatusBadRequest},
+		{"sample invalid partition", http.MethodGet, "/sample?topic=t&partition=-1", http.StatusBadRequest},
+		{"sample too many messages", http.MethodGet, "/sample?topic=t&n=1000", http.StatusBadRequest},
+		{"sample unknown format", http.MethodGet, "/sample?topic=t&messageFormat=xml", http.StatusBadRequest},
+		{"sample invalid item key template", http.MethodGet, "/sample?topic=t&itemKeyTemplate=item", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequest(tt.method, tt.url, nil))
+			if w.Code != tt.status {
+				t.Errorf("expected status %d, got %d: %s", tt.status, w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestMessageFields(t *testing.T) {
+	msg := kafka_client.KafkaMessage{Items: []interface{}{
+		map[string]interface{}{"id": 1.0, "": "x"},
+		2.0,
+	}}
+	expected := map[string]interface{}{"item_00.id": 1.0, "item_00._": "x", "item_01": 2.0}
+	if got := messageFields(msg, "item_%02d", "_"); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
+
+func TestWriteErrorSuggestions(t *testing.T) {
+	w := httptest.NewRecorder()
+	err := &kafka_client.TopicNotFoundError{Topic: "order", Suggestions: []string{"orders"}}
+	writeError(w, errorStatus(err), err)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status %d, got %d", http.StatusNotFound, w.Code)
+	}
+	var response struct {
+		Code    string `json:"code"`
+		Details struct {
+			Suggestions []string `json:"suggestions"`
+		} `json:"details"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Code != errorCodeTopicNotFound {
+		t.Errorf("expected code %s, got %s", errorCodeTopicNotFound, response.Code)
+	}
+	if len(response.Details.Suggestions) != 1 || response.Details.Suggestions[0] != "orders" {
+		t.Errorf("expected the suggested topics, got %s", w.Body.String())
+	}
+}
+
+func TestNewResourceError(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		err       error
+		code      string
+		retryable bool
+		details   map[string]interface{}
+	}{
+		{"invalid request", http.StatusBadRequest, errors.New("topic is required"), errorCodeInvalidRequest, false, nil},
+		{"missing message", http.StatusNotFound, kafka_client.ErrMessageNotFound, errorCodeMessageNotFound, false, nil},
+		{"broker unreachable", http.StatusBadGateway,
+			fmt.Errorf("%w: connection refused", kafka_client.ErrBrokerUnreachable), errorCodeBrokerUnreachable, true, nil},
+		{"kafka error", http.StatusInternalServerError,
+			kafka.NewError(kafka.ErrTopicAuthorizationFailed, "not authorized", false), errorCodeKafka, false,
+			map[string]interface{}{"kafkaCode": int(kafka.ErrTopicAuthorizationFailed), "kafkaError": kafka.ErrTopicAuthorizationFailed.String()}},
+		{"partition errors", http.StatusInternalServerError,
+			&kafka_client.PartitionErrors{Topic: "t", Errors: []kafka_client.PartitionError{{Partition: 2, Err: errors.New("x")}}},
+			errorCodeInternal, false, map[string]interface{}{"failedPartitions": []int32{2}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newResourceError(tt.status, tt.err)
+			if e.Code != tt.code || e.Retryable != tt.retryable || e.Message != tt.err.Error() {
+				t.Errorf("unexpected error %+v", e)
+			}
+			if !reflect.DeepEqual(e.Details, tt.details) {
+				t.Errorf("expected details %v, got %v", tt.details, e.Details)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package plugin
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// ShutdownTimeout bounds how long Shutdown waits for the streams to return,
+// short of the delay after which Grafana kills plugins that don't exit.
+const ShutdownTimeout = time.Second
+
+// instanceSet holds the datasource instances that weren't disposed, so that
+// they can be stopped when the plugin exits.
+type instanceSet struct {
+	mu        sync.Mutex
+	instances map[*KafkaDatasource]struct{}
+}
+
+var instances instanceSet
+
+func (s *instanceSet) add(d *KafkaDatasource) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.instances == nil {
+		s.instances = make(map[*KafkaDatasource]struct{})
+	}
+	s.instances[d] = struct{}{}
+}
+
+func (s *instanceSet) remove(d *KafkaDatasource) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.instances, d)
+}
+
+// drain removes and returns all the instances.
+func (s *instanceSet) drain() []*KafkaDatasource {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	drained := make([]*KafkaDatasource, 0, len(s.instances))
+	for d := range s.instances {
+		drained = append(drained, d)
+	}
+	s.instances = nil
+	return drained
+}
+
+// Shutdown stops every stream of the datasource instances as the plugin
+// exits, so that consumers are closed, consumer groups commit their offsets
+// and queued frames are sent, rather than the process being killed with
+// them. It waits up to timeout for the streams to return.
+func Shutdown(timeout time.Duration) {
+	drained := instances.drain()
+	streams := 0
+	for _, d := range drained {
+		streams += len(d.streams.list(d.clock.Now()))
+		d.stop()
+		d.cancel()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for _, d := range drained {
+			d.running.Wait()
+		}
+	}()
+	select {
+	case <-done:
+		log.DefaultLogger.Info("Plugin shut down", "instances", len(drained), "streams", streams)
+	case <-time.After(timeout):
+		log.DefaultLogger.Warn("Plugin shut down before every stream returned", "instances", len(drained),
+			"streams", streams, "timeout", timeout)
+	}
+}
+
+// stop tells the streams of the instance to return, once, whether it's
+// disposed or the plugin exits.
+func (d *KafkaDatasource) stop() {
+	d.stopOnce.Do(func() {
+		close(d.disposed)
+	})
+}
+
+// streamContext returns the context of a stream, which is also canceled when
+// the plugin exits, and the function to call once the stream returns.
+func (d *KafkaDatasource) streamContext(ctx context.Context) (context.Context, func()) {
+	d.running.Add(1)
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-d.ctx.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, func() {
+		cancel()
+		d.running.Done()
+	}
+}
@@ -0,0 +1,84 @@
+package plugin
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+func newStoppableDatasource() *KafkaDatasource {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &KafkaDatasource{clock: realClock{}, disposed: make(chan struct{}), ctx: ctx, cancel: cancel}
+}
+
+func TestShutdown(t *testing.T) {
+	d := newStoppableDatasource()
+	instances.add(d)
+
+	ctx, done := d.streamContext(context.Background())
+	returned := make(chan struct{})
+	go func() {
+		defer close(returned)
+		<-ctx.Done()
+		done()
+	}()
+
+	Shutdown(time.Second)
+	select {
+	case <-returned:
+	default:
+		t.Fatal("expected Shutdown to wait for the stream to return")
+	}
+	select {
+	case <-d.disposed:
+	default:
+		t.Error("expected the instance to be stopped")
+	}
+	if len(instances.drain()) != 0 {
+		t.Error("expected no instance left")
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	d := newStoppableDatasource()
+	instances.add(d)
+	_, done := d.streamContext(context.Background())
+	defer done()
+
+	start := time.Now()
+	Shutdown(10 * time.Millisecond)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected Shutdown to give up after its timeout, took %s", elapsed)
+	}
+}
+
+func TestDisposeThenShutdown(t *testing.T) {
+	d := newStoppableDatasource()
+	instances.add(d)
+	d.Dispose()
+	// Disposed instances are left to their streams.
+	Shutdown(time.Second)
+	if d.ctx.Err() != nil {
+		t.Error("expected the disposed instance to be left alone")
+	}
+}
+
+func TestFrameQueueFlush(t *testing.T) {
+	queue := newFrameQueue(dropPolicyNewest, realClock{}, nil)
+	queue.push(data.NewFrame("a"))
+	queue.push(data.NewFrame("b"))
+
+	var names []string
+	sent := queue.flush(func(frame *data.Frame) error {
+		names = append(names, frame.Name)
+		return nil
+	})
+	if sent != 2 || len(names) != 2 || names[0] != "a" || names[1] != "b" {
+		t.Errorf("expected both frames in order, got %v", names)
+	}
+	if sent := queue.flush(func(*data.Frame) error { return nil }); sent != 0 {
+		t.Errorf("expected an empty queue, got %d frames", sent)
+	}
+}
@@ -0,0 +1,55 @@
+package plugin
+
+import (
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// throughputWindow is the number of seconds the rolling throughput of a stream
+// is computed over.
+const throughputWindow = 10
+
+type throughputBucket struct {
+	second   int64
+	messages int
+	bytes    int
+}
+
+// throughputTracker computes rolling message and byte rates of a stream using
+// one bucket per second.
+type throughputTracker struct {
+	buckets [throughputWindow]throughputBucket
+}
+
+func (t *throughputTracker) add(now time.Time, bytes int) {
+	second := now.Unix()
+	bucket := &t.buckets[second%throughputWindow]
+	if bucket.second != second {
+		*bucket = throughputBucket{second: second}
+	}
+	bucket.messages++
+	bucket.bytes += bytes
+}
+
+// rates returns the messages and bytes per second over the last
+// throughputWindow seconds.
+func (t *throughputTracker) rates(now time.Time) (float64, float64) {
+	var messages, bytes int
+	oldest := now.Unix() - throughputWindow
+	for _, bucket := range t.buckets {
+		if bucket.second > oldest {
+			messages += bucket.messages
+			bytes += bucket.bytes
+		}
+	}
+	return float64(messages) / throughputWindow, float64(bytes) / throughputWindow
+}
+
+func (t *throughputTracker) stats(now time.Time) []data.QueryStat {
+	messages, bytes := t.rates(now)
+	return []data.QueryStat{
+		{FieldConfig: data.FieldConfig{DisplayName: "Messages per second", Unit: "cps"}, Value: messages},
+		{FieldConfig: data.FieldConfig{DisplayName: "Bytes per second", Unit: "Bps"}, Value: bytes},
+	}
+}
@@ -0,0 +1,108 @@
+package plugin
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/base64"
+	"io/ioutil"
+	"sync"
+)
+
+// maxStreamQueries bounds the queries of hashed stream paths held at once,
+// the least recently used being evicted beyond.
+const maxStreamQueries = 1000
+
+// compressedStreamPath returns the path made of the compressed canonical
+// query, which, unlike hashed paths, still resolves after the plugin
+// restarts.
+func compressedStreamPath(canonical []byte) (string, error) {
+	var b bytes.Buffer
+	w, err := flate.NewWriter(&b, flate.BestCompression)
+	if err != nil {
+		return "", err
+	}
+	if _, err := w.Write(canonical); err != nil {
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	return compressedStreamPrefix + base64.RawURLEncoding.EncodeToString(b.Bytes()), nil
+}
+
+// decompressStreamPath returns the canonical query of a compressed path,
+// without its prefix.
+func decompressStreamPath(encoded string) ([]byte, error) {
+	b, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(flate.NewReader(bytes.NewReader(b)))
+}
+
+// streamQueryStore holds the queries of hashed stream paths, up to
+// maxStreamQueries. The zero value is ready to use.
+type streamQueryStore struct {
+	mu      sync.Mutex
+	queries map[string]*storedQuery
+	// used orders the queries by last use.
+	used uint64
+}
+
+type storedQuery struct {
+	qm   queryModel
+	used uint64
+}
+
+func (s *streamQueryStore) store(path string, qm queryModel) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.queries == nil {
+		s.queries = make(map[string]*storedQuery)
+	}
+	if _, ok := s.queries[path]; !ok && len(s.queries) >= maxStreamQueries {
+		s.evict()
+	}
+	s.used++
+	s.queries[path] = &storedQuery{qm: qm, used: s.used}
+}
+
+// evict removes the least recently used query.
+func (s *streamQueryStore) evict() {
+	var oldest string
+	for path, stored := range s.queries {
+		if oldest == "" || stored.used < s.queries[oldest].used {
+			oldest = path
+		}
+	}
+	delete(s.queries, oldest)
+}
+
+func (s *streamQueryStore) load(path string) (queryModel, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.queries[path]
+	if !ok {
+		return queryModel{}, false
+	}
+	s.used++
+	stored.used = s.used
+	return stored.qm, true
+}
+
+func (s *streamQueryStore) remove(path string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.queries, path)
+}
+
+// len returns the number of queries held.
+func (s *streamQueryStore) len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.queries)
+}
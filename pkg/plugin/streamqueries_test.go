@@ -0,0 +1,52 @@
+package plugin
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestStreamQueryStore(t *testing.T) {
+	var store streamQueryStore
+	for i := 0; i < maxStreamQueries; i++ {
+		store.store(fmt.Sprintf("q/%d", i), queryModel{Topic: fmt.Sprint(i)})
+	}
+	// The first query is used again, so the second is the least recently
+	// used one.
+	if qm, ok := store.load("q/0"); !ok || qm.Topic != "0" {
+		t.Fatalf("expected the first query, got %+v", qm)
+	}
+	store.store("q/new", queryModel{Topic: "new"})
+
+	if n := store.len(); n != maxStreamQueries {
+		t.Errorf("expected %d queries, got %d", maxStreamQueries, n)
+	}
+	if _, ok := store.load("q/1"); ok {
+		t.Error("expected the least recently used query to be evicted")
+	}
+	if _, ok := store.load("q/0"); !ok {
+		t.Error("expected the recently used query to be kept")
+	}
+
+	store.remove("q/new")
+	if _, ok := store.load("q/new"); ok {
+		t.Error("expected the removed query to be gone")
+	}
+}
+
+func TestForgetStreamPath(t *testing.T) {
+	path := streamQueriesPrefix + "forget"
+	d := &KafkaDatasource{disposed: make(chan struct{})}
+
+	streamQueries.store(path, queryModel{Topic: "t"})
+	close(d.disposed)
+	d.forgetStreamPath(path)
+	if _, ok := streamQueries.load(path); !ok {
+		t.Error("expected the query of a stream handed over to a new instance to be kept")
+	}
+
+	d.disposed = make(chan struct{})
+	d.forgetStreamPath(path)
+	if _, ok := streamQueries.load(path); ok {
+		t.Error("expected the query of an ended stream to be removed")
+	}
+}
@@ -0,0 +1,176 @@
+package plugin
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hoptical/grafana-kafka-datasource/pkg/kafka_client"
+)
+
+// offsetCheckpointInterval is how often the offsets consumed by a stream are
+// added to its frames, when asked for.
+const offsetCheckpointInterval = 10 * time.Second
+
+// activeStream tracks the progress of a running stream.
+type activeStream struct {
+	path       string
+	refID      string
+	topic      string
+	partition  partitionValue
+	outputMode string
+	started    time.Time
+
+	mu       sync.Mutex
+	messages int64
+	offsets  map[int32]int64
+}
+
+// consumed records the message as the latest one consumed from its
+// partition.
+func (s *activeStream) consumed(msg kafka_client.KafkaMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.messages++
+	if s.offsets == nil {
+		s.offsets = make(map[int32]int64)
+	}
+	s.offsets[msg.Partition] = int64(msg.Offset)
+}
+
+// streamInfo describes an active stream in the active-streams resource.
+type streamInfo struct {
+	Path       string           `json:"path"`
+	RefID      string           `json:"refId"`
+	Topic      string           `json:"topic"`
+	Partition  partitionValue   `json:"partition"`
+	OutputMode string           `json:"outputMode"`
+	Started    time.Time        `json:"started"`
+	Uptime     float64          `json:"uptimeSeconds"`
+	Messages   int64            `json:"messages"`
+	Offsets    map[string]int64 `json:"offsets"`
+}
+
+func (s *activeStream) info(now time.Time) streamInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	outputMode := s.outputMode
+	if outputMode == "" {
+		outputMode = outputModeFields
+	}
+	return streamInfo{
+		Path:       s.path,
+		RefID:      s.refID,
+		Topic:      s.topic,
+		Partition:  s.partition,
+		OutputMode: outputMode,
+		Started:    s.started,
+		Uptime:     now.Sub(s.started).Seconds(),
+		Messages:   s.messages,
+		Offsets:    s.offsetsLocked(),
+	}
+}
+
+// checkpoint returns the latest offset consumed from every partition, keyed
+// by partition.
+func (s *activeStream) checkpoint() map[string]int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.offsetsLocked()
+}
+
+func (s *activeStream) offsetsLocked() map[string]int64 {
+	return partitionOffsets(s.offsets)
+}
+
+// partitionOffsets keys offsets by partition as strings, for JSON.
+func partitionOffsets(offsets map[int32]int64) map[string]int64 {
+	keyed := make(map[string]int64, len(offsets))
+	for partition, offset := range offsets {
+		keyed[strconv.Itoa(int(partition))] = offset
+	}
+	return keyed
+}
+
+// streamRegistry keeps track of the streams running on a datasource instance,
+// up to max unless zero. The zero value is ready to use.
+type streamRegistry struct {
+	max int
+
+	mu      sync.Mutex
+	streams map[*activeStream]struct{}
+}
+
+func (r *streamRegistry) register(now time.Time, path string, qm queryModel) (*activeStream, error) {
+	s := &activeStream{
+		path:       path,
+		refID:      qm.RefID,
+		topic:      qm.Topic,
+		partition:  qm.Partition,
+		outputMode: qm.OutputMode,
+		started:    now,
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.max > 0 && len(r.streams) >= r.max {
+		return nil, errStreamLimit(r.max)
+	}
+	if r.streams == nil {
+		r.streams = make(map[*activeStream]struct{})
+	}
+	r.streams[s] = struct{}{}
+	return s, nil
+}
+
+// running reports whether a stream runs on the path.
+func (r *streamRegistry) running(path string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for s := range r.streams {
+		if s.path == path {
+			return true
+		}
+	}
+	return false
+}
+
+// full reports whether the maximum number of streams is running.
+func (r *streamRegistry) full() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.max > 0 && len(r.streams) >= r.max
+}
+
+func (r *streamRegistry) unregister(s *activeStream) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.streams, s)
+}
+
+// list describes the active streams, oldest first.
+func (r *streamRegistry) list(now time.Time) []streamInfo {
+	r.mu.Lock()
+	streams := make([]*activeStream, 0, len(r.streams))
+	for s := range r.streams {
+		streams = append(streams, s)
+	}
+	r.mu.Unlock()
+
+	infos := make([]streamInfo, 0, len(streams))
+	for _, s := range streams {
+		infos = append(infos, s.info(now))
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Started.Before(infos[j].Started)
+	})
+	return infos
+}
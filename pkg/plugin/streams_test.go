@@ -0,0 +1,92 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/resource/httpadapter"
+
+	"github.com/hoptical/grafana-kafka-datasource/pkg/kafka_client"
+)
+
+func TestStreamRegistry(t *testing.T) {
+	var registry streamRegistry
+	start := time.Unix(1000, 0)
+
+	first, _ := registry.register(start, "a", queryModel{Topic: "orders", Partition: -1})
+	second, _ := registry.register(start.Add(time.Second), "b", queryModel{Topic: "payments", OutputMode: outputModeTraces})
+	first.consumed(kafka_client.KafkaMessage{Partition: 2, Offset: kafka.Offset(41)})
+	first.consumed(kafka_client.KafkaMessage{Partition: 2, Offset: kafka.Offset(42)})
+
+	streams := registry.list(start.Add(10 * time.Second))
+	if len(streams) != 2 || streams[0].Path != "a" || streams[1].Path != "b" {
+		t.Fatalf("expected the streams oldest first, got %+v", streams)
+	}
+	if s := streams[0]; s.Messages != 2 || s.Offsets["2"] != 42 || s.Uptime != 10 || s.OutputMode != outputModeFields {
+		t.Errorf("unexpected stream %+v", s)
+	}
+
+	if offsets := first.checkpoint(); len(offsets) != 1 || offsets["2"] != 42 {
+		t.Errorf("expected the latest offset of partition 2, got %v", offsets)
+	}
+	if offsets := second.checkpoint(); len(offsets) != 0 {
+		t.Errorf("expected no offsets before any message, got %v", offsets)
+	}
+
+	registry.unregister(second)
+	if streams := registry.list(start); len(streams) != 1 {
+		t.Errorf("expected a single stream left, got %+v", streams)
+	}
+}
+
+type responseRecorder struct {
+	response *backend.CallResourceResponse
+}
+
+func (r *responseRecorder) Send(res *backend.CallResourceResponse) error {
+	r.response = res
+	return nil
+}
+
+func TestHandleActiveStreams(t *testing.T) {
+	d := &KafkaDatasource{clock: fixedClock{time.Unix(1000, 0)}}
+	d.streams.register(time.Unix(900, 0), "a", queryModel{Topic: "orders"})
+	handler := httpadapter.New(d.newResourceMux())
+
+	call := func(role string) *backend.CallResourceResponse {
+		req := &backend.CallResourceRequest{
+			PluginContext: backend.PluginContext{User: &backend.User{Role: role}},
+			Path:          "active-streams",
+			Method:        http.MethodGet,
+			URL:           "active-streams",
+		}
+		sender := &responseRecorder{}
+		if err := handler.CallResource(context.Background(), req, sender); err != nil {
+			t.Fatal(err)
+		}
+		return sender.response
+	}
+
+	if res := call("Viewer"); res.Status != http.StatusForbidden {
+		t.Errorf("expected viewers to be forbidden, got %d", res.Status)
+	}
+
+	res := call("Admin")
+	if res.Status != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, res.Status, res.Body)
+	}
+	var body struct {
+		Streams []streamInfo `json:"streams"`
+	}
+	if err := json.Unmarshal(res.Body, &body); err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Streams) != 1 || body.Streams[0].Topic != "orders" || body.Streams[0].Uptime != 100 {
+		t.Errorf("unexpected streams %+v", body.Streams)
+	}
+}
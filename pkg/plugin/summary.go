@@ -0,0 +1,46 @@
+package plugin
+
+import (
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+
+	"github.com/hoptical/grafana-kafka-datasource/pkg/kafka_client"
+)
+
+// isSummarized reports whether the message is above the summary size of the
+// query, if any.
+func isSummarized(msg kafka_client.KafkaMessage, qm queryModel) bool {
+	return qm.SummaryAboveBytes > 0 && msg.Size > qm.SummaryAboveBytes
+}
+
+// newSummaryFrame builds the frame of a message above the summary size out of
+// its size, key, partition and offset, along with the selected top-level
+// fields, instead of flattening all of it. The message resource returns the
+// full decode on demand.
+func newSummaryFrame(msg kafka_client.KafkaMessage, frameTime time.Time, qm queryModel) *data.Frame {
+	summary := msg
+	if msg.Value != nil {
+		summary.Value = make(map[string]interface{})
+		for _, field := range strings.Split(qm.SummaryFields, ",") {
+			field = strings.TrimSpace(field)
+			if value, ok := msg.Value[field]; ok {
+				summary.Value[field] = value
+			}
+		}
+	}
+
+	frame := newMessageFrame(summary, frameTime, qm)
+	if !hasField(frame, "__bytes") {
+		frame.Fields = append(frame.Fields, data.NewField("__bytes", nil, []int64{int64(msg.Size)}))
+	}
+	if !hasField(frame, "__partition") {
+		frame.Fields = append(frame.Fields, data.NewField("__partition", nil, []int32{msg.Partition}))
+	}
+	frame.Fields = append(frame.Fields,
+		data.NewField("__offset", nil, []int64{int64(msg.Offset)}),
+		data.NewField("__key", nil, []string{sanitizeUTF8(string(msg.Key), qm.InvalidUTF8)}),
+	)
+	return frame
+}
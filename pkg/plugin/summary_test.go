@@ -0,0 +1,45 @@
+package plugin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hoptical/grafana-kafka-datasource/pkg/kafka_client"
+)
+
+func TestNewSummaryFrame(t *testing.T) {
+	msg := kafka_client.KafkaMessage{
+		Value: map[string]interface{}{
+			"id":      "order-1",
+			"status":  map[string]interface{}{"code": 2.0},
+			"payload": map[string]interface{}{"items": []interface{}{1.0, 2.0, 3.0}},
+		},
+		Size:      4096,
+		Partition: 1,
+		Offset:    42,
+		Key:       []byte("customer-7"),
+	}
+	qm := queryModel{TimestampMode: "message", SummaryAboveBytes: 1024, SummaryFields: "id, status"}
+	if !isSummarized(msg, qm) {
+		t.Fatal("expected the message to be summarized")
+	}
+
+	frame := newSummaryFrame(msg, time.Unix(1, 0), qm)
+	want := []string{"time", "id", "status.code", "__bytes", "__partition", "__offset", "__key"}
+	if len(frame.Fields) != len(want) {
+		t.Fatalf("expected %d fields, got %d", len(want), len(frame.Fields))
+	}
+	for i, name := range want {
+		if frame.Fields[i].Name != name {
+			t.Errorf("field %d: expected %s, got %s", i, name, frame.Fields[i].Name)
+		}
+	}
+	if got := frameField(frame, "__key").At(0).(string); got != "customer-7" {
+		t.Errorf("expected key customer-7, got %s", got)
+	}
+
+	msg.Size = 512
+	if isSummarized(msg, qm) {
+		t.Error("expected a message below the size not to be summarized")
+	}
+}
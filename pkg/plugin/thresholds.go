@@ -0,0 +1,98 @@
+package plugin
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+const (
+	thresholdActionTag    = "tag"
+	thresholdActionFilter = "filter"
+)
+
+// thresholdRule matches messages whose field compares to the value with the
+// operator. Numeric fields are compared numerically, other fields support ==
+// and != only.
+type thresholdRule struct {
+	Field    string `json:"field"`
+	Operator string `json:"operator"`
+	Value    string `json:"value"`
+}
+
+func (r thresholdRule) validate() error {
+	switch r.Operator {
+	case ">", ">=", "<", "<=", "==", "!=":
+	default:
+		return fmt.Errorf("invalid threshold operator %q", r.Operator)
+	}
+	if r.Field == "" {
+		return fmt.Errorf("threshold field is required")
+	}
+	return nil
+}
+
+func (r thresholdRule) match(frame *data.Frame) bool {
+	for _, field := range frame.Fields {
+		if field.Name != r.Field || field.Len() == 0 {
+			continue
+		}
+		switch v := field.At(0).(type) {
+		case float64:
+			threshold, err := strconv.ParseFloat(r.Value, 64)
+			if err != nil {
+				return false
+			}
+			return compare(r.Operator, v, threshold)
+		case string:
+			return (r.Operator == "==" && v == r.Value) || (r.Operator == "!=" && v != r.Value)
+		case bool:
+			b, err := strconv.ParseBool(r.Value)
+			if err != nil {
+				return false
+			}
+			return (r.Operator == "==" && v == b) || (r.Operator == "!=" && v != b)
+		}
+		return false
+	}
+	return false
+}
+
+func compare(operator string, a, b float64) bool {
+	switch operator {
+	case ">":
+		return a > b
+	case ">=":
+		return a >= b
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	case "==":
+		return a == b
+	case "!=":
+		return a != b
+	}
+	return false
+}
+
+// applyThresholds evaluates the rules of the query against the frame, any of
+// them matching being enough. In tag mode, the outcome is added as an alert
+// field and the frame is always kept; in filter mode, only matching frames
+// are kept.
+func applyThresholds(frame *data.Frame, qm queryModel) bool {
+	matched := false
+	for _, rule := range qm.ThresholdRules {
+		if rule.match(frame) {
+			matched = true
+			break
+		}
+	}
+
+	if qm.ThresholdAction == thresholdActionFilter {
+		return matched
+	}
+	frame.Fields = append(frame.Fields, data.NewField("alert", nil, []bool{matched}))
+	return true
+}
@@ -0,0 +1,45 @@
+package plugin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hoptical/grafana-kafka-datasource/pkg/kafka_client"
+)
+
+func TestApplyThresholds(t *testing.T) {
+	rules := []thresholdRule{
+		{Field: "temperature", Operator: ">=", Value: "30"},
+		{Field: "state", Operator: "==", Value: "failed"},
+	}
+	tests := []struct {
+		value   map[string]interface{}
+		matched bool
+	}{
+		{map[string]interface{}{"temperature": 30.0, "state": "ok"}, true},
+		{map[string]interface{}{"temperature": 20.0, "state": "failed"}, true},
+		{map[string]interface{}{"temperature": 20.0, "state": "ok"}, false},
+		{map[string]interface{}{"state": "ok"}, false},
+	}
+
+	for i, tt := range tests {
+		qm := queryModel{TimestampMode: "message", ThresholdRules: rules}
+		frame := newMessageFrame(kafka_client.KafkaMessage{Value: tt.value}, time.Unix(1, 0), qm)
+		if !applyThresholds(frame, qm) {
+			t.Errorf("message %d: expected tagged frames to be kept", i)
+		}
+		if got := frameField(frame, "alert").At(0).(bool); got != tt.matched {
+			t.Errorf("message %d: expected alert to be %v", i, tt.matched)
+		}
+
+		qm.ThresholdAction = thresholdActionFilter
+		frame = newMessageFrame(kafka_client.KafkaMessage{Value: tt.value}, time.Unix(1, 0), qm)
+		if got := applyThresholds(frame, qm); got != tt.matched {
+			t.Errorf("message %d: expected filter to keep the frame: %v", i, tt.matched)
+		}
+	}
+
+	if err := (thresholdRule{Field: "a", Operator: "=~"}).validate(); err == nil {
+		t.Error("expected an invalid operator to fail")
+	}
+}
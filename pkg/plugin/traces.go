@@ -0,0 +1,293 @@
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+
+	"github.com/hoptical/grafana-kafka-datasource/pkg/kafka_client"
+)
+
+// traceSpan is a span decoded out of an OTLP, Zipkin or Jaeger JSON message.
+type traceSpan struct {
+	TraceID       string
+	SpanID        string
+	ParentSpanID  string
+	OperationName string
+	ServiceName   string
+	ServiceTags   []traceTag
+	Tags          []traceTag
+	StartTime     time.Time
+	Duration      time.Duration
+}
+
+type traceTag struct {
+	Key   string      `json:"key"`
+	Value interface{} `json:"value"`
+}
+
+var errNoSpans = errors.New("message doesn't contain OTLP, Zipkin or Jaeger spans")
+
+// parseSpans decodes the spans carried by a message. OTLP messages are
+// recognized by their resourceSpans, Jaeger spans by their operationName and
+// Zipkin spans, sent alone or as a list, by their id.
+func parseSpans(raw []byte) ([]traceSpan, error) {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+
+	var spans []traceSpan
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if resourceSpans, ok := v["resourceSpans"].([]interface{}); ok {
+			spans = parseOTLPSpans(resourceSpans)
+		} else if span, ok := parseSpan(v); ok {
+			spans = append(spans, span)
+		}
+	case []interface{}:
+		for _, item := range v {
+			if m, ok := item.(map[string]interface{}); ok {
+				if span, ok := parseSpan(m); ok {
+					spans = append(spans, span)
+				}
+			}
+		}
+	}
+
+	if len(spans) == 0 {
+		return nil, errNoSpans
+	}
+	return spans, nil
+}
+
+func parseSpan(m map[string]interface{}) (traceSpan, bool) {
+	if _, ok := m["operationName"]; ok {
+		return parseJaegerSpan(m), true
+	}
+	if _, ok := m["id"]; ok {
+		return parseZipkinSpan(m), true
+	}
+	return traceSpan{}, false
+}
+
+func parseOTLPSpans(resourceSpans []interface{}) []traceSpan {
+	var spans []traceSpan
+	for _, rs := range resourceSpans {
+		resource, _ := rs.(map[string]interface{})
+		attributes, _ := objectAt(resource, "resource")["attributes"].([]interface{})
+		serviceTags := otlpAttributes(attributes)
+		serviceName := ""
+		for _, tag := range serviceTags {
+			if tag.Key == "service.name" {
+				serviceName, _ = tag.Value.(string)
+			}
+		}
+
+		scopes, ok := resource["scopeSpans"].([]interface{})
+		if !ok {
+			scopes, _ = resource["instrumentationLibrarySpans"].([]interface{})
+		}
+		for _, scope := range scopes {
+			scopeSpans, _ := objectOf(scope)["spans"].([]interface{})
+			for _, s := range scopeSpans {
+				span := objectOf(s)
+				start := int64At(span, "startTimeUnixNano")
+				end := int64At(span, "endTimeUnixNano")
+				attributes, _ := span["attributes"].([]interface{})
+				spans = append(spans, traceSpan{
+					TraceID:       stringAt(span, "traceId"),
+					SpanID:        stringAt(span, "spanId"),
+					ParentSpanID:  stringAt(span, "parentSpanId"),
+					OperationName: stringAt(span, "name"),
+					ServiceName:   serviceName,
+					ServiceTags:   serviceTags,
+					Tags:          otlpAttributes(attributes),
+					StartTime:     time.Unix(0, start),
+					Duration:      time.Duration(end - start),
+				})
+			}
+		}
+	}
+	return spans
+}
+
+func otlpAttributes(attributes []interface{}) []traceTag {
+	tags := make([]traceTag, 0, len(attributes))
+	for _, a := range attributes {
+		attribute := objectOf(a)
+		var value interface{}
+		for _, v := range objectAt(attribute, "value") {
+			value = v
+		}
+		tags = append(tags, traceTag{Key: stringAt(attribute, "key"), Value: value})
+	}
+	return tags
+}
+
+func parseZipkinSpan(m map[string]interface{}) traceSpan {
+	tags := make([]traceTag, 0)
+	for key, value := range objectAt(m, "tags") {
+		tags = append(tags, traceTag{Key: key, Value: value})
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i].Key < tags[j].Key })
+
+	return traceSpan{
+		TraceID:       stringAt(m, "traceId"),
+		SpanID:        stringAt(m, "id"),
+		ParentSpanID:  stringAt(m, "parentId"),
+		OperationName: stringAt(m, "name"),
+		ServiceName:   stringAt(objectAt(m, "localEndpoint"), "serviceName"),
+		Tags:          tags,
+		StartTime:     time.Unix(0, int64At(m, "timestamp")*int64(time.Microsecond)),
+		Duration:      time.Duration(int64At(m, "duration")) * time.Microsecond,
+	}
+}
+
+// parseJaegerSpan accepts both the JSON of the Jaeger API, with microsecond
+// numbers, and the JSON encoding of the Jaeger protobuf model, with RFC 3339
+// timestamps and duration strings.
+func parseJaegerSpan(m map[string]interface{}) traceSpan {
+	span := traceSpan{
+		TraceID:       stringAt(m, "traceID", "traceId"),
+		SpanID:        stringAt(m, "spanID", "spanId"),
+		OperationName: stringAt(m, "operationName"),
+		Tags:          jaegerTags(m["tags"]),
+	}
+
+	references, _ := m["references"].([]interface{})
+	for _, r := range references {
+		reference := objectOf(r)
+		if refType := stringAt(reference, "refType"); refType == "" || refType == "CHILD_OF" {
+			span.ParentSpanID = stringAt(reference, "spanID", "spanId")
+			break
+		}
+	}
+
+	process := objectAt(m, "process")
+	span.ServiceName = stringAt(process, "serviceName")
+	span.ServiceTags = jaegerTags(process["tags"])
+
+	if start, ok := m["startTime"].(string); ok {
+		span.StartTime, _ = time.Parse(time.RFC3339Nano, start)
+	} else {
+		span.StartTime = time.Unix(0, int64At(m, "startTime")*int64(time.Microsecond))
+	}
+	if duration, ok := m["duration"].(string); ok {
+		span.Duration, _ = time.ParseDuration(duration)
+	} else {
+		span.Duration = time.Duration(int64At(m, "duration")) * time.Microsecond
+	}
+
+	return span
+}
+
+func jaegerTags(value interface{}) []traceTag {
+	list, _ := value.([]interface{})
+	tags := make([]traceTag, 0, len(list))
+	for _, t := range list {
+		tag := objectOf(t)
+		var v interface{}
+		for _, key := range []string{"value", "vStr", "vBool", "vInt64", "vFloat64"} {
+			if tv, ok := tag[key]; ok {
+				v = tv
+				break
+			}
+		}
+		tags = append(tags, traceTag{Key: stringAt(tag, "key"), Value: v})
+	}
+	return tags
+}
+
+func objectOf(value interface{}) map[string]interface{} {
+	m, _ := value.(map[string]interface{})
+	return m
+}
+
+func objectAt(m map[string]interface{}, key string) map[string]interface{} {
+	return objectOf(m[key])
+}
+
+// stringAt returns the string value of the first of the keys found in m.
+func stringAt(m map[string]interface{}, keys ...string) string {
+	for _, key := range keys {
+		if s, ok := m[key].(string); ok {
+			return s
+		}
+	}
+	return ""
+}
+
+// int64At returns the integer value of key, which might be encoded as a JSON
+// number or, like 64-bit integers in protobuf JSON, as a string.
+func int64At(m map[string]interface{}, key string) int64 {
+	switch v := m[key].(type) {
+	case json.Number:
+		n, _ := v.Int64()
+		return n
+	case string:
+		n, _ := strconv.ParseInt(v, 10, 64)
+		return n
+	}
+	return 0
+}
+
+func tagsJSON(tags []traceTag) string {
+	if len(tags) == 0 {
+		return "[]"
+	}
+	b, err := json.Marshal(tags)
+	if err != nil {
+		return "[]"
+	}
+	return string(b)
+}
+
+// newSpansFrame builds the traces frame of a message, or the usual error frame
+// if the message doesn't carry any spans.
+func newSpansFrame(msg kafka_client.KafkaMessage, frameTime time.Time, qm queryModel) *data.Frame {
+	spans, err := parseSpans(msg.RawValue)
+	if err != nil {
+		msg.Err = err
+		return newMessageFrame(msg, frameTime, qm)
+	}
+	return newTracesFrame(spans)
+}
+
+// newTracesFrame builds a frame in the format of Grafana's trace view.
+func newTracesFrame(spans []traceSpan) *data.Frame {
+	frame := data.NewFrame("traces",
+		data.NewField("traceID", nil, []string{}),
+		data.NewField("spanID", nil, []string{}),
+		data.NewField("parentSpanID", nil, []string{}),
+		data.NewField("operationName", nil, []string{}),
+		data.NewField("serviceName", nil, []string{}),
+		data.NewField("serviceTags", nil, []string{}),
+		data.NewField("startTime", nil, []float64{}),
+		data.NewField("duration", nil, []float64{}),
+		data.NewField("tags", nil, []string{}),
+	)
+	frame.SetMeta(&data.FrameMeta{PreferredVisualization: data.VisTypeTrace})
+
+	for _, span := range spans {
+		frame.AppendRow(
+			span.TraceID,
+			span.SpanID,
+			span.ParentSpanID,
+			span.OperationName,
+			span.ServiceName,
+			tagsJSON(span.ServiceTags),
+			float64(span.StartTime.UnixNano())/float64(time.Millisecond),
+			float64(span.Duration)/float64(time.Millisecond),
+			tagsJSON(span.Tags),
+		)
+	}
+	return frame
+}
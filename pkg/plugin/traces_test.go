@@ -0,0 +1,87 @@
+package plugin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hoptical/grafana-kafka-datasource/pkg/kafka_client"
+)
+
+func TestParseSpans(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want traceSpan
+	}{
+		{
+			name: "otlp",
+			raw: `{"resourceSpans":[{"resource":{"attributes":[{"key":"service.name","value":{"stringValue":"api"}}]},
+				"scopeSpans":[{"spans":[{"traceId":"t1","spanId":"s2","parentSpanId":"s1","name":"GET",
+				"startTimeUnixNano":"1000000000","endTimeUnixNano":"1250000000"}]}]}]}`,
+			want: traceSpan{TraceID: "t1", SpanID: "s2", ParentSpanID: "s1", OperationName: "GET", ServiceName: "api",
+				StartTime: time.Unix(1, 0), Duration: 250 * time.Millisecond},
+		},
+		{
+			name: "zipkin",
+			raw: `[{"traceId":"t1","id":"s2","parentId":"s1","name":"get","timestamp":1000000,"duration":250000,
+				"localEndpoint":{"serviceName":"api"},"tags":{"http.method":"GET"}}]`,
+			want: traceSpan{TraceID: "t1", SpanID: "s2", ParentSpanID: "s1", OperationName: "get", ServiceName: "api",
+				StartTime: time.Unix(1, 0), Duration: 250 * time.Millisecond},
+		},
+		{
+			name: "jaeger",
+			raw: `{"traceID":"t1","spanID":"s2","operationName":"get","references":[{"refType":"CHILD_OF","spanID":"s1"}],
+				"startTime":1000000,"duration":250000,"process":{"serviceName":"api"}}`,
+			want: traceSpan{TraceID: "t1", SpanID: "s2", ParentSpanID: "s1", OperationName: "get", ServiceName: "api",
+				StartTime: time.Unix(1, 0), Duration: 250 * time.Millisecond},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spans, err := parseSpans([]byte(tt.raw))
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if len(spans) != 1 {
+				t.Fatalf("expected 1 span, got %d", len(spans))
+			}
+			span := spans[0]
+			if span.TraceID != tt.want.TraceID || span.SpanID != tt.want.SpanID ||
+				span.ParentSpanID != tt.want.ParentSpanID || span.OperationName != tt.want.OperationName ||
+				span.ServiceName != tt.want.ServiceName {
+				t.Errorf("expected %+v, got %+v", tt.want, span)
+			}
+			if !span.StartTime.Equal(tt.want.StartTime) || span.Duration != tt.want.Duration {
+				t.Errorf("expected start %v and duration %v, got %v and %v",
+					tt.want.StartTime, tt.want.Duration, span.StartTime, span.Duration)
+			}
+		})
+	}
+
+	if _, err := parseSpans([]byte(`{"value":1}`)); err != errNoSpans {
+		t.Errorf("expected errNoSpans, got %v", err)
+	}
+}
+
+func TestNewSpansFrame(t *testing.T) {
+	msg := kafka_client.KafkaMessage{
+		RawValue: []byte(`{"traceId":"t1","id":"s1","name":"get","timestamp":1000000,"duration":2000,"tags":{"a":"b"}}`),
+	}
+	frame := newSpansFrame(msg, time.Unix(1, 0), queryModel{OutputMode: outputModeTraces})
+
+	if frame.Meta == nil || frame.Meta.PreferredVisualization != "trace" {
+		t.Fatalf("expected the trace visualization, got %+v", frame.Meta)
+	}
+	if got := frameField(frame, "duration").At(0).(float64); got != 2 {
+		t.Errorf("expected a duration of 2ms, got %v", got)
+	}
+	if got := frameField(frame, "tags").At(0).(string); got != `[{"key":"a","value":"b"}]` {
+		t.Errorf("unexpected tags %s", got)
+	}
+
+	frame = newSpansFrame(kafka_client.KafkaMessage{RawValue: []byte(`{}`)}, time.Unix(1, 0), queryModel{})
+	if frameField(frame, "__error") == nil {
+		t.Error("expected an error frame for a message without spans")
+	}
+}
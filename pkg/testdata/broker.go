@@ -0,0 +1,64 @@
+package testdata
+
+import (
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+// brokerTimeoutMs bounds how long RequireBroker waits for the broker.
+const brokerTimeoutMs = 5000
+
+var brokers struct {
+	sync.Mutex
+	errs map[string]error
+}
+
+// BootstrapServers returns the bootstrap servers of the broker the
+// integration tests run against, KAFKA_BOOTSTRAP_SERVERS or localhost:9092 by
+// default.
+func BootstrapServers() string {
+	if servers := os.Getenv("KAFKA_BOOTSTRAP_SERVERS"); servers != "" {
+		return servers
+	}
+	return "localhost:9092"
+}
+
+// RequireBroker skips the test unless the broker of BootstrapServers answers,
+// so that integration tests don't fail where no broker runs. Brokers set
+// explicitly with KAFKA_BOOTSTRAP_SERVERS, like in CI, are expected to answer,
+// so the test fails instead. The broker is only asked once.
+func RequireBroker(t testing.TB) {
+	t.Helper()
+	servers := BootstrapServers()
+
+	brokers.Lock()
+	defer brokers.Unlock()
+	if brokers.errs == nil {
+		brokers.errs = make(map[string]error)
+	}
+	err, ok := brokers.errs[servers]
+	if !ok {
+		err = pingBroker(servers)
+		brokers.errs[servers] = err
+	}
+	if err == nil {
+		return
+	}
+	if os.Getenv("KAFKA_BOOTSTRAP_SERVERS") != "" {
+		t.Fatalf("broker %s isn't reachable: %v", servers, err)
+	}
+	t.Skipf("no broker reachable at %s: %v", servers, err)
+}
+
+func pingBroker(servers string) error {
+	admin, err := kafka.NewAdminClient(&kafka.ConfigMap{"bootstrap.servers": servers})
+	if err != nil {
+		return err
+	}
+	defer admin.Close()
+	_, err = admin.GetMetadata(nil, false, brokerTimeoutMs)
+	return err
+}
@@ -0,0 +1,95 @@
+package testdata
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+)
+
+// CBOR encodes the value as CBOR, with integral numbers as integers and
+// times as epoch timestamps (tag 1).
+func CBOR(v interface{}) []byte {
+	var e cborEncoder
+	e.value(v)
+	return e.b
+}
+
+type cborEncoder struct {
+	b []byte
+}
+
+// head appends the initial byte of an item of the major type and its
+// argument, in the fewest bytes.
+func (e *cborEncoder) head(major byte, arg uint64) {
+	major <<= 5
+	switch {
+	case arg < 24:
+		e.b = append(e.b, major|byte(arg))
+	case arg <= math.MaxUint8:
+		e.b = append(e.b, major|24, byte(arg))
+	case arg <= math.MaxUint16:
+		e.b = append(e.b, major|25, 0, 0)
+		binary.BigEndian.PutUint16(e.b[len(e.b)-2:], uint16(arg))
+	case arg <= math.MaxUint32:
+		e.b = append(e.b, major|26, 0, 0, 0, 0)
+		binary.BigEndian.PutUint32(e.b[len(e.b)-4:], uint32(arg))
+	default:
+		e.b = append(e.b, major|27, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(e.b[len(e.b)-8:], arg)
+	}
+}
+
+func (e *cborEncoder) value(v interface{}) {
+	switch v := v.(type) {
+	case nil:
+		e.b = append(e.b, 0xf6)
+	case bool:
+		if v {
+			e.b = append(e.b, 0xf5)
+		} else {
+			e.b = append(e.b, 0xf4)
+		}
+	case int:
+		e.int(int64(v))
+	case int64:
+		e.int(v)
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<63 {
+			e.int(int64(v))
+			return
+		}
+		e.b = append(e.b, 0xfb, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(e.b[len(e.b)-8:], math.Float64bits(v))
+	case string:
+		e.head(3, uint64(len(v)))
+		e.b = append(e.b, v...)
+	case []byte:
+		e.head(2, uint64(len(v)))
+		e.b = append(e.b, v...)
+	case time.Time:
+		e.head(6, 1)
+		e.value(float64(v.UnixNano()) / float64(time.Second))
+	case []interface{}:
+		e.head(4, uint64(len(v)))
+		for _, item := range v {
+			e.value(item)
+		}
+	case map[string]interface{}:
+		e.head(5, uint64(len(v)))
+		for _, k := range sortedKeys(v) {
+			e.value(k)
+			e.value(v[k])
+		}
+	default:
+		panic(fmt.Sprintf("testdata: no CBOR encoding for %T", v))
+	}
+}
+
+func (e *cborEncoder) int(v int64) {
+	if v < 0 {
+		e.head(1, uint64(-1-v))
+		return
+	}
+	e.head(0, uint64(v))
+}
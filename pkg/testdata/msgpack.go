@@ -0,0 +1,115 @@
+package testdata
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// MessagePack encodes the value as MessagePack, with integral numbers as
+// integers and times as timestamp extensions.
+func MessagePack(v interface{}) []byte {
+	var e msgpackEncoder
+	e.value(v)
+	return e.b
+}
+
+type msgpackEncoder struct {
+	b []byte
+}
+
+// sized appends the type of a string, binary, array or map of n elements:
+// the fix type if there is one, fix, and n fits in it, else the smallest of
+// the sized types starting at first, which begin with an 8 bit one if has8.
+func (e *msgpackEncoder) sized(n int, fix byte, fixMax int, first byte, has8 bool) {
+	if fix != 0 && n <= fixMax {
+		e.b = append(e.b, fix|byte(n))
+		return
+	}
+	if has8 {
+		if n <= math.MaxUint8 {
+			e.b = append(e.b, first, byte(n))
+			return
+		}
+		first++
+	}
+	if n <= math.MaxUint16 {
+		e.b = append(e.b, first, 0, 0)
+		binary.BigEndian.PutUint16(e.b[len(e.b)-2:], uint16(n))
+		return
+	}
+	e.b = append(e.b, first+1, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(e.b[len(e.b)-4:], uint32(n))
+}
+
+func (e *msgpackEncoder) value(v interface{}) {
+	switch v := v.(type) {
+	case nil:
+		e.b = append(e.b, 0xc0)
+	case bool:
+		if v {
+			e.b = append(e.b, 0xc3)
+		} else {
+			e.b = append(e.b, 0xc2)
+		}
+	case int:
+		e.int(int64(v))
+	case int64:
+		e.int(v)
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<63 {
+			e.int(int64(v))
+			return
+		}
+		e.b = append(e.b, 0xcb, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(e.b[len(e.b)-8:], math.Float64bits(v))
+	case string:
+		e.sized(len(v), 0xa0, 31, 0xd9, true)
+		e.b = append(e.b, v...)
+	case []byte:
+		e.sized(len(v), 0, 0, 0xc4, true)
+		e.b = append(e.b, v...)
+	case time.Time:
+		// The 96 bit timestamp extension, of type -1.
+		e.b = append(e.b, 0xc7, 12, 0xff, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint32(e.b[len(e.b)-12:], uint32(v.Nanosecond()))
+		binary.BigEndian.PutUint64(e.b[len(e.b)-8:], uint64(v.Unix()))
+	case []interface{}:
+		e.sized(len(v), 0x90, 15, 0xdc, false)
+		for _, item := range v {
+			e.value(item)
+		}
+	case map[string]interface{}:
+		e.sized(len(v), 0x80, 15, 0xde, false)
+		for _, k := range sortedKeys(v) {
+			e.value(k)
+			e.value(v[k])
+		}
+	default:
+		panic(fmt.Sprintf("testdata: no MessagePack encoding for %T", v))
+	}
+}
+
+func (e *msgpackEncoder) int(v int64) {
+	switch {
+	case v >= 0 && v <= 0x7f, v < 0 && v >= -32:
+		e.b = append(e.b, byte(v))
+	case v >= 0:
+		e.b = append(e.b, 0xcf, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(e.b[len(e.b)-8:], uint64(v))
+	default:
+		e.b = append(e.b, 0xd3, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(e.b[len(e.b)-8:], uint64(v))
+	}
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
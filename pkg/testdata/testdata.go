@@ -0,0 +1,83 @@
+// Package testdata encodes messages in the formats decoded by the datasource,
+// for the integration tests and the example producer.
+//
+// Values are built from the same types as decoded JSON: maps with string
+// keys, slices, strings, numbers, booleans and nil, along with byte slices
+// and times for the formats that have them.
+package testdata
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Message is a message to produce. A nil Value is a tombstone.
+type Message struct {
+	Key   []byte
+	Value []byte
+}
+
+// JSON encodes the value as JSON, panicking on values JSON can't encode.
+func JSON(v interface{}) []byte {
+	b, err := json.Marshal(v)
+	if err != nil {
+		panic(fmt.Sprintf("testdata: %v", err))
+	}
+	return b
+}
+
+// JSONSchema encodes the value as the Confluent JSON Schema serializer does:
+// the magic byte and the big-endian schema ID, followed by the JSON.
+func JSONSchema(schemaID uint32, v interface{}) []byte {
+	b := make([]byte, 5)
+	binary.BigEndian.PutUint32(b[1:], schemaID)
+	return append(b, JSON(v)...)
+}
+
+// CloudEvent encodes an event in the structured JSON mode of the CloudEvents
+// Kafka binding.
+func CloudEvent(id, source, eventType string, t time.Time, data interface{}) []byte {
+	return JSON(map[string]interface{}{
+		"specversion":     "1.0",
+		"id":              id,
+		"source":          source,
+		"type":            eventType,
+		"time":            t.UTC().Format(time.RFC3339Nano),
+		"datacontenttype": "application/json",
+		"data":            data,
+	})
+}
+
+// Debezium operations.
+const (
+	DebeziumCreate = "c"
+	DebeziumUpdate = "u"
+	DebeziumDelete = "d"
+	DebeziumRead   = "r"
+)
+
+// Debezium encodes a change event of the Debezium JSON converter, without
+// schemas, for a row of the table changed from before to after. Deletes are
+// followed by a tombstone of the same key.
+func Debezium(op, table string, before, after map[string]interface{}, t time.Time) []byte {
+	ms := t.UnixNano() / int64(time.Millisecond)
+	return JSON(map[string]interface{}{
+		"before": before,
+		"after":  after,
+		"source": map[string]interface{}{
+			"connector": "postgresql",
+			"name":      "example",
+			"table":     table,
+			"ts_ms":     ms,
+		},
+		"op":    op,
+		"ts_ms": ms,
+	})
+}
+
+// Tombstone returns the message deleting the key of a compacted topic.
+func Tombstone(key []byte) Message {
+	return Message{Key: key}
+}
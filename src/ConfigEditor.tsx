@@ -1,38 +1,59 @@
 import React, { ChangeEvent, PureComponent } from 'react';
-import { LegacyForms } from '@grafana/ui';
+import { Button, LegacyForms } from '@grafana/ui';
 import { DataSourcePluginOptionsEditorProps } from '@grafana/data';
-import { KafkaDataSourceOptions, KafkaSecureJsonData } from './types';
+import { getBackendSrv } from '@grafana/runtime';
+import { KafkaCluster, KafkaDataLink, KafkaDataSourceOptions, KafkaSecureJsonData } from './types';
 
 const { SecretFormField, FormField } = LegacyForms;
 
 interface Props extends DataSourcePluginOptionsEditorProps<KafkaDataSourceOptions> {}
 
-interface State {}
+interface State {
+  cluster?: KafkaCluster;
+  clusterError?: string;
+}
 
 export class ConfigEditor extends PureComponent<Props, State> {
-  onAPIKeyChange = (event: ChangeEvent<HTMLInputElement>) => {
-    const { onOptionsChange, options } = this.props;
-    onOptionsChange({
-      ...options,
-      secureJsonData: {
-        apiKey: event.target.value,
-      },
-    });
+  state: State = {};
+
+  // The brokers are those of the saved settings.
+  onShowBrokers = async () => {
+    try {
+      const cluster = await getBackendSrv().get(`api/datasources/${this.props.options.id}/resources/cluster`);
+      this.setState({ cluster, clusterError: undefined });
+    } catch (err) {
+      this.setState({ cluster: undefined, clusterError: (err.data && err.data.message) || 'Error loading brokers' });
+    }
   };
 
-  onResetAPIKey = () => {
-    const { onOptionsChange, options } = this.props;
-    onOptionsChange({
-      ...options,
-      secureJsonFields: {
-        ...options.secureJsonFields,
-        apiKey: false,
-      },
-      secureJsonData: {
-        ...options.secureJsonData,
-        apiKey: '',
-      },
-    });
+  onSecretChange = (key: keyof KafkaSecureJsonData) => {
+    return (event: ChangeEvent<HTMLInputElement>) => {
+      const { onOptionsChange, options } = this.props;
+      onOptionsChange({
+        ...options,
+        secureJsonData: {
+          ...options.secureJsonData,
+          [key]: event.target.value,
+        },
+      });
+    };
+  };
+
+  onResetSecret = (key: keyof KafkaSecureJsonData) => {
+    return () => {
+      const { onOptionsChange, options } = this.props;
+      onOptionsChange({
+        ...options,
+        secureJsonFields: {
+          ...options.secureJsonFields,
+          [key]: false,
+        },
+        secureJsonData: {
+          ...options.secureJsonData,
+          [key]: '',
+        },
+      });
+    };
   };
 
   onBootstrapServersChange = (event: ChangeEvent<HTMLInputElement>) => {
@@ -44,8 +65,71 @@ export class ConfigEditor extends PureComponent<Props, State> {
     onOptionsChange({ ...options, jsonData });
   };
 
+  onAnnotationsChange = (key: 'annotationsUrl' | 'annotationsDashboardUid') => {
+    return (event: ChangeEvent<HTMLInputElement>) => {
+      const { onOptionsChange, options } = this.props;
+      const jsonData = {
+        ...options.jsonData,
+        [key]: event.target.value,
+      };
+      onOptionsChange({ ...options, jsonData });
+    };
+  };
+
+  onProduceTopicsChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onOptionsChange, options } = this.props;
+    const jsonData = {
+      ...options.jsonData,
+      produceTopics: event.target.value,
+    };
+    onOptionsChange({ ...options, jsonData });
+  };
+
+  onJsonLimitChange = (
+    key:
+      | 'jsonMaxDepth'
+      | 'jsonMaxSize'
+      | 'jsonMaxStringLength'
+      | 'maxStreams'
+      | 'maxBufferedBytes'
+      | 'maxMessagesPerSecond'
+      | 'maxBytesPerSecond'
+      | 'commitIntervalMs'
+  ) => {
+    return (event: ChangeEvent<HTMLInputElement>) => {
+      const { onOptionsChange, options } = this.props;
+      const jsonData = {
+        ...options.jsonData,
+        [key]: parseInt(event.target.value, 10) || undefined,
+      };
+      onOptionsChange({ ...options, jsonData });
+    };
+  };
+
+  onDataLinksChange = (dataLinks: KafkaDataLink[]) => {
+    const { onOptionsChange, options } = this.props;
+    onOptionsChange({ ...options, jsonData: { ...options.jsonData, dataLinks } });
+  };
+
+  onDataLinkChange = (index: number, key: keyof KafkaDataLink) => {
+    return (event: ChangeEvent<HTMLInputElement>) => {
+      const dataLinks = [...(this.props.options.jsonData.dataLinks || [])];
+      dataLinks[index] = { ...dataLinks[index], [key]: event.target.value };
+      this.onDataLinksChange(dataLinks);
+    };
+  };
+
+  onAddDataLink = () => {
+    this.onDataLinksChange([...(this.props.options.jsonData.dataLinks || []), { title: '', url: '' }]);
+  };
+
+  onRemoveDataLink = (index: number) => {
+    this.onDataLinksChange((this.props.options.jsonData.dataLinks || []).filter((_, i) => i !== index));
+  };
+
   render() {
     const { options } = this.props;
+    const { cluster, clusterError } = this.state;
     const { jsonData, secureJsonFields } = options;
     const secureJsonData = (options.secureJsonData || {}) as KafkaSecureJsonData;
 
@@ -60,17 +144,186 @@ export class ConfigEditor extends PureComponent<Props, State> {
           />
         </div>
 
+        <div className="gf-form">
+          <Button variant="secondary" onClick={this.onShowBrokers}>
+            Show brokers
+          </Button>
+        </div>
+        {clusterError && <div className="gf-form">{clusterError}</div>}
+        {cluster &&
+          cluster.brokers.map((broker) => (
+            <div className="gf-form" key={broker.id}>
+              {`Broker ${broker.id}: ${broker.host}:${broker.port}`}
+            </div>
+          ))}
+
+        <h3 className="page-heading">JSON decoding limits</h3>
+        <div className="gf-form">
+          <FormField
+            label="Max depth"
+            type="number"
+            onChange={this.onJsonLimitChange('jsonMaxDepth')}
+            value={jsonData.jsonMaxDepth || ''}
+            placeholder="64"
+            tooltip="Maximum nesting depth of objects and arrays in a message."
+          />
+        </div>
+        <div className="gf-form">
+          <FormField
+            label="Max size"
+            type="number"
+            onChange={this.onJsonLimitChange('jsonMaxSize')}
+            value={jsonData.jsonMaxSize || ''}
+            placeholder="4194304"
+            tooltip="Maximum size of a message in bytes."
+          />
+        </div>
+        <div className="gf-form">
+          <FormField
+            label="Max string length"
+            type="number"
+            onChange={this.onJsonLimitChange('jsonMaxStringLength')}
+            value={jsonData.jsonMaxStringLength || ''}
+            placeholder="1048576"
+            tooltip="Maximum length of a single string in a message in bytes."
+          />
+        </div>
+
+        <h3 className="page-heading">Resource limits</h3>
+        <div className="gf-form">
+          <FormField
+            label="Max streams"
+            type="number"
+            onChange={this.onJsonLimitChange('maxStreams')}
+            value={jsonData.maxStreams || ''}
+            placeholder="unlimited"
+            tooltip="Maximum number of streams, each with its own consumer, run by the datasource."
+          />
+        </div>
+        <div className="gf-form">
+          <FormField
+            label="Max buffered bytes"
+            type="number"
+            onChange={this.onJsonLimitChange('maxBufferedBytes')}
+            value={jsonData.maxBufferedBytes || ''}
+            placeholder="unlimited"
+            tooltip="Estimated bytes of messages held by the streams, reference tables and time range reads."
+          />
+        </div>
+        <div className="gf-form">
+          <FormField
+            label="Max messages/s"
+            type="number"
+            onChange={this.onJsonLimitChange('maxMessagesPerSecond')}
+            value={jsonData.maxMessagesPerSecond || ''}
+            placeholder="unlimited"
+            tooltip="Messages consumed per second by all streams together, beyond which they are throttled."
+          />
+        </div>
+        <div className="gf-form">
+          <FormField
+            label="Max bytes/s"
+            type="number"
+            onChange={this.onJsonLimitChange('maxBytesPerSecond')}
+            value={jsonData.maxBytesPerSecond || ''}
+            placeholder="unlimited"
+            tooltip="Message bytes consumed per second by all streams together, beyond which they are throttled."
+          />
+        </div>
+
+        <h3 className="page-heading">Consumer groups</h3>
+        <div className="gf-form">
+          <FormField
+            label="Commit interval"
+            type="number"
+            onChange={this.onJsonLimitChange('commitIntervalMs')}
+            value={jsonData.commitIntervalMs || ''}
+            placeholder="5000"
+            tooltip="How often, in milliseconds, streams consuming as a consumer group commit their offsets."
+          />
+        </div>
+
+        <h3 className="page-heading">Annotations</h3>
+        <div className="gf-form">
+          <FormField
+            label="Grafana URL"
+            inputWidth={20}
+            onChange={this.onAnnotationsChange('annotationsUrl')}
+            value={jsonData.annotationsUrl || ''}
+            placeholder="http://localhost:3000"
+            tooltip="Grafana whose HTTP API streams starting, stopping and failing are written to as annotations."
+          />
+        </div>
+        <div className="gf-form">
+          <SecretFormField
+            isConfigured={(secureJsonFields && secureJsonFields.annotationsToken) as boolean}
+            value={secureJsonData.annotationsToken || ''}
+            label="Grafana API token"
+            placeholder="Token with the Editor role"
+            inputWidth={20}
+            onReset={this.onResetSecret('annotationsToken')}
+            onChange={this.onSecretChange('annotationsToken')}
+          />
+        </div>
+        <div className="gf-form">
+          <FormField
+            label="Dashboard UID"
+            inputWidth={20}
+            onChange={this.onAnnotationsChange('annotationsDashboardUid')}
+            value={jsonData.annotationsDashboardUid || ''}
+            placeholder="organization"
+            tooltip="Restricts the annotations to the dashboard, rather than the organization."
+          />
+        </div>
+
+        <h3 className="page-heading">Producing messages</h3>
+        <div className="gf-form">
+          <FormField
+            label="Writable topics"
+            inputWidth={20}
+            onChange={this.onProduceTopicsChange}
+            value={jsonData.produceTopics || ''}
+            placeholder="none"
+            tooltip="Comma separated topics editors can write messages to through the produce resource, e.g. from alert webhooks."
+          />
+        </div>
+
+        <h3 className="page-heading">Data links</h3>
+        {(jsonData.dataLinks || []).map((link, index) => (
+          <div className="gf-form-inline" key={index}>
+            <div className="gf-form">
+              <FormField label="Title" onChange={this.onDataLinkChange(index, 'title')} value={link.title} />
+            </div>
+            <div className="gf-form">
+              <FormField
+                label="URL"
+                inputWidth={30}
+                onChange={this.onDataLinkChange(index, 'url')}
+                value={link.url}
+                placeholder="http://akhq/ui/cluster/topic/${topic}/data?partition=${partition}&offset=${offset}"
+                tooltip="The ${topic}, ${partition} and ${offset} of the message can be used in the URL."
+              />
+            </div>
+            <Button variant="secondary" icon="trash-alt" onClick={() => this.onRemoveDataLink(index)} />
+          </div>
+        ))}
+        <div className="gf-form">
+          <Button variant="secondary" icon="plus" onClick={this.onAddDataLink}>
+            Add data link
+          </Button>
+        </div>
+
         <div className="gf-form-inline">
           <div className="gf-form">
             <SecretFormField
               isConfigured={(secureJsonFields && secureJsonFields.apiKey) as boolean}
               value={secureJsonData.apiKey || ''}
               label="API Key"
-              placeholder="secure json field (backend only)"
+              placeholder="Event Hubs connection string"
               labelWidth={6}
               inputWidth={20}
-              onReset={this.onResetAPIKey}
-              onChange={this.onAPIKeyChange}
+              onReset={this.onResetSecret('apiKey')}
+              onChange={this.onSecretChange('apiKey')}
             />
           </div>
         </div>
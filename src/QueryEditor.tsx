@@ -1,9 +1,27 @@
-import { defaults } from 'lodash';
+import { debounce, defaults } from 'lodash';
 import React, { ChangeEvent, PureComponent, SyntheticEvent } from 'react';
-import { InlineFormLabel, InlineFieldRow, Select, Switch } from '@grafana/ui';
+import { Alert, Button, InlineFormLabel, InlineFieldRow, Select, Switch } from '@grafana/ui';
 import { QueryEditorProps, SelectableValue } from '@grafana/data';
 import { DataSource } from './datasource';
-import { defaultQuery, KafkaDataSourceOptions, KafkaQuery, AutoOffsetReset, TimestampMode } from './types';
+import {
+  defaultQuery,
+  KafkaDataSourceOptions,
+  KafkaDiscoveredField,
+  KafkaQuery,
+  KafkaSample,
+  KafkaTopicConfig,
+  AutoOffsetReset,
+  TimestampMode,
+  InvalidUtf8Mode,
+  MessageFormat,
+  OutputMode,
+  KafkaThresholdRule,
+  ThresholdAction,
+  DropPolicy,
+  LatePolicy,
+  ArrayItems,
+  GapFill,
+} from './types';
 
 const autoResetOffsets = [
   {
@@ -31,9 +49,300 @@ const timestampModes = [
   },
 ] as Array<SelectableValue<TimestampMode>>;
 
+const invalidUtf8Modes = [
+  {
+    label: 'Replace',
+    value: InvalidUtf8Mode.Replace,
+    description: 'Replace invalid UTF-8 sequences with the replacement character',
+  },
+  {
+    label: 'Strip',
+    value: InvalidUtf8Mode.Strip,
+    description: 'Remove invalid UTF-8 sequences',
+  },
+] as Array<SelectableValue<InvalidUtf8Mode>>;
+
+const messageFormats = [
+  {
+    label: 'JSON',
+    value: MessageFormat.JSON,
+    description: 'Plain JSON messages',
+  },
+  {
+    label: 'JSON Schema',
+    value: MessageFormat.JSONSchema,
+    description: 'JSON messages of the Confluent JSON Schema serializer',
+  },
+  {
+    label: 'MessagePack',
+    value: MessageFormat.MessagePack,
+    description: 'MessagePack maps, flattened like JSON objects',
+  },
+  {
+    label: 'CBOR',
+    value: MessageFormat.CBOR,
+    description: 'CBOR maps, flattened like JSON objects',
+  },
+  {
+    label: 'Raw',
+    value: MessageFormat.Raw,
+    description: 'Skip decoding and show the message as a single message field',
+  },
+] as Array<SelectableValue<MessageFormat | string>>;
+
+const outputModes = [
+  {
+    label: 'Fields',
+    value: OutputMode.Fields,
+    description: 'Flatten each message into fields',
+  },
+  {
+    label: 'Traces',
+    value: OutputMode.Traces,
+    description: 'Show OTLP, Zipkin or Jaeger spans in the trace view',
+  },
+  {
+    label: 'Histogram',
+    value: OutputMode.Histogram,
+    description: 'Bucket a numeric field per interval for heatmaps',
+  },
+] as Array<SelectableValue<OutputMode>>;
+
+const thresholdOperators = ['>', '>=', '<', '<=', '==', '!='].map((operator) => ({
+  label: operator,
+  value: operator,
+})) as Array<SelectableValue<KafkaThresholdRule['operator']>>;
+
+const thresholdActions = [
+  {
+    label: 'Tag',
+    value: ThresholdAction.Tag,
+    description: 'Add an alert field telling whether a rule matched',
+  },
+  {
+    label: 'Filter',
+    value: ThresholdAction.Filter,
+    description: 'Only show messages matching a rule',
+  },
+] as Array<SelectableValue<ThresholdAction>>;
+
+const dropPolicies = [
+  {
+    label: 'Drop newest',
+    value: DropPolicy.Newest,
+    description: 'Drop incoming messages while the panel is behind',
+  },
+  {
+    label: 'Drop oldest',
+    value: DropPolicy.Oldest,
+    description: 'Drop the oldest pending messages to show the latest ones',
+  },
+] as Array<SelectableValue<DropPolicy>>;
+
+const latePolicies = [
+  {
+    label: 'Drop',
+    value: LatePolicy.Drop,
+    description: 'Drop late messages, counted in the lateDropped custom meta',
+  },
+  {
+    label: 'Emit',
+    value: LatePolicy.Emit,
+    description: 'Emit late messages right away',
+  },
+  {
+    label: 'Tag',
+    value: LatePolicy.Tag,
+    description: 'Emit late messages right away and add a late field to every message',
+  },
+] as Array<SelectableValue<LatePolicy>>;
+
+const gapFills = [
+  { label: 'None', value: GapFill.None, description: 'Leave the intervals without messages empty' },
+  { label: 'Null', value: GapFill.Null, description: 'Add a row of nulls to the intervals without messages' },
+  { label: 'Zero', value: GapFill.Zero, description: 'Add a row of zeros to the intervals without messages' },
+  {
+    label: 'Previous',
+    value: GapFill.Previous,
+    description: 'Repeat the previous row in the intervals without messages',
+  },
+] as Array<SelectableValue<GapFill>>;
+
+const arrayItemsModes = [
+  {
+    label: 'Fields',
+    value: ArrayItems.Fields,
+    description: 'Make every item of top-level arrays a field named after the key template',
+  },
+  {
+    label: 'Rows',
+    value: ArrayItems.Rows,
+    description: 'Make every item of top-level arrays a row of its own',
+  },
+] as Array<SelectableValue<ArrayItems>>;
+
+// A day, under which the retention of topics is short enough to warn about.
+const SHORT_RETENTION_MS = 24 * 60 * 60 * 1000;
+
+// topicConfigWarning warns about topics whose messages may be gone by the time
+// they are queried.
+function topicConfigWarning(config: KafkaTopicConfig): string | undefined {
+  const warnings = [];
+  if ((config.configs['cleanup.policy'] || '').includes('compact')) {
+    warnings.push('The topic is compacted: only the latest message of every key is retained.');
+  }
+  const retention = parseInt(config.configs['retention.ms'], 10);
+  if (retention > 0 && retention < SHORT_RETENTION_MS) {
+    warnings.push(`Messages of the topic are only retained for ${Math.round(retention / 60000)} minutes.`);
+  }
+  return warnings.length > 0 ? warnings.join(' ') : undefined;
+}
+
 type Props = QueryEditorProps<DataSource, KafkaQuery, KafkaDataSourceOptions>;
 
-export class QueryEditor extends PureComponent<Props> {
+interface State {
+  topicWarning?: string;
+  preview?: KafkaSample;
+  previewError?: string;
+  fields?: KafkaDiscoveredField[];
+}
+
+// previewColumns returns the union of the fields of the previewed messages,
+// sorted.
+function previewColumns(sample: KafkaSample): string[] {
+  const columns = new Set<string>();
+  for (const msg of sample.messages) {
+    Object.keys(msg.fields || {}).forEach((name) => columns.add(name));
+  }
+  return Array.from(columns).sort();
+}
+
+function previewValue(value: unknown): string {
+  if (value === undefined) {
+    return '';
+  }
+  return typeof value === 'string' ? value : JSON.stringify(value);
+}
+
+export class QueryEditor extends PureComponent<Props, State> {
+  state: State = {};
+
+  componentDidMount() {
+    this.checkTopicConfig();
+    this.discoverFields();
+  }
+
+  componentDidUpdate(prevProps: Props) {
+    const { query } = this.props;
+    if (prevProps.query.topicName !== query.topicName) {
+      this.checkTopicConfig();
+    }
+    if (
+      prevProps.query.topicName !== query.topicName ||
+      prevProps.query.messageFormat !== query.messageFormat ||
+      prevProps.query.rawPattern !== query.rawPattern
+    ) {
+      this.discoverFields();
+    }
+  }
+
+  componentWillUnmount() {
+    this.checkTopicConfig.cancel();
+    this.discoverFields.cancel();
+  }
+
+  // The topic is checked once typed in, rather than on every keystroke.
+  checkTopicConfig = debounce(async () => {
+    const { datasource, query } = this.props;
+    if (!query.topicName) {
+      this.setState({ topicWarning: undefined });
+      return;
+    }
+    try {
+      const config = await datasource.getTopicConfig(query.topicName);
+      this.setState({ topicWarning: topicConfigWarning(config) });
+    } catch (err) {
+      this.setState({ topicWarning: undefined });
+    }
+  }, 500);
+
+  // The fields of the topic are offered by the inputs naming fields.
+  discoverFields = debounce(async () => {
+    const { datasource, query } = this.props;
+    if (!query.topicName) {
+      this.setState({ fields: undefined });
+      return;
+    }
+    try {
+      const discovery = await datasource.discoverFields(query);
+      this.setState({ fields: discovery.fields });
+    } catch (err) {
+      this.setState({ fields: undefined });
+    }
+  }, 500);
+
+  onPreview = async () => {
+    const { datasource, query } = this.props;
+    try {
+      this.setState({ preview: await datasource.getSample(query), previewError: undefined });
+    } catch (err) {
+      this.setState({ preview: undefined, previewError: (err.data && err.data.message) || 'Error loading preview' });
+    }
+  };
+
+  onClosePreview = () => {
+    this.setState({ preview: undefined, previewError: undefined });
+  };
+
+  fieldsListId() {
+    return `kafka-fields-${this.props.query.refId}`;
+  }
+
+  renderPreview() {
+    const { preview, previewError } = this.state;
+    if (previewError) {
+      return <Alert severity="error" title={previewError} onRemove={this.onClosePreview} />;
+    }
+    if (!preview) {
+      return null;
+    }
+    if (preview.messages.length === 0) {
+      return <Alert severity="info" title="The topic holds no messages." onRemove={this.onClosePreview} />;
+    }
+    const columns = previewColumns(preview);
+    return (
+      <div className="gf-form">
+        <table className="filter-table">
+          <thead>
+            <tr>
+              <th>Partition</th>
+              <th>Offset</th>
+              <th>Timestamp</th>
+              {columns.map((name) => (
+                <th key={name}>{name}</th>
+              ))}
+            </tr>
+          </thead>
+          <tbody>
+            {preview.messages.map((msg) => (
+              <tr key={`${msg.partition}/${msg.offset}`}>
+                <td>{msg.partition}</td>
+                <td>{msg.offset}</td>
+                <td>{msg.timestamp}</td>
+                {msg.error ? (
+                  <td colSpan={columns.length}>{msg.error}</td>
+                ) : (
+                  columns.map((name) => <td key={name}>{previewValue(msg.fields?.[name])}</td>)
+                )}
+              </tr>
+            ))}
+          </tbody>
+        </table>
+        <Button variant="secondary" icon="times" onClick={this.onClosePreview} />
+      </div>
+    );
+  }
+
   onTopicNameChange = (event: ChangeEvent<HTMLInputElement>) => {
     const { onChange, query, onRunQuery } = this.props;
     onChange({ ...query, topicName: event.target.value });
@@ -46,12 +355,24 @@ export class QueryEditor extends PureComponent<Props> {
     onRunQuery();
   };
 
+  onAllPartitionsChange = (event: SyntheticEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, partition: event.currentTarget.checked ? 'all' : 0 });
+    onRunQuery();
+  };
+
   onWithStreamingChange = (event: SyntheticEvent<HTMLInputElement>) => {
     const { onChange, query, onRunQuery } = this.props;
     onChange({ ...query, withStreaming: event.currentTarget.checked });
     onRunQuery();
   };
 
+  onPollingChange = (event: SyntheticEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, polling: event.currentTarget.checked });
+    onRunQuery();
+  };
+
   onAutoResetOffsetChanged = (selected: SelectableValue<AutoOffsetReset>) => {
     const { onChange, query, onRunQuery } = this.props;
     onChange({ ...query, autoOffsetReset: selected.value || AutoOffsetReset.LATEST });
@@ -78,67 +399,1102 @@ export class QueryEditor extends PureComponent<Props> {
     return timestampModes[1];
   };
 
-  render() {
-    const query = defaults(this.props.query, defaultQuery);
-    const { topicName, partition, withStreaming, autoOffsetReset, timestampMode } = query;
+  onPivotNumericKeysChange = (event: SyntheticEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, pivotNumericKeys: event.currentTarget.checked });
+    onRunQuery();
+  };
 
-    return (
-      <>
-        <div className="gf-form">
-          <InlineFieldRow>
-            <InlineFormLabel width={10}>Topic</InlineFormLabel>
-            <input
-              className="gf-form-input width-14"
-              value={topicName || ''}
-              onChange={this.onTopicNameChange}
-              type="text"
-            />
-            <InlineFormLabel width={10}>Partition</InlineFormLabel>
-            <input
-              className="gf-form-input width-14"
-              value={partition}
-              onChange={this.onPartitionChange}
-              type="number"
-              step="1"
-              min="0"
-            />
-            <InlineFormLabel>
-              Enable streaming <small>(v8+)</small>
-            </InlineFormLabel>
-            <div className="add-data-source-item-badge">
-              <Switch css checked={withStreaming || false} onChange={this.onWithStreamingChange} />
-            </div>
-          </InlineFieldRow>
-        </div>
-        <div className="gf-form">
-          <InlineFieldRow>
-            <InlineFormLabel
-              className="width-5"
-              tooltip="Starting offset to consume that can be from latest or last 100."
-            >
-              Auto offset reset
-            </InlineFormLabel>
-            <div className="gf-form--has-input-icon">
-              <Select
-                className="width-14"
-                value={this.resolveAutoResetOffset(autoOffsetReset)}
-                options={autoResetOffsets}
-                defaultValue={autoResetOffsets[0]}
-                onChange={this.onAutoResetOffsetChanged}
-              />
-            </div>
-            <InlineFormLabel tooltip="Timestamp of the kafka value to visualize.">Timestamp Mode</InlineFormLabel>
-            <div className="gf-form--has-input-icon">
-              <Select
-                className="width-14"
-                value={this.resolveTimestampMode(timestampMode)}
-                options={timestampModes}
-                defaultValue={timestampModes[0]}
-                onChange={this.onTimestampModeChanged}
-              />
-            </div>
-          </InlineFieldRow>
-        </div>
+  onEmptyKeyNameChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, emptyKeyName: event.target.value });
+    onRunQuery();
+  };
+
+  onMessageStatsChange = (event: SyntheticEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, messageStats: event.currentTarget.checked });
+    onRunQuery();
+  };
+
+  onInvalidUtf8Changed = (selected: SelectableValue<InvalidUtf8Mode>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, invalidUtf8: selected.value || InvalidUtf8Mode.Replace });
+    onRunQuery();
+  };
+
+  resolveInvalidUtf8Mode = (value: string | undefined) => {
+    if (value === InvalidUtf8Mode.Strip) {
+      return invalidUtf8Modes[1];
+    }
+    return invalidUtf8Modes[0];
+  };
+
+  onMarkExplicitNullsChange = (event: SyntheticEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, markExplicitNulls: event.currentTarget.checked });
+    onRunQuery();
+  };
+
+  onTraceIdFieldChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, traceIdField: event.target.value });
+    onRunQuery();
+  };
+
+  onSpanIdFieldChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, spanIdField: event.target.value });
+    onRunQuery();
+  };
+
+  onLatitudeFieldChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, latitudeField: event.target.value });
+    onRunQuery();
+  };
+
+  onLongitudeFieldChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, longitudeField: event.target.value });
+    onRunQuery();
+  };
+
+  onLocationFieldChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, locationField: event.target.value });
+    onRunQuery();
+  };
+
+  onEnrichmentTopicChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, enrichmentTopic: event.target.value });
+    onRunQuery();
+  };
+
+  onEnrichmentFieldsChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, enrichmentFields: event.target.value });
+    onRunQuery();
+  };
+
+  onLookupFieldChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, lookupField: event.target.value });
+    onRunQuery();
+  };
+
+  onLookupTableChange = (event: ChangeEvent<HTMLTextAreaElement>) => {
+    const { onChange, query } = this.props;
+    onChange({ ...query, lookupTable: event.target.value });
+  };
+
+  onLookupTableBlur = () => {
+    this.props.onRunQuery();
+  };
+
+  onOnlyChangesChange = (event: SyntheticEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, onlyChanges: event.currentTarget.checked });
+    onRunQuery();
+  };
+
+  onChangeFieldsChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, changeFields: event.target.value });
+    onRunQuery();
+  };
+
+  onChangeHeartbeatChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, changeHeartbeat: event.target.value });
+    onRunQuery();
+  };
+
+  onThresholdRulesChange = (thresholdRules: KafkaThresholdRule[]) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, thresholdRules });
+    onRunQuery();
+  };
+
+  onThresholdRuleFieldChange = (index: number) => (event: ChangeEvent<HTMLInputElement>) => {
+    const thresholdRules = [...(this.props.query.thresholdRules || [])];
+    thresholdRules[index] = { ...thresholdRules[index], field: event.target.value };
+    this.onThresholdRulesChange(thresholdRules);
+  };
+
+  onThresholdRuleOperatorChange = (index: number) => (selected: SelectableValue<KafkaThresholdRule['operator']>) => {
+    const thresholdRules = [...(this.props.query.thresholdRules || [])];
+    thresholdRules[index] = { ...thresholdRules[index], operator: selected.value || '>' };
+    this.onThresholdRulesChange(thresholdRules);
+  };
+
+  onThresholdRuleValueChange = (index: number) => (event: ChangeEvent<HTMLInputElement>) => {
+    const thresholdRules = [...(this.props.query.thresholdRules || [])];
+    thresholdRules[index] = { ...thresholdRules[index], value: event.target.value };
+    this.onThresholdRulesChange(thresholdRules);
+  };
+
+  onAddThresholdRule = () => {
+    this.onThresholdRulesChange([...(this.props.query.thresholdRules || []), { field: '', operator: '>', value: '' }]);
+  };
+
+  onRemoveThresholdRule = (index: number) => {
+    this.onThresholdRulesChange((this.props.query.thresholdRules || []).filter((_, i) => i !== index));
+  };
+
+  onThresholdActionChanged = (selected: SelectableValue<ThresholdAction>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, thresholdAction: selected.value || ThresholdAction.Tag });
+    onRunQuery();
+  };
+
+  onDropPolicyChanged = (selected: SelectableValue<DropPolicy>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, dropPolicy: selected.value || DropPolicy.Newest });
+    onRunQuery();
+  };
+
+  onInitialSchemaChange = (event: SyntheticEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, initialSchema: event.currentTarget.checked });
+    onRunQuery();
+  };
+
+  onConsumerStatsChange = (event: SyntheticEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, consumerStats: event.currentTarget.checked });
+    onRunQuery();
+  };
+
+  onOffsetCheckpointsChange = (event: SyntheticEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, offsetCheckpoints: event.currentTarget.checked });
+    onRunQuery();
+  };
+
+  onErrorBudgetChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, errorBudget: parseInt(event.target.value, 10) || undefined });
+    onRunQuery();
+  };
+
+  onErrorCooldownChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, errorCooldown: event.target.value });
+    onRunQuery();
+  };
+
+  onSummaryAboveBytesChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, summaryAboveBytes: parseInt(event.target.value, 10) || undefined });
+    onRunQuery();
+  };
+
+  onSummaryFieldsChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, summaryFields: event.target.value });
+    onRunQuery();
+  };
+
+  onStrictDecodeChange = (event: SyntheticEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, strictDecode: event.currentTarget.checked });
+    onRunQuery();
+  };
+
+  onRequiredFieldsChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, requiredFields: event.target.value });
+    onRunQuery();
+  };
+
+  onReorderDelayChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, reorderDelay: event.target.value });
+    onRunQuery();
+  };
+
+  onMaxLatenessChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, maxLateness: event.target.value });
+    onRunQuery();
+  };
+
+  onLatePolicyChanged = (selected: SelectableValue<LatePolicy>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, latePolicy: selected.value || LatePolicy.Drop });
+    onRunQuery();
+  };
+
+  onMaxStringLengthChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, maxStringLength: parseInt(event.target.value, 10) || undefined });
+    onRunQuery();
+  };
+
+  onBinaryFieldsChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, binaryFields: event.target.value });
+    onRunQuery();
+  };
+
+  onConsumerGroupChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, consumerGroup: event.target.value });
+    onRunQuery();
+  };
+
+  onMaxQueryDurationChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, maxQueryDuration: event.target.value });
+    onRunQuery();
+  };
+
+  onGapFillChanged = (selected: SelectableValue<GapFill>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, gapFill: selected.value || GapFill.None });
+    onRunQuery();
+  };
+
+  onGapFillIntervalChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, gapFillInterval: event.target.value });
+    onRunQuery();
+  };
+
+  onRawPatternChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, rawPattern: event.target.value });
+    onRunQuery();
+  };
+
+  onArrayItemsChanged = (selected: SelectableValue<ArrayItems>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, arrayItems: selected.value || ArrayItems.Fields });
+    onRunQuery();
+  };
+
+  onItemKeyTemplateChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, itemKeyTemplate: event.target.value });
+    onRunQuery();
+  };
+
+  onMessageFormatChanged = (selected: SelectableValue<MessageFormat | string>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, messageFormat: selected.value || MessageFormat.JSON });
+    onRunQuery();
+  };
+
+  onOutputModeChanged = (selected: SelectableValue<OutputMode>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, outputMode: selected.value || OutputMode.Fields });
+    onRunQuery();
+  };
+
+  resolveOutputMode = (value: string | undefined) => {
+    if (value === OutputMode.Traces) {
+      return outputModes[1];
+    }
+    if (value === OutputMode.Histogram) {
+      return outputModes[2];
+    }
+    return outputModes[0];
+  };
+
+  onHistogramFieldChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, histogramField: event.target.value });
+    onRunQuery();
+  };
+
+  onHistogramBucketsChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, histogramBuckets: event.target.value });
+    onRunQuery();
+  };
+
+  onHistogramIntervalChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, histogramInterval: event.target.value });
+    onRunQuery();
+  };
+
+  render() {
+    const query = defaults(this.props.query, defaultQuery);
+    const { topicWarning } = this.state;
+    const {
+      topicName,
+      partition,
+      withStreaming,
+      polling,
+      autoOffsetReset,
+      timestampMode,
+      pivotNumericKeys,
+      emptyKeyName,
+      messageStats,
+      invalidUtf8,
+      markExplicitNulls,
+      traceIdField,
+      spanIdField,
+      outputMode,
+      histogramField,
+      histogramBuckets,
+      histogramInterval,
+      latitudeField,
+      longitudeField,
+      locationField,
+      enrichmentTopic,
+      enrichmentFields,
+      lookupField,
+      lookupTable,
+      onlyChanges,
+      changeFields,
+      changeHeartbeat,
+      thresholdRules,
+      thresholdAction,
+      dropPolicy,
+      initialSchema,
+      consumerStats,
+      errorBudget,
+      errorCooldown,
+      summaryAboveBytes,
+      summaryFields,
+      strictDecode,
+      requiredFields,
+      reorderDelay,
+      maxLateness,
+      latePolicy,
+      maxStringLength,
+      binaryFields,
+      consumerGroup,
+      offsetCheckpoints,
+      maxQueryDuration,
+      gapFill,
+      gapFillInterval,
+      messageFormat,
+      rawPattern,
+      arrayItems,
+      itemKeyTemplate,
+    } = query;
+
+    return (
+      <>
+        {topicWarning && <Alert severity="warning" title={topicWarning} />}
+        <div className="gf-form">
+          <InlineFieldRow>
+            <InlineFormLabel width={10}>Topic</InlineFormLabel>
+            <input
+              className="gf-form-input width-14"
+              value={topicName || ''}
+              onChange={this.onTopicNameChange}
+              type="text"
+            />
+            <InlineFormLabel width={10}>Partition</InlineFormLabel>
+            <input
+              className="gf-form-input width-14"
+              value={partition === 'all' ? '' : partition}
+              onChange={this.onPartitionChange}
+              type="number"
+              step="1"
+              min="0"
+              disabled={partition === 'all'}
+            />
+            <InlineFormLabel tooltip="Consume every partition of the topic.">All partitions</InlineFormLabel>
+            <div className="add-data-source-item-badge">
+              <Switch css checked={partition === 'all'} onChange={this.onAllPartitionsChange} />
+            </div>
+            <InlineFormLabel
+              className="width-10"
+              tooltip="Consume all partitions as a member of this consumer group, sharing them with the streams of other Grafana instances and resuming from the committed offsets."
+            >
+              Consumer group
+            </InlineFormLabel>
+            <input
+              className="gf-form-input width-14"
+              value={consumerGroup || ''}
+              onChange={this.onConsumerGroupChange}
+              disabled={partition !== 'all'}
+              type="text"
+            />
+            <InlineFormLabel>
+              Enable streaming <small>(v8+)</small>
+            </InlineFormLabel>
+            <div className="add-data-source-item-badge">
+              <Switch css checked={withStreaming || false} onChange={this.onWithStreamingChange} />
+            </div>
+            <InlineFormLabel
+              className="width-10"
+              tooltip="Poll for new messages every second rather than streaming them over Grafana Live, e.g. behind proxies breaking WebSockets. Streaming queries also poll while Live is disconnected."
+            >
+              Poll
+            </InlineFormLabel>
+            <div className="add-data-source-item-badge">
+              <Switch css checked={polling || false} disabled={!withStreaming} onChange={this.onPollingChange} />
+            </div>
+            <InlineFormLabel
+              className="width-10"
+              tooltip="Stop reading the time range after this long, e.g. 20s, and show the messages read so far."
+            >
+              Max query duration
+            </InlineFormLabel>
+            <input
+              className="gf-form-input width-14"
+              value={maxQueryDuration || ''}
+              onChange={this.onMaxQueryDurationChange}
+              disabled={withStreaming}
+              type="text"
+            />
+            <Button
+              variant="secondary"
+              icon="eye"
+              onClick={this.onPreview}
+              disabled={!topicName}
+              title="Show the fields of the latest messages of the topic."
+            >
+              Preview
+            </Button>
+          </InlineFieldRow>
+        </div>
+        {this.renderPreview()}
+        <datalist id={this.fieldsListId()}>
+          {(this.state.fields || []).map((field) => (
+            <option key={field.name} value={field.name}>
+              {field.types.join(', ')}
+            </option>
+          ))}
+        </datalist>
+        <div className="gf-form">
+          <InlineFieldRow>
+            <InlineFormLabel
+              className="width-10"
+              tooltip="Add a row to every interval without messages of the time range, so that sparse topics render as continuous lines."
+            >
+              Gap fill
+            </InlineFormLabel>
+            <div className="gf-form--has-input-icon">
+              <Select
+                className="width-14"
+                value={gapFills.find((g) => g.value === (gapFill || GapFill.None)) || gapFills[0]}
+                options={gapFills}
+                defaultValue={gapFills[0]}
+                onChange={this.onGapFillChanged}
+                disabled={withStreaming}
+              />
+            </div>
+            <InlineFormLabel className="width-10" tooltip="Length of the intervals, aligned on the epoch, e.g. 1m.">
+              Gap fill interval
+            </InlineFormLabel>
+            <input
+              className="gf-form-input width-14"
+              value={gapFillInterval || ''}
+              onChange={this.onGapFillIntervalChange}
+              disabled={withStreaming || !gapFill}
+              type="text"
+            />
+          </InlineFieldRow>
+        </div>
+        <div className="gf-form">
+          <InlineFieldRow>
+            <InlineFormLabel
+              className="width-5"
+              tooltip="Starting offset to consume that can be from latest or last 100."
+            >
+              Auto offset reset
+            </InlineFormLabel>
+            <div className="gf-form--has-input-icon">
+              <Select
+                className="width-14"
+                value={this.resolveAutoResetOffset(autoOffsetReset)}
+                options={autoResetOffsets}
+                defaultValue={autoResetOffsets[0]}
+                onChange={this.onAutoResetOffsetChanged}
+              />
+            </div>
+            <InlineFormLabel tooltip="Timestamp of the kafka value to visualize.">Timestamp Mode</InlineFormLabel>
+            <div className="gf-form--has-input-icon">
+              <Select
+                className="width-14"
+                value={this.resolveTimestampMode(timestampMode)}
+                options={timestampModes}
+                defaultValue={timestampModes[0]}
+                onChange={this.onTimestampModeChanged}
+              />
+            </div>
+          </InlineFieldRow>
+        </div>
+        <div className="gf-form">
+          <InlineFieldRow>
+            <InlineFormLabel
+              className="width-10"
+              tooltip="Move numeric identifiers in nested keys (e.g. counters.155.value) into labels instead of creating a column per identifier."
+            >
+              Pivot numeric keys
+            </InlineFormLabel>
+            <div className="add-data-source-item-badge">
+              <Switch css checked={pivotNumericKeys || false} onChange={this.onPivotNumericKeysChange} />
+            </div>
+            <InlineFormLabel
+              className="width-10"
+              tooltip="Name used for empty object keys in field names. Empty keys are dropped when left blank."
+            >
+              Empty key name
+            </InlineFormLabel>
+            <input
+              className="gf-form-input width-14"
+              value={emptyKeyName || ''}
+              onChange={this.onEmptyKeyNameChange}
+              type="text"
+            />
+            <InlineFormLabel
+              className="width-10"
+              tooltip="Add the message size as a __bytes field and the rolling stream throughput as stats."
+            >
+              Message stats
+            </InlineFormLabel>
+            <div className="add-data-source-item-badge">
+              <Switch css checked={messageStats || false} onChange={this.onMessageStatsChange} />
+            </div>
+            <InlineFormLabel
+              className="width-10"
+              tooltip="Add the fetches, bytes, rebalances, errors and lag of the consumer to the frame meta."
+            >
+              Consumer stats
+            </InlineFormLabel>
+            <div className="add-data-source-item-badge">
+              <Switch css checked={consumerStats || false} onChange={this.onConsumerStatsChange} />
+            </div>
+            <InlineFormLabel
+              className="width-10"
+              tooltip="Add the latest offset consumed per partition to the frame meta every 10 seconds."
+            >
+              Offset checkpoints
+            </InlineFormLabel>
+            <div className="add-data-source-item-badge">
+              <Switch css checked={offsetCheckpoints || false} onChange={this.onOffsetCheckpointsChange} />
+            </div>
+            <InlineFormLabel className="width-10" tooltip="How invalid UTF-8 sequences in strings are handled.">
+              Invalid UTF-8
+            </InlineFormLabel>
+            <div className="gf-form--has-input-icon">
+              <Select
+                className="width-14"
+                value={this.resolveInvalidUtf8Mode(invalidUtf8)}
+                options={invalidUtf8Modes}
+                defaultValue={invalidUtf8Modes[0]}
+                onChange={this.onInvalidUtf8Changed}
+              />
+            </div>
+            <InlineFormLabel
+              className="width-10"
+              tooltip="Emit a <field>__present field for fields explicitly set to null, to tell them apart from missing fields."
+            >
+              Mark explicit nulls
+            </InlineFormLabel>
+            <div className="add-data-source-item-badge">
+              <Switch css checked={markExplicitNulls || false} onChange={this.onMarkExplicitNullsChange} />
+            </div>
+            <InlineFormLabel
+              className="width-10"
+              tooltip="Render the columns of the latest message of the topic before the first message arrives."
+            >
+              Initial schema
+            </InlineFormLabel>
+            <div className="add-data-source-item-badge">
+              <Switch css checked={initialSchema || false} onChange={this.onInitialSchemaChange} />
+            </div>
+          </InlineFieldRow>
+        </div>
+        <div className="gf-form">
+          <InlineFieldRow>
+            <InlineFormLabel
+              className="width-10"
+              tooltip="Field holding the trace ID, or header:<name> for a message header. It is exposed as traceID for correlations."
+            >
+              Trace ID field
+            </InlineFormLabel>
+            <input
+              className="gf-form-input width-14"
+              value={traceIdField || ''}
+              list={this.fieldsListId()}
+              onChange={this.onTraceIdFieldChange}
+              placeholder="header:traceparent"
+              type="text"
+            />
+            <InlineFormLabel
+              className="width-10"
+              tooltip="Field holding the span ID, or header:<name> for a message header. It is exposed as spanID for correlations."
+            >
+              Span ID field
+            </InlineFormLabel>
+            <input
+              className="gf-form-input width-14"
+              value={spanIdField || ''}
+              list={this.fieldsListId()}
+              onChange={this.onSpanIdFieldChange}
+              placeholder="header:traceparent"
+              type="text"
+            />
+            <InlineFormLabel
+              className="width-10"
+              tooltip="How message values are decoded: as JSON, as JSON of the Confluent JSON Schema serializer, as MessagePack or CBOR, or not at all. The formats of decoders built into the plugin can be typed in."
+            >
+              Message format
+            </InlineFormLabel>
+            <div className="gf-form--has-input-icon">
+              <Select
+                className="width-14"
+                value={
+                  messageFormats.find((f) => f.value === messageFormat) ||
+                  (messageFormat ? { label: messageFormat, value: messageFormat } : messageFormats[0])
+                }
+                options={messageFormats}
+                defaultValue={messageFormats[0]}
+                allowCustomValue
+                onChange={this.onMessageFormatChanged}
+              />
+            </div>
+            <InlineFormLabel
+              className="width-10"
+              tooltip="Regular expression whose named capture groups are extracted from raw messages into fields, e.g. ^(?P<level>[A-Z]+) (?P<text>.*)$."
+            >
+              Raw pattern
+            </InlineFormLabel>
+            <input
+              className="gf-form-input width-14"
+              value={rawPattern || ''}
+              onChange={this.onRawPatternChange}
+              disabled={messageFormat !== MessageFormat.Raw}
+              type="text"
+            />
+            <InlineFormLabel className="width-10" tooltip="How messages are turned into data frames.">
+              Output mode
+            </InlineFormLabel>
+            <div className="gf-form--has-input-icon">
+              <Select
+                className="width-14"
+                value={this.resolveOutputMode(outputMode)}
+                options={outputModes}
+                defaultValue={outputModes[0]}
+                onChange={this.onOutputModeChanged}
+              />
+            </div>
+            <InlineFormLabel
+              className="width-10"
+              tooltip="Messages dropped when the panel can't keep up with the topic."
+            >
+              Drop policy
+            </InlineFormLabel>
+            <div className="gf-form--has-input-icon">
+              <Select
+                className="width-14"
+                value={dropPolicy === DropPolicy.Oldest ? dropPolicies[1] : dropPolicies[0]}
+                options={dropPolicies}
+                defaultValue={dropPolicies[0]}
+                onChange={this.onDropPolicyChanged}
+              />
+            </div>
+            <InlineFormLabel
+              className="width-10"
+              tooltip="Consecutive read errors after which a partition is suspended for the cool-down."
+            >
+              Error budget
+            </InlineFormLabel>
+            <input
+              className="gf-form-input width-6"
+              value={errorBudget || ''}
+              onChange={this.onErrorBudgetChange}
+              placeholder="10"
+              type="number"
+              step="1"
+              min="1"
+            />
+            <InlineFormLabel className="width-10" tooltip="How long a partition stays suspended, e.g. 30s.">
+              Error cool-down
+            </InlineFormLabel>
+            <input
+              className="gf-form-input width-6"
+              value={errorCooldown || ''}
+              onChange={this.onErrorCooldownChange}
+              placeholder="30s"
+              type="text"
+            />
+          </InlineFieldRow>
+        </div>
+        <div className="gf-form">
+          <InlineFieldRow>
+            <InlineFormLabel className="width-10" tooltip="Field holding the latitude, exposed as latitude for Geomap.">
+              Latitude field
+            </InlineFormLabel>
+            <input
+              className="gf-form-input width-14"
+              value={latitudeField || ''}
+              list={this.fieldsListId()}
+              onChange={this.onLatitudeFieldChange}
+              type="text"
+            />
+            <InlineFormLabel className="width-10" tooltip="Field holding the longitude, exposed as longitude for Geomap.">
+              Longitude field
+            </InlineFormLabel>
+            <input
+              className="gf-form-input width-14"
+              value={longitudeField || ''}
+              list={this.fieldsListId()}
+              onChange={this.onLongitudeFieldChange}
+              type="text"
+            />
+            <InlineFormLabel
+              className="width-10"
+              tooltip='Field holding both coordinates as a "lat,lon" string or a geohash, converted to latitude and longitude.'
+            >
+              Location field
+            </InlineFormLabel>
+            <input
+              className="gf-form-input width-14"
+              value={locationField || ''}
+              list={this.fieldsListId()}
+              onChange={this.onLocationFieldChange}
+              type="text"
+            />
+          </InlineFieldRow>
+        </div>
+        <div className="gf-form">
+          <InlineFieldRow>
+            <InlineFormLabel
+              className="width-10"
+              tooltip="Compacted topic whose latest value per key is joined onto messages with the same key."
+            >
+              Reference topic
+            </InlineFormLabel>
+            <input
+              className="gf-form-input width-14"
+              value={enrichmentTopic || ''}
+              onChange={this.onEnrichmentTopicChange}
+              type="text"
+            />
+            <InlineFormLabel
+              className="width-10"
+              tooltip="Comma separated fields of the reference topic to join. All of them are joined when left blank."
+            >
+              Reference fields
+            </InlineFormLabel>
+            <input
+              className="gf-form-input width-14"
+              value={enrichmentFields || ''}
+              onChange={this.onEnrichmentFieldsChange}
+              type="text"
+            />
+          </InlineFieldRow>
+        </div>
+        <div className="gf-form">
+          <InlineFieldRow>
+            <InlineFormLabel className="width-10" tooltip="Field whose value is looked up in the lookup table.">
+              Lookup field
+            </InlineFormLabel>
+            <input
+              className="gf-form-input width-14"
+              value={lookupField || ''}
+              list={this.fieldsListId()}
+              onChange={this.onLookupFieldChange}
+              type="text"
+            />
+            <InlineFormLabel
+              className="width-10"
+              tooltip="CSV with a header whose first column holds the keys, or a JSON object mapping keys to objects. The other columns are added as fields."
+            >
+              Lookup table
+            </InlineFormLabel>
+            <textarea
+              className="gf-form-input width-30"
+              rows={3}
+              value={lookupTable || ''}
+              onChange={this.onLookupTableChange}
+              onBlur={this.onLookupTableBlur}
+              placeholder={'sensor,location\n155,Hall A'}
+            />
+          </InlineFieldRow>
+        </div>
+        <div className="gf-form">
+          <InlineFieldRow>
+            <InlineFormLabel
+              className="width-10"
+              tooltip="Only send messages whose monitored fields changed since the last message sent."
+            >
+              Only changes
+            </InlineFormLabel>
+            <div className="add-data-source-item-badge">
+              <Switch css checked={onlyChanges || false} onChange={this.onOnlyChangesChange} />
+            </div>
+            <InlineFormLabel
+              className="width-10"
+              tooltip="Comma separated fields to monitor. All but the time and the __ fields are monitored when left blank."
+            >
+              Monitored fields
+            </InlineFormLabel>
+            <input
+              className="gf-form-input width-14"
+              value={changeFields || ''}
+              onChange={this.onChangeFieldsChange}
+              disabled={!onlyChanges}
+              type="text"
+            />
+            <InlineFormLabel className="width-10" tooltip="Send unchanged messages at least this often, e.g. 1m.">
+              Heartbeat
+            </InlineFormLabel>
+            <input
+              className="gf-form-input width-14"
+              value={changeHeartbeat || ''}
+              onChange={this.onChangeHeartbeatChange}
+              disabled={!onlyChanges}
+              type="text"
+            />
+          </InlineFieldRow>
+        </div>
+        {(thresholdRules || []).map((rule, index) => (
+          <div className="gf-form" key={index}>
+            <InlineFieldRow>
+              <InlineFormLabel className="width-10" tooltip="Messages matching any of the rules are alerts.">
+                Threshold
+              </InlineFormLabel>
+              <input
+                className="gf-form-input width-14"
+                value={rule.field}
+                list={this.fieldsListId()}
+                onChange={this.onThresholdRuleFieldChange(index)}
+                placeholder="field"
+                type="text"
+              />
+              <Select
+                className="width-6"
+                value={thresholdOperators.find((o) => o.value === rule.operator)}
+                options={thresholdOperators}
+                onChange={this.onThresholdRuleOperatorChange(index)}
+              />
+              <input
+                className="gf-form-input width-14"
+                value={rule.value}
+                onChange={this.onThresholdRuleValueChange(index)}
+                placeholder="value"
+                type="text"
+              />
+              <Button variant="secondary" icon="trash-alt" onClick={() => this.onRemoveThresholdRule(index)} />
+            </InlineFieldRow>
+          </div>
+        ))}
+        <div className="gf-form">
+          <InlineFieldRow>
+            <Button variant="secondary" icon="plus" onClick={this.onAddThresholdRule}>
+              Add threshold
+            </Button>
+            {(thresholdRules || []).length > 0 && (
+              <>
+                <InlineFormLabel className="width-10" tooltip="What to do with messages matching a threshold.">
+                  Threshold action
+                </InlineFormLabel>
+                <div className="gf-form--has-input-icon">
+                  <Select
+                    className="width-14"
+                    value={thresholdAction === ThresholdAction.Filter ? thresholdActions[1] : thresholdActions[0]}
+                    options={thresholdActions}
+                    defaultValue={thresholdActions[0]}
+                    onChange={this.onThresholdActionChanged}
+                  />
+                </div>
+              </>
+            )}
+          </InlineFieldRow>
+        </div>
+        <div className="gf-form">
+          <InlineFieldRow>
+            <InlineFormLabel
+              className="width-10"
+              tooltip="Messages above this size in bytes are only summarized by their size, key, partition, offset and summary fields."
+            >
+              Summary above
+            </InlineFormLabel>
+            <input
+              className="gf-form-input width-14"
+              value={summaryAboveBytes || ''}
+              onChange={this.onSummaryAboveBytesChange}
+              placeholder="bytes"
+              type="number"
+              step="1"
+              min="1"
+            />
+            <InlineFormLabel className="width-10" tooltip="Comma separated top-level fields kept in summaries.">
+              Summary fields
+            </InlineFormLabel>
+            <input
+              className="gf-form-input width-14"
+              value={summaryFields || ''}
+              onChange={this.onSummaryFieldsChange}
+              disabled={!summaryAboveBytes}
+              type="text"
+            />
+            <InlineFormLabel
+              className="width-10"
+              tooltip="Longer string fields, like stack traces, are truncated to this many characters followed by an ellipsis."
+            >
+              Max string length
+            </InlineFormLabel>
+            <input
+              className="gf-form-input width-14"
+              value={maxStringLength || ''}
+              onChange={this.onMaxStringLengthChange}
+              placeholder="characters"
+              type="number"
+              step="1"
+              min="1"
+            />
+          </InlineFieldRow>
+        </div>
+        <div className="gf-form">
+          <InlineFieldRow>
+            <InlineFormLabel
+              className="width-10"
+              tooltip="Show an error instead of the fields of messages failing to decode or missing required fields. Otherwise, the fields decoded are shown along with a __warning field."
+            >
+              Strict decode
+            </InlineFormLabel>
+            <div className="add-data-source-item-badge">
+              <Switch css checked={strictDecode || false} onChange={this.onStrictDecodeChange} />
+            </div>
+            <InlineFormLabel
+              className="width-10"
+              tooltip="Comma separated dotted paths of the fields every message must have."
+            >
+              Required fields
+            </InlineFormLabel>
+            <input
+              className="gf-form-input width-14"
+              value={requiredFields || ''}
+              onChange={this.onRequiredFieldsChange}
+              type="text"
+            />
+            <InlineFormLabel
+              className="width-10"
+              tooltip="Comma separated field:rendering pairs rendering base64 encoded bytes, or the __key, as base64, hex, length or utf8."
+            >
+              Binary fields
+            </InlineFormLabel>
+            <input
+              className="gf-form-input width-14"
+              value={binaryFields || ''}
+              onChange={this.onBinaryFieldsChange}
+              placeholder="payload:hex"
+              type="text"
+            />
+          </InlineFieldRow>
+        </div>
+        <div className="gf-form">
+          <InlineFieldRow>
+            <InlineFormLabel
+              className="width-10"
+              tooltip="Buffer messages for up to this long, e.g. 500ms, to merge the partitions in timestamp order."
+            >
+              Reorder delay
+            </InlineFormLabel>
+            <input
+              className="gf-form-input width-14"
+              value={reorderDelay || ''}
+              onChange={this.onReorderDelayChange}
+              type="text"
+            />
+            <InlineFormLabel
+              className="width-10"
+              tooltip="How far behind the latest message seen a message may be, e.g. 5s, before being late."
+            >
+              Max lateness
+            </InlineFormLabel>
+            <input
+              className="gf-form-input width-14"
+              value={maxLateness || ''}
+              onChange={this.onMaxLatenessChange}
+              type="text"
+            />
+            <InlineFormLabel className="width-10" tooltip="What to do with late messages.">
+              Late policy
+            </InlineFormLabel>
+            <div className="gf-form--has-input-icon">
+              <Select
+                className="width-14"
+                value={latePolicies.find((p) => p.value === latePolicy) || latePolicies[0]}
+                options={latePolicies}
+                defaultValue={latePolicies[0]}
+                onChange={this.onLatePolicyChanged}
+                disabled={!maxLateness}
+              />
+            </div>
+          </InlineFieldRow>
+        </div>
+        <div className="gf-form">
+          <InlineFieldRow>
+            <InlineFormLabel className="width-10" tooltip="How the items of messages that are top-level arrays are framed.">
+              Array items
+            </InlineFormLabel>
+            <div className="gf-form--has-input-icon">
+              <Select
+                className="width-14"
+                value={arrayItemsModes.find((m) => m.value === arrayItems) || arrayItemsModes[0]}
+                options={arrayItemsModes}
+                defaultValue={arrayItemsModes[0]}
+                onChange={this.onArrayItemsChanged}
+              />
+            </div>
+            <InlineFormLabel
+              className="width-10"
+              tooltip="Name of the fields of array items, with a %d verb for their index, e.g. row[%02d]."
+            >
+              Item key template
+            </InlineFormLabel>
+            <input
+              className="gf-form-input width-14"
+              value={itemKeyTemplate || ''}
+              onChange={this.onItemKeyTemplateChange}
+              placeholder="item_%d"
+              disabled={arrayItems === ArrayItems.Rows}
+              type="text"
+            />
+          </InlineFieldRow>
+        </div>
+        {outputMode === OutputMode.Histogram && (
+          <div className="gf-form">
+            <InlineFieldRow>
+              <InlineFormLabel className="width-10" tooltip="Dotted path of the numeric field to bucket.">
+                Histogram field
+              </InlineFormLabel>
+              <input
+                className="gf-form-input width-14"
+                value={histogramField || ''}
+                list={this.fieldsListId()}
+                onChange={this.onHistogramFieldChange}
+                placeholder="latency.seconds"
+                type="text"
+              />
+              <InlineFormLabel className="width-10" tooltip="Comma separated upper bounds of the buckets.">
+                Buckets
+              </InlineFormLabel>
+              <input
+                className="gf-form-input width-14"
+                value={histogramBuckets || ''}
+                onChange={this.onHistogramBucketsChange}
+                placeholder=".005,.01,.025,.05,.1,.25,.5,1,2.5,5,10"
+                type="text"
+              />
+              <InlineFormLabel className="width-10" tooltip="Duration covered by each histogram.">
+                Interval
+              </InlineFormLabel>
+              <input
+                className="gf-form-input width-14"
+                value={histogramInterval || ''}
+                onChange={this.onHistogramIntervalChange}
+                placeholder="10s"
+                type="text"
+              />
+            </InlineFieldRow>
+          </div>
+        )}
       </>
     );
   }
@@ -1,9 +1,173 @@
-import { DataSourceInstanceSettings } from '@grafana/data';
-import { DataSourceWithBackend } from '@grafana/runtime';
-import { KafkaDataSourceOptions, KafkaQuery } from './types';
+import {
+  CircularDataFrame,
+  DataFrame,
+  DataQueryRequest,
+  DataQueryResponse,
+  DataSourceInstanceSettings,
+  Labels,
+  LoadingState,
+} from '@grafana/data';
+import { DataSourceWithBackend, getGrafanaLiveSrv } from '@grafana/runtime';
+import { merge, Observable, timer } from 'rxjs';
+import { exhaustMap, map } from 'rxjs/operators';
+import {
+  KafkaCluster,
+  KafkaConsumerLag,
+  KafkaDataSourceOptions,
+  KafkaFieldDiscovery,
+  KafkaInferredSchema,
+  KafkaMessage,
+  KafkaOffsets,
+  KafkaProducedMessage,
+  KafkaProduceRequest,
+  KafkaQuery,
+  KafkaSample,
+  KafkaTopicConfig,
+  MessageFormat,
+} from './types';
+
+// How often polling queries ask for new messages, and how many rows they keep.
+const POLLING_INTERVAL_MS = 1000;
+const POLLING_CAPACITY = 1000;
 
 export class DataSource extends DataSourceWithBackend<KafkaQuery, KafkaDataSourceOptions> {
+  liveConnected = true;
+
   constructor(instanceSettings: DataSourceInstanceSettings<KafkaDataSourceOptions>) {
     super(instanceSettings);
+    // Streaming queries fall back to polling while Live is disconnected.
+    const live = getGrafanaLiveSrv();
+    if (live) {
+      live.getConnectionState().subscribe((connected) => (this.liveConnected = connected));
+    }
+  }
+
+  query(request: DataQueryRequest<KafkaQuery>): Observable<DataQueryResponse> {
+    const polled = request.targets.filter((t) => t.withStreaming && (t.polling || !this.liveConnected));
+    if (polled.length === 0) {
+      return super.query(request);
+    }
+    const others = request.targets.filter((t) => !polled.includes(t));
+    const responses = polled.map((target) => this.poll(request, target));
+    if (others.length > 0) {
+      responses.push(super.query({ ...request, targets: others }));
+    }
+    return merge(...responses);
+  }
+
+  // poll asks for the messages past the cursor of the previous poll every
+  // POLLING_INTERVAL_MS, appending them to the frame of the query.
+  poll(request: DataQueryRequest<KafkaQuery>, target: KafkaQuery): Observable<DataQueryResponse> {
+    const frame = new CircularDataFrame({ append: 'tail', capacity: POLLING_CAPACITY });
+    frame.refId = target.refId;
+    let cursor: Record<string, number> | undefined;
+    return timer(0, POLLING_INTERVAL_MS).pipe(
+      exhaustMap(() => super.query({ ...request, targets: [{ ...target, polling: true, cursor }] })),
+      map((response) => {
+        const polled = response.data[0] as DataFrame | undefined;
+        if (polled) {
+          cursor = (polled.meta?.custom?.cursor as Record<string, number>) || cursor;
+          appendFrame(frame, polled);
+        }
+        return { ...response, data: [frame], key: target.refId, state: LoadingState.Streaming };
+      })
+    );
+  }
+
+  getMessage(
+    topic: string,
+    partition: number,
+    offset: number,
+    messageFormat?: MessageFormat | string,
+    rawPattern?: string
+  ): Promise<KafkaMessage> {
+    return this.getResource('message', { topic, partition, offset, messageFormat, rawPattern });
+  }
+
+  getConsumerLag(group: string, topic: string): Promise<KafkaConsumerLag> {
+    return this.getResource('consumer-lag', { group, topic });
+  }
+
+  getOffsets(topic: string, timestamp?: number): Promise<KafkaOffsets> {
+    return this.getResource('offsets', { topic, timestamp });
+  }
+
+  produce(request: KafkaProduceRequest): Promise<KafkaProducedMessage> {
+    return this.postResource('produce', request);
+  }
+
+  getTopicConfig(topic: string): Promise<KafkaTopicConfig> {
+    return this.getResource('topic-config', { topic });
+  }
+
+  getCluster(): Promise<KafkaCluster> {
+    return this.getResource('cluster');
+  }
+
+  inferSchema(
+    topic: string,
+    partition: number | 'all',
+    messageFormat?: MessageFormat | string,
+    rawPattern?: string,
+    samples?: number
+  ): Promise<KafkaInferredSchema> {
+    return this.postResource('infer-schema', { topic, partition, messageFormat, rawPattern, samples });
+  }
+
+  async getMessageFields(
+    topic: string,
+    partition: number,
+    offset: number,
+    messageFormat?: MessageFormat | string,
+    rawPattern?: string
+  ): Promise<Record<string, unknown>> {
+    const response = await this.getResource('fields', { topic, partition, offset, messageFormat, rawPattern });
+    return response.fields;
+  }
+
+  discoverFields(query: KafkaQuery, n?: number): Promise<KafkaFieldDiscovery> {
+    return this.getResource('discover-fields', { topic: query.topicName, ...sampleParams(query), n });
+  }
+
+  getSample(query: KafkaQuery, n?: number): Promise<KafkaSample> {
+    return this.getResource('sample', { topic: query.topicName, ...sampleParams(query), n });
+  }
+}
+
+// sampleParams returns the parameters of the resources sampling the topic
+// of the query, to decode and name its fields as the query does.
+function sampleParams(query: KafkaQuery) {
+  const { partition, messageFormat, rawPattern, emptyKeyName, itemKeyTemplate } = query;
+  return { partition, messageFormat, rawPattern, emptyKeyName, itemKeyTemplate };
+}
+
+// fieldKey identifies a field by its name and labels, since pivoted fields
+// share their name.
+function fieldKey(field: { name: string; labels?: Labels }): string {
+  const labels = field.labels || {};
+  const pairs = Object.keys(labels)
+    .sort()
+    .map((key) => `${key}=${labels[key]}`);
+  return `${field.name}{${pairs.join(',')}}`;
+}
+
+// appendFrame appends the rows of the polled frame to the frame of a polling
+// query, adding the fields it didn't have yet.
+function appendFrame(frame: CircularDataFrame, polled: DataFrame) {
+  const keys = frame.fields.map(fieldKey);
+  for (const field of polled.fields) {
+    if (!keys.includes(fieldKey(field))) {
+      frame.addField({ name: field.name, type: field.type, config: field.config, labels: field.labels });
+      keys.push(fieldKey(field));
+    }
+  }
+  const indexes = polled.fields.map((field) => keys.indexOf(fieldKey(field)));
+  for (let i = 0; i < polled.length; i++) {
+    const row: unknown[] = new Array(frame.fields.length);
+    polled.fields.forEach((field, j) => {
+      row[indexes[j]] = field.values.get(i);
+    });
+    frame.appendRow(row);
   }
+  frame.meta = { ...polled.meta, custom: undefined };
 }
@@ -10,6 +10,53 @@ export enum TimestampMode {
   Message = 'message',
 }
 
+export enum InvalidUtf8Mode {
+  Replace = 'replace',
+  Strip = 'strip',
+}
+
+export enum MessageFormat {
+  JSON = 'json',
+  JSONSchema = 'jsonSchema',
+  Raw = 'raw',
+  MessagePack = 'msgpack',
+  CBOR = 'cbor',
+}
+
+export enum ArrayItems {
+  Fields = 'fields',
+  Rows = 'rows',
+}
+
+export enum GapFill {
+  None = '',
+  Null = 'null',
+  Zero = 'zero',
+  Previous = 'previous',
+}
+
+export enum OutputMode {
+  Fields = 'fields',
+  Traces = 'traces',
+  Histogram = 'histogram',
+}
+
+export enum ThresholdAction {
+  Tag = 'tag',
+  Filter = 'filter',
+}
+
+export enum DropPolicy {
+  Newest = 'newest',
+  Oldest = 'oldest',
+}
+
+export enum LatePolicy {
+  Drop = 'drop',
+  Emit = 'emit',
+  Tag = 'tag',
+}
+
 export type AutoOffsetResetInterface = {
   [key in AutoOffsetReset]: string;
 };
@@ -18,20 +65,99 @@ export type TimestampModeInterface = {
   [key in TimestampMode]: string;
 };
 
+export interface KafkaDataLink {
+  title: string;
+  url: string;
+}
+
+export interface KafkaThresholdRule {
+  field: string;
+  operator: '>' | '>=' | '<' | '<=' | '==' | '!=';
+  value: string;
+}
+
 export interface KafkaDataSourceOptions extends DataSourceJsonData {
   bootstrapServers: string;
+  dataLinks?: KafkaDataLink[];
+  jsonMaxDepth?: number;
+  jsonMaxSize?: number;
+  jsonMaxStringLength?: number;
+  maxStreams?: number;
+  maxBufferedBytes?: number;
+  maxMessagesPerSecond?: number;
+  maxBytesPerSecond?: number;
+  commitIntervalMs?: number;
+  annotationsUrl?: string;
+  annotationsDashboardUid?: string;
+  produceTopics?: string;
+  settingsVersion?: number;
 }
 
 export interface KafkaSecureJsonData {
   apiKey?: string;
+  annotationsToken?: string;
 }
 
 export interface KafkaQuery extends DataQuery {
   topicName: string;
-  partition: number;
+  partition: number | 'all';
   withStreaming: boolean;
   autoOffsetReset: AutoOffsetReset;
   timestampMode: TimestampMode;
+  pivotNumericKeys?: boolean;
+  emptyKeyName?: string;
+  messageStats?: boolean;
+  invalidUtf8?: InvalidUtf8Mode;
+  markExplicitNulls?: boolean;
+  traceIdField?: string;
+  spanIdField?: string;
+  outputMode?: OutputMode;
+  histogramField?: string;
+  histogramBuckets?: string;
+  histogramInterval?: string;
+  latitudeField?: string;
+  longitudeField?: string;
+  locationField?: string;
+  enrichmentTopic?: string;
+  enrichmentFields?: string;
+  lookupField?: string;
+  lookupTable?: string;
+  onlyChanges?: boolean;
+  changeFields?: string;
+  changeHeartbeat?: string;
+  thresholdRules?: KafkaThresholdRule[];
+  thresholdAction?: ThresholdAction;
+  dropPolicy?: DropPolicy;
+  initialSchema?: boolean;
+  consumerStats?: boolean;
+  errorBudget?: number;
+  errorCooldown?: string;
+  summaryAboveBytes?: number;
+  summaryFields?: string;
+  strictDecode?: boolean;
+  requiredFields?: string;
+  reorderDelay?: string;
+  maxLateness?: string;
+  latePolicy?: LatePolicy;
+  maxStringLength?: number;
+  binaryFields?: string;
+  consumerGroup?: string;
+  offsetCheckpoints?: boolean;
+  maxQueryDuration?: string;
+  gapFill?: GapFill;
+  gapFillInterval?: string;
+  selectedFields?: string[];
+  polling?: boolean;
+  // cursor is the offset of the next message of every partition, passed
+  // back by polling queries, or by paging queries along with their pageSize.
+  cursor?: Record<string, number>;
+  pageSize?: number;
+  // messageFormat is either built in or the format of a registered decoder.
+  messageFormat?: MessageFormat | string;
+  rawPattern?: string;
+  queryVersion?: number;
+  arrayItems?: ArrayItems;
+  itemKeyTemplate?: string;
 }
 
 export const defaultQuery: Partial<KafkaQuery> = {
@@ -39,4 +165,118 @@ export const defaultQuery: Partial<KafkaQuery> = {
   withStreaming: true,
   autoOffsetReset: AutoOffsetReset.LATEST,
   timestampMode: TimestampMode.Now,
+  pivotNumericKeys: false,
+  invalidUtf8: InvalidUtf8Mode.Replace,
+  outputMode: OutputMode.Fields,
+  dropPolicy: DropPolicy.Newest,
 };
+
+export interface KafkaMessage {
+  topic: string;
+  partition: number;
+  offset: number;
+  timestamp: string;
+  key: string | null;
+  value: string | null;
+  decoded?: Record<string, unknown>;
+  error?: string;
+}
+
+export interface KafkaSampleMessage {
+  partition: number;
+  offset: number;
+  timestamp: string;
+  fields?: Record<string, unknown>;
+  error?: string;
+}
+
+export interface KafkaSample {
+  topic: string;
+  messages: KafkaSampleMessage[];
+}
+
+export interface KafkaDiscoveredField {
+  name: string;
+  types: Array<'boolean' | 'number' | 'string' | 'null'>;
+  messages: number;
+}
+
+export interface KafkaFieldDiscovery {
+  topic: string;
+  fields: KafkaDiscoveredField[];
+  samples: number;
+}
+
+export interface KafkaResourceError {
+  code: string;
+  message: string;
+  details?: Record<string, unknown>;
+  retryable: boolean;
+  /** @deprecated The message, for clients predating the code. */
+  error: string;
+}
+
+export interface KafkaProduceRequest {
+  topic: string;
+  key?: string;
+  value: unknown;
+  headers?: Record<string, string>;
+}
+
+export interface KafkaProducedMessage {
+  topic: string;
+  partition: number;
+  offset: number;
+}
+
+export interface KafkaPartitionLag {
+  partition: number;
+  committed: number;
+  end: number;
+  lag: number;
+}
+
+export interface KafkaConsumerLag {
+  group: string;
+  topic: string;
+  partitions: KafkaPartitionLag[];
+  lag: number;
+}
+
+export interface KafkaBroker {
+  id: number;
+  host: string;
+  port: number;
+}
+
+export interface KafkaCluster {
+  brokers: KafkaBroker[];
+  originatingBroker: number;
+  topics: number;
+  platform: string;
+}
+
+export interface KafkaTopicConfig {
+  topic: string;
+  partitions: number;
+  replicationFactor: number;
+  configs: Record<string, string>;
+}
+
+export interface KafkaPartitionOffsets {
+  partition: number;
+  earliest: number;
+  latest: number;
+  atTimestamp?: number;
+}
+
+export interface KafkaOffsets {
+  topic: string;
+  partitions: KafkaPartitionOffsets[];
+  messages: number;
+}
+
+export interface KafkaInferredSchema {
+  schema: Record<string, unknown>;
+  samples: number;
+}